@@ -0,0 +1,272 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ToMarkdown renders the ADF document as Trello-flavoured Markdown, keeping headings, lists (including nested ones),
+emphasis, links, code, quotes and tables.
+*/
+func (content *JiraContent) ToMarkdown() string {
+	if content == nil {
+		return ""
+	}
+	return renderAdfBlocks(content.Content, "\n\n")
+}
+
+/*
+attrString returns the given attribute as a string, or an empty string if it is not present
+*/
+func attrString(attrs map[string]interface{}, key string) string {
+	if attrs == nil {
+		return ""
+	}
+	switch v := attrs[key].(type) {
+	case string:
+		return v
+	case float64:
+		return fmt.Sprintf("%d", int64(v))
+	default:
+		return ""
+	}
+}
+
+/*
+attrInt returns the given attribute as an int, or the default value if it is not present or not numeric
+*/
+func attrInt(attrs map[string]interface{}, key string, defaultValue int) int {
+	if attrs == nil {
+		return defaultValue
+	}
+	switch v := attrs[key].(type) {
+	case float64: //anything read from JSON
+		return int(v)
+	case int:
+		return v
+	default:
+		return defaultValue
+	}
+}
+
+/*
+renderAdfBlocks renders a list of block nodes, joining them with the given separator and dropping any that render empty
+*/
+func renderAdfBlocks(nodes []AdfNode, separator string) string {
+	parts := make([]string, 0, len(nodes))
+	for i := range nodes {
+		rendered := renderAdfBlock(&nodes[i])
+		if rendered != "" {
+			parts = append(parts, rendered)
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+/*
+prefixLines puts firstPrefix before the first line of text and otherPrefix before every subsequent line
+*/
+func prefixLines(text string, firstPrefix string, otherPrefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		if i == 0 {
+			lines[i] = firstPrefix + l
+		} else if l == "" {
+			lines[i] = strings.TrimRight(otherPrefix, " ")
+		} else {
+			lines[i] = otherPrefix + l
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func renderAdfBlock(node *AdfNode) string {
+	switch node.Type {
+	case "paragraph":
+		return renderAdfInline(node.Content)
+	case "heading":
+		level := attrInt(node.Attrs, "level", 1)
+		if level < 1 || level > 6 {
+			level = 1
+		}
+		return strings.Repeat("#", level) + " " + renderAdfInline(node.Content)
+	case "bulletList":
+		return renderAdfList(node, false)
+	case "orderedList":
+		return renderAdfList(node, true)
+	case "taskList":
+		return renderAdfTaskList(node)
+	case "codeBlock":
+		var sb strings.Builder
+		for _, c := range node.Content {
+			sb.WriteString(c.Text)
+		}
+		return "```" + attrString(node.Attrs, "language") + "\n" + sb.String() + "\n```"
+	case "blockquote", "panel":
+		return prefixLines(renderAdfBlocks(node.Content, "\n\n"), "> ", "> ")
+	case "rule":
+		return "---"
+	case "table":
+		return renderAdfTable(node)
+	case "expand", "nestedExpand":
+		body := renderAdfBlocks(node.Content, "\n\n")
+		if title := attrString(node.Attrs, "title"); title != "" {
+			return "**" + title + "**\n\n" + body
+		}
+		return body
+	case "mediaSingle", "mediaGroup":
+		return renderAdfBlocks(node.Content, "\n")
+	case "media":
+		return "_[attachment]_"
+	case "blockCard", "embedCard":
+		url := attrString(node.Attrs, "url")
+		return "[" + url + "](" + url + ")"
+	default:
+		//unknown block types - fall back to whatever text is inside them
+		if len(node.Content) > 0 {
+			if node.Content[0].isInline() {
+				return renderAdfInline(node.Content)
+			}
+			return renderAdfBlocks(node.Content, "\n\n")
+		}
+		return node.Text
+	}
+}
+
+/*
+isInline returns true if the node is one that sits inside a paragraph, rather than being a block in its own right
+*/
+func (node *AdfNode) isInline() bool {
+	switch node.Type {
+	case "text", "hardBreak", "mention", "emoji", "inlineCard", "status", "date":
+		return true
+	default:
+		return false
+	}
+}
+
+func renderAdfList(node *AdfNode, ordered bool) string {
+	counter := attrInt(node.Attrs, "order", 1)
+	items := make([]string, 0, len(node.Content))
+
+	for _, item := range node.Content {
+		marker := "- "
+		if ordered {
+			marker = fmt.Sprintf("%d. ", counter)
+			counter++
+		}
+		//list item children are kept "tight", so nested lists sit directly under their parent item
+		itemContent := renderAdfBlocks(item.Content, "\n")
+		items = append(items, prefixLines(itemContent, marker, strings.Repeat(" ", len(marker))))
+	}
+	return strings.Join(items, "\n")
+}
+
+func renderAdfTaskList(node *AdfNode) string {
+	items := make([]string, 0, len(node.Content))
+	for _, item := range node.Content {
+		if item.Type == "taskList" {
+			items = append(items, prefixLines(renderAdfTaskList(&item), "  ", "  "))
+			continue
+		}
+		marker := "- [ ] "
+		if attrString(item.Attrs, "state") == "DONE" {
+			marker = "- [x] "
+		}
+		items = append(items, prefixLines(renderAdfInline(item.Content), marker, "      "))
+	}
+	return strings.Join(items, "\n")
+}
+
+func renderAdfTable(node *AdfNode) string {
+	rows := make([]string, 0, len(node.Content)+1)
+	for i, row := range node.Content {
+		cells := make([]string, 0, len(row.Content))
+		for _, cell := range row.Content {
+			cellText := renderAdfBlocks(cell.Content, " ")
+			cellText = strings.ReplaceAll(cellText, "|", "\\|")
+			cellText = strings.ReplaceAll(cellText, "\n", " ")
+			cells = append(cells, cellText)
+		}
+		rows = append(rows, "| "+strings.Join(cells, " | ")+" |")
+		if i == 0 {
+			//markdown tables must have a header row, so the first row is always treated as one
+			rows = append(rows, "|"+strings.Repeat(" --- |", len(cells)))
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+func renderAdfInline(nodes []AdfNode) string {
+	var sb strings.Builder
+	for i := range nodes {
+		node := &nodes[i]
+		switch node.Type {
+		case "text":
+			sb.WriteString(applyAdfMarks(node.Text, node.Marks))
+		case "hardBreak":
+			sb.WriteString("\n")
+		case "mention":
+			text := attrString(node.Attrs, "text")
+			if !strings.HasPrefix(text, "@") {
+				text = "@" + text
+			}
+			sb.WriteString(text)
+		case "emoji":
+			if text := attrString(node.Attrs, "text"); text != "" {
+				sb.WriteString(text)
+			} else {
+				sb.WriteString(attrString(node.Attrs, "shortName"))
+			}
+		case "inlineCard":
+			url := attrString(node.Attrs, "url")
+			sb.WriteString("[" + url + "](" + url + ")")
+		case "status":
+			sb.WriteString("`" + attrString(node.Attrs, "text") + "`")
+		case "date":
+			sb.WriteString(attrString(node.Attrs, "timestamp"))
+		default:
+			if len(node.Content) > 0 {
+				sb.WriteString(renderAdfInline(node.Content))
+			} else {
+				sb.WriteString(node.Text)
+			}
+		}
+	}
+	return sb.String()
+}
+
+/*
+applyAdfMarks wraps the given text in the markdown equivalent of each mark. Surrounding whitespace is kept outside
+of the markers, otherwise Markdown won't recognise them (e.g. "** bold**")
+*/
+func applyAdfMarks(text string, marks []AdfMark) string {
+	if len(marks) == 0 || strings.TrimSpace(text) == "" {
+		return text
+	}
+	trimmed := strings.TrimSpace(text)
+	leading := text[:strings.Index(text, trimmed)]
+	trailing := text[len(leading)+len(trimmed):]
+
+	var linkTarget string
+	for _, m := range marks {
+		switch m.Type {
+		case "code":
+			trimmed = "`" + trimmed + "`"
+		case "strong":
+			trimmed = "**" + trimmed + "**"
+		case "em":
+			trimmed = "*" + trimmed + "*"
+		case "strike":
+			trimmed = "~~" + trimmed + "~~"
+		case "link":
+			linkTarget = attrString(m.Attrs, "href")
+		}
+	}
+	//links are applied last so that the formatting sits inside the link text
+	if linkTarget != "" {
+		trimmed = "[" + trimmed + "](" + linkTarget + ")"
+	}
+	return leading + trimmed + trailing
+}
@@ -0,0 +1,98 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func renderTestDoc(t *testing.T, testData string) string {
+	var content JiraContent
+	err := json.Unmarshal([]byte(testData), &content)
+	if err != nil {
+		t.Fatalf("Could not unmarshal test data: %s", err)
+	}
+	return content.ToMarkdown()
+}
+
+func TestToMarkdownMarks(t *testing.T) {
+	result := renderTestDoc(t, `{
+        "version": 1,
+        "type": "doc",
+        "content": [
+          {
+            "type": "paragraph",
+            "content": [
+              {"type": "text", "text": "Open "},
+              {"type": "text", "text": "the project", "marks": [{"type": "link", "attrs": {"href": "https://example.com/p/1"}}]},
+              {"type": "text", "text": " and check the "},
+              {"type": "text", "text": "bold ", "marks": [{"type": "strong"}]},
+              {"type": "text", "text": "and "},
+              {"type": "text", "text": "italic", "marks": [{"type": "em"}]},
+              {"type": "text", "text": " bits in "},
+              {"type": "text", "text": "config.yaml", "marks": [{"type": "code"}]}
+            ]
+          }
+        ]
+      }`)
+
+	expected := "Open [the project](https://example.com/p/1) and check the **bold** and *italic* bits in `config.yaml`"
+	if result != expected {
+		t.Errorf("Got '%s', expected '%s'", result, expected)
+	}
+}
+
+func TestToMarkdownNestedLists(t *testing.T) {
+	result := renderTestDoc(t, `{
+        "version": 1,
+        "type": "doc",
+        "content": [
+          {"type": "heading", "attrs": {"level": 2}, "content": [{"type": "text", "text": "Steps"}]},
+          {
+            "type": "orderedList",
+            "content": [
+              {"type": "listItem", "content": [
+                {"type": "paragraph", "content": [{"type": "text", "text": "first"}]},
+                {"type": "bulletList", "content": [
+                  {"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "nested"}]}]}
+                ]}
+              ]},
+              {"type": "listItem", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "second"}]}]}
+            ]
+          }
+        ]
+      }`)
+
+	expected := "## Steps\n\n1. first\n   - nested\n2. second"
+	if result != expected {
+		t.Errorf("Got '%s', expected '%s'", result, expected)
+	}
+}
+
+func TestToMarkdownBlocks(t *testing.T) {
+	result := renderTestDoc(t, `{
+        "version": 1,
+        "type": "doc",
+        "content": [
+          {"type": "codeBlock", "attrs": {"language": "go"}, "content": [{"type": "text", "text": "fmt.Println(\"hi\")"}]},
+          {"type": "blockquote", "content": [
+            {"type": "paragraph", "content": [{"type": "text", "text": "quoted"}]},
+            {"type": "paragraph", "content": [{"type": "text", "text": "twice"}]}
+          ]},
+          {"type": "table", "content": [
+            {"type": "tableRow", "content": [
+              {"type": "tableHeader", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "Name"}]}]},
+              {"type": "tableHeader", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "Value"}]}]}
+            ]},
+            {"type": "tableRow", "content": [
+              {"type": "tableCell", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "a|b"}]}]},
+              {"type": "tableCell", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "1"}]}]}
+            ]}
+          ]}
+        ]
+      }`)
+
+	expected := "```go\nfmt.Println(\"hi\")\n```\n\n> quoted\n>\n> twice\n\n| Name | Value |\n| --- | --- |\n| a\\|b | 1 |"
+	if result != expected {
+		t.Errorf("Got '%s', expected '%s'", result, expected)
+	}
+}
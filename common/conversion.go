@@ -1,17 +1,5 @@
 package common
 
-func (content *JiraContent) ToTextBlock() string {
-	accumulator := ""
-
-	for _, block := range content.Content {
-		for _, line := range block.Content {
-			accumulator += line.Text + "\n"
-		}
-		accumulator += "\n"
-	}
-	return accumulator
-}
-
 /*
 ToTrelloCard will create a new trello card request from the given issue.
 Note that this does NOT bring over any attachments - that must be done seperately
@@ -23,22 +11,30 @@ func (issue *Issue) ToTrelloCard(inList string, putToTop bool) *NewTrelloCard {
 	}
 
 	var dueComplete *bool
-	if issue.Fields.Status.Name == "Done" {
+	if issue.Fields.Status.IsDone() {
 		dueComplete = BoolPtr(true)
 	}
 
+	description := issue.Fields.Description.ToMarkdown()
+	if footer := issue.Fields.SprintFooter(); footer != "" {
+		if description != "" {
+			description += "\n\n"
+		}
+		description += footer
+	}
+
 	//PRIORITY should be a "custom field" in Trello
 	//EPIC can map to a "component" "custom field" in Trello
 	//what about comments?
 	return &NewTrelloCard{
 		ListId:      inList,
 		Name:        issue.Fields.Summary,
-		Description: (&(issue.Fields.Description)).ToTextBlock(),
+		Description: description,
 		Position:    newPos,
 		DueDate:     issue.Fields.DueDate,
 		Start:       nil,
 		DueComplete: dueComplete,
-		Members:     nil, //we need to cross-reference jira users to board members
+		Members:     nil, //filled in by the caller, as jira users need to be cross-referenced to board members
 		LabelIDs:    nil, //we need to merge in information about the epic, and any other labels
 	}
 }
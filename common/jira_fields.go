@@ -0,0 +1,333 @@
+package common
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+JiraFieldMapping says which Jira fields hold the values that IssueFields has typed fields for. Each one is the
+field's display name, as shown in Jira and returned by expand=names, or its ID (e.g. customfield_10014). Custom
+field IDs differ between Jira sites, so names are resolved against each search's names at runtime.
+*/
+type JiraFieldMapping struct {
+	EpicLink    string `yaml:"epicLink"`
+	EpicName    string `yaml:"epicName"`
+	EpicColour  string `yaml:"epicColour"`
+	Sprint      string `yaml:"sprint"`
+	StoryPoints string `yaml:"storyPoints"`
+}
+
+/*
+DefaultJiraFieldMapping returns the names that Jira Cloud gives these fields
+*/
+func DefaultJiraFieldMapping() JiraFieldMapping {
+	return JiraFieldMapping{
+		EpicLink:    "Epic Link",
+		EpicName:    "Epic Name",
+		EpicColour:  "Epic Color",
+		Sprint:      "Sprint",
+		StoryPoints: "Story point estimate",
+	}
+}
+
+/*
+LegacyFieldIds are the IDs these fields had on the Jira that this tool was first written against. They are used
+when Jira does not say what its fields are called, and as the IDs that Issue.MarshalJSON writes typed values to.
+*/
+var LegacyFieldIds = JiraFieldMapping{
+	EpicLink:    "customfield_10014",
+	EpicName:    "customfield_10011",
+	EpicColour:  "customfield_10013",
+	Sprint:      "customfield_10020",
+	StoryPoints: "customfield_10016",
+}
+
+/*
+CustomFieldMapping copies the value of any Jira field into a Trello custom field, which is created if the board
+does not have it. Type is the type of the Trello field, and defaults to text.
+*/
+type CustomFieldMapping struct {
+	Jira   string          `yaml:"jira"`   //display name or ID of the Jira field
+	Trello string          `yaml:"trello"` //name of the Trello custom field
+	Type   CustomFieldType `yaml:"type"`
+}
+
+/*
+FieldType returns the type of the Trello field, which is text if none was given
+*/
+func (m *CustomFieldMapping) FieldType() CustomFieldType {
+	if m.Type == "" {
+		return Text
+	}
+	return m.Type
+}
+
+/*
+UnmarshalJSON reads an issue as normal, and also keeps every field exactly as Jira sent it in RawFields so that
+fields without a typed equivalent can be looked up later
+*/
+func (i *Issue) UnmarshalJSON(data []byte) error {
+	type plainIssue Issue
+	if err := json.Unmarshal(data, (*plainIssue)(i)); err != nil {
+		return err
+	}
+	var raw struct {
+		Fields map[string]json.RawMessage `json:"fields"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	i.RawFields = raw.Fields
+	return nil
+}
+
+/*
+MarshalJSON writes the fields exactly as Jira sent them, if the issue was read from Jira, so that nothing is lost
+when it is saved and read back. Issues made in code have their typed custom fields written to LegacyFieldIds.
+*/
+func (i Issue) MarshalJSON() ([]byte, error) {
+	type plainIssue Issue
+	fields := i.RawFields
+	if fields == nil {
+		encoded, err := json.Marshal(i.Fields)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(encoded, &fields); err != nil {
+			return nil, err
+		}
+		typed := []struct {
+			id    string
+			isSet bool
+			value interface{}
+		}{
+			{LegacyFieldIds.EpicLink, i.Fields.EpicLink != nil, i.Fields.EpicLink},
+			{LegacyFieldIds.EpicName, i.Fields.EpicName != nil, i.Fields.EpicName},
+			{LegacyFieldIds.EpicColour, i.Fields.EpicColour != nil, i.Fields.EpicColour},
+			{LegacyFieldIds.Sprint, i.Fields.SprintLink != nil, i.Fields.SprintLink},
+			{LegacyFieldIds.StoryPoints, i.Fields.StoryPoints != nil, i.Fields.StoryPoints},
+		}
+		for _, t := range typed {
+			if !t.isSet {
+				continue
+			}
+			if fields[t.id], err = json.Marshal(t.value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return json.Marshal(struct {
+		plainIssue
+		Fields map[string]json.RawMessage `json:"fields"`
+	}{plainIssue(i), fields})
+}
+
+/*
+FieldId returns the ID of the field with the given display name (ignoring case) or ID. If two fields have the same
+name, the one with the lowest ID wins so that the choice is the same every time.
+*/
+func (i *Issue) FieldId(nameOrId string) (string, bool) {
+	if _, isId := i.Names[nameOrId]; isId {
+		return nameOrId, true
+	}
+	found := ""
+	for id, name := range i.Names {
+		if strings.EqualFold(name, nameOrId) && (found == "" || id < found) {
+			found = id
+		}
+	}
+	if found != "" {
+		return found, true
+	}
+	if _, haveRaw := i.RawFields[nameOrId]; haveRaw {
+		return nameOrId, true
+	}
+	return "", false
+}
+
+/*
+RawField returns the value of the field with the given display name or ID, as Jira sent it. Returns false if the
+issue doesn't have the field or it is null.
+*/
+func (i *Issue) RawField(nameOrId string) (json.RawMessage, bool) {
+	id, haveId := i.FieldId(nameOrId)
+	if !haveId {
+		return nil, false
+	}
+	raw, haveRaw := i.RawFields[id]
+	if !haveRaw || string(raw) == "null" {
+		return nil, false
+	}
+	return raw, true
+}
+
+// warnings about missing field names are only logged once, rather than for every issue
+var legacyFieldsWarning, unnamedFieldsWarning sync.Once
+
+/*
+ResolveFields fills in the typed custom fields (epic link, epic name and colour, sprints and story points) from
+RawFields, finding each one by the name or ID in the mapping. If Jira did not send any names and the mapping is the
+default one, LegacyFieldIds are used instead. A mapping that has been changed is never swapped for LegacyFieldIds,
+as they are likely to be different fields on that Jira, so only the IDs in it can be found.
+Parent and sub-task references are resolved too.
+*/
+func (i *Issue) ResolveFields(mapping *JiraFieldMapping) {
+	useLegacyIds := false
+	if len(i.Names) == 0 {
+		if *mapping == DefaultJiraFieldMapping() {
+			useLegacyIds = true
+			legacyFieldsWarning.Do(func() {
+				log.Printf("WARNING Jira did not send field names with %s, so epics, sprints and story points are read from the fields with the default IDs, which may be wrong for this Jira", i.Key)
+			})
+		} else {
+			unnamedFieldsWarning.Do(func() {
+				log.Printf("WARNING Jira did not send field names with %s, so only the fields mapped by ID can be found", i.Key)
+			})
+		}
+	}
+	targets := []struct {
+		nameOrId string
+		legacyId string
+		value    interface{}
+	}{
+		{mapping.EpicLink, LegacyFieldIds.EpicLink, &i.Fields.EpicLink},
+		{mapping.EpicName, LegacyFieldIds.EpicName, &i.Fields.EpicName},
+		{mapping.EpicColour, LegacyFieldIds.EpicColour, &i.Fields.EpicColour},
+		{mapping.Sprint, LegacyFieldIds.Sprint, &i.Fields.SprintLink},
+		{mapping.StoryPoints, LegacyFieldIds.StoryPoints, &i.Fields.StoryPoints},
+	}
+	for _, t := range targets {
+		nameOrId := t.nameOrId
+		if useLegacyIds {
+			nameOrId = t.legacyId
+		}
+		raw, haveRaw := i.RawField(nameOrId)
+		if !haveRaw {
+			continue
+		}
+		if err := json.Unmarshal(raw, t.value); err != nil {
+			log.Printf("WARNING Field '%s' of %s is not what was expected, ignoring it: %s", nameOrId, i.Key, err)
+		}
+	}
+
+	if i.Fields.Parent != nil {
+		i.Fields.Parent.Names = i.Names
+		i.Fields.Parent.ResolveFields(mapping)
+	}
+	for n := range i.Fields.Subtasks {
+		i.Fields.Subtasks[n].Names = i.Names
+		i.Fields.Subtasks[n].ResolveFields(mapping)
+	}
+}
+
+/*
+FieldText renders any kind of Jira field value as text: options, users and other objects by their name or value,
+rich text as Markdown and multiple values joined with commas
+*/
+func FieldText(raw json.RawMessage) string {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return ""
+	}
+	if doc, isMap := value.(map[string]interface{}); isMap && doc["type"] == "doc" {
+		var content JiraContent
+		if err := json.Unmarshal(raw, &content); err == nil {
+			return content.ToMarkdown()
+		}
+	}
+	return valueText(value)
+}
+
+func valueText(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if text := valueText(item); text != "" {
+				parts = append(parts, text)
+			}
+		}
+		return strings.Join(parts, ", ")
+	case map[string]interface{}:
+		for _, key := range []string{"value", "name", "displayName", "key"} {
+			if text, isString := v[key].(string); isString {
+				//cascading selects keep the second level in "child"
+				if child, haveChild := v["child"].(map[string]interface{}); haveChild {
+					return text + " - " + valueText(child)
+				}
+				return text
+			}
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
+/*
+FieldNumber returns a number field's value, accepting numbers sent as strings. Returns false if it isn't a number.
+*/
+func FieldNumber(raw json.RawMessage) (float64, bool) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return parsed, err == nil
+	default:
+		return 0, false
+	}
+}
+
+/*
+FieldDate returns a date or date-time field's value. Returns false if it isn't a date.
+*/
+func FieldDate(raw json.RawMessage) (time.Time, bool) {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return time.Time{}, false
+	}
+	for _, format := range []string{JiraTimeFormat, time.RFC3339, "2006-01-02"} {
+		if parsed, err := time.Parse(format, value); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+/*
+FieldChecked returns true if a field is "on": a true boolean, or a checkbox field with any of its boxes ticked
+*/
+func FieldChecked(raw json.RawMessage) bool {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false
+	}
+	switch v := value.(type) {
+	case bool:
+		return v
+	case []interface{}:
+		return len(v) > 0
+	case string:
+		return strings.EqualFold(v, "true") || strings.EqualFold(v, "yes")
+	default:
+		return v != nil
+	}
+}
@@ -0,0 +1,89 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/*
+TestIssueRawFields checks that fields without a typed equivalent survive being saved and read back, and that issues
+made in code are written with their typed custom fields under the legacy IDs
+*/
+func TestIssueRawFields(t *testing.T) {
+	content := `{"key": "PROJ-1", "fields": {"summary": "Hello", "customfield_10050": {"value": "Platform"}, "customfield_10060": null}}`
+	var issue Issue
+	if err := json.Unmarshal([]byte(content), &issue); err != nil {
+		t.Fatal(err)
+	}
+	issue.Names = map[string]string{"customfield_10050": "Team", "customfield_10060": "Reviewer"}
+	if raw, haveRaw := issue.RawField("Team"); !haveRaw || FieldText(raw) != "Platform" {
+		t.Errorf("expected Team to be Platform, got %s", string(raw))
+	}
+	if _, haveRaw := issue.RawField("Reviewer"); haveRaw {
+		t.Error("expected a null field to count as not set")
+	}
+
+	encoded, err := json.Marshal(&issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reread Issue
+	if err = json.Unmarshal(encoded, &reread); err != nil {
+		t.Fatal(err)
+	}
+	if reread.Fields.Summary != "Hello" || string(reread.RawFields["customfield_10050"]) != `{"value":"Platform"}` {
+		t.Errorf("expected the raw fields to round-trip, got %s", string(encoded))
+	}
+
+	made := Issue{Key: "PROJ-2", Fields: IssueFields{Summary: "Made in code", EpicLink: StringPtr("PROJ-100")}}
+	encoded, _ = json.Marshal(made)
+	reread = Issue{}
+	json.Unmarshal(encoded, &reread)
+	defaultMapping := DefaultJiraFieldMapping()
+	reread.ResolveFields(&defaultMapping)
+	if reread.Fields.EpicLink == nil || *reread.Fields.EpicLink != "PROJ-100" {
+		t.Errorf("expected the epic link to be found at its legacy ID without names, got %s", string(encoded))
+	}
+
+	//a changed mapping is not swapped for the legacy IDs, but the IDs in it can still be found
+	reread = Issue{}
+	json.Unmarshal(encoded, &reread)
+	reread.ResolveFields(&JiraFieldMapping{EpicLink: "Parent Epic"})
+	if reread.Fields.EpicLink != nil {
+		t.Errorf("expected a changed mapping not to fall back to the legacy IDs, got %s", *reread.Fields.EpicLink)
+	}
+	reread = Issue{}
+	json.Unmarshal(encoded, &reread)
+	reread.ResolveFields(&JiraFieldMapping{EpicLink: LegacyFieldIds.EpicLink})
+	if reread.Fields.EpicLink == nil || *reread.Fields.EpicLink != "PROJ-100" {
+		t.Error("expected a field mapped by ID to be found without names")
+	}
+}
+
+func TestFieldValues(t *testing.T) {
+	textCases := map[string]string{
+		`"plain"`:                          "plain",
+		`3.5`:                              "3.5",
+		`[{"value": "A"}, {"value": "B"}]`: "A, B",
+		`{"displayName": "Alice Example", "accountId": "1"}`:                                                               "Alice Example",
+		`{"value": "Hardware", "child": {"value": "Laptop"}}`:                                                              "Hardware - Laptop",
+		`{"version": 1, "type": "doc", "content": [{"type": "paragraph", "content": [{"type": "text", "text": "rich"}]}]}`: "rich",
+	}
+	for raw, expected := range textCases {
+		if text := FieldText(json.RawMessage(raw)); text != expected {
+			t.Errorf("expected %s to be '%s', got '%s'", raw, expected, text)
+		}
+	}
+	if n, isNumber := FieldNumber(json.RawMessage(`"8"`)); !isNumber || n != 8 {
+		t.Errorf("expected a number in a string to be read, got %v", n)
+	}
+	if d, isDate := FieldDate(json.RawMessage(`"2021-03-04"`)); !isDate || d.Day() != 4 {
+		t.Errorf("expected a date to be read, got %v", d)
+	}
+	if _, isDate := FieldDate(json.RawMessage(`"next week"`)); isDate {
+		t.Error("expected text not to be a date")
+	}
+	if !FieldChecked(json.RawMessage(`[{"value": "Yes"}]`)) || FieldChecked(json.RawMessage(`[]`)) {
+		t.Error("expected a checkbox field to be checked only when a box is ticked")
+	}
+}
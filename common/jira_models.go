@@ -1,49 +1,64 @@
 package common
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
 )
 
 const JiraTimeFormat = "2006-01-02T15:04:05.000-0700"
 
 type PagedIssues struct {
-	Expand     string  `json:"expand"`
-	StartAt    int64   `json:"startAt"`
-	MaxResults int64   `json:"maxResults"`
-	Total      int64   `json:"total"`
-	Issues     []Issue `json:"issues"`
+	Expand     string            `json:"expand"`
+	StartAt    int64             `json:"startAt"`
+	MaxResults int64             `json:"maxResults"`
+	Total      int64             `json:"total"`
+	Issues     []Issue           `json:"issues"`
+	Names      map[string]string `json:"names"` //field IDs to display names, when asked for with expand=names
 }
 
+/*
+Issue is a Jira issue. Besides the typed Fields, every field is kept as Jira sent it in RawFields, and Names holds
+the display names of the fields from the search that found it, so that any field can be looked up by name. See
+jira_fields.go.
+*/
 type Issue struct {
-	Expand string      `json:"expand"`
-	Id     string      `json:"id"`
-	Self   string      `json:"self"` //self-uri
-	Key    string      `json:"key"`  //what it's referred to as in the UI
-	Fields IssueFields `json:"fields"`
+	Expand    string                     `json:"expand"`
+	Id        string                     `json:"id"`
+	Self      string                     `json:"self"` //self-uri
+	Key       string                     `json:"key"`  //what it's referred to as in the UI
+	Fields    IssueFields                `json:"fields"`
+	RawFields map[string]json.RawMessage `json:"-"` //keyed by field ID
+	Names     map[string]string          `json:"-"` //field IDs to display names
 }
 
 type IssueFields struct {
 	Parent       *Issue        `json:"parent"` //reference to parent issue, if this is a subtask
 	Priority     IssuePriority `json:"priority"`
-	Labels       []string      `json:"labels"` //TBC schema
-	TimeEstimate *int64        `json:"timeestimate"`
+	Labels       []string      `json:"labels"`               //TBC schema
+	TimeEstimate *int64        `json:"timeestimate"`         //remaining estimate, in seconds
+	TimeOriginal *int64        `json:"timeoriginalestimate"` //original estimate, in seconds
 	Status       IssueStatus   `json:"status"`
 	Creator      JiraUser      `json:"creator"`
 	Created      string        `json:"created"`
 	Subtasks     []Issue       `json:"subTasks"`
 	Reporter     JiraUser      `json:"reporter"`
+	Assignee     *JiraUser     `json:"assignee"`
+	Watches      *IssueWatches `json:"watches"`
 	IssueType    IssueType     `json:"issuetype"`
 	Summary      string        `json:"summary"`
 	Description  JiraContent   `json:"description"`
 	Attachment   []Attachment  `json:"attachment"`
 	DueDate      *string       `json:"duedate"`
-	EpicLink     *string       `json:"customfield_10014"` //catchy name, huh? the id is unique to our jira *sigh*
-	EpicName     *string       `json:"customfield_10011"` //only set on epics
-	EpicColour   *string       `json:"customfield_10013"` //only set on epics. Use the decoding function to get a "sensible" colour name
-	SprintLink   *[]SprintLink `json:"customfield_10020"`
+	//custom fields have different IDs on every Jira, so these are filled in by Issue.ResolveFields
+	EpicLink    *string       `json:"-"`
+	EpicName    *string       `json:"-"` //only set on epics
+	EpicColour  *string       `json:"-"` //only set on epics. Use the decoding function to get a "sensible" colour name
+	SprintLink  *[]SprintLink `json:"-"`
+	StoryPoints *float64      `json:"-"`
 }
 
 //func (i IssueFields) ToTrelloEpicId(optionsList *[]TrelloCustomFieldOption) string {
@@ -58,15 +73,32 @@ type SprintLink struct {
 }
 
 /*
-TranslateEpicColour uses information from https://jira.atlassian.com/browse/JRACLOUD-59765 to translate an ID
-in the form ghx-label-nnn to a 'normal' colour name and returns it as a string.
-String can be empty if EpicColour is not set.
+DefaultEpicColours uses information from https://jira.atlassian.com/browse/JRACLOUD-59765 to map the number in a
+ghx-label-nnn epic colour to a 'normal' Trello colour name
 */
-func (i IssueFields) TranslateEpicColour() string {
-	/*
-		pink , yellow , lime , blue , black , orange , red , purple , sky , green
-	*/
+var DefaultEpicColours = map[string]string{
+	"1":  "black",
+	"2":  "yellow",
+	"3":  "yellow",
+	"4":  "blue",
+	"5":  "lime",
+	"6":  "lime",
+	"7":  "purple",
+	"8":  "purple",
+	"9":  "pink",
+	"10": "sky",
+	"11": "sky",
+	"12": "black",
+	"13": "green",
+	"14": "orange",
+}
 
+/*
+TranslateEpicColour translates an ID in the form ghx-label-nnn to a 'normal' colour name and returns it as a string.
+colours maps the number to a colour and takes precedence over DefaultEpicColours; it can be nil.
+String can be empty if EpicColour is not set.
+*/
+func (i IssueFields) TranslateEpicColour(colours map[string]string) string {
 	if i.EpicColour == nil {
 		return ""
 	} else {
@@ -75,40 +107,12 @@ func (i IssueFields) TranslateEpicColour() string {
 		if parts == nil {
 			log.Printf("ERROR Can't translate epic colour '%s' as the format is not ghx-label-nnn", *i.EpicColour)
 			return ""
-		} else {
-			switch parts[0][1] { //the extracted number
-			case "1":
-				return "black"
-			case "2":
-				return "yellow"
-			case "14":
-				return "orange"
-			case "8":
-				return "purple"
-			case "4":
-				return "blue"
-			case "5":
-				return "lime"
-			case "13":
-				return "green"
-			case "12":
-				return "black"
-			case "3":
-				return "yellow"
-			case "9":
-				return "pink"
-			case "7":
-				return "purple"
-			case "10":
-				return "sky"
-			case "11":
-				return "sky"
-			case "6":
-				return "lime"
-			default:
-				return ""
-			}
 		}
+		number := parts[0][1]
+		if colour, haveColour := colours[number]; haveColour {
+			return colour
+		}
+		return DefaultEpicColours[number]
 	}
 }
 
@@ -130,26 +134,45 @@ type IssuePriority struct {
 }
 
 /*
-ToTrelloLabel will return the corresponding ID within the given TrelloCustomFieldOption list for the priority
-contained in this object
+DefaultPriorityNames maps the IDs of Jira's built-in priorities to the text of the corresponding Trello option
 */
-func (i IssuePriority) ToTrelloLabel(optionsList *[]TrelloCustomFieldOption) (string, error) {
-	stringName := func() string {
-		switch i.Id {
-		case "1":
-			return "Highest"
-		case "2":
-			return "High"
-		case "3":
-			return "Medium"
-		case "4":
-			return "Low"
-		case "5":
-			return "Lowest"
-		default:
-			return "Not sure"
+var DefaultPriorityNames = map[string]string{
+	"1": "Highest",
+	"2": "High",
+	"3": "Medium",
+	"4": "Low",
+	"5": "Lowest",
+}
+
+/*
+OptionName returns the text of the Trello option for this priority. priorityNames maps Jira priority IDs or names to
+option text and takes precedence over DefaultPriorityNames; it can be nil. If neither has an entry then the Jira
+name of the priority is used.
+*/
+func (i IssuePriority) OptionName(priorityNames map[string]string) string {
+	if name, haveName := priorityNames[i.Id]; haveName {
+		return name
+	}
+	for jiraName, name := range priorityNames {
+		if strings.EqualFold(jiraName, i.Name) {
+			return name
 		}
-	}()
+	}
+	if name, haveName := DefaultPriorityNames[i.Id]; haveName {
+		return name
+	}
+	if i.Name != "" {
+		return i.Name
+	}
+	return "Not sure"
+}
+
+/*
+ToTrelloLabel will return the corresponding ID within the given TrelloCustomFieldOption list for the priority
+contained in this object, using OptionName to find the option's text
+*/
+func (i IssuePriority) ToTrelloLabel(priorityNames map[string]string, optionsList *[]TrelloCustomFieldOption) (string, error) {
+	stringName := i.OptionName(priorityNames)
 
 	for _, opt := range *optionsList {
 		if opt.Value.Text == stringName {
@@ -160,10 +183,33 @@ func (i IssuePriority) ToTrelloLabel(optionsList *[]TrelloCustomFieldOption) (st
 }
 
 type IssueStatus struct {
-	Self        string `json:"self"`
-	Description string `json:"description"`
-	Name        string `json:"name"`
-	Id          string `json:"id"`
+	Self           string         `json:"self"`
+	Description    string         `json:"description"`
+	Name           string         `json:"name"`
+	Id             string         `json:"id"`
+	StatusCategory StatusCategory `json:"statusCategory"`
+}
+
+/*
+StatusCategory is the broad grouping that Jira puts every status into. Key is one of "new", "indeterminate" or "done"
+*/
+type StatusCategory struct {
+	Self      string `json:"self"`
+	Id        int64  `json:"id"`
+	Key       string `json:"key"`
+	Name      string `json:"name"`
+	ColorName string `json:"colorName"`
+}
+
+/*
+IsDone returns true if the status is in Jira's "done" category. Statuses without category information fall back to
+checking for the name "Done"
+*/
+func (s IssueStatus) IsDone() bool {
+	if s.StatusCategory.Key != "" {
+		return s.StatusCategory.Key == "done"
+	}
+	return s.Name == "Done"
 }
 
 type JiraUser struct {
@@ -173,6 +219,22 @@ type JiraUser struct {
 	DisplayName  string `json:"displayName"`
 }
 
+/*
+IssueWatches is the summary of who is watching an issue, as returned with the issue itself.
+The watchers themselves have to be loaded separately with LoadWatchers
+*/
+type IssueWatches struct {
+	Self       string `json:"self"`
+	WatchCount int64  `json:"watchCount"`
+	IsWatching bool   `json:"isWatching"`
+}
+
+type IssueWatchers struct {
+	Self       string     `json:"self"`
+	WatchCount int64      `json:"watchCount"`
+	Watchers   []JiraUser `json:"watchers"`
+}
+
 type IssueType struct {
 	Self           string `json:"self"`
 	Id             string `json:"id"`
@@ -184,20 +246,35 @@ type IssueType struct {
 	HierarchyLevel int64  `json:"hierarchyLevel"`
 }
 
+/*
+JiraContent is the top-level "doc" node of an Atlassian Document Format (ADF) document, as used for issue
+descriptions and comment bodies. See https://developer.atlassian.com/cloud/jira/platform/apis/document/structure/
+*/
 type JiraContent struct {
-	Version int32              `json:"version"`
-	Type    string             `json:"type"`
-	Content []JiraContentBlock `json:"content"`
+	Version int32     `json:"version"`
+	Type    string    `json:"type"`
+	Content []AdfNode `json:"content"`
 }
 
-type JiraContentBlock struct {
-	Type    string            `json:"type"`
-	Content []JiraContentLine `json:"content"`
+/*
+AdfNode is a single node in an ADF document. Block nodes (paragraph, bulletList, table etc.) carry child nodes in
+Content, inline "text" nodes carry Text and any formatting in Marks. Attrs varies by node type, e.g. "level" for
+headings or "language" for code blocks.
+*/
+type AdfNode struct {
+	Type    string                 `json:"type"`
+	Text    string                 `json:"text,omitempty"`
+	Attrs   map[string]interface{} `json:"attrs,omitempty"`
+	Marks   []AdfMark              `json:"marks,omitempty"`
+	Content []AdfNode              `json:"content,omitempty"`
 }
 
-type JiraContentLine struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+/*
+AdfMark is formatting applied to an inline text node, e.g. "strong", "em" or "link" (which has an "href" attribute)
+*/
+type AdfMark struct {
+	Type  string                 `json:"type"`
+	Attrs map[string]interface{} `json:"attrs,omitempty"`
 }
 
 type PageOfComments struct {
@@ -215,3 +292,25 @@ type Comment struct {
 	Created string      `json:"created"`
 	Updated string      `json:"updated"`
 }
+
+/*
+CreatedIssue is what Jira returns when an issue is created
+*/
+type CreatedIssue struct {
+	Id   string `json:"id"`
+	Key  string `json:"key"`
+	Self string `json:"self"`
+}
+
+/*
+IssueTransition is a workflow transition that can be made from an issue's current status
+*/
+type IssueTransition struct {
+	Id   string      `json:"id"`
+	Name string      `json:"name"`
+	To   IssueStatus `json:"to"` //the status the issue ends up in
+}
+
+type IssueTransitions struct {
+	Transitions []IssueTransition `json:"transitions"`
+}
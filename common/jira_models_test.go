@@ -120,3 +120,33 @@ func TestComment(t *testing.T) {
 		t.Errorf("Could not unmarshal test data: %s", err)
 	}
 }
+
+func TestPriorityOptionName(t *testing.T) {
+	high := IssuePriority{Id: "2", Name: "High"}
+	if name := high.OptionName(nil); name != "High" {
+		t.Errorf("expected the default name for priority 2, got '%s'", name)
+	}
+	if name := high.OptionName(map[string]string{"2": "P2"}); name != "P2" {
+		t.Errorf("expected a mapping by ID to win, got '%s'", name)
+	}
+	if name := high.OptionName(map[string]string{"high": "Urgent"}); name != "Urgent" {
+		t.Errorf("expected a mapping by name to win, got '%s'", name)
+	}
+	custom := IssuePriority{Id: "10000", Name: "Blocker"}
+	if name := custom.OptionName(nil); name != "Blocker" {
+		t.Errorf("expected an unmapped priority to keep its Jira name, got '%s'", name)
+	}
+}
+
+func TestTranslateEpicColour(t *testing.T) {
+	fields := IssueFields{EpicColour: StringPtr("ghx-label-9")}
+	if colour := fields.TranslateEpicColour(nil); colour != "pink" {
+		t.Errorf("expected the default colour, got '%s'", colour)
+	}
+	if colour := fields.TranslateEpicColour(map[string]string{"9": "red"}); colour != "red" {
+		t.Errorf("expected the configured colour, got '%s'", colour)
+	}
+	if colour := (IssueFields{}).TranslateEpicColour(nil); colour != "" {
+		t.Errorf("expected no colour without an epic colour, got '%s'", colour)
+	}
+}
@@ -0,0 +1,521 @@
+package common
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	markdownHeading   = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownListItem  = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	markdownTaskItem  = regexp.MustCompile(`^\[([ xX])\]\s+(.*)$`)
+	markdownTableRule = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+/*
+MarkdownToAdf converts Trello-flavoured Markdown into an ADF document, so that it can be used as an issue description
+or comment body. It understands the same constructs that ToMarkdown writes: headings, bullet, numbered and task
+lists (including nested ones), emphasis, links, code, quotes, rules and tables. Anything else is kept as plain text.
+*/
+func MarkdownToAdf(markdown string) *JiraContent {
+	p := &markdownParser{}
+	lines := strings.Split(strings.ReplaceAll(markdown, "\r\n", "\n"), "\n")
+	return &JiraContent{
+		Version: 1,
+		Type:    "doc",
+		Content: p.parseBlocks(lines),
+	}
+}
+
+/*
+markdownParser holds the state needed while converting one document. Task lists and their items must each have a
+localId that is unique within the document.
+*/
+type markdownParser struct {
+	nextLocalId int
+}
+
+func (p *markdownParser) localId() string {
+	p.nextLocalId++
+	return strconv.Itoa(p.nextLocalId)
+}
+
+func isMarkdownRule(line string) bool {
+	compact := strings.ReplaceAll(strings.TrimSpace(line), " ", "")
+	if len(compact) < 3 || strings.Trim(compact, string(compact[0])) != "" {
+		return false
+	}
+	return compact[0] == '-' || compact[0] == '*' || compact[0] == '_'
+}
+
+func isMarkdownFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+func isMarkdownTableStart(lines []string, i int) bool {
+	return strings.HasPrefix(strings.TrimSpace(lines[i]), "|") && i+1 < len(lines) && markdownTableRule.MatchString(strings.TrimSpace(lines[i+1]))
+}
+
+/*
+startsBlock returns true if the line at i starts something other than a paragraph, which ends any paragraph before it
+*/
+func startsBlock(lines []string, i int) bool {
+	line := lines[i]
+	return markdownHeading.MatchString(line) || isMarkdownFence(line) || strings.HasPrefix(strings.TrimSpace(line), ">") ||
+		isMarkdownRule(line) || markdownListItem.MatchString(line) || isMarkdownTableStart(lines, i)
+}
+
+func (p *markdownParser) parseBlocks(lines []string) []AdfNode {
+	blocks := make([]AdfNode, 0)
+	i := 0
+	for i < len(lines) {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			i++
+		case isMarkdownFence(line):
+			block, next := p.parseCodeBlock(lines, i)
+			blocks = append(blocks, block)
+			i = next
+		case markdownHeading.MatchString(line):
+			match := markdownHeading.FindStringSubmatch(line)
+			blocks = append(blocks, AdfNode{
+				Type:    "heading",
+				Attrs:   map[string]interface{}{"level": len(match[1])},
+				Content: parseMarkdownInline(match[2]),
+			})
+			i++
+		case isMarkdownRule(line):
+			blocks = append(blocks, AdfNode{Type: "rule"})
+			i++
+		case strings.HasPrefix(trimmed, ">"):
+			quoted := make([]string, 0)
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				content := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(content, " "))
+				i++
+			}
+			blocks = append(blocks, AdfNode{Type: "blockquote", Content: p.parseBlocks(quoted)})
+		case isMarkdownTableStart(lines, i):
+			block, next := parseMarkdownTable(lines, i)
+			blocks = append(blocks, block)
+			i = next
+		case markdownListItem.MatchString(line):
+			block, next := p.parseList(lines, i)
+			blocks = append(blocks, block...)
+			i = next
+		default:
+			paragraph := make([]string, 0)
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(paragraph) == 0 || !startsBlock(lines, i)) {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+				i++
+			}
+			blocks = append(blocks, AdfNode{Type: "paragraph", Content: parseMarkdownLines(paragraph)})
+		}
+	}
+	return blocks
+}
+
+/*
+parseMarkdownLines converts lines of inline text, putting a hard break between each one as Trello does
+*/
+func parseMarkdownLines(lines []string) []AdfNode {
+	nodes := make([]AdfNode, 0)
+	for i, line := range lines {
+		if i > 0 {
+			nodes = append(nodes, AdfNode{Type: "hardBreak"})
+		}
+		nodes = append(nodes, parseMarkdownInline(line)...)
+	}
+	return nodes
+}
+
+func (p *markdownParser) parseCodeBlock(lines []string, start int) (AdfNode, int) {
+	language := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[start]), "```"))
+	code := make([]string, 0)
+	i := start + 1
+	for i < len(lines) && !isMarkdownFence(lines[i]) {
+		code = append(code, lines[i])
+		i++
+	}
+	block := AdfNode{Type: "codeBlock"}
+	if language != "" {
+		block.Attrs = map[string]interface{}{"language": language}
+	}
+	if text := strings.Join(code, "\n"); text != "" {
+		block.Content = []AdfNode{{Type: "text", Text: text}}
+	}
+	return block, i + 1 //skip the closing fence
+}
+
+/*
+splitTableRow splits a table row into the text of each cell, allowing for escaped pipes inside cells
+*/
+func splitTableRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+	cells := make([]string, 0)
+	var current strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '|' {
+			current.WriteByte('|')
+			i++
+		} else if line[i] == '|' {
+			cells = append(cells, strings.TrimSpace(current.String()))
+			current.Reset()
+		} else {
+			current.WriteByte(line[i])
+		}
+	}
+	return append(cells, strings.TrimSpace(current.String()))
+}
+
+func parseMarkdownTable(lines []string, start int) (AdfNode, int) {
+	table := AdfNode{Type: "table"}
+	i := start
+	for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|") {
+		if i == start+1 { //the rule under the header row
+			i++
+			continue
+		}
+		cellType := "tableCell"
+		if i == start {
+			cellType = "tableHeader"
+		}
+		row := AdfNode{Type: "tableRow"}
+		for _, cell := range splitTableRow(lines[i]) {
+			paragraph := AdfNode{Type: "paragraph", Content: parseMarkdownInline(cell)}
+			row.Content = append(row.Content, AdfNode{Type: cellType, Content: []AdfNode{paragraph}})
+		}
+		table.Content = append(table.Content, row)
+		i++
+	}
+	return table, i
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+/*
+parseList reads the list starting at the given line, along with anything nested under its items. A list whose first
+item is a task ("- [ ] something") becomes a task list. Returns the list and the index of the line after it.
+*/
+func (p *markdownParser) parseList(lines []string, start int) ([]AdfNode, int) {
+	first := markdownListItem.FindStringSubmatch(lines[start])
+	indent := len(first[1])
+	ordered := first[2] != "-" && first[2] != "*" && first[2] != "+"
+	isTasks := !ordered && markdownTaskItem.MatchString(first[3])
+
+	list := AdfNode{Type: "bulletList"}
+	if ordered {
+		list.Type = "orderedList"
+		if order, _ := strconv.Atoi(strings.TrimRight(first[2], ".)")); order > 1 {
+			list.Attrs = map[string]interface{}{"order": order}
+		}
+	} else if isTasks {
+		list.Type = "taskList"
+		list.Attrs = map[string]interface{}{"localId": p.localId()}
+	}
+
+	i := start
+	for i < len(lines) {
+		match := markdownListItem.FindStringSubmatch(lines[i])
+		if match == nil || len(match[1]) != indent {
+			break
+		}
+		if (match[2] != "-" && match[2] != "*" && match[2] != "+") != ordered {
+			break
+		}
+		//everything indented further than the marker belongs to this item, including blank lines between such parts
+		itemLines := []string{match[3]}
+		i++
+		for i < len(lines) {
+			if strings.TrimSpace(lines[i]) == "" {
+				if i+1 < len(lines) && strings.TrimSpace(lines[i+1]) != "" && indentOf(lines[i+1]) > indent {
+					itemLines = append(itemLines, "")
+					i++
+					continue
+				}
+				break
+			}
+			if indentOf(lines[i]) <= indent {
+				break
+			}
+			itemLines = append(itemLines, dedent(lines[i], indent+len(match[2])+1))
+			i++
+		}
+
+		if isTasks {
+			list.Content = append(list.Content, p.taskItems(itemLines)...)
+		} else {
+			list.Content = append(list.Content, AdfNode{Type: "listItem", Content: p.parseBlocks(itemLines)})
+		}
+	}
+	return []AdfNode{list}, i
+}
+
+/*
+dedent removes up to the given number of leading spaces from the line
+*/
+func dedent(line string, count int) string {
+	for count > 0 && len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+		line = line[1:]
+		count--
+	}
+	return line
+}
+
+/*
+taskItems converts a task list item into ADF. A task item can only hold inline content, so a nested task list goes
+alongside it in the parent list and anything else nested under it is added to its text.
+*/
+func (p *markdownParser) taskItems(itemLines []string) []AdfNode {
+	item := AdfNode{Type: "taskItem", Attrs: map[string]interface{}{"localId": p.localId(), "state": "TODO"}}
+	text := itemLines[0]
+	if match := markdownTaskItem.FindStringSubmatch(text); match != nil {
+		if match[1] != " " {
+			item.Attrs["state"] = "DONE"
+		}
+		text = match[2]
+	}
+	item.Content = parseMarkdownInline(text)
+
+	out := []AdfNode{item}
+	for _, nested := range p.parseBlocks(itemLines[1:]) {
+		if nested.Type == "taskList" {
+			out = append(out, nested)
+			continue
+		}
+		if rendered := renderAdfBlock(&nested); rendered != "" {
+			out[0].Content = append(out[0].Content, AdfNode{Type: "hardBreak"}, AdfNode{Type: "text", Text: rendered})
+		}
+	}
+	return out
+}
+
+/*
+inlineMarkers are the Markdown emphasis markers and the ADF mark that each one becomes, longest first so that "**"
+is not taken for two "*"
+*/
+var inlineMarkers = []struct {
+	marker string
+	mark   string
+}{
+	{"**", "strong"},
+	{"__", "strong"},
+	{"~~", "strike"},
+	{"*", "em"},
+	{"_", "em"},
+}
+
+func isWordChar(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+/*
+findClosing returns the index of the marker that closes one opened just before `from`, or -1 if there isn't one.
+The emphasised text can't start or end with a space, and underscores inside words don't count, e.g. in snake_case.
+*/
+func findClosing(text string, from int, marker string) int {
+	if from >= len(text) || text[from] == ' ' {
+		return -1
+	}
+	for i := from + 1; i+len(marker) <= len(text); i++ {
+		if text[i] == '\\' || text[i] == '`' {
+			//skip over escapes and code spans, which can't contain the closing marker
+			if text[i] == '\\' {
+				i++
+			} else if end := strings.IndexByte(text[i+1:], '`'); end >= 0 {
+				i += end + 1
+			}
+			continue
+		}
+		if !strings.HasPrefix(text[i:], marker) || text[i-1] == ' ' {
+			continue
+		}
+		after := i + len(marker)
+		if marker[0] == '_' && after < len(text) && isWordChar(text[after]) {
+			continue
+		}
+		if len(marker) == 1 && after < len(text) && text[after] == marker[0] {
+			//part of a longer run such as "**", which belongs to a different marker
+			i++
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+/*
+parseMarkdownInline converts a single line of Markdown into ADF text nodes with marks
+*/
+func parseMarkdownInline(text string) []AdfNode {
+	nodes := make([]AdfNode, 0)
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			nodes = appendText(nodes, AdfNode{Type: "text", Text: plain.String()})
+			plain.Reset()
+		}
+	}
+
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		if c == '\\' && i+1 < len(text) && strings.IndexByte("\\`*_~[]()#>|!-+.", text[i+1]) >= 0 {
+			plain.WriteByte(text[i+1])
+			i += 2
+			continue
+		}
+		if c == '`' {
+			if end := strings.IndexByte(text[i+1:], '`'); end > 0 {
+				flush()
+				nodes = appendText(nodes, AdfNode{Type: "text", Text: text[i+1 : i+1+end], Marks: []AdfMark{{Type: "code"}}})
+				i += end + 2
+				continue
+			}
+		}
+		if c == '[' || (c == '!' && i+1 < len(text) && text[i+1] == '[') {
+			if label, target, length := parseMarkdownLink(text[i:]); length > 0 {
+				flush()
+				if label == "" {
+					label = target
+				}
+				for _, n := range parseMarkdownInline(label) {
+					nodes = appendText(nodes, withMark(n, AdfMark{Type: "link", Attrs: map[string]interface{}{"href": target}}))
+				}
+				i += length
+				continue
+			}
+		}
+		if strings.HasPrefix(text[i:], "http://") || strings.HasPrefix(text[i:], "https://") {
+			end := strings.IndexAny(text[i:], " \t<>")
+			if end < 0 {
+				end = len(text) - i
+			}
+			url := strings.TrimRight(text[i:i+end], ".,;:!?)")
+			flush()
+			nodes = appendText(nodes, AdfNode{Type: "text", Text: url, Marks: []AdfMark{{Type: "link", Attrs: map[string]interface{}{"href": url}}}})
+			i += len(url)
+			continue
+		}
+
+		matched := false
+		for _, m := range inlineMarkers {
+			if !strings.HasPrefix(text[i:], m.marker) {
+				continue
+			}
+			if m.marker[0] == '_' && i > 0 && isWordChar(text[i-1]) {
+				break
+			}
+			start := i + len(m.marker)
+			end := findClosing(text, start, m.marker)
+			if end < 0 {
+				break
+			}
+			flush()
+			for _, n := range parseMarkdownInline(text[start:end]) {
+				nodes = appendText(nodes, withMark(n, AdfMark{Type: m.mark}))
+			}
+			i = end + len(m.marker)
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+		plain.WriteByte(c)
+		i++
+	}
+	flush()
+	return nodes
+}
+
+/*
+parseMarkdownLink reads a link such as "[label](https://example.com)" or an image "![alt](url)" from the start of
+text, returning the label, the target and how many bytes it took up. The length is 0 if text does not start with one.
+*/
+func parseMarkdownLink(text string) (string, string, int) {
+	offset := 0
+	if strings.HasPrefix(text, "!") {
+		offset = 1
+	}
+	depth := 0
+	for i := offset; i < len(text); i++ {
+		switch text[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				if i+1 >= len(text) || text[i+1] != '(' {
+					return "", "", 0
+				}
+				end := strings.IndexByte(text[i+2:], ')')
+				if end < 0 {
+					return "", "", 0
+				}
+				target := strings.TrimSpace(text[i+2 : i+2+end])
+				if target == "" {
+					return "", "", 0
+				}
+				return text[offset+1 : i], target, i + 3 + end
+			}
+		}
+	}
+	return "", "", 0
+}
+
+/*
+withMark adds a mark to a text node. ADF does not allow code to be combined with anything other than a link, so
+other marks are dropped from code.
+*/
+func withMark(node AdfNode, mark AdfMark) AdfNode {
+	if node.Type != "text" {
+		return node
+	}
+	for _, existing := range node.Marks {
+		if existing.Type == "code" && mark.Type != "link" {
+			return node
+		}
+	}
+	node.Marks = append(append([]AdfMark{}, node.Marks...), mark)
+	return node
+}
+
+/*
+appendText adds a node to the list, merging it into the previous one if both are plain text with the same marks.
+Empty text nodes are not allowed in ADF, so they are dropped.
+*/
+func appendText(nodes []AdfNode, node AdfNode) []AdfNode {
+	if node.Type == "text" && node.Text == "" {
+		return nodes
+	}
+	if len(nodes) > 0 {
+		last := &nodes[len(nodes)-1]
+		if last.Type == "text" && node.Type == "text" && sameMarks(last.Marks, node.Marks) {
+			last.Text += node.Text
+			return nodes
+		}
+	}
+	return append(nodes, node)
+}
+
+func sameMarks(a []AdfMark, b []AdfMark) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || attrString(a[i].Attrs, "href") != attrString(b[i].Attrs, "href") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+/*
+TestMarkdownToAdfRoundTrip checks that Markdown written by ToMarkdown comes back unchanged after converting it to ADF
+*/
+func TestMarkdownToAdfRoundTrip(t *testing.T) {
+	docs := []string{
+		"Open [the project](https://example.com/p/1) and check the **bold** and *italic* bits in `config.yaml`",
+		"## Steps\n\n1. first\n   - nested\n2. second",
+		"```go\nfmt.Println(\"hi\")\n```\n\n> quoted\n>\n> twice\n\n| Name | Value |\n| --- | --- |\n| a\\|b | 1 |",
+		"- [ ] to do\n- [x] done\n  - [ ] nested",
+		"first line\nsecond line with ~~strike~~\n\n---\n\n3. third\n4. fourth",
+		"snake_case_name and 2 * 3 * 4 stay as they are",
+	}
+	for _, markdown := range docs {
+		result := MarkdownToAdf(markdown).ToMarkdown()
+		if result != markdown {
+			t.Errorf("Got '%s', expected '%s'", result, markdown)
+		}
+	}
+}
+
+func TestMarkdownToAdfStructure(t *testing.T) {
+	content := MarkdownToAdf("# Title\n\nSee https://example.com/x. Or ![diagram](https://example.com/d.png)\n\n- [x] shipped")
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":1,"type":"doc","content":[` +
+		`{"type":"heading","attrs":{"level":1},"content":[{"type":"text","text":"Title"}]},` +
+		`{"type":"paragraph","content":[{"type":"text","text":"See "},{"type":"text","text":"https://example.com/x","marks":[{"type":"link","attrs":{"href":"https://example.com/x"}}]},` +
+		`{"type":"text","text":". Or "},{"type":"text","text":"diagram","marks":[{"type":"link","attrs":{"href":"https://example.com/d.png"}}]}]},` +
+		`{"type":"taskList","attrs":{"localId":"1"},"content":[{"type":"taskItem","attrs":{"localId":"2","state":"DONE"},"content":[{"type":"text","text":"shipped"}]}]}]}`
+	if string(encoded) != expected {
+		t.Errorf("Got %s, expected %s", string(encoded), expected)
+	}
+
+	if empty := MarkdownToAdf(""); len(empty.Content) != 0 {
+		t.Errorf("expected an empty document, got %v", empty.Content)
+	}
+}
@@ -0,0 +1,249 @@
+package common
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const DefaultIssueJql = "issueType in (Bug,Task,Story,Subtask)"
+const DefaultEpicJql = "issueType=Epic"
+
+type JiraConfig struct {
+	Host        string           `yaml:"host"`        //host name, or full base URL if it is not https
+	Credentials string           `yaml:"credentials"` //path to a ScriptKey file
+	PageSize    int              `yaml:"pageSize"`
+	IssueJql    string           `yaml:"issueJql"` //which issues to migrate
+	EpicJql     string           `yaml:"epicJql"`  //which issues are epics
+	Timezone    string           `yaml:"timezone"` //timezone of the Jira account, which JQL dates are interpreted in
+	Fields      JiraFieldMapping `yaml:"fields"`   //names or IDs of the Jira fields for epics, sprints and story points
+}
+
+type TrelloConfig struct {
+	Credentials string `yaml:"credentials"` //path to a ScriptKey file
+	Board       string `yaml:"board"`       //board ID
+}
+
+/*
+ListMapping says which Trello list each issue should go into, based on its Jira status. See the listmap example
+in load-issues for the meaning of each field.
+*/
+type ListMapping struct {
+	Fallback      string            `yaml:"fallback"`
+	CreateMissing bool              `yaml:"createMissing"`
+	Done          string            `yaml:"done"` //skip, archive or import
+	Statuses      map[string]string `yaml:"statuses"`
+	Categories    map[string]string `yaml:"categories"`
+}
+
+/*
+FieldNames are the names of the Trello custom fields that Jira data is put into. Empty estimate field names mean
+those values are not migrated.
+*/
+type FieldNames struct {
+	Epic              string `yaml:"epic"`
+	JiraKey           string `yaml:"jiraKey"`
+	Priority          string `yaml:"priority"`
+	Sprint            string `yaml:"sprint"`
+	OriginalEstimate  string `yaml:"originalEstimate"`
+	RemainingEstimate string `yaml:"remainingEstimate"`
+	StoryPoints       string `yaml:"storyPoints"`
+}
+
+/*
+MigrationConfig holds the settings shared by load-issues and load-epics. It is read from a YAML file given with
+-config, and any flag given on the command line overrides the value from the file.
+*/
+type MigrationConfig struct {
+	Jira         JiraConfig           `yaml:"jira"`
+	Trello       TrelloConfig         `yaml:"trello"`
+	Lists        ListMapping          `yaml:"lists"`
+	Fields       FieldNames           `yaml:"fields"`
+	Priorities   map[string]string    `yaml:"priorities"`   //Jira priority ID or name to Trello option text, over DefaultPriorityNames
+	EpicColours  map[string]string    `yaml:"epicColours"`  //ghx-label number to Trello colour, over DefaultEpicColours
+	LabelColours map[string]string    `yaml:"labelColours"` //Jira label to the Trello colour to create it with
+	CustomFields []CustomFieldMapping `yaml:"customFields"` //any other Jira fields to copy into Trello custom fields
+}
+
+/*
+DefaultMigrationConfig returns the settings used when there is no config file
+*/
+func DefaultMigrationConfig() *MigrationConfig {
+	return &MigrationConfig{
+		Jira: JiraConfig{
+			Credentials: "scriptkey.yaml",
+			PageSize:    50,
+			IssueJql:    DefaultIssueJql,
+			EpicJql:     DefaultEpicJql,
+			Timezone:    "UTC",
+			Fields:      DefaultJiraFieldMapping(),
+		},
+		Trello: TrelloConfig{
+			Credentials: "trellokey.yaml",
+		},
+		Lists: ListMapping{
+			Done: "skip",
+		},
+		Fields: FieldNames{
+			Epic:     "Components",
+			JiraKey:  "Jira Key",
+			Priority: "Priority",
+			Sprint:   "Sprint",
+		},
+	}
+}
+
+/*
+LoadFile reads the YAML file at the given path over the current settings, so anything not in the file is left alone.
+Unknown keys are an error, to catch typos.
+*/
+func (c *MigrationConfig) LoadFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = yaml.UnmarshalStrict(content, c)
+	if err != nil {
+		return errors.New(fmt.Sprintf("could not read config file '%s': %s", path, err))
+	}
+	return nil
+}
+
+/*
+ParseFlagsWithConfig parses the command line. If the flag pointed to by configPath was given, the config file is
+loaded over cfg and the command line is parsed again, so that flags given explicitly override the file.
+Flags that map to config settings should be bound directly to the fields of cfg.
+*/
+func ParseFlagsWithConfig(cfg *MigrationConfig, configPath *string) error {
+	flag.Parse()
+	if *configPath == "" {
+		return nil
+	}
+	err := cfg.LoadFile(*configPath)
+	if err != nil {
+		return err
+	}
+	flag.Parse()
+	return nil
+}
+
+/*
+Validate checks the settings that every tool needs, returning an error listing everything that is wrong
+*/
+func (c *MigrationConfig) Validate() error {
+	return c.validate(true)
+}
+
+/*
+ValidateOffline is like Validate, but does not require a Jira host or credentials. It is for tools that are reading
+Jira data from a snapshot rather than the live API.
+*/
+func (c *MigrationConfig) ValidateOffline() error {
+	return c.validate(false)
+}
+
+/*
+settingName returns how to refer to a setting in an error: its config key, followed by the flag that is bound to it
+if the running command has one. Each command only defines flags for the settings it uses, so this stops users being
+told to use a flag that they don't have.
+*/
+func settingName(key string, setting interface{}) string {
+	target := reflect.ValueOf(setting).Pointer()
+	name := key
+	flag.VisitAll(func(f *flag.Flag) {
+		if value := reflect.ValueOf(f.Value); value.Kind() == reflect.Ptr && value.Pointer() == target {
+			name = fmt.Sprintf("%s (-%s)", key, f.Name)
+		}
+	})
+	return name
+}
+
+func (c *MigrationConfig) validate(needJira bool) error {
+	problems := make([]string, 0)
+	if needJira && c.Jira.Host == "" {
+		problems = append(problems, settingName("jira.host", &c.Jira.Host)+" is required")
+	}
+	if needJira && c.Jira.Credentials == "" {
+		problems = append(problems, settingName("jira.credentials", &c.Jira.Credentials)+" is required")
+	}
+	if c.Jira.PageSize <= 0 {
+		problems = append(problems, fmt.Sprintf("%s must be more than 0, not %d", settingName("jira.pageSize", &c.Jira.PageSize), c.Jira.PageSize))
+	}
+	if c.Jira.IssueJql == "" {
+		problems = append(problems, settingName("jira.issueJql", &c.Jira.IssueJql)+" can't be empty")
+	}
+	if c.Jira.EpicJql == "" {
+		problems = append(problems, settingName("jira.epicJql", &c.Jira.EpicJql)+" can't be empty")
+	}
+	if _, err := time.LoadLocation(c.Jira.Timezone); err != nil {
+		problems = append(problems, fmt.Sprintf("%s '%s' is not a valid timezone", settingName("jira.timezone", &c.Jira.Timezone), c.Jira.Timezone))
+	}
+	if c.Trello.Credentials == "" {
+		problems = append(problems, settingName("trello.credentials", &c.Trello.Credentials)+" is required")
+	}
+	if c.Trello.Board == "" {
+		problems = append(problems, settingName("trello.board", &c.Trello.Board)+" is required")
+	}
+	switch c.Lists.Done {
+	case "", "skip", "archive", "import":
+	default:
+		problems = append(problems, fmt.Sprintf("lists.done must be one of skip, archive or import, not '%s'", c.Lists.Done))
+	}
+	if c.Fields.Epic == "" {
+		problems = append(problems, settingName("fields.epic", &c.Fields.Epic)+" can't be empty")
+	}
+	if c.Fields.JiraKey == "" {
+		problems = append(problems, settingName("fields.jiraKey", &c.Fields.JiraKey)+" can't be empty")
+	}
+	if c.Fields.Priority == "" {
+		problems = append(problems, settingName("fields.priority", &c.Fields.Priority)+" can't be empty")
+	}
+	for priority, name := range c.Priorities {
+		if name == "" {
+			problems = append(problems, fmt.Sprintf("priorities: no Trello option given for '%s'", priority))
+		}
+	}
+	for number, colour := range c.EpicColours {
+		if !IsValidTrelloColour(colour) {
+			problems = append(problems, fmt.Sprintf("epicColours: '%s' is not a valid Trello colour for ghx-label-%s", colour, number))
+		}
+	}
+	for label, colour := range c.LabelColours {
+		if !IsValidTrelloColour(colour) {
+			problems = append(problems, fmt.Sprintf("labelColours: '%s' is not a valid Trello colour for label '%s'", colour, label))
+		}
+	}
+	for role, nameOrId := range map[string]string{"epicLink": c.Jira.Fields.EpicLink, "epicName": c.Jira.Fields.EpicName, "epicColour": c.Jira.Fields.EpicColour, "sprint": c.Jira.Fields.Sprint, "storyPoints": c.Jira.Fields.StoryPoints} {
+		if nameOrId == "" {
+			problems = append(problems, fmt.Sprintf("jira.fields.%s can't be empty", role))
+		}
+	}
+	reserved := map[string]bool{c.Fields.Epic: true, c.Fields.JiraKey: true, c.Fields.Priority: true, c.Fields.Sprint: true,
+		c.Fields.OriginalEstimate: true, c.Fields.RemainingEstimate: true, c.Fields.StoryPoints: true}
+	mappedTo := make(map[string]bool, len(c.CustomFields))
+	for i, m := range c.CustomFields {
+		if m.Jira == "" || m.Trello == "" {
+			problems = append(problems, fmt.Sprintf("customFields[%d]: both jira and trello must be given", i))
+			continue
+		}
+		switch m.Type {
+		case "", Text, Number, Date, Checkbox, List:
+		default:
+			problems = append(problems, fmt.Sprintf("customFields[%d]: type must be one of text, number, date, checkbox or list, not '%s'", i, m.Type))
+		}
+		if reserved[m.Trello] || mappedTo[m.Trello] {
+			problems = append(problems, fmt.Sprintf("customFields[%d]: the Trello field '%s' is already in use", i, m.Trello))
+		}
+		mappedTo[m.Trello] = true
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "migrationconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMigrationConfigLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+jira:
+  host: example.atlassian.net
+  issueJql: project = PROJ
+trello:
+  board: board1
+lists:
+  fallback: Backlog
+  done: archive
+fields:
+  priority: Importance
+priorities:
+  "1": Critical
+epicColours:
+  "9": red
+`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	cfg := DefaultMigrationConfig()
+	if err := cfg.LoadFile(path); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cfg.Jira.IssueJql != "project = PROJ" || cfg.Lists.Done != "archive" || cfg.Fields.Priority != "Importance" {
+		t.Errorf("settings were not loaded: %+v", cfg)
+	}
+	if cfg.Jira.PageSize != 50 || cfg.Jira.EpicJql != DefaultEpicJql || cfg.Fields.JiraKey != "Jira Key" {
+		t.Errorf("settings not in the file should keep their defaults: %+v", cfg)
+	}
+}
+
+func TestMigrationConfigErrors(t *testing.T) {
+	path := writeConfig(t, "jira:\n  hostname: example.atlassian.net\n")
+	defer os.RemoveAll(filepath.Dir(path))
+	if err := DefaultMigrationConfig().LoadFile(path); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+
+	cfg := DefaultMigrationConfig()
+	cfg.Lists.Done = "delete"
+	cfg.EpicColours = map[string]string{"9": "mauve"}
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+	for _, expected := range []string{"jira.host", "trello.board", "lists.done", "mauve"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected the error to mention %s, got: %s", expected, err)
+		}
+	}
+}
+
+func TestMigrationConfigErrorsNameBoundFlags(t *testing.T) {
+	cfg := DefaultMigrationConfig()
+	flag.StringVar(&cfg.Trello.Board, "test-board", cfg.Trello.Board, "")
+	cfg.Jira.Timezone = "Mars/Olympus_Mons"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected the config to be invalid")
+	}
+	if !strings.Contains(err.Error(), "trello.board (-test-board) is required") {
+		t.Errorf("expected the error to name the flag bound to trello.board, got: %s", err)
+	}
+	if !strings.Contains(err.Error(), "jira.timezone 'Mars/Olympus_Mons'") {
+		t.Errorf("expected the error to name only the config key for jira.timezone, which has no flag, got: %s", err)
+	}
+}
@@ -0,0 +1,827 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+minJournalEntries is how long the journal has to get before it is folded into the state file. It must also have
+twice as many entries as there are issues, so that the cost of rewriting the file is spread over that many changes.
+*/
+const minJournalEntries = 1000
+
+/*
+MigrationDirection says which way a state file records a migration going. Each tool only accepts state files for its
+own direction, as the same fields mean different things in each.
+*/
+type MigrationDirection string
+
+const (
+	JiraToTrello MigrationDirection = "jira-to-trello" //load-issues, sync, verify and rollback, keyed by Jira key
+	TrelloToJira MigrationDirection = "trello-to-jira" //trello-to-jira, keyed by Trello card ID
+)
+
+/*
+MigrationStep identifies a single piece of work done when migrating an issue, so that it can be skipped on a re-run
+*/
+type MigrationStep string
+
+const (
+	StepCardCreated   MigrationStep = "card"
+	StepJiraKey       MigrationStep = "jirakey"
+	StepEpicLink      MigrationStep = "epic"
+	StepPriority      MigrationStep = "priority"
+	StepOriginComment MigrationStep = "origin-comment"
+	StepArchived      MigrationStep = "archived"
+	StepChecklist     MigrationStep = "checklist"
+	StepParentLink    MigrationStep = "parent-link"
+	StepSprint        MigrationStep = "sprint"
+	StepEstimates     MigrationStep = "estimates"
+	StepCustomFields  MigrationStep = "custom-fields"
+
+	//the parent's card links to the sub-task's card. It is done before StepParentLink, which is the other direction
+	StepParentBacklink MigrationStep = "parent-backlink"
+
+	//steps for TrelloToJira, whose state is keyed by card ID
+	StepIssueCreated MigrationStep = "issue"
+	StepStatus       MigrationStep = "status"
+)
+
+/*
+AttachmentStep returns the MigrationStep recording that the given attachment has been copied
+*/
+func AttachmentStep(attachmentId string) MigrationStep {
+	return MigrationStep("attachment:" + attachmentId)
+}
+
+/*
+CommentStep returns the MigrationStep recording that the given comment has been copied
+*/
+func CommentStep(commentId string) MigrationStep {
+	return MigrationStep("comment:" + commentId)
+}
+
+/*
+CheckItemStep returns the MigrationStep recording that the given sub-task has been added to its parent's checklist
+*/
+func CheckItemStep(subtaskKey string) MigrationStep {
+	return MigrationStep("checkitem:" + subtaskKey)
+}
+
+/*
+AttachmentOutcome records what was done with an attachment that was not uploaded to the card as normal
+*/
+type AttachmentOutcome struct {
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Action   string `json:"action"`
+	Url      string `json:"url,omitempty"` //what was attached to the card instead, if anything
+}
+
+/*
+MigratedCheckItem records the checklist item that a sub-task was added as, and whether it was last left ticked
+*/
+type MigratedCheckItem struct {
+	Id      string `json:"id"`
+	Checked bool   `json:"checked"`
+}
+
+/*
+IssueMigrationState records what has been done so far for a single item. For JiraToTrello that is a Jira issue and
+Key is its Jira key; for TrelloToJira it is a Trello card and Key is the card ID.
+*/
+type IssueMigrationState struct {
+	Key         string                       `json:"key"`
+	CardId      string                       `json:"cardId,omitempty"`
+	ShortUrl    string                       `json:"shortUrl,omitempty"`
+	ChecklistId string                       `json:"checklistId,omitempty"` //the sub-tasks checklist, if one was created
+	IssueKey    string                       `json:"issueKey,omitempty"`    //the issue that trello-to-jira created for the card
+	Steps       map[MigrationStep]bool       `json:"steps"`
+	Attachments map[string]AttachmentOutcome `json:"attachments,omitempty"` //by Jira attachment ID
+	CheckItems  map[string]MigratedCheckItem `json:"checkItems,omitempty"`  //by sub-task key
+	RunId       string                       `json:"runId,omitempty"`       //the run that created the card
+	Completed   bool                         `json:"completed"`
+	LastError   string                       `json:"lastError,omitempty"`
+	Updated     time.Time                    `json:"updated"`
+}
+
+/*
+UnmarshalJSON also accepts the "jiraKey" field that state files used before Key was renamed
+*/
+func (e *IssueMigrationState) UnmarshalJSON(content []byte) error {
+	type plainState IssueMigrationState
+	var withLegacy struct {
+		plainState
+		JiraKey string `json:"jiraKey"`
+	}
+	if err := json.Unmarshal(content, &withLegacy); err != nil {
+		return err
+	}
+	*e = IssueMigrationState(withLegacy.plainState)
+	if e.Key == "" {
+		e.Key = withLegacy.JiraKey
+	}
+	return nil
+}
+
+/*
+CreatedLabel is a board label that was created by a run
+*/
+type CreatedLabel struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+/*
+CreatedFieldOption is an option that a run added to a list custom field
+*/
+type CreatedFieldOption struct {
+	FieldId  string `json:"fieldId"`
+	OptionId string `json:"optionId"`
+	Text     string `json:"text"`
+}
+
+/*
+MigrationRun records the things a single run of load-issues created on the board, other than cards, so that the run
+can be rolled back. Cards record their own RunId.
+*/
+type MigrationRun struct {
+	Id           string               `json:"id"`
+	BoardId      string               `json:"boardId"`
+	Started      time.Time            `json:"started"`
+	Labels       []CreatedLabel       `json:"labels"`
+	FieldOptions []CreatedFieldOption `json:"fieldOptions"`
+	Incomplete   string               `json:"incomplete,omitempty"` //why the run stopped before it saw every issue
+}
+
+/*
+journalEntry is a single change to the state, as appended to the journal file. Only one of its fields is set.
+Issues and runs are written out whole, so replaying an entry twice does no harm.
+*/
+type journalEntry struct {
+	Direction MigrationDirection   `json:"direction,omitempty"`
+	Issue     *IssueMigrationState `json:"issue,omitempty"`
+	Forget    string               `json:"forget,omitempty"`
+	Run       *MigrationRun        `json:"run,omitempty"`
+	ForgetRun string               `json:"forgetRun,omitempty"`
+	LastSync  *time.Time           `json:"lastSync,omitempty"`
+}
+
+/*
+MigrationState is a persistent record of which Jira issues have been migrated to which Trello cards.
+It is kept as a JSON file, with every change appended to a journal file alongside it as it happens so that a crashed
+or failed run can be picked up where it left off. The journal is folded back into the JSON file once it gets long,
+and by Close. It is safe to use from multiple goroutines.
+*/
+type MigrationState struct {
+	path      string
+	recorded  bool //whether Direction has been written out yet
+	mutex     sync.Mutex
+	journal   *os.File                        //open for appending once something has changed
+	entries   int                             //the number of entries in the journal
+	replayed  int64                           //how much of the journal was read when loading, up to the last whole line
+	runId     string                          //the current run, if StartRun has been called
+	Direction MigrationDirection              `json:"direction"`
+	Issues    map[string]*IssueMigrationState `json:"issues"`
+	Runs      map[string]*MigrationRun        `json:"runs,omitempty"`
+	LastSync  *time.Time                      `json:"lastSync,omitempty"` //when the last run that had no failures started
+}
+
+/*
+LoadMigrationState reads in the state file at the given path, along with any changes in its journal that have not
+been folded into it yet. If neither exists then an empty state is returned, which will be written to the path when
+it is first updated. Nothing is written when loading, so a state that a run is still using can be read safely.
+An error is returned if the state records a migration in the other direction.
+*/
+func LoadMigrationState(path string, direction MigrationDirection) (*MigrationState, error) {
+	state := &MigrationState{
+		path:   path,
+		Issues: make(map[string]*IssueMigrationState),
+		Runs:   make(map[string]*MigrationRun),
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	haveFile := err == nil
+	if haveFile {
+		err = json.Unmarshal(content, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if state.Runs == nil {
+		state.Runs = make(map[string]*MigrationRun)
+	}
+	if state.Issues == nil {
+		state.Issues = make(map[string]*IssueMigrationState)
+	}
+
+	haveJournal, err := state.replayJournal()
+	if err != nil {
+		return nil, err
+	}
+	if !haveFile && !haveJournal {
+		log.Printf("INFO No existing migration state at '%s', starting afresh", path)
+		state.Direction = direction
+		return state, nil
+	}
+	for k, s := range state.Issues {
+		if s.Steps == nil {
+			state.Issues[k].Steps = make(map[MigrationStep]bool)
+		}
+		if s.Key == "" {
+			s.Key = k
+		}
+	}
+	if state.Direction == "" {
+		state.Direction = state.guessDirection()
+	} else {
+		state.recorded = true
+	}
+	if state.Direction != "" && state.Direction != direction {
+		return nil, errors.New(fmt.Sprintf("'%s' records a %s migration, not %s", path, state.Direction, direction))
+	}
+	state.Direction = direction
+	log.Printf("INFO Loaded migration state for %d issues from '%s'", len(state.Issues), path)
+	return state, nil
+}
+
+/*
+guessDirection works out the direction of a state written before it was recorded, from what has been done.
+Returns an empty string if nothing has been done yet.
+*/
+func (s *MigrationState) guessDirection() MigrationDirection {
+	for _, entry := range s.Issues {
+		if entry.Steps[StepIssueCreated] || entry.IssueKey != "" {
+			return TrelloToJira
+		}
+		if entry.Steps[StepCardCreated] {
+			return JiraToTrello
+		}
+	}
+	return ""
+}
+
+func (s *MigrationState) journalPath() string {
+	return s.path + ".journal"
+}
+
+/*
+replayJournal applies the changes in the journal file, if there is one, on top of what was read from the state file.
+A last line that is cut short is ignored, as that is what a crash part-way through a write leaves behind.
+*/
+func (s *MigrationState) replayJournal() (bool, error) {
+	content, err := ioutil.ReadFile(s.journalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	for n, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			//blank lines stay in the journal, so they count towards where the next change is written
+			if bytes.HasSuffix(line, []byte("\n")) {
+				s.replayed += int64(len(line))
+			}
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil || line[len(line)-1] != '\n' {
+			if n == len(lines)-1 {
+				log.Printf("WARNING Ignoring an incomplete change at the end of '%s'", s.journalPath())
+				break
+			}
+			return true, errors.New(fmt.Sprintf("line %d of '%s' is not valid: %s", n+1, s.journalPath(), err))
+		}
+		s.applyLocked(&entry)
+		s.entries++
+		s.replayed += int64(len(line))
+	}
+	return true, nil
+}
+
+/*
+applyLocked makes the change recorded in a journal entry. The caller must hold the mutex.
+*/
+func (s *MigrationState) applyLocked(entry *journalEntry) {
+	switch {
+	case entry.Direction != "":
+		s.Direction = entry.Direction
+	case entry.Issue != nil:
+		s.Issues[entry.Issue.Key] = entry.Issue
+	case entry.Forget != "":
+		delete(s.Issues, entry.Forget)
+	case entry.Run != nil:
+		s.Runs[entry.Run.Id] = entry.Run
+	case entry.ForgetRun != "":
+		delete(s.Runs, entry.ForgetRun)
+	case entry.LastSync != nil:
+		s.LastSync = entry.LastSync
+	}
+}
+
+/*
+Get returns a copy of the state for the given jira key, or false if there is nothing recorded for it
+*/
+func (s *MigrationState) Get(jiraKey string) (IssueMigrationState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, haveEntry := s.Issues[jiraKey]
+	if !haveEntry {
+		return IssueMigrationState{}, false
+	}
+	copied := *entry
+	copied.Steps = make(map[MigrationStep]bool, len(entry.Steps))
+	for k, v := range entry.Steps {
+		copied.Steps[k] = v
+	}
+	if entry.Attachments != nil {
+		copied.Attachments = make(map[string]AttachmentOutcome, len(entry.Attachments))
+		for k, v := range entry.Attachments {
+			copied.Attachments[k] = v
+		}
+	}
+	if entry.CheckItems != nil {
+		copied.CheckItems = make(map[string]MigratedCheckItem, len(entry.CheckItems))
+		for k, v := range entry.CheckItems {
+			copied.CheckItems[k] = v
+		}
+	}
+	return copied, true
+}
+
+/*
+Keys returns the jira keys of every issue in the state, sorted
+*/
+func (s *MigrationState) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]string, 0, len(s.Issues))
+	for k := range s.Issues {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+IsDone returns true if the given step has already been completed for the given jira key
+*/
+func (s *MigrationState) IsDone(jiraKey string, step MigrationStep) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, haveEntry := s.Issues[jiraKey]
+	if !haveEntry {
+		return false
+	}
+	return entry.Steps[step]
+}
+
+/*
+entryFor returns the state for the given key, creating it if necessary. The caller must hold the mutex.
+*/
+func (s *MigrationState) entryFor(jiraKey string) *IssueMigrationState {
+	entry, haveEntry := s.Issues[jiraKey]
+	if !haveEntry {
+		entry = &IssueMigrationState{
+			Key:   jiraKey,
+			Steps: make(map[MigrationStep]bool),
+		}
+		s.Issues[jiraKey] = entry
+	}
+	entry.Updated = time.Now()
+	return entry
+}
+
+/*
+RecordCard stores the ID of the card that was created for the given jira key and marks StepCardCreated as done
+*/
+func (s *MigrationState) RecordCard(jiraKey string, card *TrelloCard) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	entry.CardId = card.Id
+	entry.ShortUrl = card.ShortUrl
+	entry.RunId = s.runId
+	entry.Steps[StepCardCreated] = true
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+RecordIssue stores the key of the Jira issue that trello-to-jira created for the given card, and marks
+StepIssueCreated as done
+*/
+func (s *MigrationState) RecordIssue(cardId string, issueKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(cardId)
+	entry.CardId = cardId
+	entry.IssueKey = issueKey
+	entry.Steps[StepIssueCreated] = true
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+RecordChecklist stores the ID of the sub-tasks checklist that was created on the card for the given jira key
+*/
+func (s *MigrationState) RecordChecklist(jiraKey string, checklistId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	entry.ChecklistId = checklistId
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+RecordCheckItem stores the checklist item that the given sub-task was added as, or its new state if it has been
+ticked or un-ticked, and marks its CheckItemStep as done
+*/
+func (s *MigrationState) RecordCheckItem(jiraKey string, subtaskKey string, item MigratedCheckItem) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	if entry.CheckItems == nil {
+		entry.CheckItems = make(map[string]MigratedCheckItem)
+	}
+	entry.CheckItems[subtaskKey] = item
+	entry.Steps[CheckItemStep(subtaskKey)] = true
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+RecordAttachment stores what was done with an attachment that was not uploaded as normal, and marks its
+AttachmentStep as done
+*/
+func (s *MigrationState) RecordAttachment(jiraKey string, attachmentId string, outcome AttachmentOutcome) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	if entry.Attachments == nil {
+		entry.Attachments = make(map[string]AttachmentOutcome)
+	}
+	entry.Attachments[attachmentId] = outcome
+	entry.Steps[AttachmentStep(attachmentId)] = true
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+MarkDone records that the given step has been completed for the given jira key
+*/
+func (s *MigrationState) MarkDone(jiraKey string, step MigrationStep) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	entry.Steps[step] = true
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+MarkUndone records that the given step needs doing again for the given jira key, e.g. because what it did has been
+reversed
+*/
+func (s *MigrationState) MarkUndone(jiraKey string, step MigrationStep) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	delete(entry.Steps, step)
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+MarkCompleted records that every step has been done for the given jira key, or the error that stopped it
+*/
+func (s *MigrationState) MarkCompleted(jiraKey string, migrationErr error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := s.entryFor(jiraKey)
+	if migrationErr == nil {
+		entry.Completed = true
+		entry.LastError = ""
+	} else {
+		entry.Completed = false
+		entry.LastError = migrationErr.Error()
+	}
+	return s.journalLocked(journalEntry{Issue: entry})
+}
+
+/*
+LastSyncTime returns the start time of the last run that completed without failures, or false if there hasn't been one
+*/
+func (s *MigrationState) LastSyncTime() (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.LastSync == nil {
+		return time.Time{}, false
+	}
+	return *s.LastSync, true
+}
+
+/*
+RecordSync stores the start time of a run that completed without failures, so that the next sync only needs to look
+at issues updated since then
+*/
+func (s *MigrationState) RecordSync(started time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.LastSync = &started
+	return s.journalLocked(journalEntry{LastSync: &started})
+}
+
+/*
+NewRunId returns an ID for a new run, based on the current time so that runs sort in the order they were started.
+A random suffix keeps runs started in the same millisecond apart, so that rolling back one doesn't undo the other.
+If no random bytes can be had, the suffix is taken from the nanoseconds and process ID instead.
+*/
+func NewRunId() string {
+	now := time.Now().UTC()
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		log.Printf("WARNING Could not get a random run ID suffix, using the time instead: %s", err)
+		fallback := uint32(now.Nanosecond()) ^ uint32(os.Getpid())<<8
+		suffix = []byte{byte(fallback >> 16), byte(fallback >> 8), byte(fallback)}
+	}
+	return now.Format("20060102-150405.000") + "-" + hex.EncodeToString(suffix)
+}
+
+/*
+StartRun records that a run with the given ID has started on the given board. Cards, labels and field options
+recorded from now on are attributed to it. Starting a run that already exists carries on adding to it.
+*/
+func (s *MigrationState) StartRun(runId string, boardId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.runId = runId
+	if _, haveRun := s.Runs[runId]; !haveRun {
+		s.Runs[runId] = &MigrationRun{
+			Id:           runId,
+			BoardId:      boardId,
+			Started:      time.Now(),
+			Labels:       make([]CreatedLabel, 0),
+			FieldOptions: make([]CreatedFieldOption, 0),
+		}
+	}
+	return s.journalLocked(journalEntry{Run: s.Runs[runId]})
+}
+
+/*
+RecordCreatedLabel stores that the current run created the given label. Does nothing if no run has been started.
+*/
+func (s *MigrationState) RecordCreatedLabel(label *TrelloLabel) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, haveRun := s.Runs[s.runId]
+	if !haveRun {
+		return nil
+	}
+	run.Labels = append(run.Labels, CreatedLabel{Id: label.Id, Name: label.Name})
+	return s.journalLocked(journalEntry{Run: run})
+}
+
+/*
+RecordCreatedOption stores that the current run added the given option to a list custom field. Does nothing if no
+run has been started.
+*/
+func (s *MigrationState) RecordCreatedOption(fieldId string, option *TrelloCustomFieldOption) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, haveRun := s.Runs[s.runId]
+	if !haveRun {
+		return nil
+	}
+	run.FieldOptions = append(run.FieldOptions, CreatedFieldOption{FieldId: fieldId, OptionId: option.Id, Text: option.Value.Text})
+	return s.journalLocked(journalEntry{Run: run})
+}
+
+/*
+MarkRunIncomplete records that the current run stopped before it had seen every issue, and why. Does nothing if no
+run has been started.
+*/
+func (s *MigrationState) MarkRunIncomplete(reason string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, haveRun := s.Runs[s.runId]
+	if !haveRun {
+		return nil
+	}
+	run.Incomplete = reason
+	return s.journalLocked(journalEntry{Run: run})
+}
+
+/*
+GetRun returns a copy of the record for the given run ID, or false if there is no such run
+*/
+func (s *MigrationState) GetRun(runId string) (MigrationRun, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, haveRun := s.Runs[runId]
+	if !haveRun {
+		return MigrationRun{}, false
+	}
+	copied := *run
+	copied.Labels = append([]CreatedLabel{}, run.Labels...)
+	copied.FieldOptions = append([]CreatedFieldOption{}, run.FieldOptions...)
+	return copied, true
+}
+
+/*
+LatestRunId returns the ID of the most recently started run, or an empty string if there are none
+*/
+func (s *MigrationState) LatestRunId() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var latest *MigrationRun
+	for _, run := range s.Runs {
+		if latest == nil || run.Started.After(latest.Started) {
+			latest = run
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return latest.Id
+}
+
+/*
+KeysForRun returns the jira keys of the issues whose cards were created by the given run, sorted
+*/
+func (s *MigrationState) KeysForRun(runId string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keys := make([]string, 0)
+	for k, entry := range s.Issues {
+		if entry.RunId == runId && entry.CardId != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+Forget removes everything recorded for the given jira key, so that the next run migrates it again from scratch
+*/
+func (s *MigrationState) Forget(jiraKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.Issues, jiraKey)
+	return s.journalLocked(journalEntry{Forget: jiraKey})
+}
+
+/*
+ForgetRunItems removes the given labels and field options from the record of a run, once they have been removed
+from the board. The run itself is removed when it has nothing left in it and none of its cards are still recorded.
+*/
+func (s *MigrationState) ForgetRunItems(runId string, labelIds []string, optionIds []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run, haveRun := s.Runs[runId]
+	if !haveRun {
+		return nil
+	}
+	removed := make(map[string]bool, len(labelIds)+len(optionIds))
+	for _, id := range append(append([]string{}, labelIds...), optionIds...) {
+		removed[id] = true
+	}
+	labels := make([]CreatedLabel, 0, len(run.Labels))
+	for _, l := range run.Labels {
+		if !removed[l.Id] {
+			labels = append(labels, l)
+		}
+	}
+	run.Labels = labels
+	options := make([]CreatedFieldOption, 0, len(run.FieldOptions))
+	for _, o := range run.FieldOptions {
+		if !removed[o.OptionId] {
+			options = append(options, o)
+		}
+	}
+	run.FieldOptions = options
+
+	if len(run.Labels) == 0 && len(run.FieldOptions) == 0 {
+		haveCards := false
+		for _, entry := range s.Issues {
+			if entry.RunId == runId {
+				haveCards = true
+				break
+			}
+		}
+		if !haveCards {
+			delete(s.Runs, runId)
+			return s.journalLocked(journalEntry{ForgetRun: runId})
+		}
+	}
+	return s.journalLocked(journalEntry{Run: run})
+}
+
+/*
+journalLocked appends a change to the journal, which only costs as much as the change itself however many issues
+there are. Once the journal gets long it is folded into the state file. The caller must hold the mutex.
+*/
+func (s *MigrationState) journalLocked(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if s.journal == nil {
+		s.journal, err = os.OpenFile(s.journalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		//drop anything cut short by a crash, so that it doesn't run into the first new line
+		if err = s.journal.Truncate(s.replayed); err != nil {
+			return err
+		}
+	}
+	if !s.recorded {
+		direction, _ := json.Marshal(journalEntry{Direction: s.Direction})
+		if _, err = s.journal.Write(append(direction, '\n')); err != nil {
+			return err
+		}
+		s.recorded = true
+		s.entries++
+	}
+	//one write per entry, so that a crash can only ever cut short the last line
+	if _, err = s.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.entries++
+	if s.entries >= minJournalEntries && s.entries >= 2*len(s.Issues) {
+		return s.compactLocked()
+	}
+	return nil
+}
+
+/*
+compactLocked writes the whole state to the state file and then removes the journal, whose changes are now in it.
+The caller must hold the mutex.
+*/
+func (s *MigrationState) compactLocked() error {
+	if err := s.saveLocked(); err != nil {
+		return err
+	}
+	if s.journal != nil {
+		s.journal.Close()
+		s.journal = nil
+	}
+	s.entries = 0
+	s.replayed = 0
+	err := os.Remove(s.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+Close folds the journal into the state file, if anything has changed. The state must not be updated afterwards.
+*/
+func (s *MigrationState) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.entries == 0 {
+		return nil
+	}
+	return s.compactLocked()
+}
+
+/*
+saveLocked writes the state out to disk. It writes to a temporary file first and then renames it into place,
+so that a crash part-way through does not leave a corrupted state file. The caller must hold the mutex.
+*/
+func (s *MigrationState) saveLocked() error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(s.path), ".migration-state")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(content)
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return err
+	}
+	return os.Rename(tempFile.Name(), s.path)
+}
@@ -0,0 +1,209 @@
+package common
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewRunId(t *testing.T) {
+	first := NewRunId()
+	second := NewRunId()
+	if first == second {
+		t.Errorf("expected runs started together to get different IDs, both were '%s'", first)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if later := NewRunId(); later <= first {
+		t.Errorf("expected '%s' to sort after '%s'", later, first)
+	}
+}
+
+func TestMigrationStateRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrationstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	state, err := LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatalf("Could not initialise empty state: %s", err)
+	}
+	if state.IsDone("PROJ-1", StepCardCreated) {
+		t.Error("Empty state should not report any steps done")
+	}
+
+	err = state.RecordCard("PROJ-1", &TrelloCard{Id: "card1", ShortUrl: "https://trello.com/c/abc"})
+	if err != nil {
+		t.Fatalf("Could not record card: %s", err)
+	}
+	state.MarkDone("PROJ-1", CommentStep("1001"))
+	state.RecordCheckItem("PROJ-1", "PROJ-2", MigratedCheckItem{Id: "item1", Checked: true})
+	state.MarkCompleted("PROJ-1", errors.New("something broke"))
+
+	reloaded, err := LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatalf("Could not reload state: %s", err)
+	}
+	entry, haveEntry := reloaded.Get("PROJ-1")
+	if !haveEntry {
+		t.Fatal("Reloaded state did not contain PROJ-1")
+	}
+	if entry.CardId != "card1" {
+		t.Errorf("Got card id '%s', expected 'card1'", entry.CardId)
+	}
+	if !entry.Steps[StepCardCreated] || !entry.Steps[CommentStep("1001")] {
+		t.Errorf("Steps were not persisted: %v", entry.Steps)
+	}
+	if item := entry.CheckItems["PROJ-2"]; item.Id != "item1" || !item.Checked || !entry.Steps[CheckItemStep("PROJ-2")] {
+		t.Errorf("Check item was not persisted: %+v", entry.CheckItems)
+	}
+	if entry.Steps[CommentStep("1002")] {
+		t.Error("Comment 1002 should not be marked done")
+	}
+	if entry.Completed || entry.LastError != "something broke" {
+		t.Errorf("Expected failed entry, got completed=%t error='%s'", entry.Completed, entry.LastError)
+	}
+}
+
+func TestMigrationStateJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrationstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	state, err := LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.StartRun("run1", "board1")
+	state.RecordCard("PROJ-1", &TrelloCard{Id: "card1"})
+	state.RecordCard("PROJ-2", &TrelloCard{Id: "card2"})
+	state.Forget("PROJ-2")
+	if _, err = os.Stat(statePath); !os.IsNotExist(err) {
+		t.Error("expected changes to go to the journal rather than rewriting the state file")
+	}
+
+	//a crash part-way through writing leaves a cut-short line at the end, which is ignored
+	journal, err := os.OpenFile(statePath+".journal", os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"issue":{"key":"PROJ-3"`)
+	journal.Close()
+
+	state, err = LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatalf("Could not replay the journal: %s", err)
+	}
+	if keys := state.Keys(); len(keys) != 1 || keys[0] != "PROJ-1" {
+		t.Errorf("expected only PROJ-1 after replaying the journal, got %v", keys)
+	}
+	if _, haveRun := state.GetRun("run1"); !haveRun {
+		t.Error("expected run1 to be replayed from the journal")
+	}
+	state.MarkDone("PROJ-1", StepJiraKey)
+	reloaded, err := LoadMigrationState(statePath, JiraToTrello)
+	if err != nil || !reloaded.IsDone("PROJ-1", StepJiraKey) {
+		t.Errorf("expected a change after the cut-short line to be replayed: %v", err)
+	}
+
+	if err = state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(statePath + ".journal"); !os.IsNotExist(err) {
+		t.Error("expected Close to remove the journal")
+	}
+	reloaded, err = LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, haveEntry := reloaded.Get("PROJ-1"); !haveEntry || entry.CardId != "card1" || entry.RunId != "run1" {
+		t.Errorf("expected PROJ-1 to be in the state file after Close, got %+v", entry)
+	}
+}
+
+func TestMigrationStateJournalBlankLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrationstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	journal := `{"direction":"jira-to-trello"}
+
+{"issue":{"key":"PROJ-1","cardId":"card1","steps":{"card":true}}}
+   
+{"issue":{"key":"PROJ-2","cardId":"card2","steps":{"card":true}}}
+`
+	if err = ioutil.WriteFile(statePath+".journal", []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the next change must go after everything already in the journal, not over the end of it
+	if err = state.MarkDone("PROJ-3", StepJiraKey); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadMigrationState(statePath, JiraToTrello)
+	if err != nil {
+		t.Fatalf("Could not replay the journal: %s", err)
+	}
+	if keys := reloaded.Keys(); len(keys) != 3 || keys[0] != "PROJ-1" || keys[1] != "PROJ-2" || keys[2] != "PROJ-3" {
+		t.Errorf("expected all three issues after replaying the journal, got %v", keys)
+	}
+}
+
+func TestMigrationStateDirection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrationstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	statePath := filepath.Join(dir, "state.json")
+
+	state, err := LoadMigrationState(statePath, TrelloToJira)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = state.RecordIssue("card1", "PROJ-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadMigrationState(statePath, JiraToTrello); err == nil {
+		t.Error("expected a trello-to-jira journal to be refused for jira-to-trello")
+	}
+	if err = state.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadMigrationState(statePath, JiraToTrello); err == nil {
+		t.Error("expected a trello-to-jira state file to be refused for jira-to-trello")
+	}
+	if reloaded, err := LoadMigrationState(statePath, TrelloToJira); err != nil || !reloaded.IsDone("card1", StepIssueCreated) {
+		t.Errorf("expected the trello-to-jira state to load: %v", err)
+	}
+
+	//files written before the direction was recorded are told apart by what has been done
+	legacy := `{"issues": {"card2": {"jiraKey": "card2", "issueKey": "PROJ-2", "steps": {"issue": true}}}}`
+	if err = ioutil.WriteFile(statePath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadMigrationState(statePath, JiraToTrello); err == nil {
+		t.Error("expected a legacy trello-to-jira state file to be refused for jira-to-trello")
+	}
+	reloaded, err := LoadMigrationState(statePath, TrelloToJira)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, haveEntry := reloaded.Get("card2"); !haveEntry || entry.Key != "card2" || entry.IssueKey != "PROJ-2" {
+		t.Errorf("expected the legacy entry to be read, got %+v", entry)
+	}
+}
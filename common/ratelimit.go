@@ -0,0 +1,355 @@
+package common
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// how much of a response body is read before retrying, to keep the connection open. Anything longer isn't worth it
+const maxDrainBytes = 64 * 1024
+
+/*
+TokenBucket is a simple token-bucket rate limiter. It starts full, and refills at a steady rate up to its capacity.
+It can also be paused until a given time, for when a server tells us to back off.
+*/
+type TokenBucket struct {
+	mutex       sync.Mutex
+	capacity    float64
+	tokens      float64
+	refillRate  float64 //tokens per second
+	lastRefill  time.Time
+	pausedUntil time.Time
+}
+
+/*
+NewTokenBucket returns a bucket that allows `requests` requests in every `per` interval
+*/
+func NewTokenBucket(requests int, per time.Duration) *TokenBucket {
+	return &TokenBucket{
+		capacity:   float64(requests),
+		tokens:     float64(requests),
+		refillRate: float64(requests) / per.Seconds(),
+		lastRefill: time.Now(),
+	}
+}
+
+/*
+reserve takes a token if one is available and returns zero, otherwise it returns how long to wait before trying again
+*/
+func (b *TokenBucket) reserve() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.refillRate
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.lastRefill = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.refillRate * float64(time.Second))
+}
+
+/*
+Wait blocks until a token is available and takes it
+*/
+func (b *TokenBucket) Wait() {
+	b.WaitContext(context.Background())
+}
+
+/*
+WaitContext blocks until a token is available and takes it, or returns the context's error if it is cancelled first
+*/
+func (b *TokenBucket) WaitContext(ctx context.Context) error {
+	for {
+		delay := b.reserve()
+		if delay == 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+sleepContext waits for the given time, or returns the context's error if it is cancelled first
+*/
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+/*
+PauseUntil stops any tokens being handed out until the given time
+*/
+func (b *TokenBucket) PauseUntil(t time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if t.After(b.pausedUntil) {
+		b.pausedUntil = t
+	}
+}
+
+/*
+RateLimitedTransport is an http.RoundTripper that limits the rate of requests to each host, and retries requests that
+fail with 429 (too many requests) or a 5xx server error using exponential backoff with jitter. A Retry-After header
+from the server always takes precedence over the calculated delay, unless it is longer than MaxDelay in which case the
+response is returned without retrying. Jira's X-RateLimit headers pause all requests to that host until the limit
+resets.
+
+5xx errors are only retried for idempotent methods, as the server may already have acted on e.g. a POST before
+failing. 429 responses are always retried because the request was rejected before being processed.
+*/
+type RateLimitedTransport struct {
+	Base       http.RoundTripper
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+
+	limitsMutex  sync.Mutex
+	hostLimits   map[string]*TokenBucket
+	defaultLimit func() *TokenBucket //used for hosts that are not in hostLimits
+}
+
+//Trello allows 100 requests per 10 seconds for each token, see https://developer.atlassian.com/cloud/trello/guides/rest-api/rate-limits/
+const TrelloRequestsPerInterval = 100
+const TrelloRateInterval = 10 * time.Second
+
+/*
+NewRateLimitedTransport returns a transport with Trello's published limits for api.trello.com, and a generous default
+limit for any other host (i.e. Jira, which signals its limits through response headers instead).
+*/
+func NewRateLimitedTransport(base http.RoundTripper) *RateLimitedTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RateLimitedTransport{
+		Base:       base,
+		MaxRetries: 6,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   60 * time.Second,
+		hostLimits: map[string]*TokenBucket{
+			"api.trello.com": NewTokenBucket(TrelloRequestsPerInterval, TrelloRateInterval),
+		},
+		defaultLimit: func() *TokenBucket {
+			return NewTokenBucket(50, time.Second)
+		},
+	}
+}
+
+/*
+NewRateLimitedClient returns an http.Client that uses a RateLimitedTransport
+*/
+func NewRateLimitedClient() *http.Client {
+	return &http.Client{Transport: NewRateLimitedTransport(nil)}
+}
+
+var sharedClientOnce sync.Once
+var sharedClient *http.Client
+
+/*
+SharedHttpClient returns a single rate-limited client for the whole process. Rate limits are per-token rather than
+per-connection, so every request to the same service needs to go through the same limiter.
+*/
+func SharedHttpClient() *http.Client {
+	sharedClientOnce.Do(func() {
+		sharedClient = NewRateLimitedClient()
+	})
+	return sharedClient
+}
+
+/*
+SetHostLimit overrides the rate limit for the given host
+*/
+func (t *RateLimitedTransport) SetHostLimit(host string, requests int, per time.Duration) {
+	t.limitsMutex.Lock()
+	defer t.limitsMutex.Unlock()
+	t.hostLimits[host] = NewTokenBucket(requests, per)
+}
+
+func (t *RateLimitedTransport) limiterFor(host string) *TokenBucket {
+	t.limitsMutex.Lock()
+	defer t.limitsMutex.Unlock()
+	limiter, haveLimiter := t.hostLimits[host]
+	if !haveLimiter {
+		limiter = t.defaultLimit()
+		t.hostLimits[host] = limiter
+	}
+	return limiter
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+shouldRetry returns true if the response status indicates that the request can be tried again
+*/
+func shouldRetry(req *http.Request, statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(req.Method)
+	default:
+		return false
+	}
+}
+
+/*
+parseRetryAfter interprets a Retry-After header, which can either be a number of seconds or an HTTP date.
+Returns zero if the header is missing or invalid.
+*/
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if when, err := http.ParseTime(value); err == nil && when.After(now) {
+		return when.Sub(now)
+	}
+	return 0
+}
+
+/*
+backoffDelay returns the delay before the given retry attempt (starting at 0), using "full jitter" exponential backoff
+*/
+func (t *RateLimitedTransport) backoffDelay(attempt int) time.Duration {
+	ceiling := t.BaseDelay << uint(attempt)
+	if ceiling > t.MaxDelay || ceiling <= 0 {
+		ceiling = t.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+/*
+rateLimitResetFormats are the forms that X-RateLimit-Reset has been seen in. Jira Cloud sends an ISO 8601 time
+without seconds, e.g. 2021-03-04T10:15Z, but full RFC3339 times (with or without fractions of a second) are accepted too.
+*/
+var rateLimitResetFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z0700",
+}
+
+/*
+parseRateLimitReset interprets an X-RateLimit-Reset header, which is a time in one of rateLimitResetFormats or a
+number of seconds since the epoch. Returns false if the header could not be understood.
+*/
+func parseRateLimitReset(value string) (time.Time, bool) {
+	for _, format := range rateLimitResetFormats {
+		if reset, err := time.Parse(format, value); err == nil {
+			return reset, true
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil && seconds > 0 {
+		return time.Unix(seconds, 0), true
+	}
+	return time.Time{}, false
+}
+
+/*
+observeJiraLimits pauses the host's limiter if Jira tells us that we have run out of requests
+*/
+func observeJiraLimits(limiter *TokenBucket, host string, response *http.Response, now time.Time) {
+	remaining := response.Header.Get("X-RateLimit-Remaining")
+	if remaining == "" {
+		return
+	}
+	if count, err := strconv.Atoi(remaining); err != nil || count > 0 {
+		return
+	}
+	resetHeader := response.Header.Get("X-RateLimit-Reset")
+	reset, haveReset := parseRateLimitReset(resetHeader)
+	if !haveReset {
+		log.Printf("WARNING Rate limit exhausted for %s but could not understand X-RateLimit-Reset '%s', not pausing", host, resetHeader)
+		return
+	}
+	if reset.After(now) {
+		log.Printf("WARNING Rate limit exhausted for %s, pausing until %s", host, reset)
+		limiter.PauseUntil(reset)
+	}
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := t.limiterFor(req.URL.Host)
+	//we can only retry if we can get hold of the request body again
+	canReplay := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			//a RoundTripper must not modify the request it was given, so use a copy with a fresh body
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		if err := limiter.WaitContext(req.Context()); err != nil {
+			return nil, err
+		}
+		response, err := t.Base.RoundTrip(attemptReq)
+		if err != nil {
+			return nil, err
+		}
+		observeJiraLimits(limiter, req.URL.Host, response, time.Now())
+
+		if !shouldRetry(req, response.StatusCode) || !canReplay || attempt >= t.MaxRetries {
+			return response, nil
+		}
+
+		delay := parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+		if delay > t.MaxDelay {
+			//don't let one header hold up the whole run, the caller gets the error instead
+			log.Printf("WARNING %s %s returned %d and asked us to wait %s, which is longer than %s, not retrying", req.Method, req.URL.Host+req.URL.Path, response.StatusCode, delay, t.MaxDelay)
+			return response, nil
+		}
+		if delay == 0 {
+			delay = t.backoffDelay(attempt)
+		} else if response.StatusCode == http.StatusTooManyRequests {
+			//every request to this host will be rejected until then, so hold them all back
+			limiter.PauseUntil(time.Now().Add(delay))
+		}
+		//read what's left of the body so that the connection can be used again for the retry
+		io.Copy(ioutil.Discard, io.LimitReader(response.Body, maxDrainBytes))
+		response.Body.Close()
+
+		log.Printf("WARNING %s %s returned %d, retrying in %s (attempt %d of %d)", req.Method, req.URL.Host+req.URL.Path, response.StatusCode, delay, attempt+1, t.MaxRetries)
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTransport() *RateLimitedTransport {
+	transport := NewRateLimitedTransport(nil)
+	transport.BaseDelay = time.Millisecond
+	transport.MaxDelay = 5 * time.Millisecond
+	transport.MaxRetries = 3
+	return transport
+}
+
+func TestRateLimitedTransportRetries429(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newTestTransport()}
+	response, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.StatusCode != 200 {
+		t.Errorf("Expected 200 after retries, got %d", response.StatusCode)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
+
+func TestRateLimitedTransportDoesNotRetryPost500(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newTestTransport()}
+	response, err := client.Post(server.URL, "", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.StatusCode != 500 || calls != 1 {
+		t.Errorf("Expected a single 500, got %d after %d calls", response.StatusCode, calls)
+	}
+
+	calls = 0
+	response, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.StatusCode != 500 || calls != 4 {
+		t.Errorf("Expected GET to be retried 3 times, got %d after %d calls", response.StatusCode, calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 5, 18, 11, 0, 0, 0, time.UTC)
+	if d := parseRetryAfter("5", now); d != 5*time.Second {
+		t.Errorf("Expected 5s, got %s", d)
+	}
+	if d := parseRetryAfter("Wed, 18 May 2022 11:00:30 GMT", now); d != 30*time.Second {
+		t.Errorf("Expected 30s, got %s", d)
+	}
+	if d := parseRetryAfter("rubbish", now); d != 0 {
+		t.Errorf("Expected 0 for invalid header, got %s", d)
+	}
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+	expected := time.Date(2022, 5, 18, 11, 15, 0, 0, time.UTC)
+	for _, value := range []string{"2022-05-18T11:15Z", "2022-05-18T11:15:00Z", "2022-05-18T11:15:00.000Z", "2022-05-18T12:15+0100", "1652872500"} {
+		if reset, haveReset := parseRateLimitReset(value); !haveReset || !reset.Equal(expected) {
+			t.Errorf("Expected '%s' to be %s, got %s", value, expected, reset)
+		}
+	}
+	if _, haveReset := parseRateLimitReset("soon"); haveReset {
+		t.Error("Expected an invalid header not to be understood")
+	}
+}
+
+func TestRateLimitedTransportStopsWaitingWhenCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	transport := newTestTransport()
+	transport.MaxDelay = time.Minute
+	client := &http.Client{Transport: transport}
+	start := time.Now()
+	_, err := client.Do(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the request to be cancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the retry wait to stop when cancelled, took %s", elapsed)
+	}
+}
+
+func TestRateLimitedTransportRetryAfterOverMaxDelay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newTestTransport()}
+	start := time.Now()
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if response.StatusCode != 429 || calls != 1 {
+		t.Errorf("Expected the 429 to be returned without retrying, got %d after %d calls", response.StatusCode, calls)
+	}
+	//the host must not be paused either, or the next request would wait an hour
+	if _, err = client.Get(server.URL); err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected not to wait for the Retry-After, took %s", elapsed)
+	}
+}
+
+func TestTokenBucketLimitsRate(t *testing.T) {
+	bucket := NewTokenBucket(2, 100*time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		bucket.Wait()
+	}
+	//two tokens are available immediately, the next two need 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected to be held back for ~100ms, only took %s", elapsed)
+	}
+}
+
+/*
+drainCheckBody records whether it had been read to the end when it was closed
+*/
+type drainCheckBody struct {
+	*strings.Reader
+	drained *bool
+}
+
+func (b drainCheckBody) Close() error {
+	*b.drained = b.Len() == 0
+	return nil
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimitedTransportDrainsRetriedBody(t *testing.T) {
+	tests := []struct {
+		bodySize      int
+		expectDrained bool
+	}{
+		{0, true},
+		{5000, true},
+		{maxDrainBytes, true},
+		{maxDrainBytes + 1, false}, //not worth reading, the connection is dropped instead
+	}
+	for _, test := range tests {
+		drained := make([]bool, 0)
+		transport := newTestTransport()
+		transport.Base = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			if len(drained) == 1 {
+				return &http.Response{StatusCode: 200, Body: http.NoBody, Header: http.Header{}, Request: req}, nil
+			}
+			drained = append(drained, false)
+			body := drainCheckBody{Reader: strings.NewReader(strings.Repeat("x", test.bodySize)), drained: &drained[len(drained)-1]}
+			return &http.Response{StatusCode: 503, Body: body, Header: http.Header{}, Request: req}, nil
+		})
+
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		response, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("Request failed: %s", err)
+		}
+		if response.StatusCode != 200 || len(drained) != 1 {
+			t.Fatalf("Expected a single retry, got %d after %d failures", response.StatusCode, len(drained))
+		}
+		if drained[0] != test.expectDrained {
+			t.Errorf("%d byte body: expected drained %t before closing, got %t", test.bodySize, test.expectDrained, drained[0])
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 type ScriptKey struct {
 	User string `yaml:"user"`
 	Key  string `yaml:"key"`
+	Auth string `yaml:"auth,omitempty"` //Jira only: basic (default), pat or oauth
 }
 
 func LoadScriptKey(path *string) (*ScriptKey, error) {
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	SprintActive = "active"
+	SprintFuture = "future"
+	SprintClosed = "closed"
+)
+
+func (s *SprintLink) hasState(state string) bool {
+	return s.State != nil && strings.EqualFold(*s.State, state)
+}
+
+/*
+ActiveSprint returns the active sprint that the issue is in, or nil if it is not in one
+*/
+func (i *IssueFields) ActiveSprint() *SprintLink {
+	if i.SprintLink == nil {
+		return nil
+	}
+	for idx := range *i.SprintLink {
+		if (*i.SprintLink)[idx].hasState(SprintActive) {
+			return &(*i.SprintLink)[idx]
+		}
+	}
+	return nil
+}
+
+/*
+CurrentSprint returns the active sprint that the issue is in, or failing that the first future sprint it is planned
+for. Returns nil if it is only in closed sprints, or none at all.
+*/
+func (i *IssueFields) CurrentSprint() *SprintLink {
+	if active := i.ActiveSprint(); active != nil {
+		return active
+	}
+	if i.SprintLink == nil {
+		return nil
+	}
+	for idx := range *i.SprintLink {
+		if (*i.SprintLink)[idx].hasState(SprintFuture) {
+			return &(*i.SprintLink)[idx]
+		}
+	}
+	return nil
+}
+
+/*
+LatestSprint returns the current sprint if there is one, otherwise the last sprint the issue was in.
+Jira lists sprints in the order they were added to the issue, so that is the most recent.
+*/
+func (i *IssueFields) LatestSprint() *SprintLink {
+	if current := i.CurrentSprint(); current != nil {
+		return current
+	}
+	if i.SprintLink == nil || len(*i.SprintLink) == 0 {
+		return nil
+	}
+	return &(*i.SprintLink)[len(*i.SprintLink)-1]
+}
+
+/*
+SprintFooter returns Markdown listing the sprints the issue has been in, with their state and goal, to go at the
+bottom of the card description. Returns an empty string if there are none.
+*/
+func (i *IssueFields) SprintFooter() string {
+	if i.SprintLink == nil || len(*i.SprintLink) == 0 {
+		return ""
+	}
+	lines := []string{"---", "**Sprints**"}
+	for _, s := range *i.SprintLink {
+		line := "- " + s.Name
+		if s.State != nil && *s.State != "" {
+			line += fmt.Sprintf(" (%s)", *s.State)
+		}
+		if s.Goal != nil && strings.TrimSpace(*s.Goal) != "" {
+			line += ": " + strings.TrimSpace(*s.Goal)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
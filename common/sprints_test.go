@@ -0,0 +1,38 @@
+package common
+
+import "testing"
+
+func TestSprintSelection(t *testing.T) {
+	fields := IssueFields{SprintLink: &[]SprintLink{
+		{Id: 1, Name: "Sprint 1", State: StringPtr("closed")},
+		{Id: 3, Name: "Sprint 3", State: StringPtr("future")},
+		{Id: 2, Name: "Sprint 2", State: StringPtr("active"), Goal: StringPtr("Ship it")},
+	}}
+	if s := fields.CurrentSprint(); s == nil || s.Name != "Sprint 2" {
+		t.Errorf("expected the active sprint to be current, got %+v", s)
+	}
+
+	(*fields.SprintLink)[2].State = StringPtr("closed")
+	if s := fields.ActiveSprint(); s != nil {
+		t.Errorf("expected no active sprint, got %+v", s)
+	}
+	if s := fields.CurrentSprint(); s == nil || s.Name != "Sprint 3" {
+		t.Errorf("expected the future sprint to be current, got %+v", s)
+	}
+
+	(*fields.SprintLink)[1].State = StringPtr("closed")
+	if s := fields.CurrentSprint(); s != nil {
+		t.Errorf("expected no current sprint, got %+v", s)
+	}
+	if s := fields.LatestSprint(); s == nil || s.Name != "Sprint 2" {
+		t.Errorf("expected the last sprint to be the latest, got %+v", s)
+	}
+
+	expected := "---\n**Sprints**\n- Sprint 1 (closed)\n- Sprint 3 (closed)\n- Sprint 2 (closed): Ship it"
+	if footer := fields.SprintFooter(); footer != expected {
+		t.Errorf("unexpected footer:\n%s", footer)
+	}
+	if footer := (&IssueFields{}).SprintFooter(); footer != "" {
+		t.Errorf("expected no footer without sprints, got '%s'", footer)
+	}
+}
@@ -58,7 +58,13 @@ func (c *NewTrelloCard) ToQueryParams() string {
 		params = append(params, fmt.Sprintf("due=%s", url.QueryEscape(*c.DueDate)))
 	}
 	if c.DueComplete != nil {
-		params = append(params, fmt.Sprintf("due=%t", *c.DueComplete))
+		params = append(params, fmt.Sprintf("dueComplete=%t", *c.DueComplete))
+	}
+	if len(c.Members) > 0 {
+		params = append(params, fmt.Sprintf("idMembers=%s", url.QueryEscape(strings.Join(c.Members, ","))))
+	}
+	if len(c.LabelIDs) > 0 {
+		params = append(params, fmt.Sprintf("idLabels=%s", url.QueryEscape(strings.Join(c.LabelIDs, ","))))
 	}
 	return strings.Join(params, "&")
 }
@@ -82,7 +88,7 @@ type TrelloCard struct {
 	ListId           string        `json:"idList"`
 	Members          []string      `json:"idMembers"`
 	ShortId          int64         `json:"idShort"`
-	Labels           []string      `json:"labels"`
+	Labels           []TrelloLabel `json:"labels"`
 	Name             string        `json:"name"`
 	Position         float64       `json:"pos"`
 	ShortLink        string        `json:"shortLink"`
@@ -91,6 +97,46 @@ type TrelloCard struct {
 	URL              string        `json:"url"`
 }
 
+/*
+TrelloMember is a member of a board. Email is only returned for the member that owns the API token.
+*/
+type TrelloMember struct {
+	Id       string  `json:"id"`
+	FullName string  `json:"fullName"`
+	Username string  `json:"username"`
+	Email    *string `json:"email"`
+}
+
+/*
+TrelloChecklist is a checklist on a card
+*/
+type TrelloChecklist struct {
+	Id         string            `json:"id"`
+	Name       string            `json:"name"`
+	BoardId    string            `json:"idBoard"`
+	CardId     string            `json:"idCard"`
+	Pos        float64           `json:"pos"`
+	CheckItems []TrelloCheckItem `json:"checkItems"`
+}
+
+type CheckItemState string
+
+const (
+	CheckItemComplete   CheckItemState = "complete"
+	CheckItemIncomplete CheckItemState = "incomplete"
+)
+
+/*
+TrelloCheckItem is a single item on a checklist
+*/
+type TrelloCheckItem struct {
+	Id          string         `json:"id"`
+	Name        string         `json:"name"`
+	ChecklistId string         `json:"idChecklist"`
+	State       CheckItemState `json:"state"`
+	Pos         float64        `json:"pos"`
+}
+
 type TrelloLabel struct {
 	Id          string  `json:"id"` //ID if this label
 	BoardId     string  `json:"idBoard"`
@@ -189,3 +235,87 @@ type LimitsObject struct {
 	DisableAt *int64      `json:"disableAt"`
 	WarnAt    *int64      `json:"warnAt"`
 }
+
+// TrelloLabelColours is the set of colours that Trello accepts for labels and custom field options
+var TrelloLabelColours = []string{"green", "yellow", "orange", "red", "purple", "blue", "sky", "lime", "pink", "black"}
+
+/*
+IsValidTrelloColour returns true if the colour is one that Trello accepts, or "null" for no colour
+*/
+func IsValidTrelloColour(colour string) bool {
+	if colour == "null" {
+		return true
+	}
+	for _, c := range TrelloLabelColours {
+		if c == colour {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+TrelloBadges are the counts that Trello shows on the front of a card
+*/
+type TrelloBadges struct {
+	Comments    int `json:"comments"`
+	Attachments int `json:"attachments"`
+}
+
+/*
+TrelloCustomFieldItem is the value of a custom field on a card. List fields set IdValue, everything else sets Value
+e.g. {"text": "ABC-123"}
+*/
+type TrelloCustomFieldItem struct {
+	Id            string            `json:"id"`
+	CustomFieldId string            `json:"idCustomField"`
+	IdValue       string            `json:"idValue"`
+	Value         map[string]string `json:"value"`
+}
+
+/*
+TrelloAttachment is a file or link attached to a card
+*/
+type TrelloAttachment struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Url      string `json:"url"`
+	Bytes    *int64 `json:"bytes"` //null for links
+	MimeType string `json:"mimeType"`
+}
+
+/*
+TrelloCardDetail is a card as returned when loading every card on a board, with its badges, custom field values,
+attachments and checklists
+*/
+type TrelloCardDetail struct {
+	TrelloCard
+	Badges           TrelloBadges            `json:"badges"`
+	CustomFieldItems []TrelloCustomFieldItem `json:"customFieldItems"`
+	Attachments      []TrelloAttachment      `json:"attachments"`
+	Checklists       []TrelloChecklist       `json:"checklists"`
+}
+
+/*
+CustomFieldItem returns the value of the given custom field on the card, or false if it is not set
+*/
+func (c *TrelloCardDetail) CustomFieldItem(fieldId string) (TrelloCustomFieldItem, bool) {
+	for _, item := range c.CustomFieldItems {
+		if item.CustomFieldId == fieldId {
+			return item, true
+		}
+	}
+	return TrelloCustomFieldItem{}, false
+}
+
+/*
+TrelloComment is a comment on a card. Trello keeps comments as "commentCard" actions, with the text in Data.
+*/
+type TrelloComment struct {
+	Id   string `json:"id"`
+	Date string `json:"date"`
+	Data struct {
+		Text string `json:"text"`
+	} `json:"data"`
+	MemberCreator TrelloMember `json:"memberCreator"`
+}
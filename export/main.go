@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/snapshot"
+	"log"
+)
+
+func main() {
+	cfg := common.DefaultMigrationConfig()
+	configPath := flag.String("config", "", "Path to a YAML migration config file. Only the jira section is used")
+	flag.StringVar(&cfg.Jira.Credentials, "jira", cfg.Jira.Credentials, "Path to a file containing a Jira API key")
+	flag.StringVar(&cfg.Jira.Host, "host", cfg.Jira.Host, "Jira host to query, or its full base URL (including any context path) if it is not https")
+	flag.IntVar(&cfg.Jira.PageSize, "pagesize", cfg.Jira.PageSize, "number of issues to fetch in one page")
+	flag.StringVar(&cfg.Jira.IssueJql, "jql", cfg.Jira.IssueJql, "JQL query selecting the issues to export")
+	outputPath := flag.String("out", "", "Directory to write the snapshot into, or a path ending in .tar.gz or .tgz to write it as a tarball. It must not already exist")
+	withAttachments := flag.Bool("attachments", true, "Include the content of every attachment in the snapshot. Without it, load-issues can't migrate attachments from the snapshot")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Jira.Host == "" || cfg.Jira.Credentials == "" {
+		log.Fatal("You must specify the Jira host with -host and credentials with -jira")
+	}
+	if *outputPath == "" {
+		log.Fatal("You must specify where to write the snapshot with -out")
+	}
+
+	jiraKey, err := common.LoadScriptKey(&cfg.Jira.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraAuth, err := jira.AuthFromScriptKey(jiraKey)
+	if err != nil {
+		log.Fatalf("Invalid scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	baseUrl := jira.BaseUrlFor(cfg.Jira.Host)
+	jiraClient := jira.NewClient(baseUrl, jiraAuth, common.SharedHttpClient())
+	jiraClient.FieldMapping = cfg.Jira.Fields
+
+	manifest, err := snapshot.Export(jiraClient, baseUrl, cfg.Jira.PageSize, cfg.Jira.IssueJql, cfg.Jira.EpicJql, *withAttachments, *outputPath)
+	if err != nil {
+		log.Fatalf("Could not export snapshot to '%s': %s", *outputPath, err)
+	}
+	log.Printf("INFO Exported %d issues, %d epics, %d comments and %d attachments (%d bytes) to '%s'", manifest.Issues, manifest.Epics, manifest.Comments, manifest.Attachments, manifest.AttachmentBytes, *outputPath)
+}
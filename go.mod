@@ -4,6 +4,6 @@ go 1.16
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/google/uuid v1.3.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/google/uuid v1.3.0
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,67 @@
+package jira
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/http"
+)
+
+/*
+Auth adds credentials to an outgoing request. Which one to use depends on how the Jira instance is hosted.
+*/
+type Auth interface {
+	Apply(req *http.Request)
+}
+
+/*
+BasicAuth is used for Jira Cloud with an account email and API token
+*/
+type BasicAuth struct {
+	User  string
+	Token string
+}
+
+func (a BasicAuth) Apply(req *http.Request) {
+	req.SetBasicAuth(a.User, a.Token)
+}
+
+/*
+PersonalAccessToken is used for Jira Data Center and Server, which accept a PAT as a bearer token
+*/
+type PersonalAccessToken struct {
+	Token string
+}
+
+func (a PersonalAccessToken) Apply(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+}
+
+/*
+OAuthToken is an OAuth 2.0 (3LO) access token for Jira Cloud. Requests made with it must go through
+https://api.atlassian.com/ex/jira/{cloudId} rather than the site's own hostname, so set BaseUrl to match.
+*/
+type OAuthToken struct {
+	AccessToken string
+}
+
+func (a OAuthToken) Apply(req *http.Request) {
+	req.Header.Set("Authorization", "Bearer "+a.AccessToken)
+}
+
+/*
+AuthFromScriptKey returns the Auth described by a script key file. The key's `auth` setting picks the type:
+"basic" (the default) uses `user` and `key` as email and API token, while "pat" and "oauth" use `key` as the token.
+*/
+func AuthFromScriptKey(key *common.ScriptKey) (Auth, error) {
+	switch key.Auth {
+	case "", "basic":
+		return BasicAuth{User: key.User, Token: key.Key}, nil
+	case "pat":
+		return PersonalAccessToken{Token: key.Key}, nil
+	case "oauth":
+		return OAuthToken{AccessToken: key.Key}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("'%s' is not a valid auth type, expected basic, pat or oauth", key.Auth))
+	}
+}
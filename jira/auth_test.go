@@ -0,0 +1,50 @@
+package jira
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/http"
+	"testing"
+)
+
+func TestAuthFromScriptKey(t *testing.T) {
+	tests := []struct {
+		auth     string
+		expected string
+	}{
+		{"", "Basic dXNlckBleGFtcGxlLmNvbTpzZWNyZXQ="},
+		{"basic", "Basic dXNlckBleGFtcGxlLmNvbTpzZWNyZXQ="},
+		{"pat", "Bearer secret"},
+		{"oauth", "Bearer secret"},
+	}
+
+	for _, test := range tests {
+		auth, err := AuthFromScriptKey(&common.ScriptKey{User: "user@example.com", Key: "secret", Auth: test.auth})
+		if err != nil {
+			t.Errorf("auth type '%s' gave unexpected error %s", test.auth, err)
+			continue
+		}
+		req, _ := http.NewRequest("GET", "https://example.atlassian.net/", nil)
+		auth.Apply(req)
+		if req.Header.Get("Authorization") != test.expected {
+			t.Errorf("auth type '%s' gave header '%s', expected '%s'", test.auth, req.Header.Get("Authorization"), test.expected)
+		}
+	}
+
+	_, err := AuthFromScriptKey(&common.ScriptKey{Auth: "kerberos"})
+	if err == nil {
+		t.Error("expected an error for an unknown auth type")
+	}
+}
+
+func TestClientUri(t *testing.T) {
+	c := NewClient(BaseUrlFor("example.atlassian.net"), nil, http.DefaultClient)
+	if c.uri("/search", nil) != "https://example.atlassian.net/rest/api/3/search" {
+		t.Errorf("unexpected uri %s", c.uri("/search", nil))
+	}
+
+	c = NewClient(BaseUrlFor("http://localhost:8080/jira/"), nil, http.DefaultClient)
+	c.ApiPath = "/rest/api/2"
+	if c.uri("/issue/ABC-1/comment", nil) != "http://localhost:8080/jira/rest/api/2/issue/ABC-1/comment" {
+		t.Errorf("unexpected uri %s", c.uri("/issue/ABC-1/comment", nil))
+	}
+}
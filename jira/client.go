@@ -0,0 +1,102 @@
+package jira
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultApiPath = "/rest/api/3"
+const DefaultUserAgent = "mm-jira-migration"
+
+/*
+Client holds everything needed to talk to the Jira REST API. All of the operations in this package are methods on it.
+BaseUrl includes the scheme and any context path (e.g. "https://example.atlassian.net" or
+"https://jira.example.com/jira"), so it can also be pointed at a stand-in server for testing.
+*/
+type Client struct {
+	BaseUrl      string
+	ApiPath      string //Data Center only has "/rest/api/2"
+	Auth         Auth
+	HttpClient   *http.Client
+	Logger       *log.Logger
+	UserAgent    string
+	FieldMapping common.JiraFieldMapping //which fields loaded issues' epic, sprint and story point values come from
+}
+
+/*
+NewClient returns a Client for the given Jira instance. If httpClient is nil then the shared rate-limited client is used.
+*/
+func NewClient(baseUrl string, auth Auth, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = common.SharedHttpClient()
+	}
+	return &Client{
+		BaseUrl:      strings.TrimSuffix(baseUrl, "/"),
+		ApiPath:      DefaultApiPath,
+		Auth:         auth,
+		HttpClient:   httpClient,
+		Logger:       log.Default(),
+		UserAgent:    DefaultUserAgent,
+		FieldMapping: common.DefaultJiraFieldMapping(),
+	}
+}
+
+/*
+BaseUrlFor turns the value of a -host option into a base URL. A bare hostname is assumed to be https, while anything
+with a scheme is used as-is.
+*/
+func BaseUrlFor(host string) string {
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/")
+	}
+	return "https://" + strings.TrimSuffix(host, "/")
+}
+
+/*
+uri builds the full URL for the given API path (e.g. "/search")
+*/
+func (c *Client) uri(path string, params url.Values) string {
+	out := c.BaseUrl + c.ApiPath + path
+	if len(params) > 0 {
+		out += "?" + params.Encode()
+	}
+	return out
+}
+
+/*
+newRequest builds a request for the given API path with the credentials and user agent set
+*/
+func (c *Client) newRequest(method string, path string, params url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.uri(path, params), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Auth != nil {
+		c.Auth.Apply(req)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	return req, nil
+}
+
+/*
+do makes the request and reads the whole response body. The body is closed before returning.
+*/
+func (c *Client) do(req *http.Request) (*http.Response, []byte, error) {
+	response, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	responseContent, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, responseContent, nil
+}
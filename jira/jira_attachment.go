@@ -0,0 +1,127 @@
+package jira
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/url"
+	"os"
+)
+
+/*
+OpenJiraAttachment starts downloading the content of the given attachment. The caller must close the returned body.
+*/
+func (c *Client) OpenJiraAttachment(attachmentId string) (io.ReadCloser, error) {
+	req, err := c.newRequest("GET", "/attachment/content/"+attachmentId, url.Values{"redirect": {"false"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != 200 {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		return nil, errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	return response.Body, nil
+}
+
+/*
+DownloadJiraAttachment downloads the content of the given attachment to a temp file, checking that it is
+expectedSize bytes long. Returns the name of the downloaded file on success, or an error. The caller must remove
+the file when done with it.
+*/
+func (c *Client) DownloadJiraAttachment(attachmentId string, expectedSize int64) (string, error) {
+	content, err := c.OpenJiraAttachment(attachmentId)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	file, err := ioutil.TempFile("", "trelloatt")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close() //we re-open for reading
+
+	bytesCopied, err := io.Copy(file, ExpectSize(content, expectedSize))
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	c.Logger.Printf("INFO Downloaded %d bytes of attachment to '%s'", bytesCopied, file.Name())
+	return file.Name(), nil
+}
+
+/*
+UploadJiraAttachment attaches the content to the given issue under fileName. The content is streamed, rather than
+read into memory first.
+*/
+func (c *Client) UploadJiraAttachment(issueId string, fileName string, content io.Reader) error {
+	bodyReader, bodyWriter := io.Pipe()
+	form := multipart.NewWriter(bodyWriter)
+	go func() {
+		part, err := form.CreateFormFile("file", fileName)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		bodyWriter.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest("POST", fmt.Sprintf("/issue/%s/attachments", issueId), nil, bodyReader)
+	if err != nil {
+		bodyReader.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-Atlassian-Token", "no-check") //attachments are refused without this
+
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR UploadJiraAttachment server said %s", string(responseContent))
+		return errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	return nil
+}
+
+/*
+sizeCheckingReader passes through reads from the underlying reader, but fails instead of returning EOF if it did not
+produce exactly the expected number of bytes
+*/
+type sizeCheckingReader struct {
+	reader   io.Reader
+	expected int64
+	count    int64
+}
+
+/*
+ExpectSize wraps the reader so that it returns an error rather than EOF if the content is not expectedSize bytes
+long. This stops a truncated download from being passed on as if it were the whole file.
+*/
+func ExpectSize(reader io.Reader, expectedSize int64) io.Reader {
+	return &sizeCheckingReader{reader: reader, expected: expectedSize}
+}
+
+func (r *sizeCheckingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	if r.count > r.expected {
+		return n, errors.New(fmt.Sprintf("expected %d bytes but got more than that", r.expected))
+	}
+	if err == io.EOF && r.count != r.expected {
+		return n, errors.New(fmt.Sprintf("expected %d bytes but only got %d", r.expected, r.count))
+	}
+	return n, err
+}
@@ -0,0 +1,220 @@
+package jira
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// how much of a page that could not be decoded is included in the error
+const maxBodyExcerpt = 500
+
+/*
+bodyExcerpt returns the start of a response body, for error messages
+*/
+func bodyExcerpt(body []byte) string {
+	if len(body) <= maxBodyExcerpt {
+		return string(body)
+	}
+	return string(body[:maxBodyExcerpt]) + "..."
+}
+
+func (c *Client) loadCommentsPage(issueId string, startAt int64, pageSize int32) (*[]common.Comment, int64, error) {
+	params := url.Values{
+		"startAt":    {fmt.Sprintf("%d", startAt)},
+		"maxResults": {fmt.Sprintf("%d", pageSize)},
+	}
+	req, err := c.newRequest("GET", fmt.Sprintf("/issue/%s/comment", issueId), params, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR loadCommentsPage server said %s", string(responseContent))
+		return nil, 0, errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	var result common.PageOfComments
+	err = json.Unmarshal(responseContent, &result)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &result.Comments, result.Total, nil
+}
+
+func (c *Client) LoadAllComments(issueId string, pageSize int32) (*[]common.Comment, error) {
+	ctr := int64(0)
+	result := make([]common.Comment, 0)
+
+	for {
+		comments, total, err := c.loadCommentsPage(issueId, ctr, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *comments...)
+		ctr += int64(len(*comments))
+		if len(*comments) == 0 && ctr < total {
+			//total is only an estimate, so if comments are deleted while we page through we can run out early
+			c.Logger.Printf("WARNING Expected %d comments for issue id %s but only got %d", total, issueId, ctr)
+			break
+		}
+		if ctr >= total {
+			c.Logger.Printf("INFO Retrieved %d comments for issue id %s", ctr, issueId)
+			break
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		firstTime, _ := time.Parse(common.JiraTimeFormat, result[i].Created)
+		secondTime, _ := time.Parse(common.JiraTimeFormat, result[j].Created)
+		return firstTime.Before(secondTime)
+	})
+	return &result, nil
+}
+
+/*
+LoadWatchers returns the list of users watching the given issue
+*/
+func (c *Client) LoadWatchers(issueId string) ([]common.JiraUser, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/issue/%s/watchers", issueId), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR LoadWatchers server said %s", string(responseContent))
+		return nil, errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	var result common.IssueWatchers
+	err = json.Unmarshal(responseContent, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Watchers, nil
+}
+
+/*
+LoadIssues returns one page of the issues matching the query, with every field. Each issue is given the names of
+the fields so that they can be looked up by name, and its typed custom fields are resolved using FieldMapping.
+*/
+func (c *Client) LoadIssues(startAt int, pageSize int, maybeQuery string) (*common.PagedIssues, error) {
+	params := url.Values{
+		"startAt":    {fmt.Sprintf("%d", startAt)},
+		"maxResults": {fmt.Sprintf("%d", pageSize)},
+		"fields":     {"*all"},
+		"expand":     {"names"},
+	}
+	if maybeQuery != "" {
+		params.Set("jql", maybeQuery)
+	}
+
+	req, err := c.newRequest("GET", "/search", params, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, bodyContent, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var issues common.PagedIssues
+
+	switch response.StatusCode {
+	case 200:
+		err = json.Unmarshal(bodyContent, &issues)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("could not decode the page at %d: %s. Content was: %s", startAt, err, bodyExcerpt(bodyContent)))
+		}
+		for i := range issues.Issues {
+			issues.Issues[i].Names = issues.Names
+			issues.Issues[i].ResolveFields(&c.FieldMapping)
+		}
+		return &issues, nil
+	default:
+		c.Logger.Printf("Server returned %d. Body content was: ", response.StatusCode)
+		c.Logger.Print(string(bodyContent))
+		return nil, errors.New("server error")
+	}
+}
+
+/*
+AsyncLoadIssuesJQL pages through every issue matching the query, sending each one to the first channel.
+The issue channel is always closed when loading stops. If it stopped because of an error then the error is sent
+on the second channel before that happens, so once the issue channel is closed the error channel should be checked.
+*/
+func (c *Client) AsyncLoadIssuesJQL(pageSize int, maybeQuery string) (chan common.Issue, chan error) {
+	outCh := make(chan common.Issue, 50)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(outCh)
+		ctr := 0
+		for {
+			pageData, err := c.LoadIssues(ctr, pageSize, maybeQuery)
+			if err != nil {
+				c.Logger.Printf("ERROR Can't load issues page at %d: %s", ctr, err)
+				errCh <- err
+				return
+			}
+			for _, i := range pageData.Issues {
+				outCh <- i
+			}
+			ctr += len(pageData.Issues)
+			if len(pageData.Issues) == 0 && int64(ctr) < pageData.Total {
+				//total is only an estimate, so if issues are deleted while we page through we can run out early
+				c.Logger.Printf("WARNING Server said there were %d issues but only returned %d", pageData.Total, ctr)
+				return
+			}
+			if int64(ctr) >= pageData.Total {
+				c.Logger.Printf("INFO Iterated a total of %d issues, completed", ctr)
+				return
+			}
+		}
+	}()
+
+	return outCh, errCh
+}
+
+func (c *Client) AsyncLoadAllIssues(pageSize int) (chan common.Issue, chan error) {
+	return c.AsyncLoadIssuesJQL(pageSize, common.DefaultIssueJql)
+}
+
+func (c *Client) AsyncLoadAllEpics(pageSize int) (chan common.Issue, chan error) {
+	return c.AsyncLoadIssuesJQL(pageSize, common.DefaultEpicJql)
+}
+
+func (c *Client) SyncLoadAllEpics(pageSize int) ([]common.Issue, error) {
+	return c.SyncLoadIssuesJQL(pageSize, common.DefaultEpicJql)
+}
+
+/*
+SyncLoadIssuesJQL loads every issue matching the query into a slice, or returns the first error
+*/
+func (c *Client) SyncLoadIssuesJQL(pageSize int, query string) ([]common.Issue, error) {
+	outputCh, errCh := c.AsyncLoadIssuesJQL(pageSize, query)
+	result := make([]common.Issue, 0)
+
+	for rec := range outputCh {
+		result = append(result, rec)
+	}
+	select {
+	case err := <-errCh:
+		return nil, err
+	default:
+		return result, nil
+	}
+}
@@ -0,0 +1,258 @@
+package jira_test
+
+import (
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/jiratest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSampleServer(t *testing.T) *jiratest.Server {
+	server, err := jiratest.NewServerWithFixture("sample")
+	if err != nil {
+		t.Fatalf("Could not start fake Jira: %s", err)
+	}
+	return server
+}
+
+/*
+collectIssues reads everything from an AsyncLoadIssuesJQL call, failing the test if the channel is never closed
+*/
+func collectIssues(t *testing.T, client *jira.Client, pageSize int, query string) ([]string, error) {
+	t.Helper()
+	outCh, errCh := client.AsyncLoadIssuesJQL(pageSize, query)
+	keys := make([]string, 0)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case rec, moreContent := <-outCh:
+			if !moreContent {
+				select {
+				case err := <-errCh:
+					return keys, err
+				default:
+					return keys, nil
+				}
+			}
+			keys = append(keys, rec.Key)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for issues, got %v so far", keys)
+		}
+	}
+}
+
+func TestAsyncLoadIssuesJQLPaging(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	keys, err := collectIssues(t, server.Client(), 2, "issueType in (Bug,Task,Story,Subtask)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(keys) != 4 || keys[0] != "PROJ-1" || keys[3] != "PROJ-4" {
+		t.Errorf("Expected PROJ-1 to PROJ-4, got %v", keys)
+	}
+	if server.RequestCount("/search") != 2 {
+		t.Errorf("Expected 2 pages to be requested, got %d", server.RequestCount("/search"))
+	}
+}
+
+func TestAsyncLoadIssuesJQLStatus(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	keys, err := collectIssues(t, server.Client(), 50, "status = Done AND issueType != Epic ORDER BY key")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(keys) != 2 || keys[0] != "PROJ-3" || keys[1] != "PROJ-4" {
+		t.Errorf("Expected PROJ-3 and PROJ-4, got %v", keys)
+	}
+
+	_, err = collectIssues(t, server.Client(), 50, "summary ~ login")
+	if err == nil {
+		t.Error("Expected an error for a query the server can't handle")
+	}
+}
+
+func TestAsyncLoadIssuesJQLEmptyLastPage(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+	server.TotalAdjustment = 3 //as if three issues were deleted after the search started
+
+	keys, err := collectIssues(t, server.Client(), 2, "issueType in (Bug,Task,Story,Subtask)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(keys) != 4 {
+		t.Errorf("Expected all 4 issues, got %v", keys)
+	}
+}
+
+func TestAsyncLoadIssuesJQLServerError(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+	server.FailAfter("/search", 1, 500)
+
+	keys, err := collectIssues(t, server.Client(), 2, "issueType in (Bug,Task,Story,Subtask)")
+	if err == nil {
+		t.Fatal("Expected the server error to be returned")
+	}
+	if len(keys) != 2 {
+		t.Errorf("Expected the first page of issues before the error, got %v", keys)
+	}
+}
+
+func TestSyncLoadAllEpics(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	epics, err := server.Client().SyncLoadAllEpics(1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(epics) != 2 {
+		t.Fatalf("Expected 2 epics, got %d", len(epics))
+	}
+	for _, e := range epics {
+		if e.Key == "" || e.Fields.EpicName == nil {
+			t.Errorf("Got an incomplete epic: %+v", e)
+		}
+	}
+
+	server.FailAfter("/search", 1, 500)
+	_, err = server.Client().SyncLoadAllEpics(1)
+	if err == nil {
+		t.Error("Expected the server error to be returned")
+	}
+}
+
+func TestLoadAllComments(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	comments, err := server.Client().LoadAllComments("PROJ-1", 2)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(*comments) != 3 || (*comments)[0].Id != "30001" || (*comments)[2].Id != "30003" {
+		t.Errorf("Expected all three comments in order, got %+v", *comments)
+	}
+	if server.RequestCount("/issue/PROJ-1/comment") != 2 {
+		t.Errorf("Expected 2 pages to be requested, got %d", server.RequestCount("/issue/PROJ-1/comment"))
+	}
+
+	_, err = server.Client().LoadAllComments("PROJ-999", 2)
+	if err == nil {
+		t.Error("Expected an error for an issue that doesn't exist")
+	}
+}
+
+func TestLoadWatchers(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	watchers, err := server.Client().LoadWatchers("PROJ-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(watchers) != 2 || watchers[0].EmailAddress != "alice@example.com" {
+		t.Errorf("Unexpected watchers %+v", watchers)
+	}
+}
+
+func TestDownloadJiraAttachment(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	fileName, err := server.Client().DownloadJiraAttachment("20001", 16)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer os.Remove(fileName)
+	content, _ := ioutil.ReadFile(fileName)
+	if string(content) != "fake png content" {
+		t.Errorf("Unexpected content '%s'", string(content))
+	}
+
+	_, err = server.Client().DownloadJiraAttachment("99999", 16)
+	if err == nil {
+		t.Error("Expected an error for an attachment that doesn't exist")
+	}
+}
+
+func TestWrongCredentials(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+
+	client := server.Client()
+	client.Auth = jira.BasicAuth{User: server.User, Token: "wrong"}
+	_, err := client.LoadIssues(0, 10, "")
+	if err == nil {
+		t.Error("Expected an error with the wrong token")
+	}
+}
+
+func TestLoadIssuesResolvesFieldsByName(t *testing.T) {
+	server := newSampleServer(t)
+	defer server.Close()
+	client := server.Client()
+
+	issues, err := client.SyncLoadIssuesJQL(50, "issueType in (Bug,Task,Story,Subtask)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	first := issues[0]
+	if first.Fields.EpicLink == nil || *first.Fields.EpicLink != "PROJ-100" || first.Fields.StoryPoints == nil || *first.Fields.StoryPoints != 3 {
+		t.Errorf("Expected the epic link and story points to be resolved by name, got %+v", first.Fields)
+	}
+	if id, _ := first.FieldId("team"); id != "customfield_10050" {
+		t.Errorf("Expected 'team' to resolve to customfield_10050, got '%s'", id)
+	}
+
+	//on a site where the fields are called something else, they are only found once the mapping says so
+	client.FieldMapping.StoryPoints = "Story Points"
+	issues, _ = client.SyncLoadIssuesJQL(50, "issueType in (Bug,Task,Story,Subtask)")
+	if issues[0].Fields.StoryPoints != nil {
+		t.Errorf("Expected no story points from a field that doesn't exist, got %v", *issues[0].Fields.StoryPoints)
+	}
+	client.FieldMapping.StoryPoints = "customfield_10016"
+	issues, _ = client.SyncLoadIssuesJQL(50, "issueType in (Bug,Task,Story,Subtask)")
+	if issues[0].Fields.StoryPoints == nil {
+		t.Error("Expected story points to be found by field ID")
+	}
+
+	epics, _ := client.SyncLoadAllEpics(50)
+	if epics[0].Fields.EpicColour == nil || epics[0].Fields.TranslateEpicColour(nil) != "blue" {
+		t.Errorf("Expected the epic colour to be resolved, got %v", epics[0].Fields.EpicColour)
+	}
+}
+
+func TestLoadIssuesUndecodablePage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issues": [{"key": "PROJ-1", "fields": `))
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "jiraclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	workingDir, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(workingDir)
+
+	client := jira.NewClient(server.URL, jira.PersonalAccessToken{Token: "token"}, server.Client())
+	_, err = client.LoadIssues(0, 10, "")
+	if err == nil || !strings.Contains(err.Error(), `{"issues": [{"key": "PROJ-1"`) {
+		t.Errorf("expected the error to include the content that could not be decoded, got %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("expected nothing to be written to the working directory, got %d files", len(files))
+	}
+}
@@ -0,0 +1,120 @@
+package jira
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/url"
+)
+
+/*
+postJson sends content as a JSON body to the given API path and returns the response content if the server replied
+with expectedStatus. `operation` is used to label log messages and errors.
+*/
+func (c *Client) postJson(operation string, path string, content interface{}, expectedStatus int) ([]byte, error) {
+	body, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest("POST", path, nil, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != expectedStatus {
+		c.Logger.Printf("ERROR %s server said %s", operation, string(responseContent))
+		return nil, errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	return responseContent, nil
+}
+
+/*
+CreateIssue creates a new issue with the given fields, keyed by field ID (e.g. "summary", "project" or
+"customfield_10014"). Descriptions must be ADF, see common.MarkdownToAdf.
+*/
+func (c *Client) CreateIssue(fields map[string]interface{}) (*common.CreatedIssue, error) {
+	responseContent, err := c.postJson("CreateIssue", "/issue", map[string]interface{}{"fields": fields}, 201)
+	if err != nil {
+		return nil, err
+	}
+	var created common.CreatedIssue
+	err = json.Unmarshal(responseContent, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+/*
+AddComment adds a comment with the given ADF body to the end of an issue's comments
+*/
+func (c *Client) AddComment(issueId string, body *common.JiraContent) error {
+	_, err := c.postJson("AddComment", fmt.Sprintf("/issue/%s/comment", issueId), map[string]interface{}{"body": body}, 201)
+	return err
+}
+
+/*
+LoadIssueStatus returns the current status of an issue
+*/
+func (c *Client) LoadIssueStatus(issueId string) (*common.IssueStatus, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/issue/%s", issueId), url.Values{"fields": {"status"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR LoadIssueStatus server said %s", string(responseContent))
+		return nil, errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	var issue common.Issue
+	err = json.Unmarshal(responseContent, &issue)
+	if err != nil {
+		return nil, err
+	}
+	return &issue.Fields.Status, nil
+}
+
+/*
+LoadTransitions returns the transitions that can be made from the issue's current status
+*/
+func (c *Client) LoadTransitions(issueId string) ([]common.IssueTransition, error) {
+	req, err := c.newRequest("GET", fmt.Sprintf("/issue/%s/transitions", issueId), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR LoadTransitions server said %s", string(responseContent))
+		return nil, errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
+	}
+	var result common.IssueTransitions
+	err = json.Unmarshal(responseContent, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Transitions, nil
+}
+
+/*
+TransitionIssue moves an issue along the given workflow transition, as returned by LoadTransitions
+*/
+func (c *Client) TransitionIssue(issueId string, transitionId string) error {
+	content := map[string]interface{}{"transition": map[string]string{"id": transitionId}}
+	_, err := c.postJson("TransitionIssue", fmt.Sprintf("/issue/%s/transitions", issueId), content, 204)
+	return err
+}
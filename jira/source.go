@@ -0,0 +1,19 @@
+package jira
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"io"
+)
+
+/*
+Source is the read-only view of Jira that the migration tools work from. Client reads from the live Jira API, and
+snapshot.Snapshot reads from an export made earlier, so that a migration can be repeated against the same data.
+*/
+type Source interface {
+	AsyncLoadIssuesJQL(pageSize int, maybeQuery string) (chan common.Issue, chan error)
+	SyncLoadIssuesJQL(pageSize int, query string) ([]common.Issue, error)
+	LoadAllComments(issueId string, pageSize int32) (*[]common.Comment, error)
+	LoadWatchers(issueId string) ([]common.JiraUser, error)
+	OpenJiraAttachment(attachmentId string) (io.ReadCloser, error)
+	DownloadJiraAttachment(attachmentId string, expectedSize int64) (string, error)
+}
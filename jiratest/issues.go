@@ -0,0 +1,242 @@
+package jiratest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+)
+
+/*
+CreatedIssues returns a copy of every issue created through the API, in the order they were created
+*/
+func (s *Server) CreatedIssues() []CreatedIssue {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make([]CreatedIssue, len(s.created))
+	for i, c := range s.created {
+		out[i] = *c
+	}
+	return out
+}
+
+/*
+Comments returns a copy of the comments on the given issue, including any added through the API
+*/
+func (s *Server) Comments(issueKey string) []common.Comment {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]common.Comment{}, s.fixture.Comments[issueKey]...)
+}
+
+func (s *Server) findCreated(keyOrId string) *CreatedIssue {
+	for _, c := range s.created {
+		if c.Key == keyOrId || c.Id == keyOrId {
+			return c
+		}
+	}
+	return nil
+}
+
+/*
+indexCreated (re-)adds a created issue to the searchable issues, so that it reflects its current status.
+The caller must hold the mutex.
+*/
+func (s *Server) indexCreated(issue *CreatedIssue) error {
+	fields := make(map[string]interface{}, len(issue.Fields)+1)
+	for k, v := range issue.Fields {
+		fields[k] = v
+	}
+	fields["status"] = map[string]string{"name": issue.Status}
+	raw, err := json.Marshal(map[string]interface{}{"id": issue.Id, "key": issue.Key, "fields": fields})
+	if err != nil {
+		return err
+	}
+	for i := range s.issues {
+		if s.issues[i].Key == issue.Key {
+			s.issues = append(s.issues[:i], s.issues[i+1:]...)
+			break
+		}
+	}
+	return s.addIssue(raw)
+}
+
+/*
+nestedString returns fields[key][nestedKey] if it is a string, e.g. the key of {"project": {"key": "PROJ"}}
+*/
+func nestedString(fields map[string]interface{}, key string, nestedKey string) string {
+	nested, isMap := fields[key].(map[string]interface{})
+	if !isMap {
+		return ""
+	}
+	value, _ := nested[nestedKey].(string)
+	return value
+}
+
+/*
+isAdfDoc returns true if the value looks like the top level of an ADF document
+*/
+func isAdfDoc(value interface{}) bool {
+	doc, isMap := value.(map[string]interface{})
+	if !isMap {
+		return false
+	}
+	_, haveContent := doc["content"].([]interface{})
+	return doc["type"] == "doc" && doc["version"] == float64(1) && haveContent
+}
+
+/*
+getIssue returns an issue with all of its fields, whatever fields were asked for
+*/
+func (s *Server) getIssue(w http.ResponseWriter, keyOrId string) {
+	for _, i := range s.issues {
+		if i.Key == keyOrId || i.Id == keyOrId {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(i.raw)
+			return
+		}
+	}
+	writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+}
+
+func (s *Server) createIssue(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Fields map[string]interface{} `json:"fields"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &request); err != nil || request.Fields == nil {
+		writeError(w, 400, "Request body must contain fields")
+		return
+	}
+	fields := request.Fields
+	project := nestedString(fields, "project", "key")
+	switch {
+	case project == "":
+		writeError(w, 400, "project: Specify a valid project ID or key")
+		return
+	case nestedString(fields, "issuetype", "name") == "":
+		writeError(w, 400, "issuetype: Specify an issue type")
+		return
+	case fields["summary"] == nil || fields["summary"] == "":
+		writeError(w, 400, "summary: You must specify a summary of the issue.")
+		return
+	}
+	if description, haveDescription := fields["description"]; haveDescription && !isAdfDoc(description) {
+		writeError(w, 400, "description: Operation value must be an Atlassian Document.")
+		return
+	}
+
+	issue := &CreatedIssue{
+		Id:     strconv.Itoa(50000 + len(s.created) + 1),
+		Key:    fmt.Sprintf("%s-%d", project, 1000+len(s.created)+1),
+		Fields: fields,
+		Status: s.Statuses[0],
+	}
+	if err := s.indexCreated(issue); err != nil {
+		writeError(w, 500, err.Error())
+		return
+	}
+	s.created = append(s.created, issue)
+	w.WriteHeader(201)
+	writeJson(w, common.CreatedIssue{Id: issue.Id, Key: issue.Key, Self: s.URL + apiPrefix + "/issue/" + issue.Id})
+}
+
+func (s *Server) postComment(w http.ResponseWriter, r *http.Request, keyOrId string) {
+	key, haveIssue := s.findIssue(keyOrId)
+	if !haveIssue {
+		writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	var request struct {
+		Body interface{} `json:"body"`
+	}
+	content, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(content, &request); err != nil || !isAdfDoc(request.Body) {
+		writeError(w, 400, "Comment body must be an Atlassian Document.")
+		return
+	}
+	var comment common.Comment
+	json.Unmarshal(content, &comment)
+	comment.Id = strconv.Itoa(60000 + len(s.fixture.Comments[key]) + 1)
+	s.fixture.Comments[key] = append(s.fixture.Comments[key], comment)
+	w.WriteHeader(201)
+	writeJson(w, comment)
+}
+
+func (s *Server) postAttachment(w http.ResponseWriter, r *http.Request, keyOrId string) {
+	issue := s.findCreated(keyOrId)
+	if issue == nil {
+		writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	if r.Header.Get("X-Atlassian-Token") != "no-check" {
+		writeError(w, 403, "XSRF check failed")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	defer file.Close()
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	issue.Attachments = append(issue.Attachments, Upload{FileName: header.Filename, Content: content})
+	writeJson(w, []map[string]interface{}{{"filename": header.Filename, "size": len(content)}})
+}
+
+func (s *Server) transitionsFor(issue *CreatedIssue) []common.IssueTransition {
+	out := make([]common.IssueTransition, 0, len(s.Statuses))
+	for i, status := range s.Statuses {
+		if status == issue.Status {
+			continue
+		}
+		out = append(out, common.IssueTransition{
+			Id:   strconv.Itoa((i + 1) * 10),
+			Name: status,
+			To:   common.IssueStatus{Id: strconv.Itoa(i + 1), Name: status},
+		})
+	}
+	return out
+}
+
+func (s *Server) getTransitions(w http.ResponseWriter, keyOrId string) {
+	issue := s.findCreated(keyOrId)
+	if issue == nil {
+		writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	writeJson(w, common.IssueTransitions{Transitions: s.transitionsFor(issue)})
+}
+
+func (s *Server) postTransition(w http.ResponseWriter, r *http.Request, keyOrId string) {
+	issue := s.findCreated(keyOrId)
+	if issue == nil {
+		writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	var request struct {
+		Transition struct {
+			Id string `json:"id"`
+		} `json:"transition"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &request)
+	for _, t := range s.transitionsFor(issue) {
+		if t.Id == request.Transition.Id {
+			issue.Status = t.To.Name
+			if err := s.indexCreated(issue); err != nil {
+				writeError(w, 500, err.Error())
+				return
+			}
+			w.WriteHeader(204)
+			return
+		}
+	}
+	writeError(w, 400, "Transition id '"+request.Transition.Id+"' is not valid for this issue.")
+}
@@ -0,0 +1,92 @@
+package jiratest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+jqlClause is a single condition from a JQL query, e.g. `issueType in (Bug, Task)`
+*/
+type jqlClause struct {
+	field  string
+	negate bool
+	values []string
+}
+
+var clauseRegex = regexp.MustCompile(`(?i)^\s*(\w+)\s*(=|!=|not\s+in|in)\s*(.+?)\s*$`)
+var orderByRegex = regexp.MustCompile(`(?i)\s+order\s+by\s+.*$`)
+var andRegex = regexp.MustCompile(`(?i)\s+and\s+`)
+
+/*
+parseJql understands just enough JQL for the queries this project makes: clauses on issueType or status using =, !=,
+in or not in, joined with AND. An ORDER BY is accepted but ignored. Anything else is an error, like a real server
+rejecting a query it can't parse.
+*/
+func parseJql(query string) ([]jqlClause, error) {
+	query = orderByRegex.ReplaceAllString(strings.TrimSpace(query), "")
+	if query == "" {
+		return nil, nil
+	}
+
+	clauses := make([]jqlClause, 0)
+	for _, part := range andRegex.Split(query, -1) {
+		matches := clauseRegex.FindStringSubmatch(part)
+		if matches == nil {
+			return nil, errors.New(fmt.Sprintf("Error in the JQL Query: can't parse '%s'", part))
+		}
+		field := strings.ToLower(matches[1])
+		switch field {
+		case "issuetype", "type":
+			field = "issuetype"
+		case "status":
+		default:
+			return nil, errors.New(fmt.Sprintf("Field '%s' is not supported by the fake server", matches[1]))
+		}
+
+		operator := strings.ToLower(strings.Join(strings.Fields(matches[2]), " "))
+		clause := jqlClause{field: field, negate: operator == "!=" || operator == "not in"}
+		if operator == "in" || operator == "not in" {
+			list := strings.TrimSpace(matches[3])
+			if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+				return nil, errors.New(fmt.Sprintf("Error in the JQL Query: expected a list after '%s'", matches[2]))
+			}
+			for _, v := range strings.Split(list[1:len(list)-1], ",") {
+				clause.values = append(clause.values, unquote(v))
+			}
+		} else {
+			clause.values = []string{unquote(matches[3])}
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+func unquote(value string) string {
+	return strings.Trim(strings.TrimSpace(value), `"'`)
+}
+
+/*
+matches returns true if an issue with the given type and status names satisfies every clause
+*/
+func matches(clauses []jqlClause, issueType string, status string) bool {
+	for _, c := range clauses {
+		actual := issueType
+		if c.field == "status" {
+			actual = status
+		}
+		found := false
+		for _, v := range c.values {
+			if strings.EqualFold(v, actual) {
+				found = true
+				break
+			}
+		}
+		if found == c.negate {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,480 @@
+package jiratest
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+const apiPrefix = "/rest/api/3"
+
+/*
+MaxPageSize is the most results the server returns in one page, whatever maxResults asks for. Real Jira Cloud
+caps searches at 100.
+*/
+const MaxPageSize = 100
+
+/*
+Fixture is the content of a fixture file. Issues are kept as the raw JSON from Jira, so that fields the models
+don't know about are served back unchanged.
+*/
+type Fixture struct {
+	Issues      []json.RawMessage            `json:"issues"`
+	Names       map[string]string            `json:"names"`       //custom field IDs to names, for expand=names
+	Comments    map[string][]common.Comment  `json:"comments"`    //keyed by issue key
+	Watchers    map[string][]common.JiraUser `json:"watchers"`    //keyed by issue key
+	Attachments map[string]string            `json:"attachments"` //attachment ID to file content
+}
+
+/*
+indexedIssue holds the few fields of a raw issue that the server needs to find and filter it
+*/
+type indexedIssue struct {
+	Id     string `json:"id"`
+	Key    string `json:"key"`
+	Fields struct {
+		IssueType struct {
+			Name string `json:"name"`
+		} `json:"issuetype"`
+		Status struct {
+			Name string `json:"name"`
+		} `json:"status"`
+	} `json:"fields"`
+	raw json.RawMessage
+}
+
+/*
+CreatedIssue is an issue that was created through the API, along with everything that has been added to it since
+*/
+type CreatedIssue struct {
+	Id          string
+	Key         string
+	Fields      map[string]interface{} //as sent when the issue was created
+	Status      string
+	Attachments []Upload
+}
+
+/*
+Upload is an attachment that was uploaded to an issue
+*/
+type Upload struct {
+	FileName string
+	Content  []byte
+}
+
+/*
+Server is an in-memory stand-in for the parts of the Jira Cloud REST API (v3) that this project uses: searching with
+JQL, issue comments and watchers, and attachment content, plus creating issues, adding comments and attachments to
+them and moving them through the workflow. It serves whatever fixtures have been loaded into it, paging results the
+way the real server does.
+*/
+type Server struct {
+	*httptest.Server
+	User  string
+	Token string
+
+	/*
+		TotalAdjustment is added to the `total` reported by searches. Jira's total is only an estimate, so setting this
+		simulates issues being deleted (positive) or created (negative) while a client is paging through.
+	*/
+	TotalAdjustment int64
+	/*
+		Statuses are the statuses in the workflow of created issues. Issues start in the first one, and can be moved
+		straight to any other, with a transition named after the status it goes to.
+	*/
+	Statuses []string
+
+	mutex    sync.Mutex
+	created  []*CreatedIssue
+	issues   []indexedIssue
+	fixture  Fixture
+	failures []failure
+	requests map[string]int
+}
+
+type failure struct {
+	path      string
+	successes int
+	status    int
+}
+
+/*
+NewServer starts a new fake Jira server with no content. Call Close when done with it.
+*/
+func NewServer() *Server {
+	s := &Server{
+		User:     "test@example.com",
+		Token:    "test-token",
+		Statuses: []string{"To Do", "In Progress", "Done"},
+		issues:   make([]indexedIssue, 0),
+		requests: make(map[string]int),
+		fixture: Fixture{
+			Names:       make(map[string]string),
+			Comments:    make(map[string][]common.Comment),
+			Watchers:    make(map[string][]common.JiraUser),
+			Attachments: make(map[string]string),
+		},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+/*
+NewServerWithFixture starts a new fake Jira server loaded with one of the fixtures built into this package,
+e.g. "sample"
+*/
+func NewServerWithFixture(name string) (*Server, error) {
+	content, err := fixtures.ReadFile("fixtures/" + name + ".json")
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer()
+	if err = s.LoadFixtureJson(content); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+/*
+Client returns a jira.Client that talks to this server
+*/
+func (s *Server) Client() *jira.Client {
+	return jira.NewClient(s.URL, jira.BasicAuth{User: s.User, Token: s.Token}, s.Server.Client())
+}
+
+/*
+LoadFixture adds the content of the given fixture file to the server
+*/
+func (s *Server) LoadFixture(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return s.LoadFixtureJson(content)
+}
+
+/*
+LoadFixtureJson adds the content of a fixture to the server. Issues are appended to any that are already loaded.
+*/
+func (s *Server) LoadFixtureJson(content []byte) error {
+	var fixture Fixture
+	err := json.Unmarshal(content, &fixture)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, raw := range fixture.Issues {
+		if err = s.addIssue(raw); err != nil {
+			return err
+		}
+	}
+	for k, v := range fixture.Names {
+		s.fixture.Names[k] = v
+	}
+	for k, v := range fixture.Comments {
+		s.fixture.Comments[k] = append(s.fixture.Comments[k], v...)
+	}
+	for k, v := range fixture.Watchers {
+		s.fixture.Watchers[k] = append(s.fixture.Watchers[k], v...)
+	}
+	for k, v := range fixture.Attachments {
+		s.fixture.Attachments[k] = v
+	}
+	return nil
+}
+
+/*
+AddIssue adds a single issue to the server. It is marshalled to JSON and served back as-is.
+*/
+func (s *Server) AddIssue(issue interface{}) error {
+	raw, err := json.Marshal(issue)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addIssue(raw)
+}
+
+func (s *Server) addIssue(raw json.RawMessage) error {
+	var indexed indexedIssue
+	err := json.Unmarshal(raw, &indexed)
+	if err != nil {
+		return err
+	}
+	if indexed.Key == "" {
+		return errors.New("fixture issue has no key")
+	}
+	indexed.raw = raw
+	s.issues = append(s.issues, indexed)
+	return nil
+}
+
+/*
+AddComment adds a comment to the end of the given issue's comments
+*/
+func (s *Server) AddComment(issueKey string, comment common.Comment) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fixture.Comments[issueKey] = append(s.fixture.Comments[issueKey], comment)
+}
+
+/*
+AddAttachment sets the content served for the given attachment ID
+*/
+func (s *Server) AddAttachment(attachmentId string, content string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fixture.Attachments[attachmentId] = content
+}
+
+/*
+FailAfter lets `successes` more requests to the given path (relative to /rest/api/3, e.g. "/search") through and then
+fails the next one with the given status code. Use 0 successes to fail the very next request.
+*/
+func (s *Server) FailAfter(path string, successes int, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures = append(s.failures, failure{path: path, successes: successes, status: status})
+}
+
+/*
+RequestCount returns how many requests have been made to the given path (relative to /rest/api/3)
+*/
+func (s *Server) RequestCount(path string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests[path]
+}
+
+/*
+takeFailure returns the status code to fail this request with, or 0 if it should go through.
+The caller must hold the mutex.
+*/
+func (s *Server) takeFailure(path string) int {
+	for i := range s.failures {
+		if s.failures[i].path != path {
+			continue
+		}
+		if s.failures[i].successes > 0 {
+			s.failures[i].successes--
+			return 0
+		}
+		status := s.failures[i].status
+		s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		return status
+	}
+	return 0
+}
+
+func writeJson(w http.ResponseWriter, content interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(content)
+}
+
+/*
+writeError replies in the same shape as Jira's own error responses
+*/
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errorMessages": []string{message},
+		"errors":        map[string]string{},
+	})
+}
+
+func intParam(r *http.Request, name string, defaultValue int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, errors.New(fmt.Sprintf("'%s' is not a valid value for %s", value, name))
+	}
+	return parsed, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, token, haveAuth := r.BasicAuth()
+	if !haveAuth || user != s.User || token != s.Token {
+		writeError(w, 401, "Client must be authenticated to access this resource.")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix+"/") {
+		writeError(w, 404, "No resource found at "+r.URL.Path)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+	s.requests[path]++
+	if status := s.takeFailure(path); status != 0 {
+		writeError(w, status, "Injected failure")
+		return
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == "GET" && path == "/search":
+		s.search(w, r)
+	case r.Method == "GET" && len(segments) == 3 && segments[0] == "issue" && segments[2] == "comment":
+		s.comments(w, r, segments[1])
+	case r.Method == "GET" && len(segments) == 3 && segments[0] == "issue" && segments[2] == "watchers":
+		s.watchers(w, segments[1])
+	case r.Method == "GET" && len(segments) == 3 && segments[0] == "attachment" && segments[1] == "content":
+		s.attachmentContent(w, segments[2])
+	case r.Method == "GET" && len(segments) == 2 && segments[0] == "issue":
+		s.getIssue(w, segments[1])
+	case r.Method == "POST" && path == "/issue":
+		s.createIssue(w, r)
+	case r.Method == "POST" && len(segments) == 3 && segments[0] == "issue" && segments[2] == "comment":
+		s.postComment(w, r, segments[1])
+	case r.Method == "POST" && len(segments) == 3 && segments[0] == "issue" && segments[2] == "attachments":
+		s.postAttachment(w, r, segments[1])
+	case r.Method == "GET" && len(segments) == 3 && segments[0] == "issue" && segments[2] == "transitions":
+		s.getTransitions(w, segments[1])
+	case r.Method == "POST" && len(segments) == 3 && segments[0] == "issue" && segments[2] == "transitions":
+		s.postTransition(w, r, segments[1])
+	default:
+		writeError(w, 404, "No resource found at "+r.URL.Path)
+	}
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	startAt, err := intParam(r, "startAt", 0)
+	if err == nil {
+		var maxResults int
+		maxResults, err = intParam(r, "maxResults", 50)
+		if err == nil {
+			var clauses []jqlClause
+			clauses, err = parseJql(r.URL.Query().Get("jql"))
+			if err == nil {
+				s.writeSearchPage(w, r, clauses, startAt, maxResults)
+				return
+			}
+		}
+	}
+	writeError(w, 400, err.Error())
+}
+
+func (s *Server) writeSearchPage(w http.ResponseWriter, r *http.Request, clauses []jqlClause, startAt int, maxResults int) {
+	if maxResults > MaxPageSize {
+		maxResults = MaxPageSize
+	}
+
+	matched := make([]json.RawMessage, 0)
+	for _, i := range s.issues {
+		if matches(clauses, i.Fields.IssueType.Name, i.Fields.Status.Name) {
+			matched = append(matched, i.raw)
+		}
+	}
+
+	page := make([]json.RawMessage, 0)
+	if startAt < len(matched) {
+		end := startAt + maxResults
+		if end > len(matched) {
+			end = len(matched)
+		}
+		page = matched[startAt:end]
+	}
+
+	response := map[string]interface{}{
+		"expand":     "names,schema",
+		"startAt":    startAt,
+		"maxResults": maxResults,
+		"total":      int64(len(matched)) + s.TotalAdjustment,
+		"issues":     page,
+	}
+	if strings.Contains(r.URL.Query().Get("expand"), "names") {
+		response["names"] = s.fixture.Names
+	}
+	writeJson(w, response)
+}
+
+/*
+findIssue returns the key of the issue with the given key or ID, or false if there isn't one
+*/
+func (s *Server) findIssue(keyOrId string) (string, bool) {
+	for _, i := range s.issues {
+		if i.Key == keyOrId || i.Id == keyOrId {
+			return i.Key, true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) comments(w http.ResponseWriter, r *http.Request, keyOrId string) {
+	key, haveIssue := s.findIssue(keyOrId)
+	if !haveIssue {
+		writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	startAt, err := intParam(r, "startAt", 0)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	maxResults, err := intParam(r, "maxResults", 50)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	if maxResults > MaxPageSize {
+		maxResults = MaxPageSize
+	}
+
+	all := s.fixture.Comments[key]
+	page := make([]common.Comment, 0)
+	if startAt < len(all) {
+		end := startAt + maxResults
+		if end > len(all) {
+			end = len(all)
+		}
+		page = all[startAt:end]
+	}
+	writeJson(w, common.PageOfComments{
+		StartAt:    int64(startAt),
+		MaxResults: int32(maxResults),
+		Total:      int64(len(all)),
+		Comments:   page,
+	})
+}
+
+func (s *Server) watchers(w http.ResponseWriter, keyOrId string) {
+	key, haveIssue := s.findIssue(keyOrId)
+	if !haveIssue {
+		writeError(w, 404, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+	watchers := append([]common.JiraUser{}, s.fixture.Watchers[key]...)
+	writeJson(w, common.IssueWatchers{WatchCount: int64(len(watchers)), Watchers: watchers})
+}
+
+func (s *Server) attachmentContent(w http.ResponseWriter, attachmentId string) {
+	content, haveAttachment := s.fixture.Attachments[attachmentId]
+	if !haveAttachment {
+		writeError(w, 404, "The attachment with id '"+attachmentId+"' does not exist")
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write([]byte(content))
+}
@@ -3,34 +3,73 @@ package main
 import (
 	"flag"
 	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/snapshot"
 	"github.com/fredex42/mm-jira-migration/trello"
 	"log"
 )
 
 func main() {
-	jiraKeyPath := flag.String("jirakey", "scriptkey.yaml", "Path to a file containing a Jira API key")
-	trelloKeyPath := flag.String("trellokey", "trellokey.yaml", "Path to a file containing a Trello API key")
-	hostname := flag.String("host", "", "Virtual Jira host to query")
-	pageSize := flag.Int("pagesize", 50, "number of issues to fetch in one page")
-	boardId := flag.String("board", "", "Trello board to update")
-	customFieldName := flag.String("field", "component", "Custom field to create or update with epic names")
-	flag.Parse()
-
-	jiraKey, err := common.LoadScriptKey(jiraKeyPath)
+	cfg := common.DefaultMigrationConfig()
+	cfg.Fields.Epic = "component"
+	configPath := flag.String("config", "", "Path to a YAML migration config file. Flags given on the command line override its settings")
+	flag.StringVar(&cfg.Jira.Credentials, "jira", cfg.Jira.Credentials, "Path to a file containing a Jira API key")
+	flag.StringVar(&cfg.Jira.Credentials, "jirakey", cfg.Jira.Credentials, "Deprecated, use -jira")
+	flag.StringVar(&cfg.Trello.Credentials, "trello", cfg.Trello.Credentials, "Path to a file containing a Trello API key")
+	flag.StringVar(&cfg.Trello.Credentials, "trellokey", cfg.Trello.Credentials, "Deprecated, use -trello")
+	flag.StringVar(&cfg.Jira.Host, "host", cfg.Jira.Host, "Jira host to query, or its full base URL (including any context path) if it is not https")
+	flag.IntVar(&cfg.Jira.PageSize, "pagesize", cfg.Jira.PageSize, "number of issues to fetch in one page")
+	flag.StringVar(&cfg.Trello.Board, "board", cfg.Trello.Board, "Trello board to update")
+	flag.StringVar(&cfg.Fields.Epic, "epicfield", cfg.Fields.Epic, "Custom field to create or update with epic names")
+	flag.StringVar(&cfg.Fields.Epic, "field", cfg.Fields.Epic, "Deprecated, use -epicfield")
+	snapshotPath := flag.String("from-snapshot", "", "Path to a snapshot directory or tarball written by export, to read epics from instead of the Jira API. No Jira credentials are needed")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *snapshotPath != "" {
+		err = cfg.ValidateOffline()
+	} else {
+		err = cfg.Validate()
+	}
 	if err != nil {
-		log.Fatal("ERROR Could not load key from ", *jiraKeyPath, ": ", err)
+		log.Fatal(err)
+	}
+
+	var jiraClient jira.Source
+	if *snapshotPath != "" {
+		snap, err := snapshot.Open(*snapshotPath)
+		if err != nil {
+			log.Fatal("ERROR Could not open snapshot ", *snapshotPath, ": ", err)
+		}
+		defer snap.Close()
+		cfg.Jira.EpicJql = snap.Manifest.EpicJql
+		snap.FieldMapping = cfg.Jira.Fields
+		jiraClient = snap
+	} else {
+		jiraKey, err := common.LoadScriptKey(&cfg.Jira.Credentials)
+		if err != nil {
+			log.Fatal("ERROR Could not load key from ", cfg.Jira.Credentials, ": ", err)
+		}
+		jiraAuth, err := jira.AuthFromScriptKey(jiraKey)
+		if err != nil {
+			log.Fatal("ERROR Invalid key in ", cfg.Jira.Credentials, ": ", err)
+		}
+		client := jira.NewClient(jira.BaseUrlFor(cfg.Jira.Host), jiraAuth, nil)
+		client.FieldMapping = cfg.Jira.Fields
+		jiraClient = client
 	}
-	trelloKey, err := common.LoadScriptKey(trelloKeyPath)
+	trelloKey, err := common.LoadScriptKey(&cfg.Trello.Credentials)
 	if err != nil {
-		log.Fatal("ERROR Could not load key from ", *trelloKeyPath, ": ", err)
+		log.Fatal("ERROR Could not load key from ", cfg.Trello.Credentials, ": ", err)
 	}
 
-	epicsList, err := common.SyncLoadAllEpics(*hostname, jiraKey, *pageSize)
+	epicsList, err := jiraClient.SyncLoadIssuesJQL(cfg.Jira.PageSize, cfg.Jira.EpicJql)
 	if err != nil {
 		log.Fatal("ERROR Could not load in epics: ", err)
 	}
 
-	fieldContent, err := trello.SetupEpicsField(*boardId, *customFieldName, &epicsList, trelloKey)
+	fieldContent, err := trello.NewClient(trelloKey, nil).SetupEpicsField(cfg.Trello.Board, cfg.Fields.Epic, &epicsList, cfg.EpicColours)
 	if err != nil {
 		log.Fatal("ERROR Could not upload content to Trello: ", err)
 	}
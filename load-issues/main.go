@@ -1,232 +1,414 @@
 package main
 
 import (
-	"errors"
 	"flag"
-	"fmt"
 	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/migrate"
+	"github.com/fredex42/mm-jira-migration/snapshot"
 	"github.com/fredex42/mm-jira-migration/trello"
 	"log"
-	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
-/*
-MakeEpicLink sets the custom field on a created trello card to the epic's value.
-Assumes that recPtr.Fields.EpicLink != nil, will abort if this is not the case.
-*/
-func MakeEpicLink(recPtr *common.Issue, cardId string, epics *EpicsCache, epicLinkField *common.TrelloCustomField, trelloKey *common.ScriptKey, httpClient *http.Client) error {
-	log.Printf("INFO Issue '%s' has a link to epic '%s'", recPtr.Fields.Summary, *recPtr.Fields.EpicLink)
+func main() {
+	cfg := common.DefaultMigrationConfig()
+	configPath := flag.String("config", "", "Path to a YAML migration config file. Flags given on the command line override its settings")
+	flag.StringVar(&cfg.Jira.Credentials, "jira", cfg.Jira.Credentials, "Path to a file containing a Jira API key")
+	flag.StringVar(&cfg.Trello.Credentials, "trello", cfg.Trello.Credentials, "Path to a file containing a Trello API key")
+	flag.StringVar(&cfg.Jira.Host, "host", cfg.Jira.Host, "Jira host to query, or its full base URL (including any context path) if it is not https")
+	flag.IntVar(&cfg.Jira.PageSize, "pagesize", cfg.Jira.PageSize, "number of issues to fetch in one page")
+	flag.StringVar(&cfg.Jira.IssueJql, "jql", cfg.Jira.IssueJql, "JQL query selecting the issues to migrate")
+	flag.StringVar(&cfg.Trello.Board, "board", cfg.Trello.Board, "Board ID to push data into")
+	flag.StringVar(&cfg.Lists.Fallback, "defaultlist", cfg.Lists.Fallback, "Name of the list to push cards into by default")
+	listMapPath := flag.String("listmap", "", "Path to a YAML file mapping Jira statuses to Trello lists, replacing the lists section of the config. If neither is set, everything goes to -defaultlist and done issues are skipped")
+	flag.StringVar(&cfg.Fields.Epic, "epicfield", cfg.Fields.Epic, "Name of the custom field to hold epics information")
+	flag.StringVar(&cfg.Fields.JiraKey, "jira-id", cfg.Fields.JiraKey, "Name of the custom field to hold the jira ID")
+	flag.StringVar(&cfg.Fields.Priority, "priority-field", cfg.Fields.Priority, "Name of the list custom field to hold the priority")
+	statePath := flag.String("state", "migration-state.json", "Path to a file recording migration progress, so that a re-run can resume where it stopped")
+	runId := flag.String("run-id", "", "ID to record the cards, labels and field options created by this run under, for rollback. Defaults to the current UTC time. Giving the ID of an earlier run adds to it")
+	dryRun := flag.Bool("dry-run", false, "Work out what would be migrated and output a plan, without writing anything to Trello")
+	reportPaths := flag.String("report", "migration-report.json,migration-report.csv", "Comma-separated paths to write a report of what happened to each issue to. Paths ending in .csv get CSV, anything else JSON. Not written on a dry run")
+	planPath := flag.String("plan", "migration-plan.json", "When using -dry-run, write the plan as JSON to this path ('-' for stdout)")
+	memberOverridesPath := flag.String("member-overrides", "", "Path to a YAML file mapping Jira users (account ID, email or display name) to Trello usernames")
+	subtaskModeSpec := flag.String("subtasks", string(migrate.SubtasksAsChecklist), "How to migrate sub-tasks: 'checklist' to add them to a checklist on the parent's card, or 'cards' for separate cards linked to the parent")
+	labelColoursPath := flag.String("label-colours", "", "Path to a YAML file giving the colour to use for each Jira label when it is created on the board, over any in the config")
+	workers := flag.Int("workers", 1, "Number of issues to migrate in parallel. Everything for one card is still done in order by a single worker")
+	attachmentPolicyPath := flag.String("attachment-policy", "", "Path to a YAML file saying what to do with attachments that are too large for Trello. If not set, every attachment is uploaded")
+	sprintModeSpec := flag.String("sprints", string(migrate.SprintsIgnore), "How to migrate sprints: 'labels' to label cards with their active or future sprint, 'lists' to put cards in an active sprint into a list named after it, 'field' to put the sprint into the custom field named by -sprint-field, or 'none'")
+	flag.StringVar(&cfg.Fields.Sprint, "sprint-field", cfg.Fields.Sprint, "Name of the text or list custom field to hold the sprint when using -sprints field. A list field is created if it does not exist")
+	flag.StringVar(&cfg.Fields.OriginalEstimate, "original-estimate-field", cfg.Fields.OriginalEstimate, "Name of a number custom field to hold the original estimate in hours. It is created if it does not exist. If not set, original estimates are not migrated")
+	flag.StringVar(&cfg.Fields.RemainingEstimate, "remaining-estimate-field", cfg.Fields.RemainingEstimate, "Name of a number custom field to hold the remaining estimate in hours. It is created if it does not exist. If not set, remaining estimates are not migrated")
+	flag.StringVar(&cfg.Fields.StoryPoints, "story-points-field", cfg.Fields.StoryPoints, "Name of a number custom field to hold story points. It is created if it does not exist. If not set, story points are not migrated")
+	memberRolesSpec := flag.String("member-roles", "assignee,reporter,watchers", "Comma-separated list of which Jira users to add to cards as members")
+	snapshotPath := flag.String("from-snapshot", "", "Path to a snapshot directory or tarball written by export, to read issues, comments and attachments from instead of the Jira API. The snapshot's own JQL queries are used, and no Jira credentials are needed")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *snapshotPath != "" {
+		err = cfg.ValidateOffline()
+	} else {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	httpClient := common.SharedHttpClient()
 
-	epicName, haveEpic := epics.KnownEpics[*(recPtr.Fields.EpicLink)]
-	if !haveEpic {
-		log.Printf("ERROR Could not find an epic for '%s'", *(recPtr.Fields.EpicLink))
-		return errors.New("could not create epic link")
+	var jiraClient jira.Source
+	if *snapshotPath != "" {
+		snap, err := snapshot.Open(*snapshotPath)
+		if err != nil {
+			log.Fatalf("Could not open snapshot '%s': %s", *snapshotPath, err)
+		}
+		defer snap.Close()
+		log.Printf("INFO Reading %d issues and %d epics from snapshot of %s exported at %s", snap.Manifest.Issues, snap.Manifest.Epics, snap.Manifest.JiraBaseUrl, snap.Manifest.Created.Format(time.RFC3339))
+		if !snap.Manifest.AttachmentsIncluded {
+			log.Printf("WARNING Snapshot '%s' was exported without attachments, so issues with attachments will fail", *snapshotPath)
+		}
+		cfg.Jira.IssueJql = snap.Manifest.IssueJql
+		cfg.Jira.EpicJql = snap.Manifest.EpicJql
+		snap.FieldMapping = cfg.Jira.Fields
+		jiraClient = snap
+	} else {
+		jiraKey, err := common.LoadScriptKey(&cfg.Jira.Credentials)
+		if err != nil {
+			log.Fatalf("Could not open scripting key '%s': %s", cfg.Jira.Credentials, err)
+		}
+		jiraAuth, err := jira.AuthFromScriptKey(jiraKey)
+		if err != nil {
+			log.Fatalf("Invalid scripting key '%s': %s", cfg.Jira.Credentials, err)
+		}
+		client := jira.NewClient(jira.BaseUrlFor(cfg.Jira.Host), jiraAuth, httpClient)
+		client.FieldMapping = cfg.Jira.Fields
+		jiraClient = client
 	}
 
-	epicId, err := epicLinkField.FindInCustomField(epicName)
+	trelloKey, err := common.LoadScriptKey(&cfg.Trello.Credentials)
 	if err != nil {
-		log.Printf("ERROR Could not find an entry for epic '%s' %s: %s", *recPtr.Fields.EpicLink, epicName, err)
-		return errors.New("could not create epic link")
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Trello.Credentials, err)
 	}
+	trelloClient := trello.NewClient(trelloKey, httpClient)
 
-	err = trello.SetCustomFieldValue(cardId, epicLinkField.Id, epicId.Id, trelloKey, httpClient) //should use TrelloCustomFieldOptionValue as k-v i think. https://developer.atlassian.com/cloud/trello/rest/api-group-cards/#api-cards-idcard-customfield-idcustomfield-item-put
+	state, err := common.LoadMigrationState(*statePath, common.JiraToTrello)
 	if err != nil {
-		log.Printf("ERROR Could not set up custom epics info field for '%s': %s", recPtr.Fields.Summary, err)
-		return errors.New("could not create epic link")
+		log.Fatalf("Could not load migration state from '%s': %s", *statePath, err)
+	}
+	if !*dryRun {
+		if *runId == "" {
+			*runId = common.NewRunId()
+		}
+		err = state.StartRun(*runId, cfg.Trello.Board)
+		if err != nil {
+			log.Fatalf("Could not record the start of run '%s' in '%s': %s", *runId, *statePath, err)
+		}
+		log.Printf("INFO Starting run '%s'. Everything it creates can be undone with: rollback -state %s -run %s", *runId, *statePath, *runId)
 	}
-	return nil
-}
 
-func MigrateIssue(recPtr *common.Issue,
-	hostname *string,
-	defaultList *common.TrelloList,
-	epicLinkField *common.TrelloCustomField,
-	priorityField *common.TrelloCustomField,
-	epics *EpicsCache,
-	jiraIdField *common.TrelloCustomField,
-	jira *common.ScriptKey,
-	trelloKey *common.ScriptKey,
-	httpClient *http.Client,
-) error {
-	//get a base trello card
-	newCard := recPtr.ToTrelloCard(defaultList.Id, false)
-	//write the card and get an ID
-	createdCard, err := trello.PutTrelloCard(newCard, trelloKey, httpClient)
+	trelloListCache, err := trelloClient.NewListCache(cfg.Trello.Board)
 	if err != nil {
-		log.Printf("ERROR Could not create a card for '%s': %s", recPtr.Fields.Summary, err)
-		return errors.New("can't migrate issue")
+		log.Fatalf("Could not load lists from board '%s': %s", cfg.Trello.Board, err)
 	}
+	log.Printf("INFO Found %d lists on board '%s' ", trelloListCache.Count(), cfg.Trello.Board)
 
-	err = trello.SetCustomFieldText(createdCard.Id, jiraIdField.Id, recPtr.Key, trelloKey, httpClient)
+	customFieldCache, err := trelloClient.LoadAllCustomFields(cfg.Trello.Board)
 	if err != nil {
-		log.Printf("ERROR Could not add jira key for '%s': %s", recPtr.Fields.Summary, err)
-		return errors.New("can't migrate issue")
+		log.Fatalf("Could not load custom fields from board '%s': %s", cfg.Trello.Board, err)
 	}
+	log.Printf("INFO Found %d custom fields on board '%s'", len(*customFieldCache), cfg.Trello.Board)
 
-	//if there are attachments, copy them over
-	err = HandleAttachments(&recPtr.Fields.Attachment, createdCard.Id, hostname, jira, trelloKey, httpClient)
+	routing, err := migrate.ListRoutingFromConfig(cfg.Lists)
 	if err != nil {
-		log.Printf("ERROR Could not fix attachments for '%s': %s", recPtr.Fields.Summary, err)
-		return errors.New("can't migrate issue")
+		log.Fatalf("Invalid list mapping in the config: %s", err)
 	}
-	//if there is an epic link, find the custom field value corresponding and set it
-	if recPtr.Fields.EpicLink != nil {
-		err = MakeEpicLink(recPtr, createdCard.Id, epics, epicLinkField, trelloKey, httpClient)
+	if *listMapPath != "" {
+		routing, err = migrate.LoadListRouting(*listMapPath)
 		if err != nil {
-			return errors.New("can't migrate issue")
+			log.Fatalf("Could not load list mapping from '%s': %s", *listMapPath, err)
 		}
 	}
-
-	//get the priority and set that on a custom field too
-	fieldId, err := recPtr.Fields.Priority.ToTrelloLabel(priorityField.Options)
-	if err != nil {
-		log.Printf("ERROR Could not set up priority for '%s': '%s", recPtr.Fields.Summary, err)
-		return errors.New("can't migrate issue")
+	if routing.Fallback == "" {
+		routing.Fallback = cfg.Lists.Fallback
 	}
-	err = trello.SetCustomFieldValue(createdCard.Id, priorityField.Id, fieldId, trelloKey, httpClient)
-
+	if _, haveList := trelloListCache.FindByName(routing.Fallback); !haveList && !routing.CreateMissing {
+		log.Fatalf("There is no list '%s' on the board", routing.Fallback)
+	}
+	sprintMode, err := migrate.ParseSprintMode(*sprintModeSpec)
 	if err != nil {
-		log.Printf("ERROR Could not set up priority field for '%s': %s", recPtr.Fields.Summary, err)
-		return errors.New("can't migrate issue")
+		log.Fatalf("Invalid -sprints: %s", err)
 	}
+	router := migrate.NewListRouter(routing, trelloListCache, *dryRun, sprintMode == migrate.SprintsAsList, trelloClient)
 
-	//now need to migrate all other comments
-	existingComments, err := common.LoadAllComments(*hostname, recPtr.Key, jira, 20, httpClient)
-	if err != nil {
-		log.Printf("ERROR Can't load comments for '%s': %s", recPtr.Fields.Summary, err)
-		return errors.New("can't migrate issue")
+	epicLinkField, haveEpicLinkField := (*customFieldCache)[cfg.Fields.Epic]
+	if !haveEpicLinkField {
+		log.Fatalf("Could not find any custom field matching '%s' for epics information", cfg.Fields.Epic)
 	}
 
-	for _, c := range *existingComments {
-		createdTime, err := time.Parse(common.JiraTimeFormat, c.Created)
-		var createdTimeString string
+	jiraIdField, haveJiraIdField := (*customFieldCache)[cfg.Fields.JiraKey]
+	if !haveJiraIdField {
+		log.Fatalf("Could not find any custom field matching '%s' for jira key information", cfg.Fields.JiraKey)
+	}
 
-		if err != nil {
-			log.Printf("ERROR Can't parse time %s: %s", c.Created, err)
-			createdTimeString = c.Created
-		} else {
-			createdTimeString = createdTime.Format(time.RFC1123)
-		}
+	priorityField, havePriorityField := (*customFieldCache)[cfg.Fields.Priority]
+	if !havePriorityField {
+		log.Fatalf("Could not find any custom field matching '%s' for priority information", cfg.Fields.Priority)
+	}
 
-		newComment := fmt.Sprintf("%s\n-----\nOriginally by %s on %s", c.Body.ToTextBlock(), c.Author.DisplayName, createdTimeString)
-		err = trello.AddComment(createdCard.Id, newComment, trelloKey, httpClient)
+	var sprintField *migrate.SprintField
+	if sprintMode == migrate.SprintsAsField {
+		sprintField, err = migrate.NewSprintField(cfg.Trello.Board, cfg.Fields.Sprint, customFieldCache, *dryRun, trelloClient, state)
 		if err != nil {
-			log.Printf("ERROR Could not add comment to card '%s': %s", createdCard.Id, err)
-			return errors.New("can't migrate issue")
+			log.Fatalf("Could not set up the sprint field '%s': %s", cfg.Fields.Sprint, err)
 		}
 	}
 
-	//set a comment showing where this came from and when
-	creationTime, parseErr := time.Parse(common.JiraTimeFormat, recPtr.Fields.Created)
-	creationTimeString := recPtr.Fields.Created
-	if parseErr != nil {
-		log.Printf("WARNING Can't parse time '%s': %s", recPtr.Fields.Created, parseErr)
-	} else {
-		creationTimeString = creationTime.Format(time.RFC1123)
-	}
-
-	newComment := fmt.Sprintf(`This card was originally reported on %s by %s as issue %s`,
-		creationTimeString,
-		recPtr.Fields.Reporter.DisplayName,
-		recPtr.Key,
-	)
-	err = trello.AddComment(createdCard.Id, newComment, trelloKey, httpClient)
+	estimateFields, err := migrate.NewEstimateFields(cfg.Trello.Board, cfg.Fields.OriginalEstimate, cfg.Fields.RemainingEstimate, cfg.Fields.StoryPoints, customFieldCache, *dryRun, trelloClient)
 	if err != nil {
-		log.Printf("ERROR Could not add comment to card '%s': %s", createdCard.Id, err)
-		return errors.New("can't migrate issue")
+		log.Fatalf("Could not set up estimate fields: %s", err)
 	}
-	return nil
-}
 
-func main() {
-	jiraKeyPath := flag.String("jira", "scriptkey.yaml", "Path to a file containing a Jira API key")
-	trelloKeyPath := flag.String("trello", "trellokey.yaml", "Path to a file containing a Trello API key")
-	hostname := flag.String("host", "", "Virtual Jira host to query")
-	pageSize := flag.Int("pagesize", 50, "number of issues to fetch in one page")
-	trelloBoard := flag.String("board", "", "Board ID to push data into")
-	defaultList := flag.String("defaultlist", "", "Name of the list to push cards into by default")
-	epicLinkFieldName := flag.String("epicfield", "Components", "Name of the custom field to hold epics information")
-	jiraIdFieldName := flag.String("jira-id", "Jira Key", "Name of the custom field to hold the jira ID")
-	flag.Parse()
-
-	httpClient := http.DefaultClient
-
-	jira, err := common.LoadScriptKey(jiraKeyPath)
+	mappedFields, err := migrate.NewMappedFields(cfg.Trello.Board, cfg.CustomFields, customFieldCache, *dryRun, trelloClient, state)
 	if err != nil {
-		log.Fatalf("Could not open scripting key '%s': %s", *jiraKeyPath, err)
+		log.Fatalf("Could not set up custom field mappings: %s", err)
 	}
 
-	trelloKey, err := common.LoadScriptKey(trelloKeyPath)
+	subtaskMode, err := migrate.ParseSubtaskMode(*subtaskModeSpec)
 	if err != nil {
-		log.Fatalf("Could not open scripting key '%s': %s", *trelloKeyPath, err)
+		log.Fatalf("Invalid -subtasks: %s", err)
 	}
 
-	trelloListCache, err := trello.NewListCache(*trelloBoard, trelloKey, httpClient)
+	memberRoles, err := migrate.ParseMemberRoles(*memberRolesSpec)
 	if err != nil {
-		log.Fatalf("Could not load lists from board '%s': %s", *trelloBoard, err)
+		log.Fatalf("Invalid -member-roles: %s", err)
 	}
-	log.Printf("INFO Found %d lists on board '%s' ", trelloListCache.Count(), *trelloBoard)
-
-	customFieldCache, err := trello.LoadAllCustomFields(*trelloBoard, trelloKey, httpClient)
+	var memberOverrides map[string]string
+	if *memberOverridesPath != "" {
+		memberOverrides, err = migrate.LoadMemberOverrides(*memberOverridesPath)
+		if err != nil {
+			log.Fatalf("Could not load member overrides from '%s': %s", *memberOverridesPath, err)
+		}
+	}
+	memberCache, err := trelloClient.NewMemberCache(cfg.Trello.Board)
 	if err != nil {
-		log.Fatalf("Could not load custom fields from board '%s': %s", *trelloBoard, err)
+		log.Fatalf("Could not load members of board '%s': %s", cfg.Trello.Board, err)
 	}
-	log.Printf("INFO Found %d custom fields on board '%s'", len(*customFieldCache), *trelloBoard)
+	log.Printf("INFO Found %d members on board '%s'", memberCache.Count(), cfg.Trello.Board)
+	memberMapper := migrate.NewMemberMapper(memberCache, memberOverrides, memberRoles, jiraClient)
 
-	defaultListId, haveList := trelloListCache.FindByName(*defaultList)
-	if !haveList {
-		log.Fatalf("There is no list '%s' on the board", *defaultList)
+	labelCache, err := trelloClient.NewTrelloLabelCache(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load labels from board '%s': %s", cfg.Trello.Board, err)
 	}
-
-	epicLinkField, haveEpicLinkField := (*customFieldCache)[*epicLinkFieldName]
-	if !haveEpicLinkField {
-		log.Fatalf("Could not find any custom field matching '%s' for epics information", *epicLinkFieldName)
+	labelColours := migrate.LabelColours(cfg.LabelColours)
+	if labelColours == nil {
+		labelColours = migrate.LabelColours{}
 	}
-
-	jiraIdField, haveJiraIdField := (*customFieldCache)[*jiraIdFieldName]
-	if !haveJiraIdField {
-		log.Fatalf("Could not find any custom field matching '%s' for jira key information", *jiraIdFieldName)
+	if *labelColoursPath != "" {
+		fileColours, err := migrate.LoadLabelColours(*labelColoursPath)
+		if err != nil {
+			log.Fatalf("Could not load label colours from '%s': %s", *labelColoursPath, err)
+		}
+		for label, colour := range fileColours {
+			labelColours[label] = colour
+		}
 	}
 
-	priorityField, havePriorityField := (*customFieldCache)["Priority"]
-	if !havePriorityField {
-		log.Fatal("Could not find any custom field matching 'Priority' for priority information")
+	attachmentPolicy := &migrate.AttachmentPolicy{}
+	if *attachmentPolicyPath != "" {
+		attachmentPolicy, err = migrate.LoadAttachmentPolicy(*attachmentPolicyPath)
+		if err != nil {
+			log.Fatalf("Could not load attachment policy from '%s': %s", *attachmentPolicyPath, err)
+		}
 	}
 
-	epics, err := NewEpicsCache(hostname, jira, *pageSize)
+	epics, err := migrate.NewEpicsCache(jiraClient, cfg.Jira.PageSize, cfg.Jira.EpicJql)
 	if err != nil {
-		log.Fatal("Unable to load epics information")
+		log.Fatalf("Unable to load epics information: %s", err)
 	}
 	if len(epics.KnownEpics) == 0 {
 		log.Fatal("Could not load in any epics, check the code")
 	}
 
-	contentCh, errCh := common.AsyncLoadAllIssues(*hostname, jira, *pageSize)
-	ctr := 0
+	runStarted := time.Now()
+	if snap, isSnapshot := jiraClient.(*snapshot.Snapshot); isSnapshot {
+		//the issues are only as recent as the export, so a later sync must pick up changes from then
+		runStarted = snap.Manifest.Created
+	}
+
+	plan := migrate.NewMigrationPlan()
+	report := migrate.NewMigrationReport()
+	skipIssue := func(jiraKey string, reason string) {
+		plan.Skip(jiraKey, reason)
+		report.Skip(jiraKey, reason, state)
+	}
+	subtaskLinks := make([]migrate.SubtaskLink, 0)
+	skipped := 0
+
+	migrator := &migrate.Migrator{
+		Router:           router,
+		Members:          memberMapper,
+		LabelCache:       labelCache,
+		LabelColours:     labelColours,
+		SubtaskMode:      subtaskMode,
+		SprintMode:       sprintMode,
+		SprintField:      sprintField,
+		EstimateFields:   estimateFields,
+		MappedFields:     mappedFields,
+		EpicLinkField:    &epicLinkField,
+		PriorityField:    &priorityField,
+		PriorityNames:    cfg.Priorities,
+		JiraIdField:      &jiraIdField,
+		Epics:            epics,
+		AttachmentPolicy: attachmentPolicy,
+		JiraClient:       jiraClient,
+		TrelloClient:     trelloClient,
+		State:            state,
+	}
 
-	for {
-		select {
-		case err := <-errCh:
-			log.Printf("ERROR: %s", err)
-			os.Exit(1)
-		case rec, moreContent := <-contentCh:
-			if rec.Fields.Status.Name == "Done" { //don't bother importing over stuff marked as 'Done'
-				continue
-			}
+	//once the state can't be written, no more issues are started as nothing done for them would be recorded
+	var stateMutex sync.Mutex
+	var stateErr error
+	failedState := func() error {
+		stateMutex.Lock()
+		defer stateMutex.Unlock()
+		return stateErr
+	}
 
-			err := MigrateIssue(&rec, hostname, &defaultListId, &epicLinkField, &priorityField, epics, &jiraIdField, jira, trelloKey, httpClient)
+	pool := migrate.NewWorkerPool(*workers, func(rec *common.Issue) error {
+		if *dryRun {
+			issuePlan, err := migrator.PlanIssue(rec)
 			if err != nil {
-				log.Fatalf("ERROR processing '%s': %s", rec.Key, err)
+				plan.Skip(rec.Key, err.Error())
+			} else {
+				plan.AddIssue(issuePlan)
 			}
+			return nil
+		}
 
-			ctr++
-
-			if !moreContent {
-				log.Printf("Job completed! Migrated %d issues over", ctr)
-				return
+		err := migrator.MigrateIssue(rec)
+		if markErr := state.MarkCompleted(rec.Key, err); markErr != nil {
+			log.Printf("ERROR Could not write migration state to '%s': %s", *statePath, markErr)
+			stateMutex.Lock()
+			if stateErr == nil {
+				stateErr = markErr
+			}
+			stateMutex.Unlock()
+			if err == nil {
+				err = markErr
 			}
 		}
+		report.AddIssue(rec, router.ListNameFor(rec), epics, cfg.Priorities, state, err)
+		if err != nil {
+			log.Printf("ERROR processing '%s': %s", rec.Key, err)
+		}
+		return err
+	})
+
+	contentCh, errCh := jiraClient.AsyncLoadIssuesJQL(cfg.Jira.PageSize, cfg.Jira.IssueJql)
+
+	for rec := range contentCh {
+		if failedState() != nil {
+			log.Printf("ERROR Not starting any more issues as the migration state can't be written, finishing the ones already started")
+			break
+		}
+		if subtaskMode == migrate.SubtasksAsChecklist && migrate.IsSubtask(&rec) {
+			skipIssue(rec.Key, "sub-task of "+rec.Fields.Parent.Key+", added to its checklist")
+			continue
+		}
+
+		if !router.ShouldMigrate(&rec) {
+			skipIssue(rec.Key, "status is "+rec.Fields.Status.Name)
+			continue
+		}
+
+		if migrate.IsSubtask(&rec) {
+			subtaskLinks = append(subtaskLinks, migrate.SubtaskLink{SubtaskKey: rec.Key, ParentKey: rec.Fields.Parent.Key})
+		}
+
+		if previous, havePrevious := state.Get(rec.Key); havePrevious && previous.Completed {
+			log.Printf("INFO Issue %s has already been migrated to %s, skipping", rec.Key, previous.ShortUrl)
+			skipIssue(rec.Key, "already migrated to "+previous.ShortUrl)
+			skipped++
+			continue
+		}
+
+		pool.Submit(rec)
+	}
+	//the loader sends any error before it closes contentCh, so there is no need to block here
+	var loadErr error
+	select {
+	case loadErr = <-errCh:
+		log.Printf("ERROR Could not load all the issues from Jira, finishing the ones already started: %s", loadErr)
+	default:
+	}
+
+	ctr, issueErrors := pool.Wait()
+
+	if *dryRun {
+		plan.UnmatchedUsers = memberMapper.Unmatched()
+		if loadErr != nil {
+			plan.Incomplete = loadErr.Error()
+		}
+		err = plan.WriteJSON(*planPath)
+		if err != nil {
+			log.Fatalf("Could not write migration plan to '%s': %s", *planPath, err)
+		}
+		plan.WriteSummary(os.Stdout)
+		memberMapper.WriteUnmatchedReport(os.Stdout)
+		if loadErr != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := make([]string, 0, len(issueErrors))
+	for _, e := range issueErrors {
+		failed = append(failed, e.JiraKey)
+	}
+	if len(subtaskLinks) > 0 && failedState() == nil {
+		unlinked := migrate.LinkSubtaskCards(subtaskLinks, state, trelloClient)
+		for _, key := range unlinked {
+			report.MarkPartial(key, "could not link to the parent card")
+		}
+		failed = append(failed, unlinked...)
+	}
+	for _, reportPath := range strings.Split(*reportPaths, ",") {
+		if strings.TrimSpace(reportPath) == "" {
+			continue
+		}
+		if err = report.WriteFile(strings.TrimSpace(reportPath)); err != nil {
+			log.Printf("ERROR Could not write migration report to '%s': %s", reportPath, err)
+		}
+	}
+	memberMapper.WriteUnmatchedReport(os.Stderr)
+	counts := report.Counts()
+	log.Printf("INFO Report: %d ok, %d partial, %d failed, %d skipped", counts[migrate.ReportOk], counts[migrate.ReportPartial], counts[migrate.ReportFailed], counts[migrate.ReportSkipped])
+	log.Printf("Job completed! Migrated %d issues over, %d were already done and %d failed", ctr, skipped, len(failed))
+	exitCode := 0
+	if err = failedState(); err != nil {
+		log.Printf("ERROR The run was stopped as the migration state could not be written to '%s': %s. Fix that and re-run to carry on.", *statePath, err)
+		exitCode = 1
+	} else if loadErr != nil {
+		if err = state.MarkRunIncomplete(loadErr.Error()); err != nil {
+			log.Printf("ERROR Could not record that run '%s' is incomplete in '%s': %s", *runId, *statePath, err)
+		}
+		log.Printf("ERROR Not every issue could be loaded from Jira, so the run is incomplete: %s. Re-run to carry on.", loadErr)
+		exitCode = 1
+	} else if len(failed) > 0 {
+		log.Printf("Failed issues were: %s. Re-run to retry them.", strings.Join(failed, ", "))
+		exitCode = 1
+	} else if err = state.RecordSync(runStarted); err != nil {
+		log.Printf("ERROR Could not record the sync checkpoint in '%s': %s", *statePath, err)
+		exitCode = 1
+	}
+	if err = state.Close(); err != nil {
+		log.Printf("ERROR Could not write migration state to '%s', its changes are still in the journal next to it: %s", *statePath, err)
+		exitCode = 1
 	}
+	os.Exit(exitCode)
 }
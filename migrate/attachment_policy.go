@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+type AttachmentAction string
+
+const (
+	AttachmentUpload AttachmentAction = "upload" //copy the file to Trello as normal
+	AttachmentSkip   AttachmentAction = "skip"   //leave the file in Jira and add a comment to the card saying so
+	AttachmentLink   AttachmentAction = "link"   //attach a link to the original file in Jira
+	AttachmentStore  AttachmentAction = "store"  //copy the file to the blob store and attach a link to that
+)
+
+/*
+AttachmentRule says what to do with attachments larger than Over, which is a number of bytes optionally followed by
+KB, MB or GB (binary units, so 10MB is 10485760 bytes)
+*/
+type AttachmentRule struct {
+	Over      string           `yaml:"over"`
+	Action    AttachmentAction `yaml:"action"`
+	overBytes int64
+}
+
+/*
+AttachmentPolicy decides what happens to attachments that are too big for Trello, which rejects anything over 10MB
+on free workspaces or 250MB on paid ones. The rule with the largest threshold that an attachment is over applies;
+attachments that are not over any threshold are uploaded. Store rules need StoreDirectory to be set, and
+StoreUrl should be where that directory is served from.
+*/
+type AttachmentPolicy struct {
+	Rules          []AttachmentRule `yaml:"rules"`
+	StoreDirectory string           `yaml:"storeDirectory"`
+	StoreUrl       string           `yaml:"storeUrl"`
+	Blobs          BlobStore        `yaml:"-"` //set up from StoreDirectory by Validate if not already set
+}
+
+/*
+LoadAttachmentPolicy reads an AttachmentPolicy definition from a YAML file
+*/
+func LoadAttachmentPolicy(path string) (*AttachmentPolicy, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policy AttachmentPolicy
+	err = yaml.Unmarshal(content, &policy)
+	if err != nil {
+		return nil, err
+	}
+	return &policy, policy.Validate()
+}
+
+func (p *AttachmentPolicy) Validate() error {
+	needsStore := false
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		size, err := ParseSize(rule.Over)
+		if err != nil {
+			return err
+		}
+		rule.overBytes = size
+		switch rule.Action {
+		case AttachmentUpload, AttachmentSkip, AttachmentLink:
+		case AttachmentStore:
+			needsStore = true
+		default:
+			return errors.New(fmt.Sprintf("'action' must be one of upload, skip, link or store, not '%s'", rule.Action))
+		}
+	}
+	if needsStore && p.Blobs == nil {
+		store, err := NewLocalBlobStore(p.StoreDirectory, p.StoreUrl)
+		if err != nil {
+			return errors.New(fmt.Sprintf("rules with the store action need a usable 'storeDirectory': %s", err))
+		}
+		p.Blobs = store
+	}
+	return nil
+}
+
+/*
+ActionFor returns what should be done with an attachment of the given size
+*/
+func (p *AttachmentPolicy) ActionFor(size int64) AttachmentAction {
+	action := AttachmentUpload
+	threshold := int64(-1)
+	for _, rule := range p.Rules {
+		if size > rule.overBytes && rule.overBytes > threshold {
+			action = rule.Action
+			threshold = rule.overBytes
+		}
+	}
+	return action
+}
+
+var sizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+/*
+ParseSize converts a size like "10MB" or "1.5GB" into a number of bytes. A plain number is taken as bytes.
+*/
+func ParseSize(spec string) (int64, error) {
+	number := strings.ToUpper(strings.TrimSpace(spec))
+	multiplier := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, errors.New(fmt.Sprintf("'%s' is not a valid size", spec))
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+/*
+FormatSize returns a size in bytes in the most readable unit
+*/
+func FormatSize(size int64) string {
+	for _, unit := range sizeUnits {
+		if size >= unit.multiplier && unit.multiplier > 1 {
+			return strconv.FormatFloat(float64(size)/float64(unit.multiplier), 'f', 1, 64) + unit.suffix
+		}
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
@@ -0,0 +1,151 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"os"
+)
+
+/*
+streamAttachment copies a single attachment by piping the download from Jira straight into the upload to Trello
+*/
+func streamAttachment(a *common.Attachment, cardId string, jiraClient jira.Source, trelloClient *trello.Client) error {
+	content, err := jiraClient.OpenJiraAttachment(a.Id)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return trelloClient.UploadAttachmentStream(cardId, a.Filename, a.MimeType, jira.ExpectSize(content, a.Size))
+}
+
+/*
+copyAttachmentViaFile copies a single attachment by downloading it to a temp file first. This is slower but the
+upload can be retried, so it is used when streaming fails.
+*/
+func copyAttachmentViaFile(a *common.Attachment, cardId string, jiraClient jira.Source, trelloClient *trello.Client) error {
+	downloadedFileName, err := jiraClient.DownloadJiraAttachment(a.Id, a.Size)
+	if err != nil {
+		log.Printf("ERROR Could not download %s: %s", a.Filename, err)
+		return err
+	}
+	defer os.Remove(downloadedFileName)
+
+	err = trelloClient.UploadTrelloAttachment(cardId, downloadedFileName, a.Filename, a.MimeType)
+	if err != nil {
+		log.Printf("ERROR Could not upload %s: %s", a.Filename, err)
+	}
+	return err
+}
+
+/*
+isAlreadyAttached returns true if the card has a file with the same name and size as the attachment. A failed upload
+may still have reached Trello if only the response was lost, so this is checked before uploading it again.
+*/
+func isAlreadyAttached(a *common.Attachment, cardId string, trelloClient *trello.Client) (bool, error) {
+	existing, err := trelloClient.LoadAttachments(cardId)
+	if err != nil {
+		return false, err
+	}
+	for _, e := range existing {
+		if e.Name == a.Filename && e.Bytes != nil && *e.Bytes == a.Size {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+handleOversizeAttachment deals with an attachment that the policy says should not be uploaded, returning what was
+attached to the card in its place (if anything)
+*/
+func handleOversizeAttachment(jiraIssueKey string, a *common.Attachment, action AttachmentAction, cardId string, policy *AttachmentPolicy, jiraClient jira.Source, trelloClient *trello.Client) (string, error) {
+	switch action {
+	case AttachmentSkip:
+		return "", trelloClient.AddComment(cardId, FormatSkippedAttachmentComment(jiraIssueKey, a))
+	case AttachmentLink:
+		return a.Content, trelloClient.AttachUrl(cardId, a.Content, a.Filename)
+	case AttachmentStore:
+		content, err := jiraClient.OpenJiraAttachment(a.Id)
+		if err != nil {
+			return "", err
+		}
+		defer content.Close()
+		storedUrl, err := policy.Blobs.Put(jiraIssueKey, a, jira.ExpectSize(content, a.Size))
+		if err != nil {
+			return "", err
+		}
+		log.Printf("INFO Stored %s at %s", a.Filename, storedUrl)
+		return storedUrl, trelloClient.AttachUrl(cardId, storedUrl, a.Filename)
+	default:
+		return "", errors.New(fmt.Sprintf("unknown attachment action '%s'", action))
+	}
+}
+
+/*
+FormatSkippedAttachmentComment returns the text of the comment added to a card in place of an attachment that was
+too large to copy
+*/
+func FormatSkippedAttachmentComment(jiraIssueKey string, a *common.Attachment) string {
+	return fmt.Sprintf("Attachment '%s' (%s) was too large to copy from Jira, it is still available on %s", a.Filename, FormatSize(a.Size), jiraIssueKey)
+}
+
+/*
+HandleAttachments copies each of the issue's attachments from Jira to the given card, skipping any that the migration
+state says have already been copied. Attachments that the policy says are too big are skipped, linked or stored
+elsewhere instead, and what was done is recorded in the migration state.
+*/
+func HandleAttachments(jiraIssueKey string, attachmentList *[]common.Attachment, cardId string, policy *AttachmentPolicy, jiraClient jira.Source, trelloClient *trello.Client, state *common.MigrationState) error {
+	log.Printf("INFO Got %d attachments", len(*attachmentList))
+
+	for i := range *attachmentList {
+		a := &(*attachmentList)[i]
+		if state.IsDone(jiraIssueKey, common.AttachmentStep(a.Id)) {
+			log.Printf("INFO Attachment %s was already copied, skipping", a.Filename)
+			continue
+		}
+
+		action := policy.ActionFor(a.Size)
+		if action != AttachmentUpload {
+			log.Printf("INFO Attachment %s is %s, using the '%s' action", a.Filename, FormatSize(a.Size), action)
+			attachedUrl, err := handleOversizeAttachment(jiraIssueKey, a, action, cardId, policy, jiraClient, trelloClient)
+			if err != nil {
+				log.Printf("ERROR Could not %s %s: %s", action, a.Filename, err)
+				return err
+			}
+			err = state.RecordAttachment(jiraIssueKey, a.Id, common.AttachmentOutcome{
+				Filename: a.Filename,
+				Size:     a.Size,
+				Action:   string(action),
+				Url:      attachedUrl,
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := streamAttachment(a, cardId, jiraClient, trelloClient)
+		if err != nil {
+			log.Printf("WARNING Could not stream %s (%s), trying again via a temp file", a.Filename, err)
+			attached, checkErr := isAlreadyAttached(a, cardId, trelloClient)
+			if checkErr != nil {
+				log.Printf("ERROR Could not check whether %s reached the card: %s", a.Filename, checkErr)
+				return checkErr
+			}
+			if attached {
+				log.Printf("INFO %s was uploaded even though the request failed, not uploading it again", a.Filename)
+			} else if err = copyAttachmentViaFile(a, cardId, jiraClient, trelloClient); err != nil {
+				return err
+			}
+		}
+		err = state.MarkDone(jiraIssueKey, common.AttachmentStep(a.Id))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+BlobStore is somewhere to keep attachments that are too large for Trello. Put stores the content and returns a URL
+that can be attached to the card in its place.
+*/
+type BlobStore interface {
+	Put(jiraKey string, attachment *common.Attachment, content io.Reader) (string, error)
+}
+
+/*
+LocalBlobStore keeps attachments in a local directory, as <Directory>/<jira key>/<attachment id>-<filename>.
+The directory is expected to be served by something at BaseUrl; if BaseUrl is empty then file:// URLs are returned.
+*/
+type LocalBlobStore struct {
+	Directory string
+	BaseUrl   string
+}
+
+/*
+blobName returns the name of the file used for the attachment, which can't escape the issue's directory
+*/
+func blobName(attachment *common.Attachment) string {
+	return attachment.Id + "-" + filepath.Base(filepath.Clean("/"+attachment.Filename))
+}
+
+func (s *LocalBlobStore) Put(jiraKey string, attachment *common.Attachment, content io.Reader) (string, error) {
+	dir := filepath.Join(s.Directory, filepath.Base(jiraKey))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+
+	//write to a temp file first so that a failed copy never leaves a partial file under the real name
+	tempFile, err := ioutil.TempFile(dir, ".blob")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tempFile, content)
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+	os.Chmod(tempFile.Name(), 0644)
+	finalPath := filepath.Join(dir, blobName(attachment))
+	err = os.Rename(tempFile.Name(), finalPath)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", err
+	}
+
+	if s.BaseUrl == "" {
+		absPath, err := filepath.Abs(finalPath)
+		if err != nil {
+			return "", err
+		}
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String(), nil
+	}
+	return strings.TrimSuffix(s.BaseUrl, "/") + "/" + url.PathEscape(filepath.Base(jiraKey)) + "/" + url.PathEscape(blobName(attachment)), nil
+}
+
+/*
+NewLocalBlobStore checks that the directory can be used and returns a LocalBlobStore for it
+*/
+func NewLocalBlobStore(directory string, baseUrl string) (*LocalBlobStore, error) {
+	if directory == "" {
+		return nil, errors.New("no directory given for storing attachments")
+	}
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New(fmt.Sprintf("'%s' is not a directory", directory))
+	}
+	return &LocalBlobStore{Directory: directory, BaseUrl: baseUrl}, nil
+}
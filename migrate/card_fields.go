@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+)
+
+/*
+setSprintField puts the issue's latest sprint into the sprint field. If the issue is not in a sprint and
+clearMissing is set, the field is cleared instead, so that a card synced after the issue left its sprint does not
+keep the old one.
+*/
+func setSprintField(recPtr *common.Issue, cardId string, sprintField *SprintField, clearMissing bool, trelloClient *trello.Client) error {
+	sprint := recPtr.Fields.LatestSprint()
+	var err error
+	if sprint != nil {
+		err = sprintField.SetOnCard(cardId, sprint)
+	} else if clearMissing {
+		err = trelloClient.ClearCustomField(cardId, sprintField.Field.Id)
+	}
+	if err != nil {
+		log.Printf("ERROR Could not set up sprint field for '%s': %s", recPtr.Fields.Summary, err)
+	}
+	return err
+}
+
+/*
+setEstimateFields puts the issue's estimates and story points into their fields. If clearMissing is set, fields for
+values that the issue no longer has are cleared.
+*/
+func setEstimateFields(recPtr *common.Issue, cardId string, estimateFields *EstimateFields, clearMissing bool, trelloClient *trello.Client) error {
+	haveValue := make(map[*common.TrelloCustomField]bool, 3)
+	for _, estimate := range estimateFields.ValuesFor(&recPtr.Fields) {
+		err := trelloClient.SetCustomFieldNumber(cardId, estimate.Field.Id, estimate.Value)
+		if err != nil {
+			log.Printf("ERROR Could not set up %s for '%s': %s", estimate.Field.Name, recPtr.Fields.Summary, err)
+			return err
+		}
+		haveValue[estimate.Field] = true
+	}
+	if !clearMissing {
+		return nil
+	}
+	for _, field := range []*common.TrelloCustomField{estimateFields.OriginalEstimate, estimateFields.RemainingEstimate, estimateFields.StoryPoints} {
+		if field == nil || haveValue[field] {
+			continue
+		}
+		err := trelloClient.ClearCustomField(cardId, field.Id)
+		if err != nil {
+			log.Printf("ERROR Could not clear %s for '%s': %s", field.Name, recPtr.Fields.Summary, err)
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+setMappedFields copies the fields listed in customFields into their Trello fields. Values that can't be converted
+are logged and left alone. If clearMissing is set, fields that the issue no longer has a value for are cleared.
+*/
+func setMappedFields(recPtr *common.Issue, cardId string, mappedFields *MappedFields, clearMissing bool, trelloClient *trello.Client) error {
+	values, problems := mappedFields.ValuesFor(recPtr)
+	for _, p := range problems {
+		log.Printf("WARNING Not copying a field of '%s': %s", recPtr.Fields.Summary, p)
+	}
+	for i := range values {
+		err := mappedFields.SetOnCard(cardId, &values[i])
+		if err != nil {
+			log.Printf("ERROR Could not set up %s for '%s': %s", values[i].Mapping.Field.Name, recPtr.Fields.Summary, err)
+			return err
+		}
+	}
+	if !clearMissing {
+		return nil
+	}
+	for _, m := range mappedFields.Fields {
+		if _, haveValue := recPtr.RawField(m.Jira); haveValue {
+			continue
+		}
+		err := trelloClient.ClearCustomField(cardId, m.Field.Id)
+		if err != nil {
+			log.Printf("ERROR Could not clear %s for '%s': %s", m.Field.Name, recPtr.Fields.Summary, err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package migrate
+
+import (
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"time"
+)
+
+/*
+formatJiraTime converts a Jira timestamp into a more readable form, or returns it unchanged if it can't be parsed
+*/
+func formatJiraTime(jiraTime string) string {
+	parsed, err := time.Parse(common.JiraTimeFormat, jiraTime)
+	if err != nil {
+		log.Printf("WARNING Can't parse time '%s': %s", jiraTime, err)
+		return jiraTime
+	}
+	return parsed.Format(time.RFC1123)
+}
+
+/*
+FormatMigratedComment returns the text of the Trello comment that is created for the given Jira comment
+*/
+func FormatMigratedComment(c *common.Comment) string {
+	return fmt.Sprintf("%s\n-----\nOriginally by %s on %s", c.Body.ToMarkdown(), c.Author.DisplayName, formatJiraTime(c.Created))
+}
+
+/*
+FormatOriginComment returns the text of the comment that is put on each card to show where it came from and when
+*/
+func FormatOriginComment(recPtr *common.Issue) string {
+	return fmt.Sprintf(`This card was originally reported on %s by %s as issue %s`,
+		formatJiraTime(recPtr.Fields.Created),
+		recPtr.Fields.Reporter.DisplayName,
+		recPtr.Key,
+	)
+}
+
+/*
+CopyComments adds each of the issue's Jira comments to the card, skipping any that the migration state says have
+already been copied
+*/
+func CopyComments(recPtr *common.Issue, cardId string, jiraClient jira.Source, trelloClient *trello.Client, state *common.MigrationState) error {
+	existingComments, err := jiraClient.LoadAllComments(recPtr.Key, 20)
+	if err != nil {
+		log.Printf("ERROR Can't load comments for '%s': %s", recPtr.Fields.Summary, err)
+		return err
+	}
+
+	for _, c := range *existingComments {
+		if state.IsDone(recPtr.Key, common.CommentStep(c.Id)) {
+			continue
+		}
+		err = trelloClient.AddComment(cardId, FormatMigratedComment(&c))
+		if err != nil {
+			log.Printf("ERROR Could not add comment to card '%s': %s", cardId, err)
+			return err
+		}
+		if err = state.MarkDone(recPtr.Key, common.CommentStep(c.Id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
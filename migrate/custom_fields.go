@@ -0,0 +1,36 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+)
+
+/*
+ensureCustomField finds the named custom field in the cache, checking that it is one of the allowed types, and
+creates it on the board as the first allowed type if it does not exist. On a dry run nothing is created and the
+returned field has no ID.
+*/
+func ensureCustomField(boardId string, fieldName string, allowedTypes []common.CustomFieldType, customFieldCache *trello.CustomFieldCache, dryRun bool, trelloClient *trello.Client) (*common.TrelloCustomField, error) {
+	if field, haveField := (*customFieldCache)[fieldName]; haveField {
+		for _, t := range allowedTypes {
+			if field.Type == t {
+				return &field, nil
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("the field '%s' is a %s field, expected %v", fieldName, field.Type, allowedTypes))
+	}
+
+	if dryRun {
+		return &common.TrelloCustomField{Name: fieldName, BoardId: boardId, Type: allowedTypes[0]}, nil
+	}
+	log.Printf("INFO No existing field with name '%s', creating a new %s field", fieldName, allowedTypes[0])
+	created, err := trelloClient.CreateCustomField(boardId, fieldName, allowedTypes[0], true, []string{})
+	if err != nil {
+		return nil, err
+	}
+	(*customFieldCache)[fieldName] = *created
+	return created, nil
+}
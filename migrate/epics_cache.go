@@ -1,7 +1,9 @@
-package main
+package migrate
 
 import (
-	"github.com/fredex42/mm-jira-migration/common"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/jira"
 	"log"
 )
 
@@ -9,10 +11,10 @@ type EpicsCache struct {
 	KnownEpics map[string]string
 }
 
-func NewEpicsCache(hostname *string, jira *common.ScriptKey, pageSize int) (*EpicsCache, error) {
-	epicsList, err := common.SyncLoadAllEpics(*hostname, jira, pageSize)
+func NewEpicsCache(jiraClient jira.Source, pageSize int, epicJql string) (*EpicsCache, error) {
+	epicsList, err := jiraClient.SyncLoadIssuesJQL(pageSize, epicJql)
 	if err != nil {
-		log.Fatal("ERROR Could not load in epics: ", err)
+		return nil, errors.New(fmt.Sprintf("could not load epics: %s", err))
 	}
 
 	out := EpicsCache{KnownEpics: make(map[string]string, len(epicsList))}
@@ -0,0 +1,72 @@
+package migrate
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+)
+
+/*
+EstimateFields are the Trello number fields that Jira's estimates are copied into. Time estimates are converted from
+seconds to hours. A nil field means that value is not migrated.
+*/
+type EstimateFields struct {
+	OriginalEstimate  *common.TrelloCustomField
+	RemainingEstimate *common.TrelloCustomField
+	StoryPoints       *common.TrelloCustomField
+}
+
+/*
+EstimateValue is a number to be put into one of the estimate fields
+*/
+type EstimateValue struct {
+	Field *common.TrelloCustomField
+	Value float64
+}
+
+/*
+NewEstimateFields looks up the number fields with the given names, creating any that are missing from the board.
+An empty name means that value is not migrated. On a dry run nothing is created.
+*/
+func NewEstimateFields(boardId string, originalName string, remainingName string, storyPointsName string, customFieldCache *trello.CustomFieldCache, dryRun bool, trelloClient *trello.Client) (*EstimateFields, error) {
+	fields := &EstimateFields{}
+	targets := []struct {
+		name  string
+		field **common.TrelloCustomField
+	}{
+		{originalName, &fields.OriginalEstimate},
+		{remainingName, &fields.RemainingEstimate},
+		{storyPointsName, &fields.StoryPoints},
+	}
+	for _, target := range targets {
+		if target.name == "" {
+			continue
+		}
+		field, err := ensureCustomField(boardId, target.name, []common.CustomFieldType{common.Number}, customFieldCache, dryRun, trelloClient)
+		if err != nil {
+			return nil, err
+		}
+		*target.field = field
+	}
+	return fields, nil
+}
+
+func secondsToHours(seconds int64) float64 {
+	return float64(seconds) / 3600
+}
+
+/*
+ValuesFor returns the estimates that the issue has, for the fields that are being migrated
+*/
+func (e *EstimateFields) ValuesFor(fields *common.IssueFields) []EstimateValue {
+	values := make([]EstimateValue, 0, 3)
+	if e.OriginalEstimate != nil && fields.TimeOriginal != nil {
+		values = append(values, EstimateValue{e.OriginalEstimate, secondsToHours(*fields.TimeOriginal)})
+	}
+	if e.RemainingEstimate != nil && fields.TimeEstimate != nil {
+		values = append(values, EstimateValue{e.RemainingEstimate, secondsToHours(*fields.TimeEstimate)})
+	}
+	if e.StoryPoints != nil && fields.StoryPoints != nil {
+		values = append(values, EstimateValue{e.StoryPoints, *fields.StoryPoints})
+	}
+	return values
+}
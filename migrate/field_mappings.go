@@ -0,0 +1,167 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+MappedField is a Trello custom field that a Jira field is copied into, as configured in customFields
+*/
+type MappedField struct {
+	Jira  string //display name or ID of the Jira field
+	Field common.TrelloCustomField
+}
+
+/*
+MappedFields copies the values of the Jira fields listed in customFields into Trello custom fields. For list fields,
+an option is added for each value the first time it is needed, in the same way that SprintField does.
+*/
+type MappedFields struct {
+	Fields []*MappedField
+	client *trello.Client
+	state  *common.MigrationState //options that are added get recorded against the current run
+	dryRun bool
+	lock   sync.Mutex //stops two workers adding the same option at once
+}
+
+/*
+MappedValue is the value of a Jira field, converted for the Trello field it goes into. Text holds the value as it
+would be shown, for every type of field.
+*/
+type MappedValue struct {
+	Mapping *MappedField
+	Text    string
+	Number  float64
+	Date    time.Time
+	Checked bool
+}
+
+/*
+NewMappedFields looks up the Trello field for each mapping, creating any that are missing from the board. On a dry
+run nothing is created.
+*/
+func NewMappedFields(boardId string, mappings []common.CustomFieldMapping, customFieldCache *trello.CustomFieldCache, dryRun bool, trelloClient *trello.Client, state *common.MigrationState) (*MappedFields, error) {
+	fields := &MappedFields{
+		Fields: make([]*MappedField, 0, len(mappings)),
+		client: trelloClient,
+		state:  state,
+		dryRun: dryRun,
+	}
+	for i := range mappings {
+		m := &mappings[i]
+		field, err := ensureCustomField(boardId, m.Trello, []common.CustomFieldType{m.FieldType()}, customFieldCache, dryRun, trelloClient)
+		if err != nil {
+			return nil, err
+		}
+		fields.Fields = append(fields.Fields, &MappedField{Jira: m.Jira, Field: *field})
+	}
+	return fields, nil
+}
+
+/*
+ValuesFor returns the value of each mapped field that the issue has. Values that can't be converted to the type of
+their Trello field are returned as problems, rather than stopping the issue.
+*/
+func (f *MappedFields) ValuesFor(recPtr *common.Issue) ([]MappedValue, []string) {
+	values := make([]MappedValue, 0, len(f.Fields))
+	problems := make([]string, 0)
+	for _, m := range f.Fields {
+		raw, haveValue := recPtr.RawField(m.Jira)
+		if !haveValue {
+			continue
+		}
+		value := MappedValue{Mapping: m, Text: common.FieldText(raw)}
+		switch m.Field.Type {
+		case common.Number:
+			number, isNumber := common.FieldNumber(raw)
+			if !isNumber {
+				problems = append(problems, fmt.Sprintf("'%s' is not a number: %s", m.Jira, string(raw)))
+				continue
+			}
+			value.Number = number
+			value.Text = strconv.FormatFloat(number, 'f', -1, 64)
+		case common.Date:
+			date, isDate := common.FieldDate(raw)
+			if !isDate {
+				problems = append(problems, fmt.Sprintf("'%s' is not a date: %s", m.Jira, string(raw)))
+				continue
+			}
+			value.Date = date
+		case common.Checkbox:
+			value.Checked = common.FieldChecked(raw)
+			value.Text = strconv.FormatBool(value.Checked)
+		default:
+			if value.Text == "" {
+				continue
+			}
+		}
+		values = append(values, value)
+	}
+	return values, problems
+}
+
+/*
+OptionFor returns the ID of the option for the given text on a list field, adding it to the field if necessary. On
+a dry run an empty ID is returned for options that would be added.
+*/
+func (f *MappedFields) OptionFor(m *MappedField, text string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if m.Field.Options != nil {
+		for _, opt := range *m.Field.Options {
+			if opt.Value.Text == text {
+				return opt.Id, nil
+			}
+		}
+	} else {
+		m.Field.Options = &[]common.TrelloCustomFieldOption{}
+	}
+	if f.dryRun {
+		return "", nil
+	}
+
+	log.Printf("INFO Adding option '%s' to field '%s'", text, m.Field.Name)
+	created, err := f.client.CreateCustomFieldOption(m.Field.Id, &common.TrelloCustomFieldOption{
+		CustomFieldId: m.Field.Id,
+		Value:         common.TrelloCustomFieldOptionValue{Text: text},
+		Pos:           int64(len(*m.Field.Options)+1) * 10,
+	})
+	if err != nil {
+		return "", err
+	}
+	*m.Field.Options = append(*m.Field.Options, *created)
+	if err = f.state.RecordCreatedOption(m.Field.Id, created); err != nil {
+		return "", errors.New(fmt.Sprintf("could not record option '%s' in the migration state: %s", text, err))
+	}
+	return created.Id, nil
+}
+
+/*
+SetOnCard puts the value into its field on the given card
+*/
+func (f *MappedFields) SetOnCard(cardId string, value *MappedValue) error {
+	fieldId := value.Mapping.Field.Id
+	switch value.Mapping.Field.Type {
+	case common.Number:
+		return f.client.SetCustomFieldNumber(cardId, fieldId, value.Number)
+	case common.Date:
+		return f.client.SetCustomFieldDate(cardId, fieldId, value.Date)
+	case common.Checkbox:
+		return f.client.SetCustomFieldCheckbox(cardId, fieldId, value.Checked)
+	case common.List:
+		optionId, err := f.OptionFor(value.Mapping, value.Text)
+		if err != nil {
+			return err
+		}
+		return f.client.SetCustomFieldValue(cardId, fieldId, optionId)
+	default:
+		return f.client.SetCustomFieldText(cardId, fieldId, value.Text)
+	}
+}
@@ -0,0 +1,83 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"gopkg.in/yaml.v2"
+	"hash/fnv"
+	"io/ioutil"
+)
+
+/*
+LabelColours maps Jira label names to the colour that should be used when creating the equivalent Trello label.
+Labels that are not listed get a colour picked from a hash of their name, so re-runs always pick the same one.
+*/
+type LabelColours map[string]string
+
+/*
+LoadLabelColours reads a YAML file mapping label names to Trello colour names (or "null" for no colour)
+*/
+func LoadLabelColours(path string) (LabelColours, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var colours LabelColours
+	err = yaml.Unmarshal(content, &colours)
+	if err != nil {
+		return nil, err
+	}
+	for name, colour := range colours {
+		if !common.IsValidTrelloColour(colour) {
+			return nil, errors.New(fmt.Sprintf("'%s' is not a valid Trello label colour for label '%s'", colour, name))
+		}
+	}
+	return colours, nil
+}
+
+/*
+ColourFor returns the colour to use for a new label with the given name
+*/
+func (c LabelColours) ColourFor(name string) string {
+	if colour, haveColour := c[name]; haveColour {
+		return colour
+	}
+	hasher := fnv.New32a()
+	hasher.Write([]byte(name))
+	return common.TrelloLabelColours[hasher.Sum32()%uint32(len(common.TrelloLabelColours))]
+}
+
+/*
+ResolveLabels returns the IDs of the Trello labels corresponding to the given Jira labels, creating any that are
+missing from the board and recording them in the migration state against the current run. On a dry run nothing is
+created and the names of labels that would be created are returned instead.
+*/
+func ResolveLabels(jiraLabels []string, cache *trello.TrelloLabelCache, colours LabelColours, dryRun bool, state *common.MigrationState) ([]string, []string, error) {
+	labelIds := make([]string, 0, len(jiraLabels))
+	toCreate := make([]string, 0)
+
+	for _, name := range jiraLabels {
+		if dryRun {
+			if existing, haveExisting := cache.Lookup(name); haveExisting {
+				labelIds = append(labelIds, existing.Id)
+			} else {
+				toCreate = append(toCreate, name)
+			}
+			continue
+		}
+
+		label, created, err := cache.FindOrCreate(name, colours.ColourFor(name))
+		if err != nil {
+			return nil, nil, errors.New(fmt.Sprintf("could not create label '%s': %s", name, err))
+		}
+		if created {
+			if err = state.RecordCreatedLabel(&label); err != nil {
+				return nil, nil, errors.New(fmt.Sprintf("could not record label '%s' in the migration state: %s", name, err))
+			}
+		}
+		labelIds = append(labelIds, label.Id)
+	}
+	return labelIds, toCreate, nil
+}
@@ -0,0 +1,188 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+)
+
+type DoneAction string
+
+const (
+	DoneSkip    DoneAction = "skip"    //don't migrate done issues at all
+	DoneArchive DoneAction = "archive" //migrate done issues as archived cards
+	DoneImport  DoneAction = "import"  //migrate done issues as normal cards
+)
+
+/*
+ListRouting describes which Trello list each issue should go into, based on its Jira status.
+A status name match takes priority over a status category match, and anything not matched goes to Fallback.
+Status category keys are "new", "indeterminate" and "done"; matching on names is case-insensitive.
+*/
+type ListRouting struct {
+	Fallback      string            `yaml:"fallback"`
+	CreateMissing bool              `yaml:"createMissing"`
+	Done          DoneAction        `yaml:"done"`
+	Statuses      map[string]string `yaml:"statuses"`
+	Categories    map[string]string `yaml:"categories"`
+}
+
+/*
+LoadListRouting reads a ListRouting definition from a YAML file
+*/
+func LoadListRouting(path string) (*ListRouting, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var routing ListRouting
+	err = yaml.Unmarshal(content, &routing)
+	if err != nil {
+		return nil, err
+	}
+	return &routing, routing.Validate()
+}
+
+/*
+ListRoutingFromConfig returns the ListRouting for the lists section of a MigrationConfig
+*/
+func ListRoutingFromConfig(mapping common.ListMapping) (*ListRouting, error) {
+	routing := &ListRouting{
+		Fallback:      mapping.Fallback,
+		CreateMissing: mapping.CreateMissing,
+		Done:          DoneAction(mapping.Done),
+		Statuses:      mapping.Statuses,
+		Categories:    mapping.Categories,
+	}
+	return routing, routing.Validate()
+}
+
+func (r *ListRouting) Validate() error {
+	switch r.Done {
+	case "":
+		r.Done = DoneSkip
+	case DoneSkip, DoneArchive, DoneImport:
+	default:
+		return errors.New(fmt.Sprintf("'done' must be one of skip, archive or import, not '%s'", r.Done))
+	}
+	return nil
+}
+
+/*
+ListRouter resolves the Trello list for each issue, creating lists on the board if the routing allows it
+*/
+type ListRouter struct {
+	routing    *ListRouting
+	cache      *trello.ListCache
+	dryRun     bool
+	sprints    bool //route issues in an active sprint to a list named after the sprint
+	client     *trello.Client
+	createLock sync.Mutex //stops two workers creating the same list at once
+}
+
+func NewListRouter(routing *ListRouting, cache *trello.ListCache, dryRun bool, sprintLists bool, trelloClient *trello.Client) *ListRouter {
+	return &ListRouter{
+		routing: routing,
+		cache:   cache,
+		dryRun:  dryRun,
+		sprints: sprintLists,
+		client:  trelloClient,
+	}
+}
+
+/*
+lookupCaseInsensitive finds a key in the map ignoring case
+*/
+func lookupCaseInsensitive(m map[string]string, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+/*
+sprintListFor returns the active sprint that decides the issue's list, or nil if it is routed by status. Issues that
+are done are always routed by status.
+*/
+func (r *ListRouter) sprintListFor(issue *common.Issue) *common.SprintLink {
+	if !r.sprints || issue.Fields.Status.IsDone() {
+		return nil
+	}
+	return issue.Fields.ActiveSprint()
+}
+
+/*
+ListNameFor returns the name of the list that the given issue should go into
+*/
+func (r *ListRouter) ListNameFor(issue *common.Issue) string {
+	if sprint := r.sprintListFor(issue); sprint != nil {
+		return sprint.Name
+	}
+	if name, found := lookupCaseInsensitive(r.routing.Statuses, issue.Fields.Status.Name); found {
+		return name
+	}
+	category := issue.Fields.Status.StatusCategory
+	if name, found := lookupCaseInsensitive(r.routing.Categories, category.Key); found {
+		return name
+	}
+	if name, found := lookupCaseInsensitive(r.routing.Categories, category.Name); found {
+		return name
+	}
+	return r.routing.Fallback
+}
+
+/*
+ListFor returns the list that the given issue should go into. If it does not exist on the board and CreateMissing
+is set then it is created, unless this is a dry run in which case a list with no ID is returned.
+Lists for sprints are always created if they are missing.
+*/
+func (r *ListRouter) ListFor(issue *common.Issue) (common.TrelloList, error) {
+	listName := r.ListNameFor(issue)
+	if list, haveList := r.cache.FindByName(listName); haveList {
+		return list, nil
+	}
+	if !r.routing.CreateMissing && r.sprintListFor(issue) == nil {
+		return common.TrelloList{}, errors.New(fmt.Sprintf("there is no list '%s' on the board", listName))
+	}
+	if r.dryRun {
+		return common.TrelloList{Name: listName, BoardId: r.cache.BoardId}, nil
+	}
+
+	r.createLock.Lock()
+	defer r.createLock.Unlock()
+	if list, haveList := r.cache.FindByName(listName); haveList { //another worker may have just created it
+		return list, nil
+	}
+	log.Printf("INFO List '%s' does not exist on the board, creating it", listName)
+	created, err := r.client.CreateList(r.cache.BoardId, listName)
+	if err != nil {
+		return common.TrelloList{}, err
+	}
+	r.cache.Add(*created)
+	return *created, nil
+}
+
+/*
+ShouldMigrate returns false if the issue should be left out of the migration altogether
+*/
+func (r *ListRouter) ShouldMigrate(issue *common.Issue) bool {
+	return !(issue.Fields.Status.IsDone() && r.routing.Done == DoneSkip)
+}
+
+/*
+ShouldArchive returns true if the card for this issue should be archived once it is created
+*/
+func (r *ListRouter) ShouldArchive(issue *common.Issue) bool {
+	return issue.Fields.Status.IsDone() && r.routing.Done == DoneArchive
+}
@@ -0,0 +1,142 @@
+package migrate
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"testing"
+)
+
+func issueWithStatus(name string, categoryKey string, categoryName string) *common.Issue {
+	return &common.Issue{
+		Key: "PROJ-1",
+		Fields: common.IssueFields{
+			Status: common.IssueStatus{Name: name, StatusCategory: common.StatusCategory{Key: categoryKey, Name: categoryName}},
+		},
+	}
+}
+
+func TestListNameFor(t *testing.T) {
+	routing := &ListRouting{
+		Fallback:   "Backlog",
+		Statuses:   map[string]string{"In Review": "Review", "Done": "Shipped"},
+		Categories: map[string]string{"indeterminate": "Doing", "To Do": "Next up"},
+	}
+	router := NewListRouter(routing, nil, false, true, nil)
+	activeSprint := &[]common.SprintLink{{Id: 1, Name: "Sprint 7", State: common.StringPtr("active")}}
+
+	tests := []struct {
+		name     string
+		issue    *common.Issue
+		sprints  *[]common.SprintLink
+		expected string
+	}{
+		{"status name", issueWithStatus("In Review", "indeterminate", "In Progress"), nil, "Review"},
+		{"status name ignores case", issueWithStatus("in review", "indeterminate", "In Progress"), nil, "Review"},
+		{"status beats category key", issueWithStatus("Done", "done", "Done"), nil, "Shipped"},
+		{"category key", issueWithStatus("Testing", "indeterminate", "In Progress"), nil, "Doing"},
+		{"category name", issueWithStatus("Open", "new", "To Do"), nil, "Next up"},
+		{"category name ignores case", issueWithStatus("Open", "new", "to do"), nil, "Next up"},
+		{"fallback", issueWithStatus("Open", "new", "New"), nil, "Backlog"},
+		{"empty status", issueWithStatus("", "", ""), nil, "Backlog"},
+		{"active sprint beats status", issueWithStatus("In Review", "indeterminate", "In Progress"), activeSprint, "Sprint 7"},
+		{"done ignores sprint", issueWithStatus("Done", "done", "Done"), activeSprint, "Shipped"},
+	}
+	for _, test := range tests {
+		test.issue.Fields.SprintLink = test.sprints
+		if result := router.ListNameFor(test.issue); result != test.expected {
+			t.Errorf("%s: got '%s', expected '%s'", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestListRoutingDone(t *testing.T) {
+	tests := []struct {
+		done          DoneAction
+		shouldMigrate bool
+		shouldArchive bool
+	}{
+		{"", false, false},
+		{DoneSkip, false, false},
+		{DoneArchive, true, true},
+		{DoneImport, true, false},
+	}
+	done := issueWithStatus("Closed", "done", "Done")
+	open := issueWithStatus("Open", "new", "To Do")
+	for _, test := range tests {
+		routing := &ListRouting{Fallback: "To Do", Done: test.done}
+		if err := routing.Validate(); err != nil {
+			t.Fatalf("done '%s': %s", test.done, err)
+		}
+		router := NewListRouter(routing, nil, false, false, nil)
+		if router.ShouldMigrate(done) != test.shouldMigrate || router.ShouldArchive(done) != test.shouldArchive {
+			t.Errorf("done '%s': expected migrate=%t archive=%t for a done issue, got %t %t", test.done, test.shouldMigrate, test.shouldArchive, router.ShouldMigrate(done), router.ShouldArchive(done))
+		}
+		if !router.ShouldMigrate(open) || router.ShouldArchive(open) {
+			t.Errorf("done '%s': expected an open issue to be migrated as a normal card", test.done)
+		}
+	}
+
+	if _, err := ListRoutingFromConfig(common.ListMapping{Done: "delete"}); err == nil {
+		t.Error("expected an error for an unknown done action")
+	}
+}
+
+func TestLoadListRouting(t *testing.T) {
+	routing, err := LoadListRouting("../load-issues/listmap.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routing.Fallback != "To Do" || routing.Done != DoneArchive || routing.Statuses["In Review"] != "Review" || routing.Categories["indeterminate"] != "Doing" {
+		t.Errorf("example listmap was not read correctly: %+v", routing)
+	}
+}
+
+func TestListFor(t *testing.T) {
+	tests := []struct {
+		name          string
+		createMissing bool
+		dryRun        bool
+		status        string
+		expectErr     bool
+		expectCreated bool
+		expectId      bool
+	}{
+		{"existing list", false, false, "Open", false, false, true},
+		{"missing list", false, false, "In Review", true, false, false},
+		{"missing list created", true, false, "In Review", false, true, true},
+		{"missing list on a dry run", true, true, "In Review", false, false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := trellotest.NewServer()
+			defer server.Close()
+			server.AddList("board1", "To Do")
+			cache, err := server.Client().NewListCache("board1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			routing := &ListRouting{Fallback: "To Do", CreateMissing: test.createMissing, Statuses: map[string]string{"In Review": "Review"}}
+			router := NewListRouter(routing, cache, test.dryRun, false, server.Client())
+
+			list, err := router.ListFor(issueWithStatus(test.status, "indeterminate", "In Progress"))
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %t, got %v", test.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if (list.Id != "") != test.expectId {
+				t.Errorf("expected a list ID %t, got %+v", test.expectId, list)
+			}
+			if created := len(server.Lists("board1")) == 2; created != test.expectCreated {
+				t.Errorf("expected a list to be created %t, board has %+v", test.expectCreated, server.Lists("board1"))
+			}
+			if test.expectCreated {
+				//a second issue for the same list must reuse it
+				if again, _ := router.ListFor(issueWithStatus(test.status, "indeterminate", "In Progress")); again.Id != list.Id || len(server.Lists("board1")) != 2 {
+					t.Errorf("expected the created list to be reused, got %+v", server.Lists("board1"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type MemberRole string
+
+const (
+	RoleAssignee MemberRole = "assignee"
+	RoleReporter MemberRole = "reporter"
+	RoleWatchers MemberRole = "watchers"
+)
+
+/*
+ParseMemberRoles parses a comma-separated list of roles, e.g. "assignee,watchers"
+*/
+func ParseMemberRoles(spec string) (map[MemberRole]bool, error) {
+	roles := make(map[MemberRole]bool)
+	for _, part := range strings.Split(spec, ",") {
+		role := MemberRole(strings.TrimSpace(part))
+		switch role {
+		case "":
+			continue
+		case RoleAssignee, RoleReporter, RoleWatchers:
+			roles[role] = true
+		default:
+			return nil, errors.New(fmt.Sprintf("'%s' is not a valid member role, expected assignee, reporter or watchers", role))
+		}
+	}
+	return roles, nil
+}
+
+/*
+LoadMemberOverrides reads a YAML file that maps Jira users to Trello members. Keys can be the Jira account ID, email
+address or display name, and values are the Trello username or member ID
+*/
+func LoadMemberOverrides(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]string
+	err = yaml.Unmarshal(content, &raw)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]string, len(raw))
+	for k, v := range raw {
+		overrides[strings.ToLower(k)] = v
+	}
+	return overrides, nil
+}
+
+/*
+UnmatchedUser is a Jira user that could not be matched to any board member, with the issues they were found on
+*/
+type UnmatchedUser struct {
+	User   common.JiraUser `json:"user"`
+	Issues []string        `json:"issues"`
+}
+
+/*
+MemberMapper matches Jira users onto members of the Trello board. An explicit override always takes precedence,
+then email address, then display name against the member's full name, then the local part of the email address
+against the member's username. Users that can't be matched are remembered so they can be reported at the end.
+*/
+type MemberMapper struct {
+	cache      *trello.MemberCache
+	overrides  map[string]string
+	roles      map[MemberRole]bool
+	jiraClient jira.Source
+
+	mutex     sync.Mutex
+	unmatched map[string]*UnmatchedUser
+}
+
+func NewMemberMapper(cache *trello.MemberCache, overrides map[string]string, roles map[MemberRole]bool, jiraClient jira.Source) *MemberMapper {
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	return &MemberMapper{
+		cache:      cache,
+		overrides:  overrides,
+		roles:      roles,
+		jiraClient: jiraClient,
+		unmatched:  make(map[string]*UnmatchedUser),
+	}
+}
+
+func (m *MemberMapper) findOverride(user *common.JiraUser) (string, bool) {
+	for _, candidate := range []string{user.AccountId, user.EmailAddress, user.DisplayName} {
+		if candidate == "" {
+			continue
+		}
+		if target, haveTarget := m.overrides[strings.ToLower(candidate)]; haveTarget {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+/*
+Match returns the board member corresponding to the given Jira user, or false if there isn't one
+*/
+func (m *MemberMapper) Match(user *common.JiraUser) (common.TrelloMember, bool) {
+	if target, haveOverride := m.findOverride(user); haveOverride {
+		if member, found := m.cache.FindByUsername(target); found {
+			return member, true
+		}
+		if member, found := m.cache.FindById(target); found {
+			return member, true
+		}
+		log.Printf("WARNING Override for '%s' points to '%s', which is not a member of the board", user.DisplayName, target)
+		return common.TrelloMember{}, false
+	}
+
+	if user.EmailAddress != "" {
+		if member, found := m.cache.FindByEmail(user.EmailAddress); found {
+			return member, true
+		}
+	}
+	if user.DisplayName != "" {
+		if member, found := m.cache.FindByFullName(user.DisplayName); found {
+			return member, true
+		}
+	}
+	if at := strings.Index(user.EmailAddress, "@"); at > 0 {
+		if member, found := m.cache.FindByUsername(user.EmailAddress[:at]); found {
+			return member, true
+		}
+	}
+	return common.TrelloMember{}, false
+}
+
+func (m *MemberMapper) recordUnmatched(user *common.JiraUser, issueKey string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	id := user.AccountId
+	if id == "" {
+		id = user.DisplayName
+	}
+	entry, haveEntry := m.unmatched[id]
+	if !haveEntry {
+		entry = &UnmatchedUser{User: *user, Issues: make([]string, 0)}
+		m.unmatched[id] = entry
+	}
+	entry.Issues = append(entry.Issues, issueKey)
+}
+
+/*
+MembersFor returns the IDs of the board members that should be put onto the card for this issue, depending on which
+roles have been enabled
+*/
+func (m *MemberMapper) MembersFor(issue *common.Issue) []string {
+	users := make([]common.JiraUser, 0)
+	if m.roles[RoleAssignee] && issue.Fields.Assignee != nil {
+		users = append(users, *issue.Fields.Assignee)
+	}
+	if m.roles[RoleReporter] {
+		users = append(users, issue.Fields.Reporter)
+	}
+	if m.roles[RoleWatchers] && issue.Fields.Watches != nil && issue.Fields.Watches.WatchCount > 0 {
+		watchers, err := m.jiraClient.LoadWatchers(issue.Key)
+		if err != nil {
+			log.Printf("WARNING Could not load watchers for %s, they won't be added to the card: %s", issue.Key, err)
+		} else {
+			users = append(users, watchers...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	members := make([]string, 0)
+	for i := range users {
+		if users[i].AccountId == "" && users[i].DisplayName == "" {
+			continue
+		}
+		member, found := m.Match(&users[i])
+		if !found {
+			m.recordUnmatched(&users[i], issue.Key)
+			continue
+		}
+		if !seen[member.Id] {
+			seen[member.Id] = true
+			members = append(members, member.Id)
+		}
+	}
+	return members
+}
+
+/*
+Unmatched returns every user that could not be matched so far, sorted by display name
+*/
+func (m *MemberMapper) Unmatched() []UnmatchedUser {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	out := make([]UnmatchedUser, 0, len(m.unmatched))
+	for _, u := range m.unmatched {
+		out = append(out, *u)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].User.DisplayName < out[j].User.DisplayName
+	})
+	return out
+}
+
+/*
+WriteUnmatchedReport outputs the users that could not be matched to board members
+*/
+func (m *MemberMapper) WriteUnmatchedReport(w io.Writer) {
+	unmatched := m.Unmatched()
+	if len(unmatched) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n%d Jira users could not be matched to board members:\n", len(unmatched))
+	for _, u := range unmatched {
+		fmt.Fprintf(w, "  %s <%s> (%s) on %d issues: %s\n", u.User.DisplayName, u.User.EmailAddress, u.User.AccountId, len(u.Issues), strings.Join(u.Issues, ", "))
+	}
+}
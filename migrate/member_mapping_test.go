@@ -0,0 +1,150 @@
+package migrate
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jiratest"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseMemberRoles(t *testing.T) {
+	tests := []struct {
+		spec      string
+		expected  map[MemberRole]bool
+		expectErr bool
+	}{
+		{"assignee,reporter,watchers", map[MemberRole]bool{RoleAssignee: true, RoleReporter: true, RoleWatchers: true}, false},
+		{" assignee , watchers ", map[MemberRole]bool{RoleAssignee: true, RoleWatchers: true}, false},
+		{"reporter,,", map[MemberRole]bool{RoleReporter: true}, false},
+		{"", map[MemberRole]bool{}, false},
+		{"assignee,owner", nil, true},
+		{"Assignee", nil, true},
+	}
+	for _, test := range tests {
+		roles, err := ParseMemberRoles(test.spec)
+		if (err != nil) != test.expectErr {
+			t.Errorf("'%s': expected error %t, got %v", test.spec, test.expectErr, err)
+			continue
+		}
+		if !test.expectErr && !reflect.DeepEqual(roles, test.expected) {
+			t.Errorf("'%s': got %v, expected %v", test.spec, roles, test.expected)
+		}
+	}
+}
+
+/*
+newMemberFixture returns a fake board with a few members on it, and a cache of them
+*/
+func newMemberFixture(t *testing.T) (*trellotest.Server, *MemberMapper) {
+	server := trellotest.NewServer()
+	server.AddMember("board1", common.TrelloMember{Id: "m1", FullName: "Alice Smith", Username: "alice", Email: common.StringPtr("alice@example.com")})
+	server.AddMember("board1", common.TrelloMember{Id: "m2", FullName: "Bob Jones", Username: "bjones"})
+	server.AddMember("board1", common.TrelloMember{Id: "m3", FullName: "Carol White", Username: "carol"})
+	cache, err := server.Client().NewMemberCache("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := map[string]string{"acc-dave": "carol", "erin@example.com": "m2", "frank": "nobody"}
+	return server, NewMemberMapper(cache, overrides, map[MemberRole]bool{RoleAssignee: true}, nil)
+}
+
+func TestMemberMapperMatch(t *testing.T) {
+	server, mapper := newMemberFixture(t)
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		user     common.JiraUser
+		expected string //empty if there should be no match
+	}{
+		{"email", common.JiraUser{AccountId: "acc-alice", EmailAddress: "Alice@Example.com", DisplayName: "Someone Else"}, "m1"},
+		{"display name", common.JiraUser{AccountId: "acc-bob", DisplayName: "bob jones"}, "m2"},
+		{"email username", common.JiraUser{AccountId: "acc-carol", EmailAddress: "carol@elsewhere.com", DisplayName: "C. White"}, "m3"},
+		{"override by account ID to username", common.JiraUser{AccountId: "acc-dave", EmailAddress: "alice@example.com", DisplayName: "Dave"}, "m3"},
+		{"override by email to member ID", common.JiraUser{AccountId: "acc-erin", EmailAddress: "erin@example.com", DisplayName: "Erin"}, "m2"},
+		{"override to a non-member is not matched otherwise", common.JiraUser{AccountId: "acc-frank", DisplayName: "Frank", EmailAddress: "alice@example.com"}, ""},
+		{"no match", common.JiraUser{AccountId: "acc-grace", DisplayName: "Grace", EmailAddress: "grace@example.com"}, ""},
+	}
+	for _, test := range tests {
+		member, found := mapper.Match(&test.user)
+		if found != (test.expected != "") || member.Id != test.expected {
+			t.Errorf("%s: got %+v (found %t), expected '%s'", test.name, member, found, test.expected)
+		}
+	}
+}
+
+func TestLoadMemberOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "memberoverrides")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "overrides.yaml")
+	if err = ioutil.WriteFile(path, []byte("Dave@Example.com: carol\nacc-erin: bjones\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	overrides, err := LoadMemberOverrides(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//keys are matched ignoring case, so they are stored in lower case
+	if overrides["dave@example.com"] != "carol" || overrides["acc-erin"] != "bjones" {
+		t.Errorf("unexpected overrides: %v", overrides)
+	}
+}
+
+func TestMembersFor(t *testing.T) {
+	server, _ := newMemberFixture(t)
+	defer server.Close()
+	jira := jiratest.NewServer()
+	defer jira.Close()
+	err := jira.LoadFixtureJson([]byte(`{"issues": [{"id": "10001", "key": "PROJ-1", "fields": {}}], "watchers": {"PROJ-1": [
+		{"accountId": "acc-carol", "emailAddress": "carol@example.com", "displayName": "Carol White"},
+		{"accountId": "acc-alice", "emailAddress": "alice@example.com", "displayName": "Alice Smith"},
+		{"accountId": "acc-grace", "displayName": "Grace"}
+	]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := server.Client().NewMemberCache("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := &common.Issue{
+		Key: "PROJ-1",
+		Fields: common.IssueFields{
+			Assignee: &common.JiraUser{AccountId: "acc-alice", EmailAddress: "alice@example.com"},
+			Reporter: common.JiraUser{AccountId: "acc-bob", DisplayName: "Bob Jones"},
+			Watches:  &common.IssueWatches{WatchCount: 3},
+		},
+	}
+	tests := []struct {
+		roles    string
+		expected []string
+	}{
+		{"assignee", []string{"m1"}},
+		{"reporter", []string{"m2"}},
+		{"assignee,reporter", []string{"m1", "m2"}},
+		{"assignee,reporter,watchers", []string{"m1", "m2", "m3"}}, //alice is only added once
+		{"", []string{}},
+	}
+	for _, test := range tests {
+		roles, err := ParseMemberRoles(test.roles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapper := NewMemberMapper(cache, nil, roles, jira.Client())
+		if members := mapper.MembersFor(issue); !reflect.DeepEqual(members, test.expected) {
+			t.Errorf("'%s': got %v, expected %v", test.roles, members, test.expected)
+		}
+		unmatched := mapper.Unmatched()
+		if test.roles == "assignee,reporter,watchers" && (len(unmatched) != 1 || unmatched[0].User.DisplayName != "Grace" || unmatched[0].Issues[0] != "PROJ-1") {
+			t.Errorf("expected Grace to be reported as unmatched, got %+v", unmatched)
+		}
+	}
+}
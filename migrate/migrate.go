@@ -0,0 +1,246 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+)
+
+/*
+LookupEpicOption finds the option on the epics custom field that corresponds to the issue's epic link.
+Assumes that recPtr.Fields.EpicLink != nil.
+*/
+func LookupEpicOption(recPtr *common.Issue, epics *EpicsCache, epicLinkField *common.TrelloCustomField) (*common.TrelloCustomFieldOption, error) {
+	epicName, haveEpic := epics.KnownEpics[*(recPtr.Fields.EpicLink)]
+	if !haveEpic {
+		return nil, errors.New(fmt.Sprintf("could not find an epic for '%s'", *(recPtr.Fields.EpicLink)))
+	}
+
+	epicId, err := epicLinkField.FindInCustomField(epicName)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not find an entry for epic '%s' %s: %s", *recPtr.Fields.EpicLink, epicName, err))
+	}
+	return epicId, nil
+}
+
+/*
+MakeEpicLink sets the custom field on a created trello card to the epic's value.
+Assumes that recPtr.Fields.EpicLink != nil, will abort if this is not the case.
+*/
+func MakeEpicLink(recPtr *common.Issue, cardId string, epics *EpicsCache, epicLinkField *common.TrelloCustomField, trelloClient *trello.Client) error {
+	log.Printf("INFO Issue '%s' has a link to epic '%s'", recPtr.Fields.Summary, *recPtr.Fields.EpicLink)
+
+	epicId, err := LookupEpicOption(recPtr, epics, epicLinkField)
+	if err != nil {
+		log.Printf("ERROR %s", err)
+		return errors.New("could not create epic link")
+	}
+
+	err = trelloClient.SetCustomFieldValue(cardId, epicLinkField.Id, epicId.Id) //should use TrelloCustomFieldOptionValue as k-v i think. https://developer.atlassian.com/cloud/trello/rest/api-group-cards/#api-cards-idcard-customfield-idcustomfield-item-put
+	if err != nil {
+		log.Printf("ERROR Could not set up custom epics info field for '%s': %s", recPtr.Fields.Summary, err)
+		return errors.New("could not create epic link")
+	}
+	return nil
+}
+
+/*
+Migrator holds the settings, caches and clients that are shared by every issue in a run. load-issues, sync and the
+dry-run plan each build one and then hand it the issues one at a time.
+*/
+type Migrator struct {
+	Router           *ListRouter
+	Members          *MemberMapper //if nil, no members are put onto new cards
+	LabelCache       *trello.TrelloLabelCache
+	LabelColours     LabelColours
+	SubtaskMode      SubtaskMode
+	SprintMode       SprintMode
+	SprintField      *SprintField //only set when SprintMode is SprintsAsField
+	EstimateFields   *EstimateFields
+	MappedFields     *MappedFields
+	EpicLinkField    *common.TrelloCustomField
+	PriorityField    *common.TrelloCustomField
+	PriorityNames    map[string]string
+	JiraIdField      *common.TrelloCustomField
+	Epics            *EpicsCache
+	AttachmentPolicy *AttachmentPolicy
+	JiraClient       jira.Source
+	TrelloClient     *trello.Client
+	State            *common.MigrationState
+}
+
+/*
+listFor returns the list that the issue's card should go into, logging why if there isn't one
+*/
+func (m *Migrator) listFor(recPtr *common.Issue) (common.TrelloList, error) {
+	targetList, err := m.Router.ListFor(recPtr)
+	if err != nil {
+		log.Printf("ERROR Could not find a list for '%s' with status '%s': %s", recPtr.Key, recPtr.Fields.Status.Name, err)
+	}
+	return targetList, err
+}
+
+/*
+membersFor returns the board members to put onto the issue's card, if it has not been created yet
+*/
+func (m *Migrator) membersFor(recPtr *common.Issue) []string {
+	if m.Members == nil || m.State.IsDone(recPtr.Key, common.StepCardCreated) {
+		return nil
+	}
+	return m.Members.MembersFor(recPtr)
+}
+
+/*
+MigrateIssue copies the given issue over to a Trello card in the list chosen by the router, along with its custom
+fields, attachments and comments. If the router says so then the card is archived once everything else is done.
+Each step is recorded in the migration state as it completes, so if the issue was partially migrated on a previous
+run only the outstanding steps are carried out against the card that was already created.
+*/
+func (m *Migrator) MigrateIssue(recPtr *common.Issue) error {
+	targetList, err := m.listFor(recPtr)
+	if err != nil {
+		return err
+	}
+	state := m.State
+	trelloClient := m.TrelloClient
+
+	var cardId string
+	if previous, havePrevious := state.Get(recPtr.Key); havePrevious && previous.Steps[common.StepCardCreated] {
+		log.Printf("INFO Issue %s was already migrated to card %s, resuming", recPtr.Key, previous.CardId)
+		cardId = previous.CardId
+	} else {
+		//get a base trello card
+		labelIds, _, err := ResolveLabels(LabelsFor(recPtr, m.SprintMode), m.LabelCache, m.LabelColours, false, state)
+		if err != nil {
+			log.Printf("ERROR Could not set up labels for '%s': %s", recPtr.Fields.Summary, err)
+			return errors.New("can't migrate issue")
+		}
+		newCard := recPtr.ToTrelloCard(targetList.Id, false)
+		newCard.Members = m.membersFor(recPtr)
+		newCard.LabelIDs = labelIds
+		//write the card and get an ID
+		createdCard, err := trelloClient.PutTrelloCard(newCard)
+		if err != nil {
+			log.Printf("ERROR Could not create a card for '%s': %s", recPtr.Fields.Summary, err)
+			return errors.New("can't migrate issue")
+		}
+		cardId = createdCard.Id
+		err = state.RecordCard(recPtr.Key, createdCard)
+		if err != nil {
+			log.Printf("ERROR Could not record card for '%s' in the migration state: %s", recPtr.Key, err)
+			return errors.New("can't migrate issue")
+		}
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepJiraKey) {
+		err := trelloClient.SetCustomFieldText(cardId, m.JiraIdField.Id, recPtr.Key)
+		if err != nil {
+			log.Printf("ERROR Could not add jira key for '%s': %s", recPtr.Fields.Summary, err)
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepJiraKey); err != nil {
+			return err
+		}
+	}
+
+	//if there are attachments, copy them over
+	err = HandleAttachments(recPtr.Key, &recPtr.Fields.Attachment, cardId, m.AttachmentPolicy, m.JiraClient, trelloClient, state)
+	if err != nil {
+		log.Printf("ERROR Could not fix attachments for '%s': %s", recPtr.Fields.Summary, err)
+		return errors.New("can't migrate issue")
+	}
+	//if there is an epic link, find the custom field value corresponding and set it
+	if recPtr.Fields.EpicLink != nil && !state.IsDone(recPtr.Key, common.StepEpicLink) {
+		err = MakeEpicLink(recPtr, cardId, m.Epics, m.EpicLinkField, trelloClient)
+		if err != nil {
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepEpicLink); err != nil {
+			return err
+		}
+	}
+
+	//get the priority and set that on a custom field too
+	if !state.IsDone(recPtr.Key, common.StepPriority) {
+		fieldId, err := recPtr.Fields.Priority.ToTrelloLabel(m.PriorityNames, m.PriorityField.Options)
+		if err != nil {
+			log.Printf("ERROR Could not set up priority for '%s': '%s", recPtr.Fields.Summary, err)
+			return errors.New("can't migrate issue")
+		}
+		err = trelloClient.SetCustomFieldValue(cardId, m.PriorityField.Id, fieldId)
+
+		if err != nil {
+			log.Printf("ERROR Could not set up priority field for '%s': %s", recPtr.Fields.Summary, err)
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepPriority); err != nil {
+			return err
+		}
+	}
+
+	if m.SprintField != nil && !state.IsDone(recPtr.Key, common.StepSprint) {
+		if err = setSprintField(recPtr, cardId, m.SprintField, false, trelloClient); err != nil {
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepSprint); err != nil {
+			return err
+		}
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepEstimates) {
+		if err = setEstimateFields(recPtr, cardId, m.EstimateFields, false, trelloClient); err != nil {
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepEstimates); err != nil {
+			return err
+		}
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepCustomFields) {
+		if err = setMappedFields(recPtr, cardId, m.MappedFields, false, trelloClient); err != nil {
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepCustomFields); err != nil {
+			return err
+		}
+	}
+
+	if m.SubtaskMode == SubtasksAsChecklist {
+		err = MigrateSubtaskChecklist(recPtr, cardId, state, trelloClient)
+		if err != nil {
+			log.Printf("ERROR Could not set up sub-tasks checklist for '%s': %s", recPtr.Fields.Summary, err)
+			return errors.New("can't migrate issue")
+		}
+	}
+
+	//now need to migrate all other comments
+	err = CopyComments(recPtr, cardId, m.JiraClient, trelloClient, state)
+	if err != nil {
+		return errors.New("can't migrate issue")
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepOriginComment) {
+		//set a comment showing where this came from and when
+		err = trelloClient.AddComment(cardId, FormatOriginComment(recPtr))
+		if err != nil {
+			log.Printf("ERROR Could not add comment to card '%s': %s", cardId, err)
+			return errors.New("can't migrate issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepOriginComment); err != nil {
+			return err
+		}
+	}
+
+	if m.Router.ShouldArchive(recPtr) && !state.IsDone(recPtr.Key, common.StepArchived) {
+		err = trelloClient.ArchiveCard(cardId)
+		if err != nil {
+			log.Printf("ERROR Could not archive card '%s': %s", cardId, err)
+			return errors.New("can't migrate issue")
+		}
+		return state.MarkDone(recPtr.Key, common.StepArchived)
+	}
+	return nil
+}
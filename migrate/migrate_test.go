@@ -0,0 +1,711 @@
+package migrate
+
+import (
+	"encoding/json"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jiratest"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+migrationFixture holds a fake Trello board set up the way load-issues expects, a fake Jira holding one issue with a
+comment and an attachment, and an empty migration state
+*/
+type migrationFixture struct {
+	trello        *trellotest.Server
+	jira          *jiratest.Server
+	list          common.TrelloList
+	routing       *ListRouting
+	epicLinkField common.TrelloCustomField
+	priorityField common.TrelloCustomField
+	jiraIdField   common.TrelloCustomField
+	policy        *AttachmentPolicy
+	sprintMode    SprintMode
+	sprintField   *SprintField
+	estimates     *EstimateFields
+	mapped        *MappedFields
+	epics         *EpicsCache
+	state         *common.MigrationState
+	stateDir      string
+}
+
+func newMigrationFixture(t *testing.T) *migrationFixture {
+	f := &migrationFixture{trello: trellotest.NewServer()}
+	f.list = f.trello.AddList("board1", "To Do")
+	f.epicLinkField = f.trello.AddCustomField("board1", "Epic", common.List, "Big Project")
+	f.priorityField = f.trello.AddCustomField("board1", "Priority", common.List, "Highest", "High", "Medium", "Low", "Lowest")
+	f.jiraIdField = f.trello.AddCustomField("board1", "Jira Key", common.Text)
+	f.routing = &ListRouting{Fallback: "To Do", Done: DoneArchive, Statuses: map[string]string{"In Progress": "Doing"}}
+	f.policy = &AttachmentPolicy{}
+	f.sprintMode = SprintsIgnore
+	f.estimates = &EstimateFields{}
+	f.mapped = &MappedFields{}
+	f.epics = &EpicsCache{KnownEpics: map[string]string{"PROJ-100": "Big Project"}}
+
+	f.jira = jiratest.NewServer()
+	if err := f.jira.AddIssue(makeTestIssue()); err != nil {
+		t.Fatal(err)
+	}
+	f.jira.AddComment("PROJ-1", common.Comment{
+		Id:      "20001",
+		Author:  common.JiraUser{DisplayName: "Commenter"},
+		Body:    common.JiraContent{Type: "doc", Content: []common.AdfNode{{Type: "paragraph", Content: []common.AdfNode{{Type: "text", Text: "A comment"}}}}},
+		Created: "2021-03-04T10:00:00.000+0000",
+	})
+	f.jira.AddAttachment("10001", "screenshot content")
+
+	var err error
+	f.stateDir, err = ioutil.TempDir("", "loadissues")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.state, err = common.LoadMigrationState(filepath.Join(f.stateDir, "state.json"), common.JiraToTrello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func (f *migrationFixture) Close() {
+	f.trello.Close()
+	f.jira.Close()
+	os.RemoveAll(f.stateDir)
+}
+
+/*
+migrator returns a Migrator for the fixture's current settings, with its caches loaded from the fake board
+*/
+func (f *migrationFixture) migrator() (*Migrator, error) {
+	trelloClient := f.trello.Client()
+	labelCache, err := trelloClient.NewTrelloLabelCache("board1")
+	if err != nil {
+		return nil, err
+	}
+	listCache, err := trelloClient.NewListCache("board1")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		Router:           NewListRouter(f.routing, listCache, false, false, trelloClient),
+		LabelCache:       labelCache,
+		LabelColours:     LabelColours{},
+		SubtaskMode:      SubtasksAsChecklist,
+		SprintMode:       f.sprintMode,
+		SprintField:      f.sprintField,
+		EstimateFields:   f.estimates,
+		MappedFields:     f.mapped,
+		EpicLinkField:    &f.epicLinkField,
+		PriorityField:    &f.priorityField,
+		JiraIdField:      &f.jiraIdField,
+		Epics:            f.epics,
+		AttachmentPolicy: f.policy,
+		JiraClient:       f.jira.Client(),
+		TrelloClient:     trelloClient,
+		State:            f.state,
+	}, nil
+}
+
+func (f *migrationFixture) migrate(issue *common.Issue) error {
+	m, err := f.migrator()
+	if err != nil {
+		return err
+	}
+	return m.MigrateIssue(issue)
+}
+
+func (f *migrationFixture) sync(issue *common.Issue) error {
+	m, err := f.migrator()
+	if err != nil {
+		return err
+	}
+	return m.SyncIssue(issue)
+}
+
+func makeTestIssue() *common.Issue {
+	return &common.Issue{
+		Id:  "10000",
+		Key: "PROJ-1",
+		Fields: common.IssueFields{
+			Summary:  "Something is broken",
+			Priority: common.IssuePriority{Id: "2", Name: "High"},
+			Labels:   []string{"backend"},
+			Status:   common.IssueStatus{Name: "To Do"},
+			Reporter: common.JiraUser{DisplayName: "Reporter"},
+			Created:  "2021-03-01T09:00:00.000+0000",
+			EpicLink: common.StringPtr("PROJ-100"),
+			Attachment: []common.Attachment{
+				{Id: "10001", Filename: "screenshot.png", MimeType: "image/png", Size: 18},
+			},
+		},
+	}
+}
+
+func TestMigrateIssue(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	err := f.migrate(makeTestIssue())
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+
+	f.trello.AssertCardCount(t, 1)
+	f.trello.AssertCardInList(t, "Something is broken", "To Do")
+	f.trello.AssertCustomFieldText(t, "Something is broken", "Jira Key", "PROJ-1")
+	f.trello.AssertCustomFieldOption(t, "Something is broken", "Epic", "Big Project")
+	f.trello.AssertCustomFieldOption(t, "Something is broken", "Priority", "High")
+	f.trello.AssertComment(t, "Something is broken", "A comment")
+	f.trello.AssertComment(t, "Something is broken", "originally reported on")
+	attachment := f.trello.AssertAttachment(t, "Something is broken", "screenshot.png")
+	if string(attachment.Content) != "screenshot content" || attachment.MimeType != "image/png" {
+		t.Errorf("attachment was not copied correctly: %+v", attachment)
+	}
+	labels := f.trello.Labels("board1")
+	if len(labels) != 1 || labels[0].Name != "backend" {
+		t.Errorf("expected the 'backend' label to be created, got %+v", labels)
+	}
+}
+
+func TestMigrateIssueResumes(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	//the card is created before comments are loaded, so this stops the migration part-way through
+	f.jira.FailAfter("/issue/PROJ-1/comment", 0, 500)
+	err := f.migrate(makeTestIssue())
+	if err == nil {
+		t.Fatal("expected the first MigrateIssue to fail")
+	}
+	created := f.trello.AssertCard(t, "Something is broken")
+
+	err = f.migrate(makeTestIssue())
+	if err != nil {
+		t.Fatalf("second MigrateIssue failed: %s", err)
+	}
+	f.trello.AssertCardCount(t, 1)
+	resumed := f.trello.AssertCard(t, "Something is broken")
+	if resumed.Id != created.Id {
+		t.Errorf("expected the existing card to be reused")
+	}
+	if len(resumed.Comments) != 2 {
+		t.Errorf("expected the migrated comment and the origin comment, got %q", resumed.Comments)
+	}
+	if len(resumed.Attachments) != 1 {
+		t.Errorf("expected the attachment not to be copied again, got %d", len(resumed.Attachments))
+	}
+}
+
+func TestMigrateIssueAttachmentFallback(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	//the streamed copy fails, so the attachment should be copied again via a temp file
+	f.jira.FailAfter("/attachment/content/10001", 0, 500)
+	err := f.migrate(makeTestIssue())
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	attachment := f.trello.AssertAttachment(t, "Something is broken", "screenshot.png")
+	if string(attachment.Content) != "screenshot content" {
+		t.Errorf("attachment was not copied correctly: %+v", attachment)
+	}
+}
+
+func TestMigrateIssueAttachmentResponseLost(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	//the streamed upload reaches Trello but the response doesn't, so it must not be uploaded a second time
+	trelloClient := f.trello.Client()
+	card, err := trelloClient.PutTrelloCard(makeTestIssue().ToTrelloCard(f.list.Id, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.trello.FailNextAfterHandling("POST", "/cards/"+card.Id+"/attachments", 500)
+	issue := makeTestIssue()
+	err = HandleAttachments(issue.Key, &issue.Fields.Attachment, card.Id, f.policy, f.jira.Client(), trelloClient, f.state)
+	if err != nil {
+		t.Fatalf("HandleAttachments failed: %s", err)
+	}
+	uploaded, _ := f.trello.CardByName("Something is broken")
+	if len(uploaded.Attachments) != 1 || string(uploaded.Attachments[0].Content) != "screenshot content" {
+		t.Errorf("expected the attachment to be uploaded once, got %+v", uploaded.Attachments)
+	}
+	if !f.state.IsDone(issue.Key, common.AttachmentStep("10001")) {
+		t.Error("expected the attachment to be recorded as copied")
+	}
+}
+
+func TestMigrateIssueAttachmentWrongSize(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	issue := makeTestIssue()
+	issue.Fields.Attachment[0].Size = 1000 //more than Jira will send
+	err := f.migrate(issue)
+	if err == nil {
+		t.Fatal("expected a truncated attachment to stop the migration")
+	}
+	card := f.trello.AssertCard(t, "Something is broken")
+	if len(card.Attachments) != 0 {
+		t.Errorf("expected the truncated attachment not to be uploaded, got %+v", card.Attachments)
+	}
+}
+
+func TestMigrateIssueOversizeAttachment(t *testing.T) {
+	for _, action := range []AttachmentAction{AttachmentSkip, AttachmentLink, AttachmentStore} {
+		t.Run(string(action), func(t *testing.T) {
+			f := newMigrationFixture(t)
+			defer f.Close()
+
+			f.policy = &AttachmentPolicy{
+				Rules:          []AttachmentRule{{Over: "1GB", Action: AttachmentSkip}, {Over: "10", Action: action}},
+				StoreDirectory: f.stateDir,
+				StoreUrl:       "https://files.example.com/jira",
+			}
+			if err := f.policy.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			issue := makeTestIssue()
+			issue.Fields.Attachment[0].Content = "https://example.atlassian.net/rest/api/3/attachment/content/10001"
+			err := f.migrate(issue)
+			if err != nil {
+				t.Fatalf("MigrateIssue failed: %s", err)
+			}
+
+			card := f.trello.AssertCard(t, "Something is broken")
+			outcome, _ := f.state.Get("PROJ-1")
+			recorded := outcome.Attachments["10001"]
+			if recorded.Action != string(action) || !outcome.Steps[common.AttachmentStep("10001")] {
+				t.Errorf("expected the %s action to be recorded, got %+v", action, outcome)
+			}
+			switch action {
+			case AttachmentSkip:
+				if len(card.Attachments) != 0 {
+					t.Errorf("expected nothing to be attached, got %+v", card.Attachments)
+				}
+				f.trello.AssertComment(t, "Something is broken", "'screenshot.png' (18 bytes) was too large")
+			case AttachmentLink:
+				attachment := f.trello.AssertAttachment(t, "Something is broken", "screenshot.png")
+				if attachment.Url != issue.Fields.Attachment[0].Content || recorded.Url != attachment.Url {
+					t.Errorf("expected a link to the Jira attachment, got %+v", attachment)
+				}
+			case AttachmentStore:
+				attachment := f.trello.AssertAttachment(t, "Something is broken", "screenshot.png")
+				if attachment.Url != "https://files.example.com/jira/PROJ-1/10001-screenshot.png" {
+					t.Errorf("expected a link to the stored file, got %+v", attachment)
+				}
+				content, err := ioutil.ReadFile(filepath.Join(f.stateDir, "PROJ-1", "10001-screenshot.png"))
+				if err != nil || string(content) != "screenshot content" {
+					t.Errorf("attachment was not stored correctly: '%s' %v", string(content), err)
+				}
+			}
+		})
+	}
+}
+
+func withSprints(issue *common.Issue) *common.Issue {
+	issue.Fields.SprintLink = &[]common.SprintLink{
+		{Id: 1, Name: "Sprint 1", State: common.StringPtr("closed")},
+		{Id: 2, Name: "Sprint 2", State: common.StringPtr("active"), Goal: common.StringPtr("Fix the login page")},
+	}
+	return issue
+}
+
+func TestMigrateIssueSprintLabel(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	f.sprintMode = SprintsAsLabel
+	err := f.migrate(withSprints(makeTestIssue()))
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	card := f.trello.AssertCard(t, "Something is broken")
+	if len(card.LabelIDs) != 2 {
+		t.Errorf("expected the backend and sprint labels, got %v", card.LabelIDs)
+	}
+	if !strings.Contains(card.Description, "- Sprint 2 (active): Fix the login page") {
+		t.Errorf("expected the sprints in the description, got '%s'", card.Description)
+	}
+}
+
+func TestMigrateIssueSprintField(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	fields, err := f.trello.Client().LoadAllCustomFields("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.sprintMode = SprintsAsField
+	f.sprintField, err = NewSprintField("board1", "Sprint", fields, false, f.trello.Client(), f.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.migrate(withSprints(makeTestIssue()))
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	f.trello.AssertCustomFieldOption(t, "Something is broken", "Sprint", "Sprint 2")
+
+	//a second issue in the same sprint should reuse the option rather than adding another
+	second := withSprints(makeTestIssue())
+	second.Key = "PROJ-2"
+	second.Fields.Summary = "Something else is broken"
+	second.Fields.Attachment = nil
+	if err = f.jira.AddIssue(second); err != nil {
+		t.Fatal(err)
+	}
+	err = f.migrate(second)
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	f.trello.AssertCustomFieldOption(t, "Something else is broken", "Sprint", "Sprint 2")
+	if len(*f.sprintField.Field.Options) != 1 {
+		t.Errorf("expected one sprint option, got %+v", *f.sprintField.Field.Options)
+	}
+}
+
+func TestMigrateIssueEstimates(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	f.trello.AddCustomField("board1", "Story Points", common.Number)
+	fields, err := f.trello.Client().LoadAllCustomFields("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.estimates, err = NewEstimateFields("board1", "Original Estimate", "Remaining Estimate", "Story Points", fields, false, f.trello.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, haveField := f.trello.CustomField("board1", "Original Estimate"); !haveField {
+		t.Error("expected the missing estimate field to be created")
+	}
+
+	issue := makeTestIssue()
+	original := int64(5400)
+	issue.Fields.TimeOriginal = &original
+	storyPoints := 3.0
+	issue.Fields.StoryPoints = &storyPoints
+	err = f.migrate(issue)
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	f.trello.AssertCustomFieldValue(t, "Something is broken", "Original Estimate", "number", "1.5")
+	f.trello.AssertCustomFieldValue(t, "Something is broken", "Story Points", "number", "3")
+	card := f.trello.AssertCard(t, "Something is broken")
+	remaining, _ := f.trello.CustomField("board1", "Remaining Estimate")
+	if _, haveValue := card.CustomFieldItems[remaining.Id]; haveValue {
+		t.Error("expected no remaining estimate, as the issue doesn't have one")
+	}
+
+	_, err = NewEstimateFields("board1", "", "", "Jira Key", fields, false, f.trello.Client())
+	if err == nil {
+		t.Error("expected an error for a field that isn't a number field")
+	}
+}
+
+func TestMigrateIssueMappedFields(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+
+	if err := f.state.StartRun("run1", "board1"); err != nil {
+		t.Fatal(err)
+	}
+	fields, err := f.trello.Client().LoadAllCustomFields("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings := []common.CustomFieldMapping{
+		{Jira: "team", Trello: "Team", Type: common.List},
+		{Jira: "customfield_10060", Trello: "Risk", Type: common.Number},
+		{Jira: "Release Date", Trello: "Release", Type: common.Date},
+	}
+	f.mapped, err = NewMappedFields("board1", mappings, fields, false, f.trello.Client(), f.state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issue := makeTestIssue()
+	issue.Names = map[string]string{"customfield_10050": "Team", "customfield_10060": "Risk", "customfield_10070": "Release Date"}
+	issue.RawFields = map[string]json.RawMessage{
+		"customfield_10050": json.RawMessage(`{"value": "Platform", "id": "10100"}`),
+		"customfield_10060": json.RawMessage(`"high"`),
+		"customfield_10070": json.RawMessage(`"2021-06-01"`),
+	}
+	values, problems := f.mapped.ValuesFor(issue)
+	if len(values) != 2 || len(problems) != 1 {
+		t.Errorf("expected the risk field to be a problem, got %+v and %v", values, problems)
+	}
+
+	err = f.migrate(issue)
+	if err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	f.trello.AssertCustomFieldOption(t, "Something is broken", "Team", "Platform")
+	f.trello.AssertCustomFieldValue(t, "Something is broken", "Release", "date", "2021-06-01T00:00:00.000Z")
+	if run, _ := f.state.GetRun("run1"); len(run.FieldOptions) != 1 || run.FieldOptions[0].Text != "Platform" {
+		t.Errorf("expected the Platform option to be recorded, got %+v", run.FieldOptions)
+	}
+}
+
+func TestMigrationReport(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+	report := NewMigrationReport()
+
+	//PROJ-1 goes over but its attachment is left behind, so it is only partly migrated
+	f.policy = &AttachmentPolicy{Rules: []AttachmentRule{{Over: "10", Action: AttachmentSkip}}}
+	if err := f.policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	issue := makeTestIssue()
+	err := f.migrate(issue)
+	report.AddIssue(issue, f.list.Name, f.epics, nil, f.state, err)
+
+	//PROJ-2 has nothing left behind
+	second := makeTestIssue()
+	second.Key = "PROJ-2"
+	second.Fields.Attachment = nil
+	if err = f.jira.AddIssue(second); err != nil {
+		t.Fatal(err)
+	}
+	err = f.migrate(second)
+	report.AddIssue(second, f.list.Name, f.epics, nil, f.state, err)
+
+	//PROJ-3 can't get a card
+	third := makeTestIssue()
+	third.Key = "PROJ-3"
+	f.trello.FailNext("POST", "/cards", 500)
+	err = f.migrate(third)
+	report.AddIssue(third, f.list.Name, f.epics, nil, f.state, err)
+
+	report.Skip("PROJ-4", "status is Done", f.state)
+
+	rows := report.sortedIssues()
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %+v", rows)
+	}
+	first := rows[0]
+	if first.Status != ReportPartial || first.CardId == "" || first.Epic != "Big Project" || first.Priority != "High" || first.Comments != 1 || first.Attachments != 0 || len(first.Skipped) != 1 {
+		t.Errorf("unexpected row for PROJ-1: %+v", first)
+	}
+	if rows[1].Status != ReportOk || rows[1].ListName != "To Do" {
+		t.Errorf("unexpected row for PROJ-2: %+v", rows[1])
+	}
+	if rows[2].Status != ReportFailed || rows[2].Error == "" {
+		t.Errorf("unexpected row for PROJ-3: %+v", rows[2])
+	}
+	if rows[3].Status != ReportSkipped || rows[3].Skipped[0] != "status is Done" {
+		t.Errorf("unexpected row for PROJ-4: %+v", rows[3])
+	}
+
+	var csvOut strings.Builder
+	if err = report.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if len(lines) != 5 || lines[0] != "jiraKey,cardId,shortUrl,list,epic,priority,comments,attachments,status,skipped,error,runId" {
+		t.Errorf("unexpected CSV:\n%s", csvOut.String())
+	}
+	if !strings.HasPrefix(lines[4], "PROJ-4,,,,,,0,0,skipped,status is Done,") {
+		t.Errorf("unexpected CSV row for a skipped issue: %s", lines[4])
+	}
+}
+
+func TestSyncJql(t *testing.T) {
+	since := time.Date(2021, 3, 4, 10, 30, 45, 0, time.UTC)
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("no timezone data available")
+	}
+	tests := []struct {
+		base     string
+		zone     *time.Location
+		expected string
+	}{
+		{"issueType in (Bug,Task)", time.UTC, `(issueType in (Bug,Task)) AND updated >= "2021/03/04 10:29"`},
+		{"project = PROJ order by key", time.UTC, `(project = PROJ) AND updated >= "2021/03/04 10:29" order by key`},
+		{"project = PROJ ORDER  BY\tkey", time.UTC, `(project = PROJ) AND updated >= "2021/03/04 10:29" ORDER  BY` + "\tkey"},
+		{`summary ~ "order by \"x\"" ORDER BY key`, time.UTC, `(summary ~ "order by \"x\"") AND updated >= "2021/03/04 10:29" ORDER BY key`},
+		{`summary ~ 'sort order by date'`, time.UTC, `(summary ~ 'sort order by date') AND updated >= "2021/03/04 10:29"`},
+		{"recordered by = me", time.UTC, `(recordered by = me) AND updated >= "2021/03/04 10:29"`},
+		{"", time.UTC, `updated >= "2021/03/04 10:29"`},
+		{"project = PROJ", london, `(project = PROJ) AND updated >= "2021/03/04 10:29"`},
+		{"project = PROJ", time.FixedZone("EST", -5*3600), `(project = PROJ) AND updated >= "2021/03/04 05:29"`},
+	}
+	for _, test := range tests {
+		if result := SyncJql(test.base, since, test.zone); result != test.expected {
+			t.Errorf("SyncJql('%s') returned '%s', expected '%s'", test.base, result, test.expected)
+		}
+	}
+}
+
+func TestKeysJql(t *testing.T) {
+	tests := []struct {
+		keys     []string
+		expected string
+	}{
+		{[]string{"PROJ-1"}, `key in ("PROJ-1")`},
+		{[]string{"PROJ-1", "OTHER-22"}, `key in ("PROJ-1", "OTHER-22")`},
+	}
+	for _, test := range tests {
+		if result := KeysJql(test.keys); result != test.expected {
+			t.Errorf("KeysJql(%v) returned '%s', expected '%s'", test.keys, result, test.expected)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	est := time.FixedZone("EST", -5*3600)
+	parsed, err := ParseSince("2021-03-04", est)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Date(2021, 3, 4, 5, 0, 0, 0, time.UTC); !parsed.Equal(expected) {
+		t.Errorf("expected a date to be midnight in the Jira timezone, got %s", parsed)
+	}
+	//the JQL should then ask for exactly that date, whatever timezone this machine is in
+	if jql := SyncJql("", parsed, est); jql != `updated >= "2021/03/03 23:59"` {
+		t.Errorf("unexpected JQL for a date: %s", jql)
+	}
+
+	parsed, err = ParseSince("2021-03-04T10:30:00Z", est)
+	if err != nil || !parsed.Equal(time.Date(2021, 3, 4, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("expected an RFC3339 time to keep its own offset, got %s %v", parsed, err)
+	}
+	if _, err = ParseSince("yesterday", est); err == nil {
+		t.Error("expected an error for something that isn't a time or date")
+	}
+}
+
+func TestSyncIssue(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+	fields, err := f.trello.Client().LoadAllCustomFields("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.estimates, err = NewEstimateFields("board1", "", "Remaining Estimate", "Story Points", fields, false, f.trello.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue := makeTestIssue()
+	storyPoints := 3.0
+	remaining := int64(3600)
+	issue.Fields.StoryPoints = &storyPoints
+	issue.Fields.TimeEstimate = &remaining
+	if err := f.migrate(issue); err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+	if err := f.state.MarkCompleted("PROJ-1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := makeTestIssue()
+	newStoryPoints := 5.0
+	updated.Fields.StoryPoints = &newStoryPoints
+	updated.Fields.Summary = "Something is still broken"
+	updated.Fields.Priority = common.IssuePriority{Id: "1", Name: "Highest"}
+	updated.Fields.EpicLink = nil
+	updated.Fields.DueDate = common.StringPtr("2021-04-01")
+	updated.Fields.Status = common.IssueStatus{Name: "In Progress", StatusCategory: common.StatusCategory{Key: "indeterminate"}}
+	f.jira.AddComment("PROJ-1", common.Comment{
+		Id:      "20002",
+		Author:  common.JiraUser{DisplayName: "Commenter"},
+		Body:    common.JiraContent{Type: "doc", Content: []common.AdfNode{{Type: "paragraph", Content: []common.AdfNode{{Type: "text", Text: "A later comment"}}}}},
+		Created: "2021-03-05T10:00:00.000+0000",
+	})
+	f.trello.AddList("board1", "Doing")
+
+	err = f.sync(updated)
+	if err != nil {
+		t.Fatalf("SyncIssue failed: %s", err)
+	}
+	f.trello.AssertCardCount(t, 1)
+	f.trello.AssertCardInList(t, "Something is still broken", "Doing")
+	f.trello.AssertCustomFieldOption(t, "Something is still broken", "Priority", "Highest")
+	f.trello.AssertCustomFieldValue(t, "Something is still broken", "Story Points", "number", "5")
+	f.trello.AssertComment(t, "Something is still broken", "A later comment")
+	card := f.trello.AssertCard(t, "Something is still broken")
+	if _, haveRemaining := card.CustomFieldItems[f.estimates.RemainingEstimate.Id]; haveRemaining {
+		t.Error("expected the remaining estimate to be cleared, as the issue no longer has one")
+	}
+	if _, haveEpic := card.CustomFieldItems[f.epicLinkField.Id]; haveEpic {
+		t.Error("expected the epic to be cleared")
+	}
+	if card.Due == nil || *card.Due != "2021-04-01" {
+		t.Errorf("expected the due date to be updated, got %v", card.Due)
+	}
+	if len(card.Comments) != 3 || len(card.Attachments) != 1 {
+		t.Errorf("expected only the new comment to be added, got %d comments and %d attachments", len(card.Comments), len(card.Attachments))
+	}
+
+	//syncing again with the issue done and archived, then re-opened
+	inProgress := updated.Fields.Status
+	updated.Fields.Status = common.IssueStatus{Name: "Done", StatusCategory: common.StatusCategory{Key: "done"}}
+	err = f.sync(updated)
+	if err != nil {
+		t.Fatalf("SyncIssue failed: %s", err)
+	}
+	if card = f.trello.AssertCard(t, "Something is still broken"); !card.Closed || len(card.Comments) != 3 {
+		t.Errorf("expected the card to be archived without adding comments, got %+v", card)
+	}
+	updated.Fields.Status = inProgress
+	err = f.sync(updated)
+	if err != nil {
+		t.Fatalf("SyncIssue failed: %s", err)
+	}
+	if card = f.trello.AssertCard(t, "Something is still broken"); card.Closed {
+		t.Error("expected the card to be restored")
+	}
+}
+
+func TestSyncIssueChecklist(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+	issue := makeTestIssue()
+	issue.Fields.Subtasks = issueWithSubtasks().Fields.Subtasks
+	if err := f.migrate(issue); err != nil {
+		t.Fatalf("MigrateIssue failed: %s", err)
+	}
+
+	//PROJ-3 is finished and PROJ-5 is added after the card was migrated
+	updated := makeTestIssue()
+	updated.Fields.Subtasks = issueWithSubtasks().Fields.Subtasks
+	updated.Fields.Subtasks[1].Fields.Status = common.IssueStatus{StatusCategory: common.StatusCategory{Key: "done"}}
+	updated.Fields.Subtasks = append(updated.Fields.Subtasks, common.Issue{Key: "PROJ-5", Fields: common.IssueFields{Summary: "Document it"}})
+	if err := f.sync(updated); err != nil {
+		t.Fatalf("SyncIssue failed: %s", err)
+	}
+
+	card := f.trello.AssertCard(t, "Something is broken")
+	checklists := f.trello.Checklists(card.Id)
+	if len(checklists) != 1 {
+		t.Fatalf("expected the existing checklist to be kept, got %+v", checklists)
+	}
+	expected := []common.TrelloCheckItem{
+		{Name: "PROJ-2: Write it", State: common.CheckItemComplete},
+		{Name: "PROJ-3: Test it", State: common.CheckItemComplete},
+		{Name: "PROJ-4: Ship it", State: common.CheckItemIncomplete},
+		{Name: "PROJ-5: Document it", State: common.CheckItemIncomplete},
+	}
+	items := checklists[0].CheckItems
+	if len(items) != len(expected) {
+		t.Fatalf("expected %d checklist items, got %+v", len(expected), items)
+	}
+	for i, item := range items {
+		if item.Name != expected[i].Name || item.State != expected[i].State {
+			t.Errorf("item %d: got '%s' (%s), expected '%s' (%s)", i, item.Name, item.State, expected[i].Name, expected[i].State)
+		}
+	}
+}
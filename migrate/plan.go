@@ -0,0 +1,425 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/*
+PlannedFieldValue is a custom field value that would be set on a card
+*/
+type PlannedFieldValue struct {
+	FieldName string `json:"fieldName"`
+	FieldId   string `json:"fieldId"`
+	Value     string `json:"value"`
+	OptionId  string `json:"optionId,omitempty"` //only set for list-type fields
+}
+
+/*
+PlannedAttachment is an attachment that would be copied from Jira to the card
+*/
+type PlannedAttachment struct {
+	Id       string           `json:"id"`
+	Filename string           `json:"filename"`
+	MimeType string           `json:"mimeType"`
+	Size     int64            `json:"size"`
+	Action   AttachmentAction `json:"action"` //what the attachment policy says to do with it
+}
+
+/*
+PlannedCheckItem is an item that would be added to the sub-tasks checklist, or an existing item that would be ticked
+or un-ticked
+*/
+type PlannedCheckItem struct {
+	Name    string `json:"name"`
+	Checked bool   `json:"checked"`
+	Update  bool   `json:"update,omitempty"` //true if the item is already on the checklist and only its tick would change
+}
+
+/*
+IssuePlan describes everything that MigrateIssue would do for a single issue
+*/
+type IssuePlan struct {
+	JiraKey        string                `json:"jiraKey"`
+	Summary        string                `json:"summary"`
+	ListName       string                `json:"listName"`
+	CreateList     bool                  `json:"createList,omitempty"` //true if the list does not exist yet and would be created
+	Archive        bool                  `json:"archive,omitempty"`
+	ExistingCardId string                `json:"existingCardId,omitempty"` //set if a previous run already created the card
+	Card           *common.NewTrelloCard `json:"card,omitempty"`
+	LabelsToCreate []string              `json:"labelsToCreate,omitempty"`
+	CustomFields   []PlannedFieldValue   `json:"customFields"`
+	Attachments    []PlannedAttachment   `json:"attachments"`
+	ChecklistItems []PlannedCheckItem    `json:"checklistItems,omitempty"`
+	Comments       []string              `json:"comments"`
+	Problems       []string              `json:"problems"` //lookups that would fail and stop the issue migrating
+}
+
+/*
+SkippedIssue is an issue that would not be migrated at all
+*/
+type SkippedIssue struct {
+	JiraKey string `json:"jiraKey"`
+	Reason  string `json:"reason"`
+}
+
+/*
+MigrationPlan is the output of a dry run
+*/
+type MigrationPlan struct {
+	mutex          sync.Mutex
+	Issues         []IssuePlan     `json:"issues"`
+	Skipped        []SkippedIssue  `json:"skipped"`
+	UnmatchedUsers []UnmatchedUser `json:"unmatchedUsers"`
+	Incomplete     string          `json:"incomplete,omitempty"` //why not every issue could be planned, if they couldn't
+}
+
+func NewMigrationPlan() *MigrationPlan {
+	return &MigrationPlan{
+		Issues:  make([]IssuePlan, 0),
+		Skipped: make([]SkippedIssue, 0),
+	}
+}
+
+func (p *MigrationPlan) Skip(jiraKey string, reason string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Skipped = append(p.Skipped, SkippedIssue{JiraKey: jiraKey, Reason: reason})
+}
+
+func (p *MigrationPlan) AddIssue(issuePlan IssuePlan) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Issues = append(p.Issues, issuePlan)
+}
+
+/*
+priorityOptionName returns the text of the option with the given ID on a list-type custom field
+*/
+func priorityOptionName(field *common.TrelloCustomField, optionId string) string {
+	if field.Options == nil {
+		return ""
+	}
+	for _, opt := range *field.Options {
+		if opt.Id == optionId {
+			return opt.Value.Text
+		}
+	}
+	return ""
+}
+
+/*
+PlanIssue works out what MigrateIssue would do for the given issue, without writing anything to Trello.
+Comments are still read from Jira so that they can be included in the plan. An error is returned if the issue has no
+list to go into, in which case it would not be migrated at all.
+*/
+func (m *Migrator) PlanIssue(recPtr *common.Issue) (IssuePlan, error) {
+	targetList, err := m.listFor(recPtr)
+	if err != nil {
+		return IssuePlan{}, err
+	}
+	state := m.State
+	archive := m.Router.ShouldArchive(recPtr)
+
+	plan := IssuePlan{
+		JiraKey:      recPtr.Key,
+		Summary:      recPtr.Fields.Summary,
+		ListName:     targetList.Name,
+		CreateList:   targetList.Id == "",
+		Archive:      archive,
+		CustomFields: make([]PlannedFieldValue, 0),
+		Attachments:  make([]PlannedAttachment, 0),
+		Comments:     make([]string, 0),
+		Problems:     make([]string, 0),
+	}
+
+	if previous, havePrevious := state.Get(recPtr.Key); havePrevious && previous.Steps[common.StepCardCreated] {
+		plan.ExistingCardId = previous.CardId
+	} else {
+		plan.Card = recPtr.ToTrelloCard(targetList.Id, false)
+		plan.Card.Members = m.membersFor(recPtr)
+		plan.Card.LabelIDs, plan.LabelsToCreate, _ = ResolveLabels(LabelsFor(recPtr, m.SprintMode), m.LabelCache, nil, true, state)
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepJiraKey) {
+		plan.CustomFields = append(plan.CustomFields, PlannedFieldValue{
+			FieldName: m.JiraIdField.Name,
+			FieldId:   m.JiraIdField.Id,
+			Value:     recPtr.Key,
+		})
+	}
+
+	for _, a := range recPtr.Fields.Attachment {
+		if !state.IsDone(recPtr.Key, common.AttachmentStep(a.Id)) {
+			plan.Attachments = append(plan.Attachments, PlannedAttachment{
+				Id:       a.Id,
+				Filename: a.Filename,
+				MimeType: a.MimeType,
+				Size:     a.Size,
+				Action:   m.AttachmentPolicy.ActionFor(a.Size),
+			})
+		}
+	}
+
+	if recPtr.Fields.EpicLink != nil && !state.IsDone(recPtr.Key, common.StepEpicLink) {
+		opt, err := LookupEpicOption(recPtr, m.Epics, m.EpicLinkField)
+		if err != nil {
+			plan.Problems = append(plan.Problems, err.Error())
+		} else {
+			plan.CustomFields = append(plan.CustomFields, PlannedFieldValue{
+				FieldName: m.EpicLinkField.Name,
+				FieldId:   m.EpicLinkField.Id,
+				Value:     opt.Value.Text,
+				OptionId:  opt.Id,
+			})
+		}
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepPriority) {
+		optionId, err := recPtr.Fields.Priority.ToTrelloLabel(m.PriorityNames, m.PriorityField.Options)
+		if err != nil {
+			plan.Problems = append(plan.Problems, fmt.Sprintf("could not set up priority: %s", err))
+		} else {
+			plan.CustomFields = append(plan.CustomFields, PlannedFieldValue{
+				FieldName: m.PriorityField.Name,
+				FieldId:   m.PriorityField.Id,
+				Value:     priorityOptionName(m.PriorityField, optionId),
+				OptionId:  optionId,
+			})
+		}
+	}
+
+	if m.SprintField != nil && !state.IsDone(recPtr.Key, common.StepSprint) {
+		if sprint := recPtr.Fields.LatestSprint(); sprint != nil {
+			plannedValue := PlannedFieldValue{
+				FieldName: m.SprintField.Field.Name,
+				FieldId:   m.SprintField.Field.Id,
+				Value:     sprint.Name,
+			}
+			if m.SprintField.Field.Type == common.List {
+				optionId, err := m.SprintField.OptionFor(sprint.Name)
+				if err != nil {
+					plan.Problems = append(plan.Problems, fmt.Sprintf("could not set up sprint: %s", err))
+				}
+				plannedValue.OptionId = optionId
+			}
+			plan.CustomFields = append(plan.CustomFields, plannedValue)
+		}
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepEstimates) {
+		for _, estimate := range m.EstimateFields.ValuesFor(&recPtr.Fields) {
+			plan.CustomFields = append(plan.CustomFields, PlannedFieldValue{
+				FieldName: estimate.Field.Name,
+				FieldId:   estimate.Field.Id,
+				Value:     strconv.FormatFloat(estimate.Value, 'f', -1, 64),
+			})
+		}
+	}
+
+	if !state.IsDone(recPtr.Key, common.StepCustomFields) {
+		values, problems := m.MappedFields.ValuesFor(recPtr)
+		plan.Problems = append(plan.Problems, problems...)
+		for _, value := range values {
+			plannedValue := PlannedFieldValue{
+				FieldName: value.Mapping.Field.Name,
+				FieldId:   value.Mapping.Field.Id,
+				Value:     value.Text,
+			}
+			if value.Mapping.Field.Type == common.List {
+				optionId, err := m.MappedFields.OptionFor(value.Mapping, value.Text)
+				if err != nil {
+					plan.Problems = append(plan.Problems, fmt.Sprintf("could not set up %s: %s", value.Mapping.Field.Name, err))
+				}
+				plannedValue.OptionId = optionId
+			}
+			plan.CustomFields = append(plan.CustomFields, plannedValue)
+		}
+	}
+
+	if m.SubtaskMode == SubtasksAsChecklist {
+		previous, _ := state.Get(recPtr.Key)
+		for _, change := range checklistChanges(recPtr, &previous) {
+			plan.ChecklistItems = append(plan.ChecklistItems, PlannedCheckItem{
+				Name:    checkItemName(change.subtask),
+				Checked: change.checked,
+				Update:  change.itemId != "",
+			})
+		}
+	}
+
+	existingComments, err := m.JiraClient.LoadAllComments(recPtr.Key, 20)
+	if err != nil {
+		plan.Problems = append(plan.Problems, fmt.Sprintf("can't load comments: %s", err))
+	} else {
+		for _, c := range *existingComments {
+			if !state.IsDone(recPtr.Key, common.CommentStep(c.Id)) {
+				plan.Comments = append(plan.Comments, FormatMigratedComment(&c))
+			}
+		}
+	}
+	if !state.IsDone(recPtr.Key, common.StepOriginComment) {
+		plan.Comments = append(plan.Comments, FormatOriginComment(recPtr))
+	}
+	return plan, nil
+}
+
+/*
+WriteJSON outputs the full plan as JSON to the given path, or to stdout if the path is "-"
+*/
+func (p *MigrationPlan) WriteJSON(path string) error {
+	content, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err = os.Stdout.Write(append(content, '\n'))
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(content)
+	if err == nil {
+		log.Printf("INFO Wrote migration plan to '%s'", path)
+	}
+	return err
+}
+
+/*
+sortedCounts formats a map of counts as "name: count" lines, in name order
+*/
+func sortedCounts(counts map[string]int) []string {
+	names := make([]string, 0, len(counts))
+	for n := range counts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = fmt.Sprintf("%s: %d", n, counts[n])
+	}
+	return out
+}
+
+/*
+WriteSummary outputs a human-readable summary of the plan
+*/
+func (p *MigrationPlan) WriteSummary(w io.Writer) {
+	listCounts := make(map[string]int)
+	fieldCounts := make(map[string]int)
+	newCards := 0
+	archived := 0
+	listsToCreate := make(map[string]int)
+	labelsToCreate := make(map[string]int)
+	attachmentCount := 0
+	attachmentBytes := int64(0)
+	oversizeActions := make(map[string]int)
+	commentCount := 0
+	checkItemCount := 0
+	checkItemUpdates := 0
+	problemIssues := make([]IssuePlan, 0)
+
+	for _, i := range p.Issues {
+		listCounts[i.ListName]++
+		if i.CreateList {
+			listsToCreate[i.ListName]++
+		}
+		for _, l := range i.LabelsToCreate {
+			labelsToCreate[l]++
+		}
+		if i.Archive {
+			archived++
+		}
+		if i.Card != nil {
+			newCards++
+		}
+		for _, f := range i.CustomFields {
+			if f.OptionId != "" {
+				fieldCounts[f.FieldName+" = "+f.Value]++
+			}
+		}
+		for _, a := range i.Attachments {
+			attachmentCount++
+			attachmentBytes += a.Size
+			if a.Action != AttachmentUpload {
+				oversizeActions[string(a.Action)]++
+			}
+		}
+		commentCount += len(i.Comments)
+		for _, c := range i.ChecklistItems {
+			if c.Update {
+				checkItemUpdates++
+			} else {
+				checkItemCount++
+			}
+		}
+		if len(i.Problems) > 0 {
+			problemIssues = append(problemIssues, i)
+		}
+	}
+
+	fmt.Fprintf(w, "Migration plan: %d issues to migrate (%d new cards, %d resumed), %d skipped\n", len(p.Issues), newCards, len(p.Issues)-newCards, len(p.Skipped))
+	fmt.Fprintf(w, "%d comments, %d attachments (%d bytes) and %d sub-task checklist items to copy, %d cards to archive\n", commentCount, attachmentCount, attachmentBytes, checkItemCount, archived)
+	if checkItemUpdates > 0 {
+		fmt.Fprintf(w, "%d existing sub-task checklist items to tick or un-tick\n", checkItemUpdates)
+	}
+
+	fmt.Fprintln(w, "\nCards per list:")
+	for _, line := range sortedCounts(listCounts) {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	if len(listsToCreate) > 0 {
+		fmt.Fprintln(w, "\nLists to create:")
+		for _, line := range sortedCounts(listsToCreate) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if len(labelsToCreate) > 0 {
+		fmt.Fprintln(w, "\nLabels to create (with number of cards using them):")
+		for _, line := range sortedCounts(labelsToCreate) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	if len(oversizeActions) > 0 {
+		fmt.Fprintln(w, "\nAttachments too large to upload, by action:")
+		for _, line := range sortedCounts(oversizeActions) {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	fmt.Fprintln(w, "\nCustom field options used:")
+	for _, line := range sortedCounts(fieldCounts) {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+
+	if len(p.Skipped) > 0 {
+		fmt.Fprintln(w, "\nSkipped issues:")
+		for _, s := range p.Skipped {
+			fmt.Fprintf(w, "  %s: %s\n", s.JiraKey, s.Reason)
+		}
+	}
+
+	if len(problemIssues) > 0 {
+		fmt.Fprintf(w, "\n%d issues would fail:\n", len(problemIssues))
+		for _, i := range problemIssues {
+			for _, problem := range i.Problems {
+				fmt.Fprintf(w, "  %s (%s): %s\n", i.JiraKey, i.Summary, problem)
+			}
+		}
+	} else {
+		fmt.Fprintln(w, "\nNo problems found")
+	}
+
+	if p.Incomplete != "" {
+		fmt.Fprintf(w, "\nThe plan is incomplete, not every issue could be loaded from Jira: %s\n", p.Incomplete)
+	}
+}
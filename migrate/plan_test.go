@@ -0,0 +1,219 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fredex42/mm-jira-migration/common"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+fieldNames returns the planned custom field values as "name=value", in order
+*/
+func fieldNames(values []PlannedFieldValue) []string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		out[i] = v.FieldName + "=" + v.Value
+	}
+	return out
+}
+
+func TestPlanIssue(t *testing.T) {
+	tests := []struct {
+		name           string
+		prepare        func(t *testing.T, f *migrationFixture, issue *common.Issue)
+		expectNewCard  bool
+		expectList     string
+		expectFields   []string
+		expectComments int
+		expectProblems int
+	}{
+		{"new card", nil, true, "To Do", []string{"Jira Key=PROJ-1", "Epic=Big Project", "Priority=High"}, 2, 0},
+		{"resumed card", func(t *testing.T, f *migrationFixture, issue *common.Issue) {
+			if err := f.migrate(issue); err != nil {
+				t.Fatal(err)
+			}
+		}, false, "To Do", []string{}, 0, 0},
+		{"unknown epic", func(t *testing.T, f *migrationFixture, issue *common.Issue) {
+			issue.Fields.EpicLink = common.StringPtr("PROJ-999")
+		}, true, "To Do", []string{"Jira Key=PROJ-1", "Priority=High"}, 2, 1},
+		{"list to create", func(t *testing.T, f *migrationFixture, issue *common.Issue) {
+			f.routing.CreateMissing = true
+			issue.Fields.Status = common.IssueStatus{Name: "In Progress"}
+		}, true, "Doing", []string{"Jira Key=PROJ-1", "Epic=Big Project", "Priority=High"}, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newMigrationFixture(t)
+			defer f.Close()
+			issue := makeTestIssue()
+			if test.prepare != nil {
+				test.prepare(t, f, issue)
+			}
+			writesBefore := f.trello.RequestCount("POST", "/") + f.trello.RequestCount("PUT", "/") + f.trello.RequestCount("DELETE", "/")
+
+			m, err := f.migrator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			//load-issues plans with a dry-run router, so that missing lists aren't created
+			listCache, err := f.trello.Client().NewListCache("board1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Router = NewListRouter(f.routing, listCache, true, false, f.trello.Client())
+			plan, err := m.PlanIssue(issue)
+			if err != nil {
+				t.Fatalf("PlanIssue failed: %s", err)
+			}
+
+			if writes := f.trello.RequestCount("POST", "/") + f.trello.RequestCount("PUT", "/") + f.trello.RequestCount("DELETE", "/"); writes != writesBefore {
+				t.Errorf("expected nothing to be written to Trello, got %d requests", writes-writesBefore)
+			}
+			if plan.JiraKey != "PROJ-1" || plan.Summary != "Something is broken" || plan.ListName != test.expectList {
+				t.Errorf("unexpected plan header: %+v", plan)
+			}
+			if plan.CreateList != (test.expectList != "To Do") {
+				t.Errorf("expected createList to be %t", test.expectList != "To Do")
+			}
+			if (plan.Card != nil) != test.expectNewCard || (plan.ExistingCardId == "") != test.expectNewCard {
+				t.Errorf("expected a new card %t, got card %+v and existing card '%s'", test.expectNewCard, plan.Card, plan.ExistingCardId)
+			}
+			if test.expectNewCard && (len(plan.LabelsToCreate) != 1 || plan.LabelsToCreate[0] != "backend") {
+				t.Errorf("expected the 'backend' label to be planned, got %v", plan.LabelsToCreate)
+			}
+			if fields := fieldNames(plan.CustomFields); strings.Join(fields, ",") != strings.Join(test.expectFields, ",") {
+				t.Errorf("got fields %v, expected %v", fields, test.expectFields)
+			}
+			if test.expectNewCard && (len(plan.Attachments) != 1 || plan.Attachments[0].Action != AttachmentUpload) {
+				t.Errorf("expected the attachment to be uploaded, got %+v", plan.Attachments)
+			}
+			if len(plan.Comments) != test.expectComments || len(plan.Problems) != test.expectProblems {
+				t.Errorf("expected %d comments and %d problems, got %q and %q", test.expectComments, test.expectProblems, plan.Comments, plan.Problems)
+			}
+		})
+	}
+}
+
+func TestPlanIssueNoList(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+	issue := makeTestIssue()
+	issue.Fields.Status = common.IssueStatus{Name: "In Progress"} //routed to "Doing", which doesn't exist
+
+	m, err := f.migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.PlanIssue(issue); err == nil {
+		t.Error("expected an error for an issue with no list to go into")
+	}
+}
+
+func TestPlanIssueChecklist(t *testing.T) {
+	f := newMigrationFixture(t)
+	defer f.Close()
+	issue := makeTestIssue()
+	issue.Fields.Subtasks = issueWithSubtasks().Fields.Subtasks
+	if err := f.migrate(issue); err != nil {
+		t.Fatal(err)
+	}
+
+	//one sub-task is re-opened and another is added after the card was migrated
+	issue.Fields.Subtasks[0].Fields.Status = common.IssueStatus{StatusCategory: common.StatusCategory{Key: "indeterminate"}}
+	issue.Fields.Subtasks = append(issue.Fields.Subtasks, common.Issue{Key: "PROJ-5", Fields: common.IssueFields{Summary: "Document it"}})
+	m, err := f.migrator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := m.PlanIssue(issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []PlannedCheckItem{{Name: "PROJ-2: Write it", Checked: false, Update: true}, {Name: "PROJ-5: Document it"}}
+	if len(plan.ChecklistItems) != len(expected) || plan.ChecklistItems[0] != expected[0] || plan.ChecklistItems[1] != expected[1] {
+		t.Fatalf("expected checklist items %+v, got %+v", expected, plan.ChecklistItems)
+	}
+
+	//the migration must do exactly what was planned
+	previous, _ := f.state.Get("PROJ-1")
+	writesBefore := f.trello.RequestCount("POST", "/checklists") + f.trello.RequestCount("PUT", "/cards/"+previous.CardId+"/checkItem")
+	if err = MigrateSubtaskChecklist(issue, previous.CardId, f.state, f.trello.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if writes := f.trello.RequestCount("POST", "/checklists") + f.trello.RequestCount("PUT", "/cards/"+previous.CardId+"/checkItem") - writesBefore; writes != len(expected) {
+		t.Errorf("expected %d checklist changes, got %d", len(expected), writes)
+	}
+	if plan, err = m.PlanIssue(issue); err != nil || len(plan.ChecklistItems) != 0 {
+		t.Errorf("expected nothing left to plan for the checklist, got %+v (%v)", plan.ChecklistItems, err)
+	}
+}
+
+func TestMigrationPlanOutput(t *testing.T) {
+	plan := NewMigrationPlan()
+	plan.AddIssue(IssuePlan{
+		JiraKey:        "PROJ-1",
+		Summary:        "Something is broken",
+		ListName:       "To Do",
+		Card:           &common.NewTrelloCard{Name: "Something is broken"},
+		LabelsToCreate: []string{"backend"},
+		CustomFields:   []PlannedFieldValue{{FieldName: "Priority", Value: "High", OptionId: "opt1"}, {FieldName: "Jira Key", Value: "PROJ-1"}},
+		Attachments:    []PlannedAttachment{{Id: "1", Size: 100, Action: AttachmentUpload}, {Id: "2", Size: 5000, Action: AttachmentLink}},
+		Comments:       []string{"one", "two"},
+		Problems:       []string{},
+	})
+	plan.AddIssue(IssuePlan{
+		JiraKey:        "PROJ-2",
+		Summary:        "Already there",
+		ListName:       "Doing",
+		CreateList:     true,
+		ExistingCardId: "card2",
+		CustomFields:   []PlannedFieldValue{{FieldName: "Priority", Value: "High", OptionId: "opt1"}},
+		ChecklistItems: []PlannedCheckItem{{Name: "PROJ-3: Sub-task"}, {Name: "PROJ-5: Sub-task", Checked: true, Update: true}},
+		Problems:       []string{"no epic"},
+	})
+	plan.Skip("PROJ-4", "done")
+
+	var summary bytes.Buffer
+	plan.WriteSummary(&summary)
+	for _, expected := range []string{
+		"2 issues to migrate (1 new cards, 1 resumed), 1 skipped",
+		"2 comments, 2 attachments (5100 bytes) and 1 sub-task checklist items to copy, 0 cards to archive",
+		"1 existing sub-task checklist items to tick or un-tick",
+		"Lists to create:\n  Doing: 1",
+		"Labels to create (with number of cards using them):\n  backend: 1",
+		"by action:\n  link: 1",
+		"Custom field options used:\n  Priority = High: 2",
+		"PROJ-4: done",
+		"1 issues would fail:\n  PROJ-2 (Already there): no epic",
+	} {
+		if !strings.Contains(summary.String(), expected) {
+			t.Errorf("expected the summary to contain %q, got:\n%s", expected, summary.String())
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "migrationplan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "plan.json")
+	if err = plan.WriteJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloaded MigrationPlan
+	if err = json.Unmarshal(content, &reloaded); err != nil {
+		t.Fatalf("plan was not valid JSON: %s", err)
+	}
+	if len(reloaded.Issues) != 2 || reloaded.Issues[1].ExistingCardId != "card2" || len(reloaded.Skipped) != 1 || reloaded.Skipped[0].JiraKey != "PROJ-4" {
+		t.Errorf("plan was not written correctly: %s", string(content))
+	}
+}
@@ -0,0 +1,258 @@
+package migrate
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type ReportStatus string
+
+const (
+	ReportOk      ReportStatus = "ok"      //everything was migrated
+	ReportPartial ReportStatus = "partial" //the card exists but something was left behind or failed
+	ReportFailed  ReportStatus = "failed"  //no card could be created
+	ReportSkipped ReportStatus = "skipped" //the issue was not migrated on this run
+)
+
+/*
+IssueReport is one row of the migration report
+*/
+type IssueReport struct {
+	JiraKey     string       `json:"jiraKey"`
+	CardId      string       `json:"cardId"`
+	ShortUrl    string       `json:"shortUrl"`
+	RunId       string       `json:"runId"` //the run that created the card
+	ListName    string       `json:"list"`
+	Epic        string       `json:"epic"`
+	Priority    string       `json:"priority"`
+	Comments    int          `json:"comments"`    //number of comments copied to the card, over all runs
+	Attachments int          `json:"attachments"` //number of attachments uploaded, linked or stored, over all runs
+	Skipped     []string     `json:"skipped"`     //things that were not migrated, with the reason
+	Status      ReportStatus `json:"status"`
+	Error       string       `json:"error,omitempty"`
+}
+
+/*
+MigrationReport records the outcome for every issue seen during a run, so that it can be signed off or used to drive
+a follow-up run. It is safe to use from multiple goroutines.
+*/
+type MigrationReport struct {
+	mutex  sync.Mutex
+	Issues []IssueReport `json:"issues"`
+}
+
+func NewMigrationReport() *MigrationReport {
+	return &MigrationReport{Issues: make([]IssueReport, 0)}
+}
+
+/*
+baseReport fills in the parts of a row that come from the migration state
+*/
+func baseReport(jiraKey string, state *common.MigrationState) IssueReport {
+	row := IssueReport{JiraKey: jiraKey, Skipped: make([]string, 0)}
+	previous, havePrevious := state.Get(jiraKey)
+	if !havePrevious {
+		return row
+	}
+	row.CardId = previous.CardId
+	row.ShortUrl = previous.ShortUrl
+	row.RunId = previous.RunId
+	for step, done := range previous.Steps {
+		if !done {
+			continue
+		}
+		if strings.HasPrefix(string(step), string(common.CommentStep(""))) {
+			row.Comments++
+		}
+		if strings.HasPrefix(string(step), string(common.AttachmentStep(""))) {
+			attachmentId := strings.TrimPrefix(string(step), string(common.AttachmentStep("")))
+			if outcome, haveOutcome := previous.Attachments[attachmentId]; haveOutcome && outcome.Action == string(AttachmentSkip) {
+				continue
+			}
+			row.Attachments++
+		}
+	}
+	attachmentIds := make([]string, 0, len(previous.Attachments))
+	for id := range previous.Attachments {
+		attachmentIds = append(attachmentIds, id)
+	}
+	sort.Strings(attachmentIds)
+	for _, id := range attachmentIds {
+		outcome := previous.Attachments[id]
+		what := "left in Jira"
+		switch AttachmentAction(outcome.Action) {
+		case AttachmentLink:
+			what = "linked to " + outcome.Url
+		case AttachmentStore:
+			what = "stored at " + outcome.Url
+		}
+		row.Skipped = append(row.Skipped, fmt.Sprintf("attachment %s (%s) too large to upload, %s", outcome.Filename, FormatSize(outcome.Size), what))
+	}
+	return row
+}
+
+/*
+AddIssue records the outcome of migrating an issue, using the migration state to find out what was done.
+migrationErr is the error that stopped the issue, if any.
+*/
+func (r *MigrationReport) AddIssue(recPtr *common.Issue, listName string, epics *EpicsCache, priorityNames map[string]string, state *common.MigrationState, migrationErr error) {
+	row := baseReport(recPtr.Key, state)
+	row.ListName = listName
+	if recPtr.Fields.EpicLink != nil && state.IsDone(recPtr.Key, common.StepEpicLink) {
+		row.Epic = epics.KnownEpics[*recPtr.Fields.EpicLink]
+	}
+	if state.IsDone(recPtr.Key, common.StepPriority) {
+		row.Priority = recPtr.Fields.Priority.OptionName(priorityNames)
+	}
+
+	switch {
+	case migrationErr != nil && row.CardId == "":
+		row.Status = ReportFailed
+	case migrationErr != nil || len(row.Skipped) > 0:
+		row.Status = ReportPartial
+	default:
+		row.Status = ReportOk
+	}
+	if migrationErr != nil {
+		row.Error = migrationErr.Error()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Issues = append(r.Issues, row)
+}
+
+/*
+Skip records that an issue was not migrated on this run, and why. If it was migrated on a previous run then the card
+is still included.
+*/
+func (r *MigrationReport) Skip(jiraKey string, reason string, state *common.MigrationState) {
+	row := baseReport(jiraKey, state)
+	row.Status = ReportSkipped
+	row.Skipped = append(row.Skipped, reason)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Issues = append(r.Issues, row)
+}
+
+/*
+MarkPartial downgrades an issue that was otherwise migrated, e.g. when its sub-task card could not be linked to its
+parent after the main run
+*/
+func (r *MigrationReport) MarkPartial(jiraKey string, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i := range r.Issues {
+		if r.Issues[i].JiraKey == jiraKey {
+			r.Issues[i].Skipped = append(r.Issues[i].Skipped, reason)
+			if r.Issues[i].Status == ReportOk {
+				r.Issues[i].Status = ReportPartial
+			}
+		}
+	}
+}
+
+/*
+sortedIssues returns the rows in Jira key order. The caller must hold the mutex.
+*/
+func (r *MigrationReport) sortedIssues() []IssueReport {
+	sorted := make([]IssueReport, len(r.Issues))
+	copy(sorted, r.Issues)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].JiraKey < sorted[j].JiraKey
+	})
+	return sorted
+}
+
+/*
+Counts returns the number of issues with each status
+*/
+func (r *MigrationReport) Counts() map[ReportStatus]int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	counts := make(map[ReportStatus]int)
+	for _, row := range r.Issues {
+		counts[row.Status]++
+	}
+	return counts
+}
+
+/*
+WriteJSON outputs the report as a JSON document
+*/
+func (r *MigrationReport) WriteJSON(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	content, err := json.MarshalIndent(map[string]interface{}{"issues": r.sortedIssues()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+var reportCsvHeader = []string{"jiraKey", "cardId", "shortUrl", "list", "epic", "priority", "comments", "attachments", "status", "skipped", "error", "runId"}
+
+/*
+WriteCSV outputs the report as CSV with a header row. Skipped items are joined with "; ".
+*/
+func (r *MigrationReport) WriteCSV(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	writer := csv.NewWriter(w)
+	err := writer.Write(reportCsvHeader)
+	if err != nil {
+		return err
+	}
+	for _, row := range r.sortedIssues() {
+		err = writer.Write([]string{
+			row.JiraKey,
+			row.CardId,
+			row.ShortUrl,
+			row.ListName,
+			row.Epic,
+			row.Priority,
+			strconv.Itoa(row.Comments),
+			strconv.Itoa(row.Attachments),
+			string(row.Status),
+			strings.Join(row.Skipped, "; "),
+			row.Error,
+			row.RunId,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+/*
+WriteFile writes the report to the given path, as CSV if the path ends in .csv and JSON otherwise
+*/
+func (r *MigrationReport) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		err = r.WriteCSV(f)
+	} else {
+		err = r.WriteJSON(f)
+	}
+	if err == nil {
+		log.Printf("INFO Wrote migration report to '%s'", path)
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"sync"
+)
+
+type SprintMode string
+
+const (
+	SprintsIgnore  SprintMode = "none"   //sprints are only shown in the card description
+	SprintsAsLabel SprintMode = "labels" //the active or future sprint becomes a label on the card
+	SprintsAsList  SprintMode = "lists"  //cards in an active sprint go into a list named after it
+	SprintsAsField SprintMode = "field"  //the latest sprint goes into a text or list custom field
+)
+
+func ParseSprintMode(spec string) (SprintMode, error) {
+	switch SprintMode(spec) {
+	case SprintsIgnore, SprintsAsLabel, SprintsAsList, SprintsAsField:
+		return SprintMode(spec), nil
+	default:
+		return "", errors.New(fmt.Sprintf("'%s' is not a valid sprint mode, expected none, labels, lists or field", spec))
+	}
+}
+
+/*
+LabelsFor returns the names of the labels that the issue's card should have, which is its Jira labels plus the
+current sprint if sprints are being mapped to labels
+*/
+func LabelsFor(recPtr *common.Issue, sprintMode SprintMode) []string {
+	if sprintMode != SprintsAsLabel {
+		return recPtr.Fields.Labels
+	}
+	sprint := recPtr.Fields.CurrentSprint()
+	if sprint == nil {
+		return recPtr.Fields.Labels
+	}
+	labels := make([]string, 0, len(recPtr.Fields.Labels)+1)
+	for _, l := range recPtr.Fields.Labels {
+		if l == sprint.Name {
+			return recPtr.Fields.Labels
+		}
+		labels = append(labels, l)
+	}
+	return append(labels, sprint.Name)
+}
+
+/*
+SprintField sets the sprint custom field on cards. For a list-type field, an option is added for each sprint the
+first time it is needed, in the same way that SetupEpicsField adds options for epics.
+*/
+type SprintField struct {
+	Field  common.TrelloCustomField
+	client *trello.Client
+	state  *common.MigrationState //options that are added get recorded against the current run
+	dryRun bool
+	lock   sync.Mutex //stops two workers adding the same option at once
+}
+
+/*
+NewSprintField finds the named custom field in the cache, creating it as a list field on the board if it does not
+exist. On a dry run nothing is created and the returned field has no ID.
+*/
+func NewSprintField(boardId string, fieldName string, customFieldCache *trello.CustomFieldCache, dryRun bool, trelloClient *trello.Client, state *common.MigrationState) (*SprintField, error) {
+	field, err := ensureCustomField(boardId, fieldName, []common.CustomFieldType{common.List, common.Text}, customFieldCache, dryRun, trelloClient)
+	if err != nil {
+		return nil, err
+	}
+	return &SprintField{Field: *field, client: trelloClient, state: state, dryRun: dryRun}, nil
+}
+
+/*
+OptionFor returns the ID of the option for the given sprint name, adding it to the field if necessary. On a dry run
+an empty ID is returned for options that would be added.
+*/
+func (f *SprintField) OptionFor(sprintName string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.Field.Options != nil {
+		for _, opt := range *f.Field.Options {
+			if opt.Value.Text == sprintName {
+				return opt.Id, nil
+			}
+		}
+	} else {
+		f.Field.Options = &[]common.TrelloCustomFieldOption{}
+	}
+	if f.dryRun {
+		return "", nil
+	}
+
+	log.Printf("INFO Adding option '%s' to sprint field '%s'", sprintName, f.Field.Name)
+	created, err := f.client.CreateCustomFieldOption(f.Field.Id, &common.TrelloCustomFieldOption{
+		CustomFieldId: f.Field.Id,
+		Value:         common.TrelloCustomFieldOptionValue{Text: sprintName},
+		Pos:           int64(len(*f.Field.Options)+1) * 10,
+	})
+	if err != nil {
+		return "", err
+	}
+	*f.Field.Options = append(*f.Field.Options, *created)
+	if err = f.state.RecordCreatedOption(f.Field.Id, created); err != nil {
+		return "", errors.New(fmt.Sprintf("could not record option '%s' in the migration state: %s", sprintName, err))
+	}
+	return created.Id, nil
+}
+
+/*
+SetOnCard puts the name of the sprint into the field on the given card
+*/
+func (f *SprintField) SetOnCard(cardId string, sprint *common.SprintLink) error {
+	if f.Field.Type == common.Text {
+		return f.client.SetCustomFieldText(cardId, f.Field.Id, sprint.Name)
+	}
+	optionId, err := f.OptionFor(sprint.Name)
+	if err != nil {
+		return err
+	}
+	return f.client.SetCustomFieldValue(cardId, f.Field.Id, optionId)
+}
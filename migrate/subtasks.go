@@ -0,0 +1,176 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+)
+
+type SubtaskMode string
+
+const (
+	SubtasksAsChecklist SubtaskMode = "checklist" //sub-tasks become items on a checklist on the parent's card
+	SubtasksAsCards     SubtaskMode = "cards"     //sub-tasks get their own cards, linked to the parent's card
+)
+
+const SubtaskChecklistName = "Sub-tasks"
+
+func ParseSubtaskMode(spec string) (SubtaskMode, error) {
+	switch SubtaskMode(spec) {
+	case SubtasksAsChecklist, SubtasksAsCards:
+		return SubtaskMode(spec), nil
+	default:
+		return "", errors.New(fmt.Sprintf("'%s' is not a valid sub-task mode, expected checklist or cards", spec))
+	}
+}
+
+/*
+IsSubtask returns true if the issue is a sub-task of another issue
+*/
+func IsSubtask(issue *common.Issue) bool {
+	return issue.Fields.IssueType.SubTask && issue.Fields.Parent != nil
+}
+
+/*
+checkItemName returns the text of the checklist item for a sub-task
+*/
+func checkItemName(subtask *common.Issue) string {
+	return fmt.Sprintf("%s: %s", subtask.Key, subtask.Fields.Summary)
+}
+
+/*
+checkItemChange is an item that needs adding to the sub-tasks checklist, or an existing item whose tick is out of date
+*/
+type checkItemChange struct {
+	subtask *common.Issue
+	itemId  string //the existing item, or empty if one needs adding
+	checked bool
+}
+
+/*
+checklistChanges works out what needs doing to bring the sub-tasks checklist on an issue's card up to date: adding
+items for sub-tasks that aren't on it yet, and ticking or un-ticking items for sub-tasks that have been done or
+re-opened since. Items added before their IDs were recorded in the state can't be updated, so they are left alone.
+*/
+func checklistChanges(recPtr *common.Issue, previous *common.IssueMigrationState) []checkItemChange {
+	changes := make([]checkItemChange, 0)
+	for i := range recPtr.Fields.Subtasks {
+		subtask := &recPtr.Fields.Subtasks[i]
+		checked := subtask.Fields.Status.IsDone()
+		if item, haveItem := previous.CheckItems[subtask.Key]; haveItem {
+			if item.Checked != checked {
+				changes = append(changes, checkItemChange{subtask: subtask, itemId: item.Id, checked: checked})
+			}
+		} else if !previous.Steps[common.CheckItemStep(subtask.Key)] {
+			changes = append(changes, checkItemChange{subtask: subtask, checked: checked})
+		}
+	}
+	return changes
+}
+
+/*
+MigrateSubtaskChecklist adds a "Sub-tasks" checklist to the card, with one item for each of the issue's sub-tasks.
+Items are ticked if the sub-task is done. The checklist and each item are recorded in the migration state, so a
+re-run carries on with the same checklist rather than adding a second one, adds items for any new sub-tasks and
+ticks or un-ticks the items of sub-tasks that have been done or re-opened since.
+*/
+func MigrateSubtaskChecklist(recPtr *common.Issue, cardId string, state *common.MigrationState, trelloClient *trello.Client) error {
+	previous, _ := state.Get(recPtr.Key)
+	changes := checklistChanges(recPtr, &previous)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	checklistId := previous.ChecklistId
+	if checklistId == "" {
+		checklist, err := trelloClient.CreateChecklist(cardId, SubtaskChecklistName)
+		if err != nil {
+			return err
+		}
+		checklistId = checklist.Id
+		if err = state.RecordChecklist(recPtr.Key, checklistId); err != nil {
+			return err
+		}
+	}
+
+	added := 0
+	for _, change := range changes {
+		itemId := change.itemId
+		if itemId == "" {
+			item, err := trelloClient.AddCheckItem(checklistId, checkItemName(change.subtask), change.checked)
+			if err != nil {
+				return err
+			}
+			itemId = item.Id
+			added++
+		} else {
+			itemState := common.CheckItemIncomplete
+			if change.checked {
+				itemState = common.CheckItemComplete
+			}
+			if err := trelloClient.SetCheckItemState(cardId, itemId, itemState); err != nil {
+				return err
+			}
+		}
+		err := state.RecordCheckItem(recPtr.Key, change.subtask.Key, common.MigratedCheckItem{Id: itemId, Checked: change.checked})
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("INFO Added %d sub-tasks to the checklist on %s and updated %d", added, recPtr.Key, len(changes)-added)
+	return state.MarkDone(recPtr.Key, common.StepChecklist)
+}
+
+/*
+SubtaskLink records a sub-task that was migrated as a separate card, so that it can be linked to its parent once
+both cards exist
+*/
+type SubtaskLink struct {
+	SubtaskKey string
+	ParentKey  string
+}
+
+/*
+LinkSubtaskCards attaches each sub-task's card to its parent's card and vice versa. This is done once all issues have
+been migrated, as a sub-task can come out of Jira before its parent. Returns the keys of sub-tasks that could not
+be linked.
+*/
+func LinkSubtaskCards(links []SubtaskLink, state *common.MigrationState, trelloClient *trello.Client) []string {
+	failed := make([]string, 0)
+
+	for _, link := range links {
+		if state.IsDone(link.SubtaskKey, common.StepParentLink) {
+			continue
+		}
+		child, haveChild := state.Get(link.SubtaskKey)
+		parent, haveParent := state.Get(link.ParentKey)
+		if !haveChild || !child.Steps[common.StepCardCreated] || !haveParent || !parent.Steps[common.StepCardCreated] {
+			log.Printf("WARNING Can't link sub-task %s to %s as one of them has no card", link.SubtaskKey, link.ParentKey)
+			failed = append(failed, link.SubtaskKey)
+			continue
+		}
+
+		//each direction is recorded separately so that a retry doesn't attach the same link twice. The parent's
+		//goes first, so that StepParentLink still means both are done as it did before they were split up
+		var err error
+		if !child.Steps[common.StepParentBacklink] {
+			err = trelloClient.AttachUrl(parent.CardId, child.ShortUrl, "Sub-task: "+link.SubtaskKey)
+			if err == nil {
+				err = state.MarkDone(link.SubtaskKey, common.StepParentBacklink)
+			}
+		}
+		if err == nil {
+			err = trelloClient.AttachUrl(child.CardId, parent.ShortUrl, "Parent: "+link.ParentKey)
+		}
+		if err == nil {
+			err = state.MarkDone(link.SubtaskKey, common.StepParentLink)
+		}
+		if err != nil {
+			log.Printf("ERROR Could not link sub-task %s to %s: %s", link.SubtaskKey, link.ParentKey, err)
+			failed = append(failed, link.SubtaskKey)
+		}
+	}
+	return failed
+}
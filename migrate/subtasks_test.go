@@ -0,0 +1,268 @@
+package migrate
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSubtaskMode(t *testing.T) {
+	tests := []struct {
+		spec      string
+		expected  SubtaskMode
+		expectErr bool
+	}{
+		{"checklist", SubtasksAsChecklist, false},
+		{"cards", SubtasksAsCards, false},
+		{"", "", true},
+		{"Cards", "", true},
+		{"links", "", true},
+	}
+	for _, test := range tests {
+		mode, err := ParseSubtaskMode(test.spec)
+		if (err != nil) != test.expectErr || mode != test.expected {
+			t.Errorf("'%s': got '%s' and error %v, expected '%s' and error %t", test.spec, mode, err, test.expected, test.expectErr)
+		}
+	}
+}
+
+func TestIsSubtask(t *testing.T) {
+	parent := &common.Issue{Key: "PROJ-1"}
+	tests := []struct {
+		name     string
+		subTask  bool
+		parent   *common.Issue
+		expected bool
+	}{
+		{"sub-task with a parent", true, parent, true},
+		{"sub-task type without a parent", true, nil, false},
+		{"story under an epic", false, parent, false},
+		{"standalone issue", false, nil, false},
+	}
+	for _, test := range tests {
+		issue := &common.Issue{Key: "PROJ-2", Fields: common.IssueFields{IssueType: common.IssueType{SubTask: test.subTask}, Parent: test.parent}}
+		if result := IsSubtask(issue); result != test.expected {
+			t.Errorf("%s: got %t, expected %t", test.name, result, test.expected)
+		}
+	}
+}
+
+/*
+newSubtaskFixture returns a fake board with a card on it for each of the given keys, and an empty migration state
+*/
+func newSubtaskFixture(t *testing.T, keys ...string) (*trellotest.Server, *common.MigrationState, map[string]*common.TrelloCard, func()) {
+	server := trellotest.NewServer()
+	list := server.AddList("board1", "To Do")
+	dir, err := ioutil.TempDir("", "subtasks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		server.Close()
+		os.RemoveAll(dir)
+	}
+	state, err := common.LoadMigrationState(filepath.Join(dir, "state.json"), common.JiraToTrello)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	cards := make(map[string]*common.TrelloCard, len(keys))
+	for _, key := range keys {
+		card, err := server.Client().PutTrelloCard(&common.NewTrelloCard{ListId: list.Id, Name: key})
+		if err != nil {
+			cleanup()
+			t.Fatal(err)
+		}
+		cards[key] = card
+	}
+	return server, state, cards, cleanup
+}
+
+func issueWithSubtasks() *common.Issue {
+	subtask := func(key string, summary string, categoryKey string) common.Issue {
+		return common.Issue{Key: key, Fields: common.IssueFields{Summary: summary, Status: common.IssueStatus{StatusCategory: common.StatusCategory{Key: categoryKey}}}}
+	}
+	return &common.Issue{
+		Key: "PROJ-1",
+		Fields: common.IssueFields{Subtasks: []common.Issue{
+			subtask("PROJ-2", "Write it", "done"),
+			subtask("PROJ-3", "Test it", "indeterminate"),
+			subtask("PROJ-4", "Ship it", "new"),
+		}},
+	}
+}
+
+/*
+earlierItem is a checklist item that an earlier run added for a sub-task
+*/
+type earlierItem struct {
+	subtaskKey string
+	checked    bool
+	recordId   bool //false for items added before their IDs were recorded
+}
+
+/*
+earlierChecklist sets up the checklist and state that an earlier run would have left behind with the given items,
+returning the checklist's ID
+*/
+func earlierChecklist(t *testing.T, server *trellotest.Server, state *common.MigrationState, cardId string, items ...earlierItem) string {
+	checklist, err := server.Client().CreateChecklist(cardId, SubtaskChecklistName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.RecordChecklist("PROJ-1", checklist.Id)
+	for _, i := range items {
+		item, err := server.Client().AddCheckItem(checklist.Id, i.subtaskKey, i.checked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i.recordId {
+			state.RecordCheckItem("PROJ-1", i.subtaskKey, common.MigratedCheckItem{Id: item.Id, Checked: i.checked})
+		} else {
+			state.MarkDone("PROJ-1", common.CheckItemStep(i.subtaskKey))
+		}
+	}
+	return checklist.Id
+}
+
+func TestMigrateSubtaskChecklist(t *testing.T) {
+	complete := common.CheckItemComplete
+	incomplete := common.CheckItemIncomplete
+	tests := []struct {
+		name         string
+		earlierItems []earlierItem //nil if there was no earlier run
+		expectStates []common.CheckItemState
+		expectWrites int
+	}{
+		{"fresh run", nil, []common.CheckItemState{complete, incomplete, incomplete}, 4},
+		{"resume after the checklist was created", []earlierItem{}, []common.CheckItemState{complete, incomplete, incomplete}, 3},
+		{"new sub-tasks since", []earlierItem{{"PROJ-2", true, true}}, []common.CheckItemState{complete, incomplete, incomplete}, 2},
+		{"up to date", []earlierItem{{"PROJ-2", true, true}, {"PROJ-3", false, true}, {"PROJ-4", false, true}}, []common.CheckItemState{complete, incomplete, incomplete}, 0},
+		{"done and re-opened since", []earlierItem{{"PROJ-2", false, true}, {"PROJ-3", true, true}, {"PROJ-4", false, true}}, []common.CheckItemState{complete, incomplete, incomplete}, 2},
+		{"items without recorded IDs", []earlierItem{{"PROJ-2", false, false}, {"PROJ-3", true, false}}, []common.CheckItemState{incomplete, complete, incomplete}, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, state, cards, cleanup := newSubtaskFixture(t, "PROJ-1")
+			defer cleanup()
+			cardId := cards["PROJ-1"].Id
+			earlierId := ""
+			if test.earlierItems != nil {
+				earlierId = earlierChecklist(t, server, state, cardId, test.earlierItems...)
+			}
+			writesBefore := server.RequestCount("POST", "/") + server.RequestCount("PUT", "/")
+
+			if err := MigrateSubtaskChecklist(issueWithSubtasks(), cardId, state, server.Client()); err != nil {
+				t.Fatal(err)
+			}
+			if writes := server.RequestCount("POST", "/") + server.RequestCount("PUT", "/") - writesBefore; writes != test.expectWrites {
+				t.Errorf("expected %d requests to change the checklist, got %d", test.expectWrites, writes)
+			}
+			checklists := server.Checklists(cardId)
+			if len(checklists) != 1 {
+				t.Fatalf("expected a single checklist, got %+v", checklists)
+			}
+			checklist := checklists[0]
+			if earlierId != "" && checklist.Id != earlierId {
+				t.Errorf("expected the checklist from the earlier run to be reused")
+			}
+			if checklist.Name != SubtaskChecklistName || len(checklist.CheckItems) != len(test.expectStates) {
+				t.Fatalf("expected a '%s' checklist with %d items, got %+v", SubtaskChecklistName, len(test.expectStates), checklist)
+			}
+			for i, item := range checklist.CheckItems {
+				if item.State != test.expectStates[i] {
+					t.Errorf("item %d (%s): got %s, expected %s", i, item.Name, item.State, test.expectStates[i])
+				}
+			}
+			if test.earlierItems == nil && checklist.CheckItems[1].Name != "PROJ-3: Test it" {
+				t.Errorf("expected items to be named after their sub-tasks, got '%s'", checklist.CheckItems[1].Name)
+			}
+
+			//everything is recorded, so running again changes nothing
+			writesBefore = server.RequestCount("POST", "/") + server.RequestCount("PUT", "/")
+			if err := MigrateSubtaskChecklist(issueWithSubtasks(), cardId, state, server.Client()); err != nil {
+				t.Fatal(err)
+			}
+			if writes := server.RequestCount("POST", "/") + server.RequestCount("PUT", "/") - writesBefore; writes != 0 {
+				t.Errorf("expected a second run to change nothing, got %d requests", writes)
+			}
+		})
+	}
+}
+
+func TestMigrateSubtaskChecklistNoSubtasks(t *testing.T) {
+	server, state, cards, cleanup := newSubtaskFixture(t, "PROJ-1")
+	defer cleanup()
+	if err := MigrateSubtaskChecklist(&common.Issue{Key: "PROJ-1"}, cards["PROJ-1"].Id, state, server.Client()); err != nil {
+		t.Fatal(err)
+	}
+	if checklists := server.Checklists(cards["PROJ-1"].Id); len(checklists) != 0 {
+		t.Errorf("expected no checklist for an issue without sub-tasks, got %+v", checklists)
+	}
+}
+
+func TestLinkSubtaskCards(t *testing.T) {
+	server, state, cards, cleanup := newSubtaskFixture(t, "PROJ-1", "PROJ-2")
+	defer cleanup()
+	state.RecordCard("PROJ-1", cards["PROJ-1"])
+	state.RecordCard("PROJ-2", cards["PROJ-2"])
+	links := []SubtaskLink{
+		{SubtaskKey: "PROJ-2", ParentKey: "PROJ-1"},
+		{SubtaskKey: "PROJ-3", ParentKey: "PROJ-1"}, //never got a card
+	}
+
+	failed := LinkSubtaskCards(links, state, server.Client())
+	if len(failed) != 1 || failed[0] != "PROJ-3" {
+		t.Errorf("expected only PROJ-3 to fail, got %v", failed)
+	}
+	child := server.AssertAttachment(t, "PROJ-2", "Parent: PROJ-1")
+	if child.Url != cards["PROJ-1"].ShortUrl {
+		t.Errorf("expected the sub-task's card to link to %s, got %s", cards["PROJ-1"].ShortUrl, child.Url)
+	}
+	parent := server.AssertAttachment(t, "PROJ-1", "Sub-task: PROJ-2")
+	if parent.Url != cards["PROJ-2"].ShortUrl {
+		t.Errorf("expected the parent's card to link to %s, got %s", cards["PROJ-2"].ShortUrl, parent.Url)
+	}
+
+	//a re-run must not link the same cards twice
+	before := server.RequestCount("POST", "/cards")
+	LinkSubtaskCards(links[:1], state, server.Client())
+	if after := server.RequestCount("POST", "/cards"); after != before {
+		t.Errorf("expected no more attachments on a re-run, got %d requests", after-before)
+	}
+}
+
+func TestLinkSubtaskCardsRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		failCard string //the card whose link fails on the first run
+	}{
+		{"parent link fails", "PROJ-1"},
+		{"sub-task link fails", "PROJ-2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, state, cards, cleanup := newSubtaskFixture(t, "PROJ-1", "PROJ-2")
+			defer cleanup()
+			state.RecordCard("PROJ-1", cards["PROJ-1"])
+			state.RecordCard("PROJ-2", cards["PROJ-2"])
+			links := []SubtaskLink{{SubtaskKey: "PROJ-2", ParentKey: "PROJ-1"}}
+
+			server.FailNext("POST", "/cards/"+cards[test.failCard].Id+"/attachments", 500)
+			if failed := LinkSubtaskCards(links, state, server.Client()); len(failed) != 1 {
+				t.Fatalf("expected the first run to fail, got %v", failed)
+			}
+			if failed := LinkSubtaskCards(links, state, server.Client()); len(failed) != 0 {
+				t.Fatalf("expected the retry to succeed, got %v", failed)
+			}
+			for key, expected := range map[string]string{"PROJ-1": "Sub-task: PROJ-2", "PROJ-2": "Parent: PROJ-1"} {
+				if found, _ := server.CardByName(key); len(found.Attachments) != 1 || found.Attachments[0].Name != expected {
+					t.Errorf("expected %s to have a single '%s' link, got %+v", key, expected, found.Attachments)
+				}
+			}
+		})
+	}
+}
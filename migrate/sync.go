@@ -0,0 +1,209 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JQL dates only go down to the minute, so the checkpoint is moved back by this much to be sure nothing is missed
+const syncOverlap = time.Minute
+
+var orderByPattern = regexp.MustCompile(`(?i)\bORDER\s+BY\b`)
+
+/*
+orderByIndex returns where the ORDER BY clause of a JQL query starts, or -1 if it has none. Quoted values are
+ignored, so that e.g. summary ~ "order by" is not taken for one.
+*/
+func orderByIndex(jql string) int {
+	//blank out everything in quotes, keeping the length the same so that indexes still match
+	masked := []byte(jql)
+	var quote byte
+	for i := 0; i < len(masked); i++ {
+		c := masked[i]
+		switch {
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote != 0 && c == '\\' && i+1 < len(masked):
+			masked[i] = ' '
+			i++
+			masked[i] = ' '
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			masked[i] = ' '
+		}
+	}
+	match := orderByPattern.FindIndex(masked)
+	if match == nil {
+		return -1
+	}
+	return match[0]
+}
+
+/*
+SyncJql returns the JQL query for issues matching baseJql that were updated since the given time. JQL dates are
+interpreted in the timezone of the Jira account, so `since` is converted to jiraZone first. Any ORDER BY clause in
+baseJql is kept at the end.
+*/
+func SyncJql(baseJql string, since time.Time, jiraZone *time.Location) string {
+	orderBy := ""
+	if idx := orderByIndex(baseJql); idx >= 0 {
+		orderBy = " " + strings.TrimSpace(baseJql[idx:])
+		baseJql = baseJql[:idx]
+	}
+	condition := fmt.Sprintf(`updated >= "%s"`, since.Add(-syncOverlap).In(jiraZone).Format("2006/01/02 15:04"))
+	if strings.TrimSpace(baseJql) == "" {
+		return condition + orderBy
+	}
+	return fmt.Sprintf("(%s) AND %s%s", strings.TrimSpace(baseJql), condition, orderBy)
+}
+
+/*
+KeysJql returns the JQL query for the issues with the given keys
+*/
+func KeysJql(keys []string) string {
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = `"` + k + `"`
+	}
+	return fmt.Sprintf("key in (%s)", strings.Join(quoted, ", "))
+}
+
+/*
+ParseSince reads the value of the -since flag, which is either an RFC3339 timestamp or a date. A date is taken to be
+midnight in jiraZone, the timezone that the sync query is built in, so that it means the same as it would in Jira.
+*/
+func ParseSince(spec string, jiraZone *time.Location) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, spec); err == nil {
+		return parsed, nil
+	}
+	parsed, err := time.ParseInLocation("2006-01-02", spec, jiraZone)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("'%s' is not an RFC3339 timestamp or a yyyy-mm-dd date", spec))
+	}
+	return parsed, nil
+}
+
+/*
+SyncIssue brings an issue's existing card up to date with Jira, rather than creating a new one. The card's title,
+description, due date and list are overwritten, the epic, priority, sprint, estimate and mapped custom fields are set
+again (or cleared if the issue no longer has a value), and any comments and attachments that have been added in Jira
+since the last run are copied over. Sprint labels are not changed. With SubtasksAsChecklist, items are added to the
+sub-tasks checklist for new sub-tasks and ticked or un-ticked for sub-tasks that have been done or re-opened.
+If the router says the card should be archived, or that the issue would now be skipped (e.g. because it is done), the
+card is archived and left in its current list. Otherwise a card that was archived by an earlier run is restored, e.g.
+because the issue was re-opened.
+Comments that were edited in Jira after they were copied are not updated.
+*/
+func (m *Migrator) SyncIssue(recPtr *common.Issue) error {
+	state := m.State
+	trelloClient := m.TrelloClient
+	previous, havePrevious := state.Get(recPtr.Key)
+	if !havePrevious || previous.CardId == "" {
+		return errors.New(fmt.Sprintf("%s has no card to sync", recPtr.Key))
+	}
+
+	var targetList common.TrelloList
+	var err error
+	archive := true
+	if m.Router.ShouldMigrate(recPtr) {
+		if targetList, err = m.listFor(recPtr); err != nil {
+			return err
+		}
+		archive = m.Router.ShouldArchive(recPtr)
+	}
+
+	cardId := previous.CardId
+	log.Printf("INFO Syncing %s to card %s", recPtr.Key, previous.ShortUrl)
+
+	_, err = trelloClient.UpdateCard(cardId, recPtr.ToTrelloCard(targetList.Id, false))
+	if err != nil {
+		log.Printf("ERROR Could not update card '%s' for %s: %s", cardId, recPtr.Key, err)
+		return errors.New("can't sync issue")
+	}
+
+	if recPtr.Fields.EpicLink != nil {
+		err = MakeEpicLink(recPtr, cardId, m.Epics, m.EpicLinkField, trelloClient)
+		if err != nil {
+			return errors.New("can't sync issue")
+		}
+		if err = state.MarkDone(recPtr.Key, common.StepEpicLink); err != nil {
+			return err
+		}
+	} else if previous.Steps[common.StepEpicLink] {
+		log.Printf("INFO Issue '%s' is no longer in an epic, clearing %s", recPtr.Key, m.EpicLinkField.Name)
+		err = trelloClient.ClearCustomField(cardId, m.EpicLinkField.Id)
+		if err != nil {
+			log.Printf("ERROR Could not clear the epic on card '%s': %s", cardId, err)
+			return errors.New("can't sync issue")
+		}
+		if err = state.MarkUndone(recPtr.Key, common.StepEpicLink); err != nil {
+			return err
+		}
+	}
+
+	optionId, err := recPtr.Fields.Priority.ToTrelloLabel(m.PriorityNames, m.PriorityField.Options)
+	if err != nil {
+		log.Printf("ERROR Could not set up priority for '%s': '%s", recPtr.Fields.Summary, err)
+		return errors.New("can't sync issue")
+	}
+	err = trelloClient.SetCustomFieldValue(cardId, m.PriorityField.Id, optionId)
+	if err != nil {
+		log.Printf("ERROR Could not set up priority field for '%s': %s", recPtr.Fields.Summary, err)
+		return errors.New("can't sync issue")
+	}
+
+	if m.SprintField != nil {
+		if err = setSprintField(recPtr, cardId, m.SprintField, true, trelloClient); err != nil {
+			return errors.New("can't sync issue")
+		}
+	}
+	if err = setEstimateFields(recPtr, cardId, m.EstimateFields, true, trelloClient); err != nil {
+		return errors.New("can't sync issue")
+	}
+	if err = setMappedFields(recPtr, cardId, m.MappedFields, true, trelloClient); err != nil {
+		return errors.New("can't sync issue")
+	}
+
+	err = HandleAttachments(recPtr.Key, &recPtr.Fields.Attachment, cardId, m.AttachmentPolicy, m.JiraClient, trelloClient, state)
+	if err != nil {
+		log.Printf("ERROR Could not sync attachments for '%s': %s", recPtr.Fields.Summary, err)
+		return errors.New("can't sync issue")
+	}
+
+	if m.SubtaskMode == SubtasksAsChecklist {
+		err = MigrateSubtaskChecklist(recPtr, cardId, state, trelloClient)
+		if err != nil {
+			log.Printf("ERROR Could not sync sub-tasks checklist for '%s': %s", recPtr.Fields.Summary, err)
+			return errors.New("can't sync issue")
+		}
+	}
+
+	err = CopyComments(recPtr, cardId, m.JiraClient, trelloClient, state)
+	if err != nil {
+		return errors.New("can't sync issue")
+	}
+
+	if archive && !state.IsDone(recPtr.Key, common.StepArchived) {
+		err = trelloClient.ArchiveCard(cardId)
+		if err != nil {
+			log.Printf("ERROR Could not archive card '%s': %s", cardId, err)
+			return errors.New("can't sync issue")
+		}
+		return state.MarkDone(recPtr.Key, common.StepArchived)
+	}
+	if !archive && state.IsDone(recPtr.Key, common.StepArchived) {
+		err = trelloClient.RestoreCard(cardId)
+		if err != nil {
+			log.Printf("ERROR Could not restore card '%s': %s", cardId, err)
+			return errors.New("can't sync issue")
+		}
+		return state.MarkUndone(recPtr.Key, common.StepArchived)
+	}
+	return nil
+}
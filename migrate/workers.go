@@ -0,0 +1,79 @@
+package migrate
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"sync"
+)
+
+/*
+IssueError records why a single issue could not be processed
+*/
+type IssueError struct {
+	JiraKey string
+	Err     error
+}
+
+/*
+WorkerPool runs a processing function over submitted issues on a fixed number of goroutines.
+Each issue is handled start to finish by a single worker, so everything done for one card (e.g. its comments) still
+happens in order; only separate issues run in parallel. All requests still go through the shared rate-limited HTTP
+client, so adding workers won't push us over the APIs' rate limits.
+*/
+type WorkerPool struct {
+	jobs    chan common.Issue
+	process func(rec *common.Issue) error
+	wg      sync.WaitGroup
+
+	mutex     sync.Mutex
+	succeeded int
+	errors    []IssueError
+}
+
+func NewWorkerPool(workers int, process func(rec *common.Issue) error) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	pool := &WorkerPool{
+		jobs:    make(chan common.Issue),
+		process: process,
+		errors:  make([]IssueError, 0),
+	}
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go pool.run()
+	}
+	return pool
+}
+
+func (p *WorkerPool) run() {
+	defer p.wg.Done()
+	for rec := range p.jobs {
+		err := p.process(&rec)
+		p.mutex.Lock()
+		if err != nil {
+			p.errors = append(p.errors, IssueError{JiraKey: rec.Key, Err: err})
+		} else {
+			p.succeeded++
+		}
+		p.mutex.Unlock()
+	}
+}
+
+/*
+Submit queues an issue for processing. It blocks until a worker is free to take it.
+*/
+func (p *WorkerPool) Submit(rec common.Issue) {
+	p.jobs <- rec
+}
+
+/*
+Wait stops accepting new work, waits for everything already submitted to finish and returns the number of issues
+that succeeded along with the errors from those that failed
+*/
+func (p *WorkerPool) Wait() (int, []IssueError) {
+	close(p.jobs)
+	p.wg.Wait()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.succeeded, p.errors
+}
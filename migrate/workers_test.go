@@ -0,0 +1,88 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	tests := []struct {
+		workers       int
+		issues        int
+		expectMaxBusy int
+	}{
+		{0, 5, 1},
+		{-3, 5, 1},
+		{1, 10, 1},
+		{4, 10, 4},
+		{8, 3, 8},
+	}
+	for _, test := range tests {
+		var mutex sync.Mutex
+		busy := 0
+		maxBusy := 0
+		processed := make(map[string]int)
+		pool := NewWorkerPool(test.workers, func(rec *common.Issue) error {
+			mutex.Lock()
+			busy++
+			if busy > maxBusy {
+				maxBusy = busy
+			}
+			processed[rec.Key]++
+			mutex.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mutex.Lock()
+			busy--
+			mutex.Unlock()
+			if rec.Id == "odd" {
+				return errors.New("could not migrate " + rec.Key)
+			}
+			return nil
+		})
+
+		expectedErrors := make([]string, 0)
+		for i := 0; i < test.issues; i++ {
+			issue := common.Issue{Key: fmt.Sprintf("PROJ-%d", i)}
+			if i%2 == 1 {
+				issue.Id = "odd"
+				expectedErrors = append(expectedErrors, issue.Key)
+			}
+			pool.Submit(issue)
+		}
+		succeeded, issueErrors := pool.Wait()
+
+		if succeeded != test.issues-len(expectedErrors) {
+			t.Errorf("%d workers: expected %d to succeed, got %d", test.workers, test.issues-len(expectedErrors), succeeded)
+		}
+		errorKeys := make([]string, len(issueErrors))
+		for i, e := range issueErrors {
+			errorKeys[i] = e.JiraKey
+			if e.Err == nil || e.Err.Error() != "could not migrate "+e.JiraKey {
+				t.Errorf("%d workers: expected the error for %s to be kept, got %v", test.workers, e.JiraKey, e.Err)
+			}
+		}
+		sort.Strings(errorKeys)
+		sort.Strings(expectedErrors)
+		if fmt.Sprint(errorKeys) != fmt.Sprint(expectedErrors) {
+			t.Errorf("%d workers: expected errors for %v, got %v", test.workers, expectedErrors, errorKeys)
+		}
+		if len(processed) != test.issues {
+			t.Errorf("%d workers: expected %d issues to be processed, got %d", test.workers, test.issues, len(processed))
+		}
+		for key, count := range processed {
+			if count != 1 {
+				t.Errorf("%d workers: expected %s to be processed once, was processed %d times", test.workers, key, count)
+			}
+		}
+		if maxBusy > test.expectMaxBusy {
+			t.Errorf("%d workers: expected at most %d issues at once, got %d", test.workers, test.expectMaxBusy, maxBusy)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"os"
+)
+
+func main() {
+	cfg := common.DefaultMigrationConfig()
+	configPath := flag.String("config", "", "Path to a YAML migration config file. Only the trello credentials are used")
+	flag.StringVar(&cfg.Trello.Credentials, "trello", cfg.Trello.Credentials, "Path to a file containing a Trello API key")
+	statePath := flag.String("state", "migration-state.json", "Path to the migration state written by load-issues")
+	reportPath := flag.String("report", "", "Path to a JSON report written by load-issues, to take the cards to remove from instead of the state. Labels and field options are still taken from the state")
+	runId := flag.String("run", "", "ID of the run to roll back, as logged by load-issues, or 'latest' for the most recent run in the state")
+	deleteCards := flag.Bool("delete", false, "Permanently delete the cards instead of archiving them")
+	keepLabels := flag.Bool("keep-labels", false, "Don't delete the labels that the run created. Deleting a label also removes it from any other cards it has been put on since")
+	keepOptions := flag.Bool("keep-options", false, "Don't remove the custom field options that the run added")
+	dryRun := flag.Bool("dry-run", false, "List what would be removed, without changing anything")
+	assumeYes := flag.Bool("yes", false, "Don't ask for confirmation before removing anything")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *runId == "" {
+		log.Fatal("You must specify the run to roll back with -run")
+	}
+
+	state, err := common.LoadMigrationState(*statePath, common.JiraToTrello)
+	if err != nil {
+		log.Fatalf("Could not load migration state from '%s': %s", *statePath, err)
+	}
+	if *runId == "latest" {
+		*runId = state.LatestRunId()
+		if *runId == "" {
+			log.Fatalf("There are no runs recorded in '%s'", *statePath)
+		}
+		log.Printf("INFO Latest run is '%s'", *runId)
+	}
+
+	plan, err := PlanFromState(state, *runId, *keepLabels, *keepOptions)
+	if err != nil && *reportPath == "" {
+		log.Fatalf("Could not roll back: %s", err)
+	}
+	if *reportPath != "" {
+		if plan == nil {
+			plan = &RollbackPlan{RunId: *runId}
+		}
+		plan.Cards, err = LoadReportCards(*reportPath, *runId)
+		if err != nil {
+			log.Fatalf("Could not load cards from '%s': %s", *reportPath, err)
+		}
+	}
+	if plan.IsEmpty() {
+		log.Printf("INFO Nothing to roll back for run '%s'", *runId)
+		return
+	}
+
+	plan.Describe(os.Stdout, *deleteCards)
+	if *dryRun {
+		log.Printf("INFO Dry run, would %s", plan.Summary(*deleteCards))
+		return
+	}
+	if !*assumeYes && !Confirm("About to "+plan.Summary(*deleteCards)+".", os.Stdin, os.Stdout) {
+		log.Fatal("Rollback cancelled, nothing was changed")
+	}
+
+	trelloKey, err := common.LoadScriptKey(&cfg.Trello.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Trello.Credentials, err)
+	}
+	failures := Execute(plan, *deleteCards, trello.NewClient(trelloKey, common.SharedHttpClient()), state)
+	if err = state.Close(); err != nil {
+		log.Printf("ERROR Could not write migration state to '%s', its changes are still in the journal next to it: %s", *statePath, err)
+	}
+	if failures > 0 {
+		log.Fatalf("ERROR Rollback of run '%s' finished with %d failures, re-run it to retry them", *runId, failures)
+	}
+	log.Printf("INFO Rolled back run '%s'", *runId)
+}
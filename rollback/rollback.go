@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+/*
+RollbackCard is a card that will be archived or deleted
+*/
+type RollbackCard struct {
+	JiraKey  string
+	CardId   string
+	ShortUrl string
+}
+
+/*
+RollbackPlan lists everything that a run created and that is going to be removed
+*/
+type RollbackPlan struct {
+	RunId        string
+	Cards        []RollbackCard
+	Labels       []common.CreatedLabel
+	FieldOptions []common.CreatedFieldOption
+}
+
+/*
+PlanFromState finds the cards, labels and field options that the given run recorded in the migration state.
+keepLabels and keepOptions leave labels and field options out of the plan.
+*/
+func PlanFromState(state *common.MigrationState, runId string, keepLabels bool, keepOptions bool) (*RollbackPlan, error) {
+	run, haveRun := state.GetRun(runId)
+	keys := state.KeysForRun(runId)
+	if !haveRun && len(keys) == 0 {
+		return nil, errors.New(fmt.Sprintf("there is no run '%s' in the migration state", runId))
+	}
+
+	plan := &RollbackPlan{
+		RunId:        runId,
+		Cards:        make([]RollbackCard, 0, len(keys)),
+		Labels:       make([]common.CreatedLabel, 0),
+		FieldOptions: make([]common.CreatedFieldOption, 0),
+	}
+	for _, k := range keys {
+		entry, _ := state.Get(k)
+		plan.Cards = append(plan.Cards, RollbackCard{JiraKey: k, CardId: entry.CardId, ShortUrl: entry.ShortUrl})
+	}
+	if !keepLabels {
+		plan.Labels = append(plan.Labels, run.Labels...)
+	}
+	if !keepOptions {
+		plan.FieldOptions = append(plan.FieldOptions, run.FieldOptions...)
+	}
+	return plan, nil
+}
+
+/*
+LoadReportCards reads the cards created by the given run from a JSON migration report written by load-issues.
+The report does not say which labels or field options were created, so only cards can be rolled back this way.
+*/
+func LoadReportCards(path string, runId string) ([]RollbackCard, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report struct {
+		Issues []struct {
+			JiraKey  string `json:"jiraKey"`
+			CardId   string `json:"cardId"`
+			ShortUrl string `json:"shortUrl"`
+			RunId    string `json:"runId"`
+		} `json:"issues"`
+	}
+	err = json.Unmarshal(content, &report)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not read report '%s', only JSON reports are supported: %s", path, err))
+	}
+
+	cards := make([]RollbackCard, 0)
+	for _, row := range report.Issues {
+		if row.RunId == runId && row.CardId != "" {
+			cards = append(cards, RollbackCard{JiraKey: row.JiraKey, CardId: row.CardId, ShortUrl: row.ShortUrl})
+		}
+	}
+	return cards, nil
+}
+
+/*
+IsEmpty returns true if there is nothing to roll back
+*/
+func (p *RollbackPlan) IsEmpty() bool {
+	return len(p.Cards) == 0 && len(p.Labels) == 0 && len(p.FieldOptions) == 0
+}
+
+/*
+Summary returns a one-line description of what the plan will do
+*/
+func (p *RollbackPlan) Summary(deleteCards bool) string {
+	cardAction := "archive"
+	if deleteCards {
+		cardAction = "permanently delete"
+	}
+	return fmt.Sprintf("%s %d cards, delete %d labels and remove %d custom field options created by run '%s'", cardAction, len(p.Cards), len(p.Labels), len(p.FieldOptions), p.RunId)
+}
+
+/*
+Describe writes out everything that the plan will remove, one item per line
+*/
+func (p *RollbackPlan) Describe(w io.Writer, deleteCards bool) {
+	cardAction := "archive"
+	if deleteCards {
+		cardAction = "delete"
+	}
+	for _, c := range p.Cards {
+		fmt.Fprintf(w, "%s card %s for %s %s\n", cardAction, c.CardId, c.JiraKey, c.ShortUrl)
+	}
+	for _, l := range p.Labels {
+		fmt.Fprintf(w, "delete label '%s' (%s)\n", l.Name, l.Id)
+	}
+	for _, o := range p.FieldOptions {
+		fmt.Fprintf(w, "remove option '%s' (%s) from custom field %s\n", o.Text, o.OptionId, o.FieldId)
+	}
+}
+
+/*
+Confirm asks the user to type "yes" before carrying on, and returns true if they did
+*/
+func Confirm(prompt string, in io.Reader, out io.Writer) bool {
+	fmt.Fprintf(out, "%s\nType 'yes' to continue: ", prompt)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false
+	}
+	return strings.TrimSpace(strings.ToLower(answer)) == "yes"
+}
+
+/*
+Execute archives or deletes the cards in the plan, then removes its field options and labels. Everything that is
+removed is forgotten from the migration state, so that a later run migrates those issues again. Failures are
+logged and the remaining items are still attempted; the number of failures is returned, and the failed items stay
+in the state so that the rollback can be re-run.
+*/
+func Execute(plan *RollbackPlan, deleteCards bool, trelloClient *trello.Client, state *common.MigrationState) int {
+	failures := 0
+	for _, c := range plan.Cards {
+		var err error
+		if deleteCards {
+			err = trelloClient.DeleteCard(c.CardId)
+		} else {
+			err = trelloClient.ArchiveCard(c.CardId)
+		}
+		if err != nil {
+			log.Printf("ERROR Could not remove card %s for %s: %s", c.CardId, c.JiraKey, err)
+			failures++
+			continue
+		}
+		if entry, haveEntry := state.Get(c.JiraKey); haveEntry && entry.CardId == c.CardId {
+			if err = state.Forget(c.JiraKey); err != nil {
+				log.Printf("ERROR Removed card %s but could not update the migration state for %s: %s", c.CardId, c.JiraKey, err)
+				failures++
+			}
+		}
+	}
+
+	removedOptions := make([]string, 0, len(plan.FieldOptions))
+	for _, o := range plan.FieldOptions {
+		err := trelloClient.RemoveCustomFieldOption(o.FieldId, o.OptionId)
+		if err != nil {
+			log.Printf("ERROR Could not remove option '%s' from custom field %s: %s", o.Text, o.FieldId, err)
+			failures++
+			continue
+		}
+		removedOptions = append(removedOptions, o.OptionId)
+	}
+
+	removedLabels := make([]string, 0, len(plan.Labels))
+	for _, l := range plan.Labels {
+		err := trelloClient.DeleteLabel(l.Id)
+		if err != nil {
+			log.Printf("ERROR Could not delete label '%s': %s", l.Name, err)
+			failures++
+			continue
+		}
+		removedLabels = append(removedLabels, l.Id)
+	}
+
+	err := state.ForgetRunItems(plan.RunId, removedLabels, removedOptions)
+	if err != nil {
+		log.Printf("ERROR Could not update the migration state for run '%s': %s", plan.RunId, err)
+		failures++
+	}
+	return failures
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+TestRollback creates cards, a label and a field option under one run and a card under another, then checks that
+rolling back the first run removes only what it created and forgets it from the state
+*/
+func TestRollback(t *testing.T) {
+	server := trellotest.NewServer()
+	defer server.Close()
+	list := server.AddList("board1", "To Do")
+	field := server.AddCustomField("board1", "Sprint", common.List, "Sprint 1")
+	client := server.Client()
+
+	stateDir, err := ioutil.TempDir("", "rollback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	state, err := common.LoadMigrationState(filepath.Join(stateDir, "state.json"), common.JiraToTrello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createCard := func(runId string, jiraKey string) {
+		if err := state.StartRun(runId, "board1"); err != nil {
+			t.Fatal(err)
+		}
+		card, err := client.PutTrelloCard(&common.NewTrelloCard{Name: jiraKey, ListId: list.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = state.RecordCard(jiraKey, card); err != nil {
+			t.Fatal(err)
+		}
+	}
+	createCard("run-1", "PROJ-1")
+	createCard("run-1", "PROJ-2")
+	label, err := client.CreateLabel("board1", "backend", "blue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.RecordCreatedLabel(label)
+	option, err := client.CreateCustomFieldOption(field.Id, &common.TrelloCustomFieldOption{Value: common.TrelloCustomFieldOptionValue{Text: "Sprint 2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.RecordCreatedOption(field.Id, option)
+	createCard("run-2", "PROJ-3")
+
+	if state.LatestRunId() != "run-2" {
+		t.Errorf("expected run-2 to be the latest run, got '%s'", state.LatestRunId())
+	}
+	if _, err = PlanFromState(state, "run-3", false, false); err == nil {
+		t.Error("expected an error planning a run that does not exist")
+	}
+
+	plan, err := PlanFromState(state, "run-1", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Cards) != 2 || len(plan.Labels) != 1 || len(plan.FieldOptions) != 1 {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+	var preview strings.Builder
+	plan.Describe(&preview, true)
+	if !strings.Contains(preview.String(), "delete card") || !strings.Contains(preview.String(), "delete label 'backend'") {
+		t.Errorf("unexpected preview:\n%s", preview.String())
+	}
+
+	server.FailNext("DELETE", "/labels/"+label.Id, 500)
+	if failures := Execute(plan, true, client, state); failures != 1 {
+		t.Errorf("expected 1 failure, got %d", failures)
+	}
+	server.AssertCardCount(t, 1)
+	server.AssertCard(t, "PROJ-3")
+	if _, haveEntry := state.Get("PROJ-1"); haveEntry {
+		t.Error("expected PROJ-1 to be forgotten from the state")
+	}
+	if f, _ := server.CustomField("board1", "Sprint"); len(*f.Options) != 1 {
+		t.Errorf("expected the added option to be removed, got %+v", *f.Options)
+	}
+
+	plan, err = PlanFromState(state, "run-1", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Cards) != 0 || len(plan.Labels) != 1 || len(plan.FieldOptions) != 0 {
+		t.Fatalf("expected only the failed label to be left, got %+v", plan)
+	}
+	if failures := Execute(plan, true, client, state); failures != 0 {
+		t.Errorf("expected no failures on retry, got %d", failures)
+	}
+	if len(server.Labels("board1")) != 0 {
+		t.Errorf("expected the label to be deleted, got %+v", server.Labels("board1"))
+	}
+	if _, haveRun := state.GetRun("run-1"); haveRun {
+		t.Error("expected run-1 to be forgotten once everything was removed")
+	}
+
+	plan, err = PlanFromState(state, "run-2", true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := Execute(plan, false, client, state); failures != 0 {
+		t.Errorf("expected no failures archiving, got %d", failures)
+	}
+	if card := server.AssertCard(t, "PROJ-3"); !card.Closed {
+		t.Error("expected PROJ-3 to be archived")
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	var out strings.Builder
+	if !Confirm("Go?", strings.NewReader("yes\n"), &out) {
+		t.Error("expected 'yes' to confirm")
+	}
+	if Confirm("Go?", strings.NewReader("y\n"), &out) {
+		t.Error("expected anything but 'yes' to cancel")
+	}
+	if Confirm("Go?", strings.NewReader(""), &out) {
+		t.Error("expected no answer to cancel")
+	}
+}
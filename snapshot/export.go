@@ -0,0 +1,226 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// bumped whenever the layout of a snapshot changes in a way that older readers can't handle
+const ManifestVersion = 1
+
+const (
+	manifestFile = "manifest.json"
+	issuesFile   = "issues.json"
+	epicsFile    = "epics.json"
+	namesFile    = "names.json"
+)
+
+/*
+Manifest describes a snapshot: where it came from, which queries it answers and a checksum for every file in it,
+so that it can be audited and checked for tampering or corruption
+*/
+type Manifest struct {
+	Version             int               `json:"version"`
+	Created             time.Time         `json:"created"`
+	JiraBaseUrl         string            `json:"jiraBaseUrl"`
+	IssueJql            string            `json:"issueJql"`
+	EpicJql             string            `json:"epicJql"`
+	Issues              int               `json:"issues"`
+	Epics               int               `json:"epics"`
+	Comments            int               `json:"comments"`
+	Attachments         int               `json:"attachments"`
+	AttachmentBytes     int64             `json:"attachmentBytes"`
+	AttachmentsIncluded bool              `json:"attachmentsIncluded"`
+	Files               map[string]string `json:"files"` //path within the snapshot to the SHA-256 of its content
+}
+
+func commentsPath(issueKey string) string {
+	return filepath.Join("comments", issueKey+".json")
+}
+
+func watchersPath(issueKey string) string {
+	return filepath.Join("watchers", issueKey+".json")
+}
+
+func attachmentPath(attachmentId string) string {
+	return filepath.Join("attachments", attachmentId)
+}
+
+/*
+exporter writes the files of a snapshot into a directory and keeps track of their checksums
+*/
+type exporter struct {
+	directory string
+	manifest  *Manifest
+}
+
+/*
+writeFrom copies content into the given path within the snapshot, recording its checksum. Returns the number of
+bytes written.
+*/
+func (e *exporter) writeFrom(path string, content io.Reader) (int64, error) {
+	fullPath := filepath.Join(e.directory, path)
+	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(fullPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(f, hasher), content)
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	e.manifest.Files[filepath.ToSlash(path)] = hex.EncodeToString(hasher.Sum(nil))
+	return written, nil
+}
+
+func (e *exporter) writeJson(path string, content interface{}) error {
+	encoded, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = e.writeFrom(path, strings.NewReader(string(encoded)+"\n"))
+	return err
+}
+
+/*
+Export reads every issue matching issueJql, with its comments, watchers and (if withAttachments is set) attachment
+content, plus every epic matching epicJql, and writes them into a snapshot at outputPath. If outputPath ends in
+.tar.gz or .tgz the snapshot is written as a gzipped tarball, otherwise as a directory, which must not already exist.
+The manifest is written last, so a snapshot without one is incomplete.
+*/
+func Export(source jira.Source, jiraBaseUrl string, pageSize int, issueJql string, epicJql string, withAttachments bool, outputPath string) (*Manifest, error) {
+	tarball := IsTarball(outputPath)
+	directory := outputPath
+	if tarball {
+		tempDir, err := ioutil.TempDir("", "jira-snapshot")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tempDir)
+		directory = tempDir
+	} else {
+		if _, err := os.Stat(outputPath); err == nil {
+			return nil, errors.New(fmt.Sprintf("'%s' already exists, snapshots are never overwritten", outputPath))
+		}
+		if err := os.MkdirAll(outputPath, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &exporter{
+		directory: directory,
+		manifest: &Manifest{
+			Version:             ManifestVersion,
+			Created:             time.Now().UTC(),
+			JiraBaseUrl:         jiraBaseUrl,
+			IssueJql:            issueJql,
+			EpicJql:             epicJql,
+			AttachmentsIncluded: withAttachments,
+			Files:               make(map[string]string),
+		},
+	}
+
+	issues, err := source.SyncLoadIssuesJQL(pageSize, issueJql)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not load issues: %s", err))
+	}
+	for i := range issues {
+		issue := &issues[i]
+		comments, err := source.LoadAllComments(issue.Key, 50)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("could not load comments for %s: %s", issue.Key, err))
+		}
+		if err = e.writeJson(commentsPath(issue.Key), comments); err != nil {
+			return nil, err
+		}
+		e.manifest.Comments += len(*comments)
+
+		watchers, err := source.LoadWatchers(issue.Key)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("could not load watchers for %s: %s", issue.Key, err))
+		}
+		if err = e.writeJson(watchersPath(issue.Key), watchers); err != nil {
+			return nil, err
+		}
+
+		for _, a := range issue.Fields.Attachment {
+			e.manifest.Attachments++
+			if !withAttachments {
+				continue
+			}
+			content, err := source.OpenJiraAttachment(a.Id)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("could not download attachment %s of %s: %s", a.Filename, issue.Key, err))
+			}
+			written, err := e.writeFrom(attachmentPath(a.Id), jira.ExpectSize(content, a.Size))
+			content.Close()
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("could not save attachment %s of %s: %s", a.Filename, issue.Key, err))
+			}
+			e.manifest.AttachmentBytes += written
+		}
+		log.Printf("INFO Exported %s (%d/%d)", issue.Key, i+1, len(issues))
+	}
+	if err = e.writeJson(issuesFile, issues); err != nil {
+		return nil, err
+	}
+	e.manifest.Issues = len(issues)
+
+	epics, err := source.SyncLoadIssuesJQL(pageSize, epicJql)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("could not load epics: %s", err))
+	}
+	if err = e.writeJson(epicsFile, epics); err != nil {
+		return nil, err
+	}
+	e.manifest.Epics = len(epics)
+
+	//field names are kept so that fields can be found by name when the snapshot is read, as they are from Jira
+	names := make(map[string]string)
+	for _, list := range [][]common.Issue{issues, epics} {
+		for _, issue := range list {
+			for id, name := range issue.Names {
+				names[id] = name
+			}
+		}
+	}
+	if err = e.writeJson(namesFile, names); err != nil {
+		return nil, err
+	}
+
+	encoded, err := json.MarshalIndent(e.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(filepath.Join(directory, manifestFile), append(encoded, '\n'), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if tarball {
+		if err = writeTarball(directory, outputPath); err != nil {
+			return nil, err
+		}
+	}
+	return e.manifest, nil
+}
@@ -0,0 +1,303 @@
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+Snapshot reads Jira issues, comments, watchers and attachments from an export made by Export, instead of from the
+live API. It implements jira.Source, but only answers the two queries that the export was made with.
+*/
+type Snapshot struct {
+	Manifest     Manifest
+	FieldMapping common.JiraFieldMapping //which fields issues' epic, sprint and story point values come from
+	directory    string
+	tempDir      string //set if the snapshot was extracted from a tarball, and removed by Close
+	issues       []common.Issue
+	epics        []common.Issue
+	names        map[string]string //field IDs to display names, empty for snapshots made before they were kept
+}
+
+/*
+Open reads the snapshot at the given path, which is either a directory or a tarball written by Export. The checksum
+of every file except the attachments is checked straight away; attachments are checked as they are read.
+Close must be called when done, to remove the extracted copy of a tarball.
+*/
+func Open(path string) (*Snapshot, error) {
+	s := &Snapshot{directory: path, FieldMapping: common.DefaultJiraFieldMapping()}
+	if IsTarball(path) {
+		tempDir, err := ioutil.TempDir("", "jira-snapshot")
+		if err != nil {
+			return nil, err
+		}
+		s.directory = tempDir
+		s.tempDir = tempDir
+		if err = extractTarball(path, tempDir); err != nil {
+			s.Close()
+			return nil, errors.New(fmt.Sprintf("could not extract '%s': %s", path, err))
+		}
+	}
+
+	err := s.load()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Snapshot) load() error {
+	content, err := ioutil.ReadFile(filepath.Join(s.directory, manifestFile))
+	if os.IsNotExist(err) {
+		return errors.New("snapshot has no manifest, the export did not complete")
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(content, &s.Manifest); err != nil {
+		return errors.New(fmt.Sprintf("could not read the manifest: %s", err))
+	}
+	if s.Manifest.Version != ManifestVersion {
+		return errors.New(fmt.Sprintf("snapshot is version %d, only version %d can be read", s.Manifest.Version, ManifestVersion))
+	}
+
+	for path := range s.Manifest.Files {
+		if strings.HasPrefix(path, "attachments/") {
+			continue
+		}
+		if err = s.checkFile(path); err != nil {
+			return err
+		}
+	}
+	if _, haveNames := s.Manifest.Files[namesFile]; haveNames {
+		if err = s.readJson(namesFile, &s.names); err != nil {
+			return err
+		}
+	}
+	if err = s.readJson(issuesFile, &s.issues); err != nil {
+		return err
+	}
+	return s.readJson(epicsFile, &s.epics)
+}
+
+/*
+open opens the given file within the snapshot, failing if it is not listed in the manifest
+*/
+func (s *Snapshot) open(path string) (*os.File, string, error) {
+	expected, isListed := s.Manifest.Files[filepath.ToSlash(path)]
+	if !isListed {
+		return nil, "", errors.New(fmt.Sprintf("'%s' is not in the snapshot", path))
+	}
+	f, err := os.Open(filepath.Join(s.directory, path))
+	return f, expected, err
+}
+
+func (s *Snapshot) checkFile(path string) error {
+	f, expected, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(ioutil.Discard, checkSum(f, path, expected))
+	return err
+}
+
+func (s *Snapshot) readJson(path string, dest interface{}) error {
+	f, _, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(dest)
+	if err != nil {
+		return errors.New(fmt.Sprintf("could not read '%s' from the snapshot: %s", path, err))
+	}
+	return nil
+}
+
+/*
+Close removes the extracted copy of a tarball snapshot. It does nothing for a directory.
+*/
+func (s *Snapshot) Close() error {
+	if s.tempDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(s.tempDir)
+	s.tempDir = ""
+	return err
+}
+
+/*
+Issues returns every issue in the snapshot
+*/
+func (s *Snapshot) Issues() []common.Issue {
+	return s.issues
+}
+
+/*
+Epics returns every epic in the snapshot
+*/
+func (s *Snapshot) Epics() []common.Issue {
+	return s.epics
+}
+
+/*
+issuesForQuery returns a copy of the issues that the snapshot holds for the query, with their typed custom fields
+resolved using FieldMapping in the same way that Client.LoadIssues does
+*/
+func (s *Snapshot) issuesForQuery(query string) ([]common.Issue, error) {
+	var issues []common.Issue
+	switch query {
+	case s.Manifest.IssueJql:
+		issues = s.issues
+	case s.Manifest.EpicJql:
+		issues = s.epics
+	default:
+		return nil, errors.New(fmt.Sprintf("snapshot was exported with issue query '%s' and epic query '%s', it can't answer '%s'", s.Manifest.IssueJql, s.Manifest.EpicJql, query))
+	}
+	result := make([]common.Issue, len(issues))
+	copy(result, issues)
+	for i := range result {
+		result[i].Names = s.names
+		result[i].ResolveFields(&s.FieldMapping)
+	}
+	return result, nil
+}
+
+/*
+AsyncLoadIssuesJQL sends every issue that the snapshot holds for the query, in the same way as Client.AsyncLoadIssuesJQL
+*/
+func (s *Snapshot) AsyncLoadIssuesJQL(pageSize int, maybeQuery string) (chan common.Issue, chan error) {
+	outCh := make(chan common.Issue, 50)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(outCh)
+		issues, err := s.issuesForQuery(maybeQuery)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		for _, i := range issues {
+			outCh <- i
+		}
+	}()
+	return outCh, errCh
+}
+
+func (s *Snapshot) SyncLoadIssuesJQL(pageSize int, query string) ([]common.Issue, error) {
+	return s.issuesForQuery(query)
+}
+
+/*
+validName stops an issue key or attachment ID from the API being used to reach outside the snapshot
+*/
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func (s *Snapshot) LoadAllComments(issueId string, pageSize int32) (*[]common.Comment, error) {
+	if !validName(issueId) {
+		return nil, errors.New(fmt.Sprintf("invalid issue key '%s'", issueId))
+	}
+	var comments []common.Comment
+	err := s.readJson(commentsPath(issueId), &comments)
+	if err != nil {
+		return nil, err
+	}
+	return &comments, nil
+}
+
+func (s *Snapshot) LoadWatchers(issueId string) ([]common.JiraUser, error) {
+	if !validName(issueId) {
+		return nil, errors.New(fmt.Sprintf("invalid issue key '%s'", issueId))
+	}
+	var watchers []common.JiraUser
+	err := s.readJson(watchersPath(issueId), &watchers)
+	return watchers, err
+}
+
+/*
+OpenJiraAttachment opens the content of an attachment. Reading fails at the end if the content does not match the
+checksum in the manifest.
+*/
+func (s *Snapshot) OpenJiraAttachment(attachmentId string) (io.ReadCloser, error) {
+	if !validName(attachmentId) {
+		return nil, errors.New(fmt.Sprintf("invalid attachment ID '%s'", attachmentId))
+	}
+	if !s.Manifest.AttachmentsIncluded {
+		return nil, errors.New("snapshot was exported without attachments")
+	}
+	path := attachmentPath(attachmentId)
+	f, expected, err := s.open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &checkedFile{Reader: checkSum(f, path, expected), Closer: f}, nil
+}
+
+/*
+DownloadJiraAttachment copies the content of an attachment to a temp file, in the same way as
+Client.DownloadJiraAttachment. The caller must remove the file when done with it.
+*/
+func (s *Snapshot) DownloadJiraAttachment(attachmentId string, expectedSize int64) (string, error) {
+	content, err := s.OpenJiraAttachment(attachmentId)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	file, err := ioutil.TempFile("", "trelloatt")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, jira.ExpectSize(content, expectedSize))
+	if err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+type checkedFile struct {
+	io.Reader
+	io.Closer
+}
+
+/*
+checksumReader passes through reads from the underlying reader, but fails instead of returning EOF if the content
+did not have the expected SHA-256
+*/
+type checksumReader struct {
+	reader   io.Reader
+	hasher   hash.Hash
+	path     string
+	expected string
+}
+
+func checkSum(reader io.Reader, path string, expected string) io.Reader {
+	return &checksumReader{reader: reader, hasher: sha256.New(), path: path, expected: expected}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hasher.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hasher.Sum(nil)) != r.expected {
+		return n, errors.New(fmt.Sprintf("'%s' does not match the checksum in the manifest, the snapshot has been modified or is corrupt", r.path))
+	}
+	return n, err
+}
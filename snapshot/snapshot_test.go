@@ -0,0 +1,133 @@
+package snapshot
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jiratest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+exportSample exports the sample fixture from a fake Jira to the given path and opens the result
+*/
+func exportSample(t *testing.T, outputPath string) *Snapshot {
+	t.Helper()
+	server, err := jiratest.NewServerWithFixture("sample")
+	if err != nil {
+		t.Fatalf("Could not start fake Jira: %s", err)
+	}
+	defer server.Close()
+
+	manifest, err := Export(server.Client(), server.URL, 2, common.DefaultIssueJql, common.DefaultEpicJql, true, outputPath)
+	if err != nil {
+		t.Fatalf("Export failed: %s", err)
+	}
+	if manifest.Issues != 4 || manifest.Epics != 2 || manifest.Comments != 3 || manifest.Attachments != 1 {
+		t.Errorf("unexpected manifest counts: %+v", manifest)
+	}
+
+	snap, err := Open(outputPath)
+	if err != nil {
+		t.Fatalf("Could not open snapshot: %s", err)
+	}
+	return snap
+}
+
+func checkSample(t *testing.T, snap *Snapshot) {
+	t.Helper()
+	issues, err := snap.SyncLoadIssuesJQL(50, common.DefaultIssueJql)
+	if err != nil || len(issues) != 4 {
+		t.Fatalf("expected 4 issues, got %d (%v)", len(issues), err)
+	}
+	//fields are found by name in the same way as from Jira, including ones with no typed equivalent
+	if issues[0].Fields.StoryPoints == nil || *issues[0].Fields.StoryPoints != 3 {
+		t.Errorf("expected PROJ-1's story points to be resolved from the snapshot, got %v", issues[0].Fields.StoryPoints)
+	}
+	if raw, haveTeam := issues[0].RawField("Team"); !haveTeam || common.FieldText(raw) != "Platform" {
+		t.Errorf("expected PROJ-1's Team field to be kept in the snapshot, got %s", string(raw))
+	}
+	issueCh, errCh := snap.AsyncLoadIssuesJQL(50, common.DefaultEpicJql)
+	epics := 0
+	for range issueCh {
+		epics++
+	}
+	if len(errCh) > 0 || epics != 2 {
+		t.Errorf("expected 2 epics, got %d", epics)
+	}
+	if _, err = snap.SyncLoadIssuesJQL(50, "project = OTHER"); err == nil {
+		t.Error("expected a query that was not exported to fail")
+	}
+
+	comments, err := snap.LoadAllComments("PROJ-1", 20)
+	if err != nil || len(*comments) != 3 {
+		t.Errorf("expected 3 comments on PROJ-1, got %v (%v)", comments, err)
+	}
+	if _, err = snap.LoadAllComments("../manifest", 20); err == nil {
+		t.Error("expected an issue key with a path in it to be rejected")
+	}
+
+	fileName, err := snap.DownloadJiraAttachment("20001", 16)
+	if err != nil {
+		t.Fatalf("Could not read attachment: %s", err)
+	}
+	defer os.Remove(fileName)
+	if info, _ := os.Stat(fileName); info.Size() != 16 {
+		t.Errorf("expected a 16 byte attachment, got %d", info.Size())
+	}
+}
+
+func TestExportDirectory(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	outputPath := filepath.Join(tempDir, "export")
+
+	snap := exportSample(t, outputPath)
+	checkSample(t, snap)
+	snap.Close()
+
+	//a modified attachment is only noticed when it is read, anything else when the snapshot is opened
+	err = ioutil.WriteFile(filepath.Join(outputPath, "attachments", "20001"), []byte("sixteen bytes!!!"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snap, err = Open(outputPath)
+	if err != nil {
+		t.Fatalf("Could not open snapshot: %s", err)
+	}
+	if _, err = snap.DownloadJiraAttachment("20001", 16); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected a modified attachment to fail its checksum, got %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(outputPath, "comments", "PROJ-1.json"), []byte("[]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(outputPath); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected modified comments to fail their checksum, got %v", err)
+	}
+
+	if _, err = Open(tempDir); err == nil {
+		t.Error("expected a directory with no manifest to be rejected")
+	}
+}
+
+func TestExportTarball(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	snap := exportSample(t, filepath.Join(tempDir, "export.tar.gz"))
+	checkSample(t, snap)
+	extracted := snap.directory
+	snap.Close()
+	if _, err = os.Stat(extracted); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove the extracted snapshot in '%s'", extracted)
+	}
+}
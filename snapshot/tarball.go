@@ -0,0 +1,116 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+IsTarball returns true if the path names a gzipped tarball rather than a directory
+*/
+func IsTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+/*
+writeTarball packs every regular file under directory into a gzipped tarball at outputPath, with paths relative to
+directory. An existing file at outputPath is not overwritten.
+*/
+func writeTarball(directory string, outputPath string) error {
+	f, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		relPath, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		content, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		_, err = io.Copy(tw, content)
+		return err
+	})
+	for _, closer := range []io.Closer{tw, gz, f} {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(outputPath)
+	}
+	return err
+}
+
+/*
+extractTarball unpacks a gzipped tarball written by writeTarball into directory. Entries that are not regular files,
+or whose paths would land outside directory, are rejected.
+*/
+func extractTarball(tarballPath string, directory string) error {
+	f, err := os.Open(tarballPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return errors.New(fmt.Sprintf("unexpected entry '%s' in snapshot, only regular files are allowed", header.Name))
+		}
+		target := filepath.Join(directory, filepath.FromSlash(header.Name))
+		if !strings.HasPrefix(target, filepath.Clean(directory)+string(os.PathSeparator)) {
+			return errors.New(fmt.Sprintf("entry '%s' in snapshot is outside the snapshot directory", header.Name))
+		}
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		closeErr := out.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
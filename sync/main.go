@@ -0,0 +1,267 @@
+package main
+
+import (
+	"flag"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/migrate"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// how many parents of changed sub-tasks are loaded from Jira in one query
+const parentBatchSize = 50
+
+func main() {
+	cfg := common.DefaultMigrationConfig()
+	configPath := flag.String("config", "", "Path to the YAML migration config file used for load-issues. Flags given on the command line override its settings")
+	flag.StringVar(&cfg.Jira.Credentials, "jira", cfg.Jira.Credentials, "Path to a file containing a Jira API key")
+	flag.StringVar(&cfg.Trello.Credentials, "trello", cfg.Trello.Credentials, "Path to a file containing a Trello API key")
+	flag.StringVar(&cfg.Jira.Host, "host", cfg.Jira.Host, "Jira host to query, or its full base URL (including any context path) if it is not https")
+	flag.IntVar(&cfg.Jira.PageSize, "pagesize", cfg.Jira.PageSize, "number of issues to fetch in one page")
+	flag.StringVar(&cfg.Jira.IssueJql, "jql", cfg.Jira.IssueJql, "JQL query that load-issues was run with. Only the issues it matches that were updated since the last sync are loaded")
+	flag.StringVar(&cfg.Jira.Timezone, "jira-timezone", cfg.Jira.Timezone, "Timezone of the Jira account, which Jira uses to interpret the dates in the sync query")
+	flag.StringVar(&cfg.Trello.Board, "board", cfg.Trello.Board, "Board ID that the issues were migrated to")
+	flag.StringVar(&cfg.Lists.Fallback, "defaultlist", cfg.Lists.Fallback, "Name of the list to move cards into by default")
+	listMapPath := flag.String("listmap", "", "Path to the listmap file that load-issues was run with, if any, replacing the lists section of the config")
+	flag.StringVar(&cfg.Fields.Epic, "epicfield", cfg.Fields.Epic, "Name of the custom field holding epics information")
+	flag.StringVar(&cfg.Fields.Priority, "priority-field", cfg.Fields.Priority, "Name of the list custom field holding the priority")
+	statePath := flag.String("state", "migration-state.json", "Path to the migration state written by load-issues, which says which card each issue went to and when the last sync was")
+	sinceSpec := flag.String("since", "", "Load issues updated since this RFC3339 time or yyyy-mm-dd date, instead of since the last run that had no failures")
+	runId := flag.String("run-id", "", "ID to record any field options created by this sync under, for rollback. Defaults to the current UTC time")
+	workers := flag.Int("workers", 1, "Number of issues to sync in parallel")
+	attachmentPolicyPath := flag.String("attachment-policy", "", "Path to the attachment policy that load-issues was run with, for new attachments that are too large for Trello")
+	subtaskModeSpec := flag.String("subtasks", string(migrate.SubtasksAsChecklist), "How load-issues migrated sub-tasks: with 'checklist', a changed sub-task updates the checklist on its parent's card instead of having a card of its own")
+	sprintModeSpec := flag.String("sprints", string(migrate.SprintsIgnore), "How load-issues migrated sprints: 'lists' and 'field' are kept up to date, while 'labels' and 'none' leave sprints alone")
+	flag.StringVar(&cfg.Fields.Sprint, "sprint-field", cfg.Fields.Sprint, "Name of the custom field holding the sprint when using -sprints field")
+	flag.StringVar(&cfg.Fields.OriginalEstimate, "original-estimate-field", cfg.Fields.OriginalEstimate, "Name of the number custom field holding the original estimate in hours, if it is migrated")
+	flag.StringVar(&cfg.Fields.RemainingEstimate, "remaining-estimate-field", cfg.Fields.RemainingEstimate, "Name of the number custom field holding the remaining estimate in hours, if it is migrated")
+	flag.StringVar(&cfg.Fields.StoryPoints, "story-points-field", cfg.Fields.StoryPoints, "Name of the number custom field holding story points, if they are migrated")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	sprintMode, err := migrate.ParseSprintMode(*sprintModeSpec)
+	if err != nil {
+		log.Fatalf("Invalid -sprints: %s", err)
+	}
+	subtaskMode, err := migrate.ParseSubtaskMode(*subtaskModeSpec)
+	if err != nil {
+		log.Fatalf("Invalid -subtasks: %s", err)
+	}
+
+	httpClient := common.SharedHttpClient()
+	jiraKey, err := common.LoadScriptKey(&cfg.Jira.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraAuth, err := jira.AuthFromScriptKey(jiraKey)
+	if err != nil {
+		log.Fatalf("Invalid scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraClient := jira.NewClient(jira.BaseUrlFor(cfg.Jira.Host), jiraAuth, httpClient)
+	jiraClient.FieldMapping = cfg.Jira.Fields
+
+	trelloKey, err := common.LoadScriptKey(&cfg.Trello.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Trello.Credentials, err)
+	}
+	trelloClient := trello.NewClient(trelloKey, httpClient)
+
+	state, err := common.LoadMigrationState(*statePath, common.JiraToTrello)
+	if err != nil {
+		log.Fatalf("Could not load migration state from '%s': %s", *statePath, err)
+	}
+	jiraZone, _ := time.LoadLocation(cfg.Jira.Timezone) //already checked by cfg.Validate
+	since, haveSince := state.LastSyncTime()
+	if *sinceSpec != "" {
+		since, err = migrate.ParseSince(*sinceSpec, jiraZone)
+		if err != nil {
+			log.Fatalf("Invalid -since: %s", err)
+		}
+		haveSince = true
+	}
+	if !haveSince {
+		log.Fatalf("There is no record of a previous run without failures in '%s', use -since to say where to sync from", *statePath)
+	}
+	if *runId == "" {
+		*runId = common.NewRunId()
+	}
+	err = state.StartRun(*runId, cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not record the start of run '%s' in '%s': %s", *runId, *statePath, err)
+	}
+
+	trelloListCache, err := trelloClient.NewListCache(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load lists from board '%s': %s", cfg.Trello.Board, err)
+	}
+	customFieldCache, err := trelloClient.LoadAllCustomFields(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load custom fields from board '%s': %s", cfg.Trello.Board, err)
+	}
+
+	routing, err := migrate.ListRoutingFromConfig(cfg.Lists)
+	if err != nil {
+		log.Fatalf("Invalid list mapping in the config: %s", err)
+	}
+	if *listMapPath != "" {
+		routing, err = migrate.LoadListRouting(*listMapPath)
+		if err != nil {
+			log.Fatalf("Could not load list mapping from '%s': %s", *listMapPath, err)
+		}
+	}
+	if routing.Fallback == "" {
+		routing.Fallback = cfg.Lists.Fallback
+	}
+	router := migrate.NewListRouter(routing, trelloListCache, false, sprintMode == migrate.SprintsAsList, trelloClient)
+
+	epicLinkField, haveEpicLinkField := (*customFieldCache)[cfg.Fields.Epic]
+	if !haveEpicLinkField {
+		log.Fatalf("Could not find any custom field matching '%s' for epics information", cfg.Fields.Epic)
+	}
+	priorityField, havePriorityField := (*customFieldCache)[cfg.Fields.Priority]
+	if !havePriorityField {
+		log.Fatalf("Could not find any custom field matching '%s' for priority information", cfg.Fields.Priority)
+	}
+
+	var sprintField *migrate.SprintField
+	if sprintMode == migrate.SprintsAsField {
+		sprintField, err = migrate.NewSprintField(cfg.Trello.Board, cfg.Fields.Sprint, customFieldCache, false, trelloClient, state)
+		if err != nil {
+			log.Fatalf("Could not set up the sprint field '%s': %s", cfg.Fields.Sprint, err)
+		}
+	}
+	estimateFields, err := migrate.NewEstimateFields(cfg.Trello.Board, cfg.Fields.OriginalEstimate, cfg.Fields.RemainingEstimate, cfg.Fields.StoryPoints, customFieldCache, false, trelloClient)
+	if err != nil {
+		log.Fatalf("Could not set up estimate fields: %s", err)
+	}
+	mappedFields, err := migrate.NewMappedFields(cfg.Trello.Board, cfg.CustomFields, customFieldCache, false, trelloClient, state)
+	if err != nil {
+		log.Fatalf("Could not set up custom field mappings: %s", err)
+	}
+
+	attachmentPolicy := &migrate.AttachmentPolicy{}
+	if *attachmentPolicyPath != "" {
+		attachmentPolicy, err = migrate.LoadAttachmentPolicy(*attachmentPolicyPath)
+		if err != nil {
+			log.Fatalf("Could not load attachment policy from '%s': %s", *attachmentPolicyPath, err)
+		}
+	}
+
+	epics, err := migrate.NewEpicsCache(jiraClient, cfg.Jira.PageSize, cfg.Jira.EpicJql)
+	if err != nil {
+		log.Fatalf("Unable to load epics information: %s", err)
+	}
+
+	runStarted := time.Now()
+	issueJql := migrate.SyncJql(cfg.Jira.IssueJql, since, jiraZone)
+	log.Printf("INFO Syncing issues updated since %s with: %s", since.Format(time.RFC3339), issueJql)
+
+	migrator := &migrate.Migrator{
+		Router:           router,
+		SubtaskMode:      subtaskMode,
+		SprintMode:       sprintMode,
+		SprintField:      sprintField,
+		EstimateFields:   estimateFields,
+		MappedFields:     mappedFields,
+		EpicLinkField:    &epicLinkField,
+		PriorityField:    &priorityField,
+		PriorityNames:    cfg.Priorities,
+		Epics:            epics,
+		AttachmentPolicy: attachmentPolicy,
+		JiraClient:       jiraClient,
+		TrelloClient:     trelloClient,
+		State:            state,
+	}
+
+	pool := migrate.NewWorkerPool(*workers, func(rec *common.Issue) error {
+		err := migrator.SyncIssue(rec)
+		if err != nil {
+			log.Printf("ERROR syncing '%s': %s", rec.Key, err)
+		}
+		return err
+	})
+
+	notMigrated := make([]string, 0)
+	submitted := make(map[string]bool)
+	submit := func(rec common.Issue) {
+		if previous, havePrevious := state.Get(rec.Key); !havePrevious || !previous.Completed {
+			notMigrated = append(notMigrated, rec.Key)
+			return
+		}
+		submitted[rec.Key] = true
+		pool.Submit(rec)
+	}
+
+	//changing a sub-task doesn't mark its parent as updated, so with checklists the parents of changed sub-tasks are
+	//loaded and synced afterwards, which brings their checklists up to date
+	changedParents := make([]string, 0)
+	contentCh, errCh := jiraClient.AsyncLoadIssuesJQL(cfg.Jira.PageSize, issueJql)
+	for rec := range contentCh {
+		if subtaskMode == migrate.SubtasksAsChecklist && migrate.IsSubtask(&rec) {
+			changedParents = append(changedParents, rec.Fields.Parent.Key)
+			continue
+		}
+		submit(rec)
+	}
+	var loadErr error
+	select {
+	case loadErr = <-errCh:
+	default:
+	}
+
+	parentKeys := make([]string, 0, len(changedParents))
+	for _, key := range changedParents {
+		if !submitted[key] {
+			submitted[key] = true
+			parentKeys = append(parentKeys, key)
+		}
+	}
+	for start := 0; start < len(parentKeys) && loadErr == nil; start += parentBatchSize {
+		end := start + parentBatchSize
+		if end > len(parentKeys) {
+			end = len(parentKeys)
+		}
+		parents, err := jiraClient.SyncLoadIssuesJQL(cfg.Jira.PageSize, migrate.KeysJql(parentKeys[start:end]))
+		if err != nil {
+			loadErr = err
+			break
+		}
+		for _, rec := range parents {
+			submit(rec)
+		}
+	}
+	ctr, issueErrors := pool.Wait()
+
+	log.Printf("INFO Synced %d cards, %d failed", ctr, len(issueErrors))
+	if len(notMigrated) > 0 {
+		log.Printf("WARNING These issues have no finished card to sync, run load-issues to migrate them: %s", strings.Join(notMigrated, ", "))
+	}
+	exitCode := 0
+	if loadErr != nil {
+		log.Printf("ERROR Not every updated issue could be loaded from Jira: %s. Re-run to carry on.", loadErr)
+		exitCode = 1
+	} else if len(issueErrors) > 0 {
+		failed := make([]string, 0, len(issueErrors))
+		for _, e := range issueErrors {
+			failed = append(failed, e.JiraKey)
+		}
+		log.Printf("ERROR Failed issues were: %s. Re-run to retry them.", strings.Join(failed, ", "))
+		exitCode = 1
+	} else if err = state.RecordSync(runStarted); err != nil {
+		log.Printf("ERROR Could not record the sync checkpoint in '%s': %s", *statePath, err)
+		exitCode = 1
+	}
+	if err = state.Close(); err != nil {
+		log.Printf("ERROR Could not write migration state to '%s', its changes are still in the journal next to it: %s", *statePath, err)
+		exitCode = 1
+	}
+	os.Exit(exitCode)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Jira rejects summaries longer than this
+const maxSummaryLength = 255
+
+/*
+CardConverter turns Trello cards into the fields of new Jira issues. The epic, priority and Jira key fields are
+optional; any other custom field values are written into the description.
+*/
+type CardConverter struct {
+	ProjectKey      string
+	IssueType       string
+	EpicLinkFieldId string //Jira field that takes the key of the issue's epic, e.g. customfield_10014
+	Lists           *trello.ListCache
+	Members         *trello.MemberCache
+	CustomFields    map[string]common.TrelloCustomField //keyed by ID
+	EpicField       *common.TrelloCustomField
+	PriorityField   *common.TrelloCustomField
+	JiraKeyField    *common.TrelloCustomField
+	EpicKeys        map[string]string //epic name to Jira key
+	Priorities      map[string]map[string]string
+	Statuses        map[string]string //list name to Jira status
+}
+
+/*
+NewCardConverter indexes the board's custom fields and inverts the priority mapping. priorityNames is the same
+Jira priority ID or name to option text mapping that load-issues takes, and can be nil.
+*/
+func NewCardConverter(projectKey string, issueType string, epicLinkFieldId string, lists *trello.ListCache, members *trello.MemberCache, customFields *trello.CustomFieldCache, epicFieldName string, priorityFieldName string, jiraKeyFieldName string, epicKeys map[string]string, priorityNames map[string]string, statuses map[string]string) *CardConverter {
+	c := &CardConverter{
+		ProjectKey:      projectKey,
+		IssueType:       issueType,
+		EpicLinkFieldId: epicLinkFieldId,
+		Lists:           lists,
+		Members:         members,
+		CustomFields:    make(map[string]common.TrelloCustomField, len(*customFields)),
+		EpicKeys:        epicKeys,
+		Priorities:      InvertPriorityNames(priorityNames),
+		Statuses:        statuses,
+	}
+	for name, f := range *customFields {
+		field := f
+		c.CustomFields[f.Id] = field
+		switch name {
+		case epicFieldName:
+			c.EpicField = &field
+		case priorityFieldName:
+			c.PriorityField = &field
+		case jiraKeyFieldName:
+			c.JiraKeyField = &field
+		}
+	}
+	return c
+}
+
+/*
+InvertPriorityNames returns the Jira priority to set for each Trello option text, as {"id": ...} for numeric keys
+and {"name": ...} for everything else. Entries in priorityNames take precedence over DefaultPriorityNames.
+*/
+func InvertPriorityNames(priorityNames map[string]string) map[string]map[string]string {
+	out := make(map[string]map[string]string, len(common.DefaultPriorityNames)+len(priorityNames))
+	add := func(jiraIdOrName string, optionText string) {
+		if _, err := strconv.Atoi(jiraIdOrName); err == nil {
+			out[optionText] = map[string]string{"id": jiraIdOrName}
+		} else {
+			out[optionText] = map[string]string{"name": jiraIdOrName}
+		}
+	}
+	for id, text := range common.DefaultPriorityNames {
+		add(id, text)
+	}
+	for idOrName, text := range priorityNames {
+		add(idOrName, text)
+	}
+	return out
+}
+
+/*
+InvertListStatuses turns the status to list mapping that load-issues takes into a list to status one. Where
+several statuses go to the same list, the first in alphabetical order is used.
+*/
+func InvertListStatuses(statuses map[string]string) map[string]string {
+	names := make([]string, 0, len(statuses))
+	for status := range statuses {
+		names = append(names, status)
+	}
+	sort.Strings(names)
+	out := make(map[string]string, len(statuses))
+	for _, status := range names {
+		if _, haveList := out[statuses[status]]; !haveList {
+			out[statuses[status]] = status
+		}
+	}
+	return out
+}
+
+/*
+ListName returns the name of the list the card is on, or its ID if the list is not known
+*/
+func (c *CardConverter) ListName(card *common.TrelloCardDetail) string {
+	if list, haveList := c.Lists.FindById(card.ListId); haveList {
+		return list.Name
+	}
+	return card.ListId
+}
+
+/*
+TargetStatus returns the Jira status that the card's issue should be moved to: the mapped status for its list, or
+a status with the same name as the list
+*/
+func (c *CardConverter) TargetStatus(card *common.TrelloCardDetail) string {
+	listName := c.ListName(card)
+	if status, haveStatus := c.Statuses[listName]; haveStatus {
+		return status
+	}
+	return listName
+}
+
+/*
+optionText returns the text of the option chosen in a list custom field on the card, or an empty string
+*/
+func optionText(card *common.TrelloCardDetail, field *common.TrelloCustomField) string {
+	if field == nil || field.Options == nil {
+		return ""
+	}
+	item, haveItem := card.CustomFieldItem(field.Id)
+	if !haveItem {
+		return ""
+	}
+	for _, opt := range *field.Options {
+		if opt.Id == item.IdValue {
+			return opt.Value.Text
+		}
+	}
+	return ""
+}
+
+/*
+JiraKey returns the value of the Jira key field on the card, i.e. the issue it was migrated from, if any
+*/
+func (c *CardConverter) JiraKey(card *common.TrelloCardDetail) string {
+	if c.JiraKeyField == nil {
+		return ""
+	}
+	item, haveItem := card.CustomFieldItem(c.JiraKeyField.Id)
+	if !haveItem {
+		return ""
+	}
+	return item.Value["text"]
+}
+
+/*
+fieldValueText renders the value of any kind of custom field as text
+*/
+func fieldValueText(field *common.TrelloCustomField, item *common.TrelloCustomFieldItem) string {
+	switch field.Type {
+	case common.List:
+		if field.Options != nil {
+			for _, opt := range *field.Options {
+				if opt.Id == item.IdValue {
+					return opt.Value.Text
+				}
+			}
+		}
+		return ""
+	case common.Checkbox:
+		if item.Value["checked"] == "true" {
+			return "yes"
+		}
+		return "no"
+	case common.Date:
+		if parsed, err := time.Parse(time.RFC3339, item.Value["date"]); err == nil {
+			return parsed.Format("2006-01-02 15:04")
+		}
+		return item.Value["date"]
+	default:
+		return item.Value[string(field.Type)]
+	}
+}
+
+/*
+extraFields returns the name and value of everything on the card that has no Jira field of its own: members and
+the custom fields other than epic, priority and Jira key, sorted by name
+*/
+func (c *CardConverter) extraFields(card *common.TrelloCardDetail) [][2]string {
+	out := make([][2]string, 0)
+	if len(card.Members) > 0 {
+		names := make([]string, 0, len(card.Members))
+		for _, memberId := range card.Members {
+			if member, haveMember := c.Members.FindById(memberId); haveMember {
+				names = append(names, member.FullName)
+			} else {
+				names = append(names, memberId)
+			}
+		}
+		out = append(out, [2]string{"Members", strings.Join(names, ", ")})
+	}
+	for i := range card.CustomFieldItems {
+		item := &card.CustomFieldItems[i]
+		field, haveField := c.CustomFields[item.CustomFieldId]
+		if !haveField {
+			continue
+		}
+		if (c.PriorityField != nil && field.Id == c.PriorityField.Id) || (c.JiraKeyField != nil && field.Id == c.JiraKeyField.Id) {
+			continue
+		}
+		if c.EpicField != nil && field.Id == c.EpicField.Id {
+			//epics that exist in Jira are linked, anything else is kept in the description
+			if _, haveEpic := c.EpicKeys[optionText(card, c.EpicField)]; haveEpic {
+				continue
+			}
+		}
+		if value := fieldValueText(&field, item); value != "" {
+			out = append(out, [2]string{field.Name, value})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
+
+/*
+Description builds the Markdown for the issue description: the card description, then its checklists as task
+lists, its link attachments and a table of everything else that Jira has no field for
+*/
+func (c *CardConverter) Description(card *common.TrelloCardDetail) string {
+	sections := make([]string, 0)
+	if strings.TrimSpace(card.Description) != "" {
+		sections = append(sections, strings.TrimSpace(card.Description))
+	}
+
+	checklists := append([]common.TrelloChecklist{}, card.Checklists...)
+	sort.SliceStable(checklists, func(i, j int) bool { return checklists[i].Pos < checklists[j].Pos })
+	for _, checklist := range checklists {
+		items := append([]common.TrelloCheckItem{}, checklist.CheckItems...)
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Pos < items[j].Pos })
+		lines := []string{"### " + checklist.Name, ""}
+		for _, item := range items {
+			marker := "- [ ] "
+			if item.State == common.CheckItemComplete {
+				marker = "- [x] "
+			}
+			lines = append(lines, marker+item.Name)
+		}
+		sections = append(sections, strings.Join(lines, "\n"))
+	}
+
+	links := make([]string, 0)
+	for _, a := range card.Attachments {
+		if a.Bytes == nil {
+			links = append(links, fmt.Sprintf("- [%s](%s)", a.Name, a.Url))
+		}
+	}
+	if len(links) > 0 {
+		sections = append(sections, "### Links\n\n"+strings.Join(links, "\n"))
+	}
+
+	if extra := c.extraFields(card); len(extra) > 0 {
+		rows := []string{"| Field | Value |", "| --- | --- |"}
+		for _, f := range extra {
+			value := strings.ReplaceAll(strings.ReplaceAll(f[1], "|", "\\|"), "\n", " ")
+			rows = append(rows, fmt.Sprintf("| %s | %s |", f[0], value))
+		}
+		sections = append(sections, "### Trello fields\n\n"+strings.Join(rows, "\n"))
+	}
+	return strings.Join(sections, "\n\n")
+}
+
+/*
+truncateSummary cuts a card name down to the longest summary that Jira accepts, without splitting a character
+*/
+func truncateSummary(name string) string {
+	name = strings.TrimSpace(strings.ReplaceAll(name, "\n", " "))
+	runes := []rune(name)
+	if len(runes) <= maxSummaryLength {
+		return name
+	}
+	return string(runes[:maxSummaryLength-1]) + "…"
+}
+
+/*
+IssueFields returns the fields to create the card's issue with. Anything on the card that can't be mapped is
+returned as a warning, rather than failing the card.
+*/
+func (c *CardConverter) IssueFields(card *common.TrelloCardDetail) (map[string]interface{}, []string) {
+	warnings := make([]string, 0)
+	fields := map[string]interface{}{
+		"project":   map[string]string{"key": c.ProjectKey},
+		"issuetype": map[string]string{"name": c.IssueType},
+		"summary":   truncateSummary(card.Name),
+	}
+	if description := c.Description(card); description != "" {
+		fields["description"] = common.MarkdownToAdf(description)
+	}
+
+	labels := make([]string, 0, len(card.Labels))
+	for _, l := range card.Labels {
+		//jira labels can't contain spaces, and trello labels with only a colour have no name
+		if name := strings.Join(strings.Fields(l.Name), "_"); name != "" {
+			labels = append(labels, name)
+		}
+	}
+	if len(labels) > 0 {
+		fields["labels"] = labels
+	}
+
+	if epicName := optionText(card, c.EpicField); epicName != "" {
+		if epicKey, haveEpic := c.EpicKeys[epicName]; haveEpic {
+			fields[c.EpicLinkFieldId] = epicKey
+		} else {
+			warnings = append(warnings, fmt.Sprintf("no epic named '%s' in Jira", epicName))
+		}
+	}
+
+	if priorityText := optionText(card, c.PriorityField); priorityText != "" {
+		if priority, havePriority := c.Priorities[priorityText]; havePriority {
+			fields["priority"] = priority
+		} else {
+			fields["priority"] = map[string]string{"name": priorityText}
+		}
+	}
+
+	if card.Due != nil && *card.Due != "" {
+		if due, err := time.Parse(time.RFC3339, *card.Due); err == nil {
+			fields["duedate"] = due.Format("2006-01-02")
+		} else {
+			warnings = append(warnings, fmt.Sprintf("could not understand due date '%s'", *card.Due))
+		}
+	}
+	return fields, warnings
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*
+loadStatusMap reads a YAML mapping of Trello list names to Jira statuses
+*/
+func loadStatusMap(path string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mapping map[string]string
+	err = yaml.UnmarshalStrict(content, &mapping)
+	if err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+/*
+loadEpicKeys returns the key of each epic, keyed by its epic name. Epics without one are keyed by their summary.
+The ID of the epic link field is returned too, resolved from its display name or ID using the field names that Jira
+sent with the epics.
+*/
+func loadEpicKeys(jiraClient jira.Source, pageSize int, epicJql string, epicLinkField string) (map[string]string, string, error) {
+	epics, err := jiraClient.SyncLoadIssuesJQL(pageSize, epicJql)
+	if err != nil {
+		return nil, "", err
+	}
+	out := make(map[string]string, len(epics))
+	for _, e := range epics {
+		if e.Fields.EpicName != nil && *e.Fields.EpicName != "" {
+			out[*e.Fields.EpicName] = e.Key
+		} else {
+			out[e.Fields.Summary] = e.Key
+		}
+	}
+	return out, epicLinkFieldId(epics, epicLinkField), nil
+}
+
+/*
+epicLinkFieldId finds the ID of the epic link field from the names that Jira sent with the epics. If there were no
+epics to go on, a value that is already an ID is used as it is and anything else falls back to LegacyFieldIds.
+*/
+func epicLinkFieldId(epics []common.Issue, nameOrId string) string {
+	for i := range epics {
+		if len(epics[i].Names) == 0 {
+			continue
+		}
+		if id, found := epics[i].FieldId(nameOrId); found {
+			return id
+		}
+		break
+	}
+	if strings.HasPrefix(nameOrId, "customfield_") {
+		return nameOrId
+	}
+	log.Printf("WARNING Could not find the Jira field '%s', using %s as the epic link", nameOrId, common.LegacyFieldIds.EpicLink)
+	return common.LegacyFieldIds.EpicLink
+}
+
+func main() {
+	cfg := common.DefaultMigrationConfig()
+	configPath := flag.String("config", "", "Path to the YAML migration config file used for load-issues. Flags given on the command line override its settings")
+	flag.StringVar(&cfg.Jira.Credentials, "jira", cfg.Jira.Credentials, "Path to a file containing a Jira API key")
+	flag.StringVar(&cfg.Trello.Credentials, "trello", cfg.Trello.Credentials, "Path to a file containing a Trello API key")
+	flag.StringVar(&cfg.Jira.Host, "host", cfg.Jira.Host, "Jira host to create issues on, or its full base URL (including any context path) if it is not https")
+	flag.IntVar(&cfg.Jira.PageSize, "pagesize", cfg.Jira.PageSize, "number of epics to fetch in one page")
+	flag.StringVar(&cfg.Jira.EpicJql, "epicjql", cfg.Jira.EpicJql, "JQL query that finds the epics that cards can be linked to")
+	flag.StringVar(&cfg.Trello.Board, "board", cfg.Trello.Board, "Board ID to read cards from")
+	flag.StringVar(&cfg.Fields.Epic, "epicfield", cfg.Fields.Epic, "Name of the list custom field holding epics information")
+	flag.StringVar(&cfg.Fields.JiraKey, "jira-id", cfg.Fields.JiraKey, "Name of the custom field holding the jira ID of cards that came from Jira")
+	flag.StringVar(&cfg.Fields.Priority, "priority-field", cfg.Fields.Priority, "Name of the list custom field holding the priority")
+	projectKey := flag.String("project", "", "Key of the Jira project to create issues in")
+	issueType := flag.String("issuetype", "Task", "Issue type to create")
+	epicLinkField := flag.String("epic-link-field", "", "Name or ID of the Jira field that links an issue to its epic. Defaults to the epicLink field in the config's jira.fields")
+	statusMapPath := flag.String("statusmap", "", "Path to a YAML file mapping Trello list names to Jira statuses. Without one, the config's list statuses are used in reverse and anything else goes to the status with the same name as its list")
+	statePath := flag.String("state", "trello-to-jira-state.json", "Path to a file recording what has been migrated, so that a re-run carries on where the last one stopped")
+	includeArchived := flag.Bool("include-archived", false, "Migrate archived cards too")
+	includeMigrated := flag.Bool("include-migrated", false, "Migrate cards that came from Jira in the first place, i.e. that have a Jira key set")
+	dryRun := flag.Bool("dry-run", false, "List what would be migrated, without writing anything to Jira")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *projectKey == "" {
+		log.Fatal("You must specify a Jira project to create issues in with -project")
+	}
+	if *epicLinkField == "" {
+		*epicLinkField = cfg.Jira.Fields.EpicLink
+	}
+
+	statuses := InvertListStatuses(cfg.Lists.Statuses)
+	if *statusMapPath != "" {
+		fromFile, err := loadStatusMap(*statusMapPath)
+		if err != nil {
+			log.Fatalf("Could not load status mapping from '%s': %s", *statusMapPath, err)
+		}
+		for listName, status := range fromFile {
+			statuses[listName] = status
+		}
+	}
+
+	httpClient := common.SharedHttpClient()
+	jiraKey, err := common.LoadScriptKey(&cfg.Jira.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraAuth, err := jira.AuthFromScriptKey(jiraKey)
+	if err != nil {
+		log.Fatalf("Invalid scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraClient := jira.NewClient(jira.BaseUrlFor(cfg.Jira.Host), jiraAuth, httpClient)
+	jiraClient.FieldMapping = cfg.Jira.Fields
+
+	trelloKey, err := common.LoadScriptKey(&cfg.Trello.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Trello.Credentials, err)
+	}
+	trelloClient := trello.NewClient(trelloKey, httpClient)
+
+	listCache, err := trelloClient.NewListCache(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load lists from board '%s': %s", cfg.Trello.Board, err)
+	}
+	memberCache, err := trelloClient.NewMemberCache(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load members of board '%s': %s", cfg.Trello.Board, err)
+	}
+	customFieldCache, err := trelloClient.LoadAllCustomFields(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load custom fields from board '%s': %s", cfg.Trello.Board, err)
+	}
+	epicKeys, epicLinkFieldId, err := loadEpicKeys(jiraClient, cfg.Jira.PageSize, cfg.Jira.EpicJql, *epicLinkField)
+	if err != nil {
+		log.Fatalf("Could not load epics from Jira: %s", err)
+	}
+	log.Printf("INFO Loaded %d epics from Jira, linking issues to them with %s", len(epicKeys), epicLinkFieldId)
+
+	converter := NewCardConverter(*projectKey, *issueType, epicLinkFieldId, listCache, memberCache, customFieldCache, cfg.Fields.Epic, cfg.Fields.Priority, cfg.Fields.JiraKey, epicKeys, cfg.Priorities, statuses)
+	for name, field := range map[string]*common.TrelloCustomField{cfg.Fields.Epic: converter.EpicField, cfg.Fields.Priority: converter.PriorityField} {
+		if field == nil {
+			log.Printf("WARNING There is no '%s' field on board '%s', so it will not be migrated", name, cfg.Trello.Board)
+		}
+	}
+
+	cards, err := trelloClient.LoadAllCards(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load cards from board '%s': %s", cfg.Trello.Board, err)
+	}
+	//oldest first, so that issue keys follow the order the cards were created in
+	sort.SliceStable(cards, func(i, j int) bool { return cards[i].Id < cards[j].Id })
+	log.Printf("INFO Found %d cards on board '%s'", len(cards), cfg.Trello.Board)
+
+	var state *common.MigrationState
+	if !*dryRun {
+		state, err = common.LoadMigrationState(*statePath, common.TrelloToJira)
+		if err != nil {
+			log.Fatalf("Could not load state from '%s': %s", *statePath, err)
+		}
+	}
+	migrator := &Migrator{
+		JiraClient:   jiraClient,
+		TrelloClient: trelloClient,
+		Converter:    converter,
+		State:        state,
+	}
+
+	migrated := 0
+	skipped := 0
+	failed := 0
+	for i := range cards {
+		card := &cards[i]
+		if card.Closed && !*includeArchived {
+			skipped++
+			continue
+		}
+		if jiraKey := converter.JiraKey(card); jiraKey != "" && !*includeMigrated {
+			log.Printf("INFO Skipping '%s', it was migrated from %s", card.Name, jiraKey)
+			skipped++
+			continue
+		}
+		if *dryRun {
+			fields, warnings := converter.IssueFields(card)
+			fmt.Printf("%s\t%s\t%s\t%v\n", card.ShortUrl, converter.TargetStatus(card), fields[epicLinkFieldId], fields["summary"])
+			for _, w := range warnings {
+				log.Printf("WARNING '%s': %s", card.Name, w)
+			}
+			migrated++
+			continue
+		}
+		if cardState, haveState := state.Get(card.Id); haveState && cardState.Completed {
+			skipped++
+			continue
+		}
+
+		warnings, err := migrator.MigrateCard(card)
+		for _, w := range warnings {
+			log.Printf("WARNING '%s': %s", card.Name, w)
+		}
+		if stateErr := state.MarkCompleted(card.Id, err); stateErr != nil {
+			log.Fatalf("Could not save state to '%s': %s", *statePath, stateErr)
+		}
+		if err != nil {
+			log.Printf("ERROR Could not migrate '%s': %s", card.Name, err)
+			failed++
+			continue
+		}
+		migrated++
+	}
+
+	if *dryRun {
+		log.Printf("INFO Would migrate %d cards, skipping %d", migrated, skipped)
+		return
+	}
+	log.Printf("INFO Migrated %d cards, skipped %d, %d failed", migrated, skipped, failed)
+	if err = state.Close(); err != nil {
+		log.Printf("ERROR Could not write state to '%s', its changes are still in the journal next to it: %s", *statePath, err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		log.Printf("ERROR Some cards could not be migrated, run again to retry them")
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"strings"
+	"time"
+)
+
+/*
+Migrator creates a Jira issue for each card and copies its status, comments and attachments across, recording each
+step in the state so that a re-run picks up where the last one stopped
+*/
+type Migrator struct {
+	JiraClient   *jira.Client
+	TrelloClient *trello.Client
+	Converter    *CardConverter
+	State        *common.MigrationState
+}
+
+/*
+MigrateCard runs every step that has not been done yet for the given card. Problems that leave the issue usable,
+like a status with no transition to it, are returned as warnings; anything else stops the card and is returned as
+an error, to be retried on the next run.
+*/
+func (m *Migrator) MigrateCard(card *common.TrelloCardDetail) ([]string, error) {
+	warnings := make([]string, 0)
+	if !m.State.IsDone(card.Id, common.StepIssueCreated) {
+		fields, fieldWarnings := m.Converter.IssueFields(card)
+		warnings = append(warnings, fieldWarnings...)
+		created, err := m.JiraClient.CreateIssue(fields)
+		if err != nil {
+			return warnings, errors.New(fmt.Sprintf("could not create issue: %s", err))
+		}
+		if err = m.State.RecordIssue(card.Id, created.Key); err != nil {
+			return warnings, err
+		}
+		log.Printf("INFO Created %s for card '%s'", created.Key, card.Name)
+	}
+	cardState, _ := m.State.Get(card.Id)
+	issueKey := cardState.IssueKey
+
+	if !cardState.Steps[common.StepStatus] {
+		moved, err := m.moveToStatus(issueKey, m.Converter.TargetStatus(card))
+		if err != nil {
+			return warnings, err
+		}
+		if moved {
+			if err = m.State.MarkDone(card.Id, common.StepStatus); err != nil {
+				return warnings, err
+			}
+		} else {
+			warnings = append(warnings, fmt.Sprintf("no transition to status '%s'", m.Converter.TargetStatus(card)))
+		}
+	}
+
+	if card.Badges.Comments > 0 {
+		comments, err := m.TrelloClient.LoadComments(card.Id)
+		if err != nil {
+			return warnings, errors.New(fmt.Sprintf("could not load comments: %s", err))
+		}
+		for i := range comments {
+			if cardState.Steps[common.CommentStep(comments[i].Id)] {
+				continue
+			}
+			err = m.JiraClient.AddComment(issueKey, common.MarkdownToAdf(commentMarkdown(&comments[i])))
+			if err != nil {
+				return warnings, errors.New(fmt.Sprintf("could not copy comment %s: %s", comments[i].Id, err))
+			}
+			if err = m.State.MarkDone(card.Id, common.CommentStep(comments[i].Id)); err != nil {
+				return warnings, err
+			}
+		}
+	}
+
+	for i := range card.Attachments {
+		a := &card.Attachments[i]
+		if a.Bytes == nil || cardState.Steps[common.AttachmentStep(a.Id)] {
+			//links are written into the description instead
+			continue
+		}
+		if err := m.copyAttachment(card.Id, issueKey, a); err != nil {
+			return warnings, errors.New(fmt.Sprintf("could not copy attachment '%s': %s", a.Name, err))
+		}
+		if err := m.State.MarkDone(card.Id, common.AttachmentStep(a.Id)); err != nil {
+			return warnings, err
+		}
+	}
+
+	if !cardState.Steps[common.StepOriginComment] {
+		err := m.JiraClient.AddComment(issueKey, common.MarkdownToAdf(m.originMarkdown(card)))
+		if err != nil {
+			return warnings, errors.New(fmt.Sprintf("could not add origin comment: %s", err))
+		}
+		if err = m.State.MarkDone(card.Id, common.StepOriginComment); err != nil {
+			return warnings, err
+		}
+	}
+	return warnings, nil
+}
+
+/*
+moveToStatus transitions the issue to the named status, unless it is already there. Returns false if the workflow
+has no transition from the issue's current status to the target one.
+*/
+func (m *Migrator) moveToStatus(issueKey string, target string) (bool, error) {
+	current, err := m.JiraClient.LoadIssueStatus(issueKey)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("could not load status: %s", err))
+	}
+	if strings.EqualFold(current.Name, target) {
+		return true, nil
+	}
+	transitions, err := m.JiraClient.LoadTransitions(issueKey)
+	if err != nil {
+		return false, errors.New(fmt.Sprintf("could not load transitions: %s", err))
+	}
+	for _, t := range transitions {
+		if strings.EqualFold(t.To.Name, target) {
+			if err = m.JiraClient.TransitionIssue(issueKey, t.Id); err != nil {
+				return false, errors.New(fmt.Sprintf("could not move to '%s': %s", target, err))
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Migrator) copyAttachment(cardId string, issueKey string, attachment *common.TrelloAttachment) error {
+	content, err := m.TrelloClient.OpenTrelloAttachment(cardId, attachment)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return m.JiraClient.UploadJiraAttachment(issueKey, attachment.Name, jira.ExpectSize(content, *attachment.Bytes))
+}
+
+/*
+commentMarkdown prefixes the text of a Trello comment with who wrote it and when, since the copy is posted by
+whoever owns the Jira credentials
+*/
+func commentMarkdown(comment *common.TrelloComment) string {
+	author := comment.MemberCreator.FullName
+	if author == "" {
+		author = comment.MemberCreator.Username
+	}
+	date := comment.Date
+	if parsed, err := time.Parse(time.RFC3339, comment.Date); err == nil {
+		date = parsed.UTC().Format("2 Jan 2006 15:04 MST")
+	}
+	return fmt.Sprintf("*%s commented in Trello on %s:*\n\n%s", author, date, comment.Data.Text)
+}
+
+/*
+originMarkdown is the comment linking the issue back to the card it came from
+*/
+func (m *Migrator) originMarkdown(card *common.TrelloCardDetail) string {
+	text := fmt.Sprintf("Migrated from Trello card [%s](%s) on list *%s*.", card.ShortUrl, card.ShortUrl, m.Converter.ListName(card))
+	if jiraKey := m.Converter.JiraKey(card); jiraKey != "" {
+		text += fmt.Sprintf(" The card was originally migrated from %s.", jiraKey)
+	}
+	return text
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jiratest"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+TestMigrateCard sets up a board with a card that uses everything that can be migrated, copies it into a fake Jira
+and checks the issue, then checks that running again does not create a second one
+*/
+func TestMigrateCard(t *testing.T) {
+	trelloServer := trellotest.NewServer()
+	defer trelloServer.Close()
+	jiraServer, err := jiratest.NewServerWithFixture("sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jiraServer.Close()
+
+	doing := trelloServer.AddList("board1", "Doing")
+	label := trelloServer.AddLabel("board1", "needs review", "red")
+	member := trelloServer.AddMember("board1", common.TrelloMember{FullName: "Ann Example", Username: "ann"})
+	epicField := trelloServer.AddCustomField("board1", "Components", common.List, "Big Project", "Unknown Project")
+	priorityField := trelloServer.AddCustomField("board1", "Priority", common.List, "High")
+	trelloServer.AddCustomField("board1", "Jira Key", common.Text)
+	estimateField := trelloServer.AddCustomField("board1", "Estimate", common.Number)
+	trelloClient := trelloServer.Client()
+
+	card, err := trelloClient.PutTrelloCard(&common.NewTrelloCard{
+		ListId:      doing.Id,
+		Name:        "Make the login page faster",
+		Description: "It takes **ages**",
+		DueDate:     common.StringPtr("2021-06-01T12:00:00.000Z"),
+		Members:     []string{member.Id},
+		LabelIDs:    []string{label.Id},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trelloClient.SetCustomFieldValue(card.Id, epicField.Id, (*epicField.Options)[0].Id)
+	trelloClient.SetCustomFieldValue(card.Id, priorityField.Id, (*priorityField.Options)[0].Id)
+	trelloClient.SetCustomFieldNumber(card.Id, estimateField.Id, 3)
+	checklist, err := trelloClient.CreateChecklist(card.Id, "Steps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trelloClient.AddCheckItem(checklist.Id, "Profile it", true)
+	trelloClient.AddCheckItem(checklist.Id, "Fix it", false)
+	trelloClient.AddComment(card.Id, "Is this still a problem?")
+	trelloClient.AttachUrl(card.Id, "https://example.com/report", "Slow page report")
+	err = trelloClient.UploadAttachmentStream(card.Id, "trace.txt", "text/plain", strings.NewReader("sixteen bytes!!!"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jiraClient := jiraServer.Client()
+	listCache, _ := trelloClient.NewListCache("board1")
+	memberCache, _ := trelloClient.NewMemberCache("board1")
+	customFields, _ := trelloClient.LoadAllCustomFields("board1")
+	epicKeys, epicLinkFieldId, err := loadEpicKeys(jiraClient, 50, common.DefaultEpicJql, "Epic Link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if epicLinkFieldId != "customfield_10014" {
+		t.Fatalf("expected Epic Link to be customfield_10014, got %s", epicLinkFieldId)
+	}
+	converter := NewCardConverter("NEW", "Task", epicLinkFieldId, listCache, memberCache, customFields, "Components", "Priority", "Jira Key", epicKeys, nil, map[string]string{"Doing": "In Progress"})
+
+	stateDir, err := ioutil.TempDir("", "trello-to-jira")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	state, err := common.LoadMigrationState(filepath.Join(stateDir, "state.json"), common.TrelloToJira)
+	if err != nil {
+		t.Fatal(err)
+	}
+	migrator := &Migrator{JiraClient: jiraClient, TrelloClient: trelloClient, Converter: converter, State: state}
+
+	cards, err := trelloClient.LoadAllCards("board1")
+	if err != nil || len(cards) != 1 {
+		t.Fatalf("expected 1 card, got %d (%v)", len(cards), err)
+	}
+	warnings, err := migrator.MigrateCard(&cards[0])
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("MigrateCard failed: %v %v", warnings, err)
+	}
+
+	created := jiraServer.CreatedIssues()
+	if len(created) != 1 {
+		t.Fatalf("expected 1 issue to be created, got %d", len(created))
+	}
+	issue := created[0]
+	if issue.Status != "In Progress" {
+		t.Errorf("expected the issue to be moved to In Progress, it is in %s", issue.Status)
+	}
+	if issue.Fields["customfield_10014"] != "PROJ-100" || issue.Fields["duedate"] != "2021-06-01" {
+		t.Errorf("unexpected epic link or due date: %v", issue.Fields)
+	}
+	if !reflect.DeepEqual(issue.Fields["priority"], map[string]interface{}{"id": "2"}) {
+		t.Errorf("expected priority 2, got %v", issue.Fields["priority"])
+	}
+	if !reflect.DeepEqual(issue.Fields["labels"], []interface{}{"needs_review"}) {
+		t.Errorf("expected label needs_review, got %v", issue.Fields["labels"])
+	}
+	if len(issue.Attachments) != 1 || string(issue.Attachments[0].Content) != "sixteen bytes!!!" {
+		t.Errorf("expected trace.txt to be uploaded, got %v", issue.Attachments)
+	}
+
+	description := converter.Description(&cards[0])
+	for _, expected := range []string{"It takes **ages**", "- [x] Profile it", "[Slow page report](https://example.com/report)", "| Estimate | 3 |", "| Members | Ann Example |"} {
+		if !strings.Contains(description, expected) {
+			t.Errorf("expected the description to contain '%s', got:\n%s", expected, description)
+		}
+	}
+
+	comments := jiraServer.Comments(issue.Key)
+	if len(comments) != 2 || !strings.Contains(comments[0].Body.ToMarkdown(), "Is this still a problem?") ||
+		!strings.Contains(comments[1].Body.ToMarkdown(), cards[0].ShortUrl) {
+		t.Errorf("expected the Trello comment then the origin comment, got %d comments", len(comments))
+	}
+
+	if _, err = migrator.MigrateCard(&cards[0]); err != nil {
+		t.Fatal(err)
+	}
+	if len(jiraServer.CreatedIssues()) != 1 || len(jiraServer.Comments(issue.Key)) != 2 {
+		t.Error("expected a second run not to repeat anything")
+	}
+
+	//an epic that isn't in Jira is kept in the description instead
+	trelloClient.SetCustomFieldValue(card.Id, epicField.Id, (*epicField.Options)[1].Id)
+	cards, _ = trelloClient.LoadAllCards("board1")
+	fields, warnings := converter.IssueFields(&cards[0])
+	if _, haveEpic := fields["customfield_10014"]; haveEpic || len(warnings) != 1 {
+		t.Errorf("expected an unknown epic to be a warning, got %v", warnings)
+	}
+	if !strings.Contains(converter.Description(&cards[0]), "| Components | Unknown Project |") {
+		t.Error("expected an unknown epic to be kept in the description")
+	}
+}
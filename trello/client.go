@@ -0,0 +1,107 @@
+package trello
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DefaultBaseUrl = "https://api.trello.com/1"
+const DefaultUserAgent = "mm-jira-migration"
+
+/*
+Client holds everything needed to talk to the Trello REST API. All of the operations in this package are methods on it.
+BaseUrl can be pointed at a stand-in server for testing, and middleware can be added through HttpClient's Transport.
+*/
+type Client struct {
+	BaseUrl    string
+	Key        *common.ScriptKey //User is the API key, Key is the token
+	HttpClient *http.Client
+	Logger     *log.Logger
+	UserAgent  string
+}
+
+/*
+NewClient returns a Client for the real Trello API using the given credentials. If httpClient is nil then the shared
+rate-limited client is used.
+*/
+func NewClient(key *common.ScriptKey, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = common.SharedHttpClient()
+	}
+	return &Client{
+		BaseUrl:    DefaultBaseUrl,
+		Key:        key,
+		HttpClient: httpClient,
+		Logger:     log.Default(),
+		UserAgent:  DefaultUserAgent,
+	}
+}
+
+/*
+uri builds the full URL for the given API path (e.g. "/cards"), adding the credentials and any other parameters
+*/
+func (c *Client) uri(path string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("key", c.Key.User)
+	params.Set("token", c.Key.Key)
+	return strings.TrimSuffix(c.BaseUrl, "/") + path + "?" + params.Encode()
+}
+
+/*
+newRequest builds a request for the given API path with the credentials and user agent set
+*/
+func (c *Client) newRequest(method string, path string, params url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.uri(path, params), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	return req, nil
+}
+
+/*
+do makes the request and reads the whole response body. The body is closed before returning.
+*/
+func (c *Client) do(req *http.Request) (*http.Response, []byte, error) {
+	response, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+	responseContent, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, responseContent, nil
+}
+
+/*
+simpleRequest makes a request with no body and returns the response content if the server replied 200.
+`operation` is used to label log messages and errors.
+*/
+func (c *Client) simpleRequest(operation string, method string, path string, params url.Values) ([]byte, error) {
+	req, err := c.newRequest(method, path, params, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR %s server response was %s", operation, string(responseContent))
+		return nil, errors.New(fmt.Sprintf("%s server returned %d", operation, response.StatusCode))
+	}
+	return responseContent, nil
+}
@@ -1,55 +1,180 @@
 package trello
 
 import (
-	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/fredex42/mm-jira-migration/common"
 	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 )
 
-func UploadTrelloAttachment(cardId string, fileName string, jiraAttachment *common.Attachment, apiKey *common.ScriptKey, httpClient *http.Client) error {
-	uri := fmt.Sprintf("https://api.trello.com/1/cards/%s/attachments?key=%s&token=%s", cardId, apiKey.User, apiKey.Key)
+/*
+multipartBody returns a reader that produces a multipart form holding the content as the "file" part, and the
+content type to send with it. The form is written on the fly by a goroutine, so the content is never held in memory.
+If the reader is closed early, the goroutine stops. If boundary is empty then a random one is used.
+*/
+func multipartBody(fileName string, mimeType string, content io.Reader, boundary string) (io.ReadCloser, string) {
+	pipeReader, pipeWriter := io.Pipe()
+	writer := multipart.NewWriter(pipeWriter)
+	if boundary != "" {
+		writer.SetBoundary(boundary)
+	}
+	contentType := writer.FormDataContentType()
+
+	go func() {
+		part, err := writer.CreateFormFile("file", fileName)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = writer.WriteField("mimeType", mimeType)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pipeWriter.CloseWithError(err)
+	}()
+	return pipeReader, contentType
+}
 
-	file, err := os.Open(fileName)
+/*
+UploadAttachmentStream uploads the content as a file attached to the given card, streaming it straight through.
+The request can't be retried as the content can only be read once, so if it fails the caller should fall back to
+UploadTrelloAttachment.
+*/
+func (c *Client) UploadAttachmentStream(cardId string, fileName string, mimeType string, content io.Reader) error {
+	body, contentType := multipartBody(fileName, mimeType, content, "")
+	defer body.Close()
+
+	req, err := c.newRequest("POST", fmt.Sprintf("/cards/%s/attachments", cardId), nil, body)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile("file", jiraAttachment.Filename)
-	io.Copy(part, file)
-
-	mt, _ := writer.CreateFormField("mimeType")
-	mt.Write([]byte(jiraAttachment.MimeType))
+	req.Header.Set("Content-Type", contentType)
+	return c.sendAttachment(req, fileName)
+}
 
-	writer.Close()
+/*
+UploadTrelloAttachment uploads the content of a local file as an attachment called `name` on the given card.
+The file is read again if the request has to be retried. The caller is responsible for removing the file.
+*/
+func (c *Client) UploadTrelloAttachment(cardId string, localFile string, name string, mimeType string) error {
+	//every retry has to produce the same form, to match the Content-Type header
+	boundary := multipart.NewWriter(nil).Boundary()
+	openBody := func() (io.ReadCloser, error) {
+		file, err := os.Open(localFile)
+		if err != nil {
+			return nil, err
+		}
+		body, _ := multipartBody(name, mimeType, file, boundary)
+		return &fileBackedBody{body, file}, nil
+	}
 
-	req, err := http.NewRequest("POST", uri, body)
+	body, err := openBody()
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest("POST", fmt.Sprintf("/cards/%s/attachments", cardId), nil, body)
 	if err != nil {
+		body.Close()
 		return err
 	}
-	req.Header.Add("Content-Type", writer.FormDataContentType())
-	response, err := httpClient.Do(req)
+	req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+	req.GetBody = openBody
+	return c.sendAttachment(req, name)
+}
+
+/*
+fileBackedBody closes the file that a multipart body is being read from along with the body itself
+*/
+type fileBackedBody struct {
+	io.ReadCloser
+	file *os.File
+}
 
-	responseContent, err := ioutil.ReadAll(response.Body)
+func (b *fileBackedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.file.Close()
+}
+
+func (c *Client) sendAttachment(req *http.Request, name string) error {
+	response, responseContent, err := c.do(req)
 	if err != nil {
 		return err
 	}
 
 	if response.StatusCode != 200 {
-		log.Printf("ERROR Server responded %s", string(responseContent))
+		c.Logger.Printf("ERROR Server responded %s", string(responseContent))
 		return errors.New(fmt.Sprintf("could not create attachment, server responded with a %d", response.StatusCode))
 	}
 
-	log.Printf("INFO Uploaded attachment from %s to Trello for %s", fileName, jiraAttachment.Filename)
-	os.Remove(fileName)
+	c.Logger.Printf("INFO Uploaded attachment %s to Trello", name)
 	return nil
 }
+
+/*
+AttachUrl attaches a link to the given card. If the URL is another Trello card then Trello shows it as a linked card.
+*/
+func (c *Client) AttachUrl(cardId string, linkUrl string, name string) error {
+	req, err := c.newRequest("POST", fmt.Sprintf("/cards/%s/attachments", cardId), url.Values{"url": {linkUrl}, "name": {name}}, nil)
+	if err != nil {
+		return err
+	}
+	response, responseContent, err := c.do(req)
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode != 200 {
+		c.Logger.Printf("ERROR Server responded %s", string(responseContent))
+		return errors.New(fmt.Sprintf("could not attach url, server responded with a %d", response.StatusCode))
+	}
+	c.Logger.Printf("INFO Attached %s to card %s", linkUrl, cardId)
+	return nil
+}
+
+/*
+LoadAttachments returns the files and links attached to the given card
+*/
+func (c *Client) LoadAttachments(cardId string) ([]common.TrelloAttachment, error) {
+	responseContent, err := c.simpleRequest("LoadAttachments", "GET", fmt.Sprintf("/cards/%s/attachments", cardId), nil)
+	if err != nil {
+		return nil, err
+	}
+	var attachments []common.TrelloAttachment
+	err = json.Unmarshal(responseContent, &attachments)
+	if err != nil {
+		c.Logger.Printf("ERROR LoadAttachments invalid response was %s", string(responseContent))
+		return nil, err
+	}
+	return attachments, nil
+}
+
+/*
+OpenTrelloAttachment starts downloading the content of a file attached to the given card. Trello only accepts
+credentials in a header for downloads. The caller must close the returned body.
+*/
+func (c *Client) OpenTrelloAttachment(cardId string, attachment *common.TrelloAttachment) (io.ReadCloser, error) {
+	path := fmt.Sprintf("/cards/%s/attachments/%s/download/%s", cardId, attachment.Id, url.PathEscape(attachment.Name))
+	req, err := c.newRequest("GET", path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(`OAuth oauth_consumer_key="%s", oauth_token="%s"`, c.Key.User, c.Key.Key))
+
+	response, err := c.HttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != 200 {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		return nil, errors.New(fmt.Sprintf("could not download attachment, server responded with a %d", response.StatusCode))
+	}
+	return response.Body, nil
+}
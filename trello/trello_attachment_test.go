@@ -0,0 +1,83 @@
+package trello_test
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+newRetryingServer returns a fake Trello server, a client that goes through the retrying transport with a very short
+backoff, and a card to attach things to
+*/
+func newRetryingServer(t *testing.T) (*trellotest.Server, *trello.Client, *common.TrelloCard) {
+	server := trellotest.NewServer()
+	list := server.AddList("board1", "To Do")
+
+	transport := common.NewRateLimitedTransport(http.DefaultTransport)
+	transport.BaseDelay = time.Millisecond
+	transport.MaxDelay = time.Millisecond
+
+	card, err := server.Client().PutTrelloCard(&common.NewTrelloCard{ListId: list.Id, Name: "Card"})
+	if err != nil {
+		server.Close()
+		t.Fatalf("Could not create card: %s", err)
+	}
+	client := server.Client()
+	client.HttpClient = &http.Client{Transport: transport}
+	return server, client, card
+}
+
+func TestUploadTrelloAttachmentRetries(t *testing.T) {
+	server, client, card := newRetryingServer(t)
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "uploadtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("file content")
+	file.Close()
+
+	server.FailNext("POST", "/cards/"+card.Id+"/attachments", 429)
+	err = client.UploadTrelloAttachment(card.Id, file.Name(), "report.txt", "text/plain")
+	if err != nil {
+		t.Fatalf("Expected the upload to be retried, got %s", err)
+	}
+
+	attachment := server.AssertAttachment(t, "Card", "report.txt")
+	if string(attachment.Content) != "file content" || attachment.MimeType != "text/plain" {
+		t.Errorf("Attachment was not uploaded correctly: %+v", attachment)
+	}
+	if server.RequestCount("POST", "/cards/"+card.Id+"/attachments") != 2 {
+		t.Errorf("Expected 2 attempts, got %d", server.RequestCount("POST", "/cards/"+card.Id+"/attachments"))
+	}
+}
+
+func TestUploadAttachmentStream(t *testing.T) {
+	server, client, card := newRetryingServer(t)
+	defer server.Close()
+
+	err := client.UploadAttachmentStream(card.Id, "streamed.txt", "text/plain", strings.NewReader("streamed content"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	attachment := server.AssertAttachment(t, "Card", "streamed.txt")
+	if string(attachment.Content) != "streamed content" {
+		t.Errorf("Attachment was not uploaded correctly: %+v", attachment)
+	}
+
+	//a stream can't be replayed, so this has to be handed back to the caller
+	server.FailNext("POST", "/cards/"+card.Id+"/attachments", 429)
+	err = client.UploadAttachmentStream(card.Id, "again.txt", "text/plain", strings.NewReader("streamed content"))
+	if err == nil {
+		t.Error("Expected the failed stream upload to return an error")
+	}
+}
@@ -6,23 +6,23 @@ import (
 	"errors"
 	"fmt"
 	"github.com/fredex42/mm-jira-migration/common"
-	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
-func PutTrelloCard(definition *common.NewTrelloCard, apiKey *common.ScriptKey, httpClient *http.Client) (*common.TrelloCard, error) {
-	uri := fmt.Sprintf("https://api.trello.com/1/cards?key=%s&token=%s", apiKey.User, apiKey.Key)
-	uri += "&" + definition.ToQueryParams()
-
-	log.Printf("URI is %s", uri)
-	response, err := httpClient.Post(uri, "", nil)
+func (c *Client) PutTrelloCard(definition *common.NewTrelloCard) (*common.TrelloCard, error) {
+	params, err := url.ParseQuery(definition.ToQueryParams())
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest("POST", "/cards", params, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	responseContent, err := ioutil.ReadAll(response.Body)
+	response, responseContent, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -31,13 +31,13 @@ func PutTrelloCard(definition *common.NewTrelloCard, apiKey *common.ScriptKey, h
 		var out common.TrelloCard
 		err = json.Unmarshal(responseContent, &out)
 		if err != nil {
-			log.Printf("ERROR Invalid content was %s", string(responseContent))
+			c.Logger.Printf("ERROR Invalid content was %s", string(responseContent))
 			return nil, errors.New("could not understand server response")
 		}
-		log.Printf("INFO PutTrelloCard created new card '%s' at '%s'", out.Name, out.ShortUrl)
+		c.Logger.Printf("INFO PutTrelloCard created new card '%s' at '%s'", out.Name, out.ShortUrl)
 		return &out, nil
 	} else {
-		log.Print("ERROR Server response was ", string(responseContent))
+		c.Logger.Print("ERROR Server response was ", string(responseContent))
 		msg := fmt.Sprintf("server returned %d when trying to create a card", response.StatusCode)
 		return nil, errors.New(msg)
 	}
@@ -47,27 +47,51 @@ func PutTrelloCard(definition *common.NewTrelloCard, apiKey *common.ScriptKey, h
 SetCustomFieldValue sets the value for a "list" type customfield on a card.
 
 - cardId ID of the card to set
-- fieldId ID of the customfield to set (this is NOT the name. Use trello.LoadAllCustomFields to get a CustomFieldCache to look up this value.
+- fieldId ID of the customfield to set (this is NOT the name. Use Client.LoadAllCustomFields to get a CustomFieldCache to look up this value.
 - value ID of the value to set. This must be a pre-existing value or the server returns 400. Look it up from the `options` list of a TrelloCustomField instance
-- trelloKey common.ScriptKey pointer giving API credentials
-- httpClient http client instance to use. This enables connection re-use
 */
-func SetCustomFieldValue(cardId string, fieldId string, value string, trelloKey *common.ScriptKey, httpClient *http.Client) error {
-	uri := fmt.Sprintf("https://api.trello.com/1/cards/%s/customField/%s/item?key=%s&token=%s&idValue=%s", cardId, fieldId, trelloKey.User, trelloKey.Key, url.QueryEscape(value))
-	req, err := http.NewRequest("PUT", uri, nil)
+func (c *Client) SetCustomFieldValue(cardId string, fieldId string, value string) error {
+	req, err := c.newRequest("PUT", fmt.Sprintf("/cards/%s/customField/%s/item", cardId, fieldId), url.Values{"idValue": {value}}, nil)
 	if err != nil {
 		return err
 	}
 
-	return internalSetCustomField(req, httpClient)
+	return c.internalSetCustomField(req)
+}
+
+func (c *Client) SetCustomFieldText(cardId string, fieldId string, value string) error {
+	return c.setCustomFieldItem(cardId, fieldId, "text", value)
+}
+
+/*
+SetCustomFieldNumber sets the value for a "number" type customfield on a card
+*/
+func (c *Client) SetCustomFieldNumber(cardId string, fieldId string, value float64) error {
+	return c.setCustomFieldItem(cardId, fieldId, "number", strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+/*
+SetCustomFieldDate sets the value for a "date" type customfield on a card
+*/
+func (c *Client) SetCustomFieldDate(cardId string, fieldId string, value time.Time) error {
+	return c.setCustomFieldItem(cardId, fieldId, "date", value.UTC().Format("2006-01-02T15:04:05.000Z"))
 }
 
-func SetCustomFieldText(cardId string, fieldId string, value string, trelloKey *common.ScriptKey, httpClient *http.Client) error {
-	uri := fmt.Sprintf("https://api.trello.com/1/cards/%s/customField/%s/item?key=%s&token=%s", cardId, fieldId, trelloKey.User, trelloKey.Key)
+/*
+SetCustomFieldCheckbox ticks or clears a "checkbox" type customfield on a card
+*/
+func (c *Client) SetCustomFieldCheckbox(cardId string, fieldId string, checked bool) error {
+	return c.setCustomFieldItem(cardId, fieldId, "checked", strconv.FormatBool(checked))
+}
 
+/*
+setCustomFieldItem sets a non-list customfield on a card. Trello takes every type of value as a string, under a key
+that depends on the type of the field.
+*/
+func (c *Client) setCustomFieldItem(cardId string, fieldId string, valueKey string, value string) error {
 	contentDict := map[string]interface{}{
 		"value": map[string]string{
-			"text": value,
+			valueKey: value,
 		},
 	}
 
@@ -76,22 +100,36 @@ func SetCustomFieldText(cardId string, fieldId string, value string, trelloKey *
 		return err
 	}
 	reader := bytes.NewReader(contentBody)
-	req, err := http.NewRequest("PUT", uri, reader)
+	req, err := c.newRequest("PUT", fmt.Sprintf("/cards/%s/customField/%s/item", cardId, fieldId), nil, reader)
+	if err != nil {
+		return err
+	}
 	req.Header.Add("Content-Type", "application/json")
-	return internalSetCustomField(req, httpClient)
+	return c.internalSetCustomField(req)
 }
 
-func internalSetCustomField(req *http.Request, httpClient *http.Client) error {
-	response, err := httpClient.Do(req)
+/*
+ClearCustomField removes the value of any type of customfield from a card
+*/
+func (c *Client) ClearCustomField(cardId string, fieldId string) error {
+	contentBody, err := json.Marshal(map[string]string{"idValue": "", "value": ""})
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest("PUT", fmt.Sprintf("/cards/%s/customField/%s/item", cardId, fieldId), nil, bytes.NewReader(contentBody))
 	if err != nil {
 		return err
 	}
+	req.Header.Add("Content-Type", "application/json")
+	return c.internalSetCustomField(req)
+}
 
-	responseContent, err := ioutil.ReadAll(response.Body)
+func (c *Client) internalSetCustomField(req *http.Request) error {
+	response, responseContent, err := c.do(req)
 	if err != nil {
 		return err
 	}
-	log.Printf("INFO SetupLinkField server returned %d %s", response.StatusCode, string(responseContent))
+	c.Logger.Printf("INFO SetupLinkField server returned %d %s", response.StatusCode, string(responseContent))
 
 	if response.StatusCode == 200 {
 		return nil
@@ -100,22 +138,165 @@ func internalSetCustomField(req *http.Request, httpClient *http.Client) error {
 		return errors.New(msg)
 	}
 }
-func AddComment(cardId string, content string, trelloKey *common.ScriptKey, httpClient *http.Client) error {
-	uri := fmt.Sprintf("https://api.trello.com/1/cards/%s/actions/comments?key=%s&token=%s&text=%s", cardId, trelloKey.User, trelloKey.Key, url.QueryEscape(content))
 
-	response, err := httpClient.Post(uri, "", nil)
+func (c *Client) AddComment(cardId string, content string) error {
+	req, err := c.newRequest("POST", fmt.Sprintf("/cards/%s/actions/comments", cardId), url.Values{"text": {content}}, nil)
 	if err != nil {
 		return err
 	}
-	responseContent, err := ioutil.ReadAll(response.Body)
+	response, responseContent, err := c.do(req)
 	if err != nil {
 		return err
 	}
-	log.Printf("INFO AddComment server returned %d", response.StatusCode)
+	c.Logger.Printf("INFO AddComment server returned %d", response.StatusCode)
 	if response.StatusCode == 200 {
 		return nil
 	} else {
-		log.Printf("ERROR AddComment server said %s", string(responseContent))
+		c.Logger.Printf("ERROR AddComment server said %s", string(responseContent))
 		return errors.New(fmt.Sprintf("server returned %d", response.StatusCode))
 	}
 }
+
+// the most comments that Trello returns in one request
+const cardCommentsLimit = 1000
+
+/*
+LoadComments returns the comments on a card, oldest first. Trello only returns the most recent 1000.
+*/
+func (c *Client) LoadComments(cardId string) ([]common.TrelloComment, error) {
+	responseContent, err := c.simpleRequest("LoadComments", "GET", fmt.Sprintf("/cards/%s/actions", cardId), url.Values{
+		"filter": {"commentCard"},
+		"limit":  {strconv.Itoa(cardCommentsLimit)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var comments []common.TrelloComment
+	err = json.Unmarshal(responseContent, &comments)
+	if err != nil {
+		c.Logger.Printf("ERROR LoadComments invalid response was %s", string(responseContent))
+		return nil, err
+	}
+	if len(comments) == cardCommentsLimit {
+		c.Logger.Printf("WARNING LoadComments card %s has more than %d comments, only the most recent were loaded", cardId, cardCommentsLimit)
+	}
+	//actions come newest first
+	for i, j := 0, len(comments)-1; i < j; i, j = i+1, j-1 {
+		comments[i], comments[j] = comments[j], comments[i]
+	}
+	return comments, nil
+}
+
+/*
+UpdateCard overwrites the name, description, list and due date of an existing card from the given definition,
+leaving its members, labels and position alone. An empty ListId leaves the card in its current list, and a nil
+DueDate clears the due date.
+*/
+func (c *Client) UpdateCard(cardId string, definition *common.NewTrelloCard) (*common.TrelloCard, error) {
+	params := url.Values{
+		"name":        {definition.Name},
+		"desc":        {definition.Description},
+		"due":         {"null"},
+		"dueComplete": {"false"},
+	}
+	if definition.ListId != "" {
+		params.Set("idList", definition.ListId)
+	}
+	if definition.DueDate != nil {
+		params.Set("due", *definition.DueDate)
+	}
+	if definition.DueComplete != nil {
+		params.Set("dueComplete", strconv.FormatBool(*definition.DueComplete))
+	}
+	responseContent, err := c.simpleRequest("UpdateCard", "PUT", "/cards/"+cardId, params)
+	if err != nil {
+		return nil, err
+	}
+	var card common.TrelloCard
+	err = json.Unmarshal(responseContent, &card)
+	if err != nil {
+		c.Logger.Printf("ERROR UpdateCard invalid response was %s", string(responseContent))
+		return nil, err
+	}
+	return &card, nil
+}
+
+/*
+ArchiveCard sets the "closed" flag on a card, which archives it. Archived cards are hidden from the board but can be
+restored from the Trello UI.
+*/
+func (c *Client) ArchiveCard(cardId string) error {
+	_, err := c.simpleRequest("ArchiveCard", "PUT", "/cards/"+cardId, url.Values{"closed": {"true"}})
+	if err == nil {
+		c.Logger.Printf("INFO ArchiveCard archived card %s", cardId)
+	}
+	return err
+}
+
+/*
+RestoreCard clears the "closed" flag on a card, which puts an archived card back on the board
+*/
+func (c *Client) RestoreCard(cardId string) error {
+	_, err := c.simpleRequest("RestoreCard", "PUT", "/cards/"+cardId, url.Values{"closed": {"false"}})
+	if err == nil {
+		c.Logger.Printf("INFO RestoreCard restored card %s", cardId)
+	}
+	return err
+}
+
+/*
+DeleteCard permanently deletes a card, along with its comments, attachments and checklists. This can't be undone;
+use ArchiveCard if the card might be wanted again.
+*/
+func (c *Client) DeleteCard(cardId string) error {
+	_, err := c.simpleRequest("DeleteCard", "DELETE", "/cards/"+cardId, nil)
+	if err == nil {
+		c.Logger.Printf("INFO DeleteCard deleted card %s", cardId)
+	}
+	return err
+}
+
+// the most cards that Trello returns in one request
+const boardCardsPageSize = 1000
+
+/*
+LoadAllCards returns every card on the board, including archived ones, with their custom field values, attachments
+and checklists. Trello returns cards a page at a time so this keeps asking for older cards until it has them all.
+*/
+func (c *Client) LoadAllCards(boardId string) ([]common.TrelloCardDetail, error) {
+	cards := make([]common.TrelloCardDetail, 0)
+	before := ""
+	for {
+		params := url.Values{
+			"customFieldItems":  {"true"},
+			"attachments":       {"true"},
+			"attachment_fields": {"name,url,bytes,mimeType"},
+			"checklists":        {"all"},
+			"limit":             {strconv.Itoa(boardCardsPageSize)},
+		}
+		if before != "" {
+			params.Set("before", before)
+		}
+		responseContent, err := c.simpleRequest("LoadAllCards", "GET", fmt.Sprintf("/boards/%s/cards/all", boardId), params)
+		if err != nil {
+			return nil, err
+		}
+		var page []common.TrelloCardDetail
+		err = json.Unmarshal(responseContent, &page)
+		if err != nil {
+			c.Logger.Printf("ERROR LoadAllCards invalid response was %s", string(responseContent))
+			return nil, err
+		}
+		cards = append(cards, page...)
+		if len(page) < boardCardsPageSize {
+			return cards, nil
+		}
+		//card IDs start with their creation time, so the smallest one is the oldest card on the page
+		before = page[0].Id
+		for _, card := range page {
+			if card.Id < before {
+				before = card.Id
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package trello
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/url"
+	"strconv"
+)
+
+/*
+CreateChecklist adds a new, empty checklist with the given name to the bottom of a card
+*/
+func (c *Client) CreateChecklist(cardId string, name string) (*common.TrelloChecklist, error) {
+	responseContent, err := c.simpleRequest("CreateChecklist", "POST", "/checklists", url.Values{
+		"idCard": {cardId},
+		"name":   {name},
+		"pos":    {"bottom"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var checklist common.TrelloChecklist
+	err = json.Unmarshal(responseContent, &checklist)
+	if err != nil {
+		c.Logger.Printf("ERROR CreateChecklist invalid response was %s", string(responseContent))
+		return nil, err
+	}
+	return &checklist, nil
+}
+
+/*
+AddCheckItem adds an item to the bottom of a checklist, optionally already ticked
+*/
+func (c *Client) AddCheckItem(checklistId string, name string, checked bool) (*common.TrelloCheckItem, error) {
+	responseContent, err := c.simpleRequest("AddCheckItem", "POST", fmt.Sprintf("/checklists/%s/checkItems", checklistId), url.Values{
+		"name":    {name},
+		"checked": {strconv.FormatBool(checked)},
+		"pos":     {"bottom"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var item common.TrelloCheckItem
+	err = json.Unmarshal(responseContent, &item)
+	if err != nil {
+		c.Logger.Printf("ERROR AddCheckItem invalid response was %s", string(responseContent))
+		return nil, err
+	}
+	return &item, nil
+}
+
+/*
+SetCheckItemState ticks or un-ticks an item on a checklist belonging to the given card
+*/
+func (c *Client) SetCheckItemState(cardId string, checkItemId string, state common.CheckItemState) error {
+	_, err := c.simpleRequest("SetCheckItemState", "PUT", fmt.Sprintf("/cards/%s/checkItem/%s", cardId, checkItemId), url.Values{
+		"state": {string(state)},
+	})
+	return err
+}
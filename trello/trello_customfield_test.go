@@ -0,0 +1,97 @@
+package trello_test
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"testing"
+	"time"
+)
+
+func makeEpic(key string, name string) common.Issue {
+	return common.Issue{
+		Key: key,
+		Fields: common.IssueFields{
+			Summary:  name,
+			EpicName: common.StringPtr(name),
+		},
+	}
+}
+
+func TestSetupEpicsFieldCreatesField(t *testing.T) {
+	server := trellotest.NewServer()
+	defer server.Close()
+
+	epics := []common.Issue{
+		makeEpic("PROJ-3", "Zebra"),
+		makeEpic("PROJ-1", "Aardvark"),
+		{Key: "PROJ-2", Fields: common.IssueFields{Summary: "No epic name"}},
+	}
+	field, err := server.Client().SetupEpicsField("board1", "Epic", &epics, nil)
+	if err != nil {
+		t.Fatalf("SetupEpicsField failed: %s", err)
+	}
+	if field.Name != "Epic" || field.Type != common.List {
+		t.Errorf("unexpected field returned: %+v", field)
+	}
+
+	created, haveField := server.CustomField("board1", "Epic")
+	if !haveField {
+		t.Fatal("expected the Epic field to be created on the board")
+	}
+	if len(*created.Options) != 2 {
+		t.Fatalf("expected 2 options, got %d", len(*created.Options))
+	}
+	if (*created.Options)[0].Value.Text != "Aardvark" || (*created.Options)[1].Value.Text != "Zebra" {
+		t.Errorf("expected options to be sorted by epic name, got %+v", *created.Options)
+	}
+}
+
+func TestSetupEpicsFieldUsesExistingField(t *testing.T) {
+	server := trellotest.NewServer()
+	defer server.Close()
+	existing := server.AddCustomField("board1", "Epic", common.List)
+
+	epics := []common.Issue{makeEpic("PROJ-1", "Aardvark")}
+	field, err := server.Client().SetupEpicsField("board1", "Epic", &epics, nil)
+	if err != nil {
+		t.Fatalf("SetupEpicsField failed: %s", err)
+	}
+	if field.Id != existing.Id {
+		t.Errorf("expected the existing field %s to be used, got %s", existing.Id, field.Id)
+	}
+	if server.RequestCount("POST", "/customFields") != 1 { //just the one option, no new field
+		t.Errorf("expected no new custom field to be created")
+	}
+	updated, _ := server.CustomField("board1", "Epic")
+	if _, err := updated.FindInCustomField("Aardvark"); err != nil {
+		t.Errorf("expected the epic to be added as an option: %s", err)
+	}
+}
+
+func TestSetCustomFieldTypes(t *testing.T) {
+	server := trellotest.NewServer()
+	defer server.Close()
+	list := server.AddList("board1", "To Do")
+	number := server.AddCustomField("board1", "Points", common.Number)
+	date := server.AddCustomField("board1", "Started", common.Date)
+	checkbox := server.AddCustomField("board1", "Reviewed", common.Checkbox)
+
+	client := server.Client()
+	card, err := client.PutTrelloCard(&common.NewTrelloCard{ListId: list.Id, Name: "Card"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = client.SetCustomFieldNumber(card.Id, number.Id, 2.5); err != nil {
+		t.Errorf("could not set number: %s", err)
+	}
+	if err = client.SetCustomFieldDate(card.Id, date.Id, time.Date(2021, 3, 4, 10, 30, 0, 0, time.FixedZone("", 3600))); err != nil {
+		t.Errorf("could not set date: %s", err)
+	}
+	if err = client.SetCustomFieldCheckbox(card.Id, checkbox.Id, true); err != nil {
+		t.Errorf("could not set checkbox: %s", err)
+	}
+
+	server.AssertCustomFieldValue(t, "Card", "Points", "number", "2.5")
+	server.AssertCustomFieldValue(t, "Card", "Started", "date", "2021-03-04T09:30:00.000Z")
+	server.AssertCustomFieldValue(t, "Card", "Reviewed", "checked", "true")
+}
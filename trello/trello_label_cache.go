@@ -4,16 +4,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/fredex42/mm-jira-migration/common"
-	"io/ioutil"
-	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 )
 
+// the most labels that Trello returns in one request. Without a limit it only returns 50
+const boardLabelsLimit = 1000
+
 type TrelloLabelCache struct {
-	Labels map[string]common.TrelloLabel
+	BoardId string
+	Labels  map[string]common.TrelloLabel
+	client  *Client
+	mutex   sync.Mutex
 }
 
 /*
-TrelloLabelCache.lookup returns the TrelloLabel with the given name or an empty trello label with false
+TrelloLabelCache.lookup returns the TrelloLabel with the given name or an empty trello label with false.
+The caller must hold the mutex.
 */
 func (c *TrelloLabelCache) lookup(name string) (common.TrelloLabel, bool) {
 	content, haveContent := c.Labels[name]
@@ -21,17 +29,13 @@ func (c *TrelloLabelCache) lookup(name string) (common.TrelloLabel, bool) {
 }
 
 /*
-NewTrelloLabelCache initialises a new label cache object with the label contents of the given board
+NewTrelloLabelCache initialises a new label cache object with the label contents of the given board. Trello only
+returns the first 1000 labels of a board.
 */
-func NewTrelloLabelCache(boardId string, key *common.ScriptKey) (*TrelloLabelCache, error) {
-	uri := fmt.Sprintf("https://api.trello.com/1/boards/%s/labels?key=%s&token=%s", boardId, key.User, key.Key)
-
-	response, err := http.Get(uri)
-	if err != nil {
-		return nil, err
-	}
-
-	contentBody, err := ioutil.ReadAll(response.Body)
+func (c *Client) NewTrelloLabelCache(boardId string) (*TrelloLabelCache, error) {
+	contentBody, err := c.simpleRequest("NewTrelloLabelCache", "GET", fmt.Sprintf("/boards/%s/labels", boardId), url.Values{
+		"limit": {strconv.Itoa(boardLabelsLimit)},
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -41,9 +45,14 @@ func NewTrelloLabelCache(boardId string, key *common.ScriptKey) (*TrelloLabelCac
 	if err != nil {
 		return nil, err
 	}
+	if len(rawList) == boardLabelsLimit {
+		c.Logger.Printf("WARNING NewTrelloLabelCache board %s has more than %d labels, labels beyond those may be created again", boardId, boardLabelsLimit)
+	}
 
 	cache := TrelloLabelCache{
-		Labels: make(map[string]common.TrelloLabel, len(rawList)),
+		BoardId: boardId,
+		Labels:  make(map[string]common.TrelloLabel, len(rawList)),
+		client:  c,
 	}
 
 	for _, l := range rawList {
@@ -51,3 +60,34 @@ func NewTrelloLabelCache(boardId string, key *common.ScriptKey) (*TrelloLabelCac
 	}
 	return &cache, nil
 }
+
+/*
+Lookup returns the TrelloLabel with the given name or an empty trello label with false
+*/
+func (c *TrelloLabelCache) Lookup(name string) (common.TrelloLabel, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lookup(name)
+}
+
+/*
+FindOrCreate returns the label with the given name, creating it on the board with the given colour if it does not
+exist yet. The created label is added to the cache so that it is only ever created once.
+Returns the label and a flag that is true if it was newly created.
+*/
+func (c *TrelloLabelCache) FindOrCreate(name string, maybeColour string) (common.TrelloLabel, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if existing, haveExisting := c.lookup(name); haveExisting {
+		return existing, false, nil
+	}
+
+	created, err := c.client.CreateLabel(c.BoardId, name, maybeColour)
+	if err != nil {
+		return common.TrelloLabel{}, false, err
+	}
+	c.client.Logger.Printf("INFO Created label '%s' on board %s", name, c.BoardId)
+	c.Labels[created.Name] = *created
+	return *created, true, nil
+}
@@ -0,0 +1,26 @@
+package trello_test
+
+import (
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"testing"
+)
+
+func TestLabelCacheLoadsMoreThanDefaultPage(t *testing.T) {
+	server := trellotest.NewServer()
+	defer server.Close()
+	for i := 0; i < 60; i++ {
+		server.AddLabel("board1", fmt.Sprintf("label %d", i), "green")
+	}
+
+	cache, err := server.Client().NewTrelloLabelCache("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.Labels) != 60 {
+		t.Errorf("expected all 60 labels to be loaded, got %d", len(cache.Labels))
+	}
+	if _, created, err := cache.FindOrCreate("label 59", "green"); err != nil || created {
+		t.Errorf("expected 'label 59' to be found rather than created (%v)", err)
+	}
+}
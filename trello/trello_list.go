@@ -0,0 +1,30 @@
+package trello
+
+import (
+	"encoding/json"
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/url"
+)
+
+/*
+CreateList creates a new list with the given name at the right-hand end of the board
+*/
+func (c *Client) CreateList(boardId string, name string) (*common.TrelloList, error) {
+	responseContent, err := c.simpleRequest("CreateList", "POST", "/lists", url.Values{
+		"idBoard": {boardId},
+		"name":    {name},
+		"pos":     {"bottom"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var list common.TrelloList
+	err = json.Unmarshal(responseContent, &list)
+	if err != nil {
+		c.Logger.Printf("ERROR CreateList invalid response was %s", string(responseContent))
+		return nil, err
+	}
+	c.Logger.Printf("INFO CreateList created list '%s' on board %s", list.Name, boardId)
+	return &list, nil
+}
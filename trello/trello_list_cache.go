@@ -2,48 +2,34 @@ package trello
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/fredex42/mm-jira-migration/common"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"sync"
 )
 
 type ListCache struct {
-	BoardId string
+	BoardId    string
+	mutex      sync.RWMutex
 	knownLists map[string]common.TrelloList
-	listsById map[string]common.TrelloList
+	listsById  map[string]common.TrelloList
 }
 
-func GetListsForBoard(boardId string, apiKey *common.ScriptKey, httpClient *http.Client) ([]common.TrelloList, error) {
-	uri := fmt.Sprintf("https://api.trello.com/1/boards/%s/lists?key=%s&token=%s", boardId, apiKey.User, apiKey.Key)
-
-	response, err := httpClient.Get(uri)
+func (c *Client) GetListsForBoard(boardId string) ([]common.TrelloList, error) {
+	responseContent, err := c.simpleRequest("GetListsForBoard", "GET", fmt.Sprintf("/boards/%s/lists", boardId), nil)
 	if err != nil {
 		return nil, err
 	}
-	responseContent, err := ioutil.ReadAll(response.Body)
+	var out []common.TrelloList
+	err = json.Unmarshal(responseContent, &out)
 	if err != nil {
 		return nil, err
-	}
-	if response.StatusCode==200 {
-		var out []common.TrelloList
-		err = json.Unmarshal(responseContent, &out)
-		if err != nil {
-			return nil, err
-		} else {
-			return out, nil
-		}
 	} else {
-		log.Printf("ERROR GetListsForBoard server response was %s", string(responseContent))
-		msg := fmt.Sprintf("GetListsForBoard server returned %d", response.StatusCode)
-		return nil, errors.New(msg)
+		return out, nil
 	}
 }
 
-func NewListCache(boardId string, apiKey *common.ScriptKey, httpClient *http.Client) (*ListCache, error) {
-	content, err := GetListsForBoard(boardId, apiKey, httpClient)
+func (c *Client) NewListCache(boardId string) (*ListCache, error) {
+	content, err := c.GetListsForBoard(boardId)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +37,7 @@ func NewListCache(boardId string, apiKey *common.ScriptKey, httpClient *http.Cli
 	cache := &ListCache{
 		BoardId:    boardId,
 		knownLists: make(map[string]common.TrelloList, len(content)),
-		listsById: make(map[string]common.TrelloList, len(content)),
+		listsById:  make(map[string]common.TrelloList, len(content)),
 	}
 	for _, l := range content {
 		cache.knownLists[l.Name] = l
@@ -60,16 +46,32 @@ func NewListCache(boardId string, apiKey *common.ScriptKey, httpClient *http.Cli
 	return cache, nil
 }
 
-func (c *ListCache) FindByName(listName string) (common.TrelloList, bool){
+func (c *ListCache) FindByName(listName string) (common.TrelloList, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	content, haveResult := c.knownLists[listName]
 	return content, haveResult
 }
 
 func (c *ListCache) FindById(listId string) (common.TrelloList, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	content, haveResult := c.listsById[listId]
 	return content, haveResult
 }
 
 func (c *ListCache) Count() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
 	return len(c.knownLists)
-}
\ No newline at end of file
+}
+
+/*
+Add puts a newly created list into the cache
+*/
+func (c *ListCache) Add(list common.TrelloList) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.knownLists[list.Name] = list
+	c.listsById[list.Id] = list
+}
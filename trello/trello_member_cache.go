@@ -0,0 +1,84 @@
+package trello
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"net/url"
+	"strings"
+)
+
+/*
+MemberCache holds the members of a board, indexed by the various ways that we can look them up
+*/
+type MemberCache struct {
+	BoardId    string
+	byId       map[string]common.TrelloMember
+	byUsername map[string]common.TrelloMember
+	byFullName map[string]common.TrelloMember
+	byEmail    map[string]common.TrelloMember
+}
+
+func (c *Client) GetBoardMembers(boardId string) ([]common.TrelloMember, error) {
+	responseContent, err := c.simpleRequest("GetBoardMembers", "GET", fmt.Sprintf("/boards/%s/members", boardId), url.Values{"fields": {"fullName,username,email"}})
+	if err != nil {
+		return nil, err
+	}
+	var out []common.TrelloMember
+	err = json.Unmarshal(responseContent, &out)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+/*
+NewMemberCache loads the members of the given board. Usernames, full names and emails are matched case-insensitively.
+*/
+func (c *Client) NewMemberCache(boardId string) (*MemberCache, error) {
+	content, err := c.GetBoardMembers(boardId)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := &MemberCache{
+		BoardId:    boardId,
+		byId:       make(map[string]common.TrelloMember, len(content)),
+		byUsername: make(map[string]common.TrelloMember, len(content)),
+		byFullName: make(map[string]common.TrelloMember, len(content)),
+		byEmail:    make(map[string]common.TrelloMember, len(content)),
+	}
+	for _, m := range content {
+		cache.byId[m.Id] = m
+		cache.byUsername[strings.ToLower(m.Username)] = m
+		cache.byFullName[strings.ToLower(m.FullName)] = m
+		if m.Email != nil && *m.Email != "" {
+			cache.byEmail[strings.ToLower(*m.Email)] = m
+		}
+	}
+	return cache, nil
+}
+
+func (c *MemberCache) FindById(memberId string) (common.TrelloMember, bool) {
+	content, haveResult := c.byId[memberId]
+	return content, haveResult
+}
+
+func (c *MemberCache) FindByUsername(username string) (common.TrelloMember, bool) {
+	content, haveResult := c.byUsername[strings.ToLower(strings.TrimPrefix(username, "@"))]
+	return content, haveResult
+}
+
+func (c *MemberCache) FindByFullName(fullName string) (common.TrelloMember, bool) {
+	content, haveResult := c.byFullName[strings.ToLower(fullName)]
+	return content, haveResult
+}
+
+func (c *MemberCache) FindByEmail(email string) (common.TrelloMember, bool) {
+	content, haveResult := c.byEmail[strings.ToLower(email)]
+	return content, haveResult
+}
+
+func (c *MemberCache) Count() int {
+	return len(c.byId)
+}
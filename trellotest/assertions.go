@@ -0,0 +1,234 @@
+package trellotest
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"strings"
+	"testing"
+)
+
+/*
+Cards returns a copy of every card on the server, in the order they were created
+*/
+func (s *Server) Cards() []Card {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make([]Card, len(s.cards))
+	for i, c := range s.cards {
+		out[i] = *c
+	}
+	return out
+}
+
+/*
+CardByName returns a copy of the first card with the given name, or false if there isn't one
+*/
+func (s *Server) CardByName(name string) (Card, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range s.cards {
+		if c.Name == name {
+			return *c, true
+		}
+	}
+	return Card{}, false
+}
+
+/*
+Lists returns a copy of every list on the given board
+*/
+func (s *Server) Lists(boardId string) []common.TrelloList {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make([]common.TrelloList, 0)
+	for _, l := range s.lists {
+		if l.BoardId == boardId {
+			out = append(out, *l)
+		}
+	}
+	return out
+}
+
+/*
+Labels returns a copy of every label on the given board
+*/
+func (s *Server) Labels(boardId string) []common.TrelloLabel {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make([]common.TrelloLabel, 0)
+	for _, l := range s.labels {
+		if l.BoardId == boardId {
+			out = append(out, *l)
+		}
+	}
+	return out
+}
+
+/*
+CustomField returns a copy of the custom field with the given name on the given board, or false if there isn't one
+*/
+func (s *Server) CustomField(boardId string, name string) (common.TrelloCustomField, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, f := range s.customFields {
+		if f.BoardId == boardId && f.Name == name {
+			return copyCustomField(f), true
+		}
+	}
+	return common.TrelloCustomField{}, false
+}
+
+/*
+Checklists returns a copy of the checklists on the given card
+*/
+func (s *Server) Checklists(cardId string) []common.TrelloChecklist {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make([]common.TrelloChecklist, 0)
+	for _, c := range s.checklists {
+		if c.CardId == cardId {
+			copied := *c
+			copied.CheckItems = append([]common.TrelloCheckItem{}, c.CheckItems...)
+			out = append(out, copied)
+		}
+	}
+	return out
+}
+
+/*
+RequestCount returns how many requests have been made with the given method to paths starting with pathPrefix
+(relative to /1, e.g. "/cards")
+*/
+func (s *Server) RequestCount(method string, pathPrefix string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, method+" "+pathPrefix) {
+			count++
+		}
+	}
+	return count
+}
+
+/*
+AssertCardCount fails the test if the server does not hold exactly `expected` cards
+*/
+func (s *Server) AssertCardCount(t testing.TB, expected int) {
+	t.Helper()
+	if actual := len(s.Cards()); actual != expected {
+		t.Errorf("expected %d cards on the board, got %d", expected, actual)
+	}
+}
+
+/*
+AssertCard returns the card with the given name, stopping the test if there isn't one
+*/
+func (s *Server) AssertCard(t testing.TB, name string) Card {
+	t.Helper()
+	card, haveCard := s.CardByName(name)
+	if !haveCard {
+		t.Fatalf("expected a card called '%s'", name)
+	}
+	return card
+}
+
+/*
+AssertCardInList fails the test if the named card is not in the named list
+*/
+func (s *Server) AssertCardInList(t testing.TB, cardName string, listName string) {
+	t.Helper()
+	card := s.AssertCard(t, cardName)
+	s.mutex.Lock()
+	list := s.findList(card.ListId)
+	s.mutex.Unlock()
+	if list == nil || list.Name != listName {
+		t.Errorf("expected card '%s' to be in list '%s'", cardName, listName)
+	}
+}
+
+/*
+AssertComment fails the test if none of the named card's comments contain `substring`
+*/
+func (s *Server) AssertComment(t testing.TB, cardName string, substring string) {
+	t.Helper()
+	card := s.AssertCard(t, cardName)
+	for _, c := range card.Comments {
+		if strings.Contains(c, substring) {
+			return
+		}
+	}
+	t.Errorf("expected a comment on '%s' containing '%s', comments were %q", cardName, substring, card.Comments)
+}
+
+/*
+AssertCustomFieldValue fails the test if the named custom field on the named card doesn't have `expected` under
+`key` (e.g. "text", "number", "date" or "checked")
+*/
+func (s *Server) AssertCustomFieldValue(t testing.TB, cardName string, fieldName string, key string, expected string) {
+	t.Helper()
+	card := s.AssertCard(t, cardName)
+	field, haveField := s.CustomField(s.boardIdForList(card.ListId), fieldName)
+	if !haveField {
+		t.Fatalf("expected a custom field called '%s'", fieldName)
+	}
+	item, haveItem := card.CustomFieldItems[field.Id]
+	if !haveItem {
+		t.Errorf("expected custom field '%s' to be set on '%s'", fieldName, cardName)
+		return
+	}
+	if item.Value[key] != expected {
+		t.Errorf("expected custom field '%s' on '%s' to have %s '%s', got '%s'", fieldName, cardName, key, expected, item.Value[key])
+	}
+}
+
+/*
+AssertCustomFieldText fails the test if the named text custom field on the named card isn't `expected`
+*/
+func (s *Server) AssertCustomFieldText(t testing.TB, cardName string, fieldName string, expected string) {
+	t.Helper()
+	s.AssertCustomFieldValue(t, cardName, fieldName, "text", expected)
+}
+
+/*
+AssertCustomFieldOption fails the test if the named list custom field on the named card isn't set to the option
+with the given text
+*/
+func (s *Server) AssertCustomFieldOption(t testing.TB, cardName string, fieldName string, optionText string) {
+	t.Helper()
+	card := s.AssertCard(t, cardName)
+	field, haveField := s.CustomField(s.boardIdForList(card.ListId), fieldName)
+	if !haveField {
+		t.Fatalf("expected a custom field called '%s'", fieldName)
+	}
+	option, err := field.FindInCustomField(optionText)
+	if err != nil {
+		t.Fatalf("custom field '%s' has no option '%s'", fieldName, optionText)
+	}
+	if card.CustomFieldItems[field.Id].IdValue != option.Id {
+		t.Errorf("expected custom field '%s' on '%s' to be '%s'", fieldName, cardName, optionText)
+	}
+}
+
+/*
+AssertAttachment returns the attachment with the given name on the named card, failing the test if there isn't one
+*/
+func (s *Server) AssertAttachment(t testing.TB, cardName string, attachmentName string) Attachment {
+	t.Helper()
+	card := s.AssertCard(t, cardName)
+	for _, a := range card.Attachments {
+		if a.Name == attachmentName {
+			return a
+		}
+	}
+	t.Errorf("expected an attachment called '%s' on '%s'", attachmentName, cardName)
+	return Attachment{}
+}
+
+func (s *Server) boardIdForList(listId string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if list := s.findList(listId); list != nil {
+		return list.BoardId
+	}
+	return ""
+}
@@ -0,0 +1,838 @@
+package trellotest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+Server is an in-memory stand-in for the parts of the Trello REST API that this project uses. It runs on a local
+httptest.Server, keeps everything it is sent in memory and has helpers to set up a board and check what was written
+to it. Requests must carry the key and token from Key, or they get a 401 like the real thing.
+*/
+type Server struct {
+	*httptest.Server
+	Key *common.ScriptKey
+
+	mutex        sync.Mutex
+	nextId       int64
+	lists        []*common.TrelloList
+	labels       []*common.TrelloLabel
+	customFields []*common.TrelloCustomField
+	members      map[string][]common.TrelloMember //keyed by board ID
+	cards        []*Card
+	checklists   []*common.TrelloChecklist
+	requests     []string
+	failures     []failure
+}
+
+/*
+Card is a card held by the fake server, along with everything that has been added to it
+*/
+type Card struct {
+	common.TrelloCard
+	CustomFieldItems map[string]CustomFieldItem //keyed by custom field ID
+	Comments         []string
+	Attachments      []Attachment
+}
+
+/*
+CustomFieldItem is the value of a custom field on a card. List fields set IdValue, everything else sets Value
+e.g. {"text": "ABC-123"}
+*/
+type CustomFieldItem struct {
+	IdValue string
+	Value   map[string]string
+}
+
+/*
+Attachment is either an uploaded file (FileName, MimeType and Content are set) or a link (Url is set)
+*/
+type Attachment struct {
+	Id       string
+	Name     string
+	Url      string
+	FileName string
+	MimeType string
+	Content  []byte
+}
+
+type failure struct {
+	method string
+	path   string
+	status int
+	handle bool //the request is carried out before failing, as if the response was lost
+}
+
+/*
+NewServer starts a new, empty fake Trello server. Call Close when done with it.
+*/
+func NewServer() *Server {
+	s := &Server{
+		Key:     &common.ScriptKey{User: "test-api-key", Key: "test-token"},
+		members: make(map[string][]common.TrelloMember),
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+/*
+Client returns a trello.Client that talks to this server
+*/
+func (s *Server) Client() *trello.Client {
+	client := trello.NewClient(s.Key, s.Server.Client())
+	client.BaseUrl = s.URL + "/1"
+	return client
+}
+
+func (s *Server) newId() string {
+	s.nextId++
+	return fmt.Sprintf("%024x", s.nextId)
+}
+
+/*
+AddList puts a list onto the given board
+*/
+func (s *Server) AddList(boardId string, name string) common.TrelloList {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return *s.addList(boardId, name)
+}
+
+func (s *Server) addList(boardId string, name string) *common.TrelloList {
+	list := &common.TrelloList{
+		Id:      s.newId(),
+		Name:    name,
+		BoardId: boardId,
+		Pos:     int64(len(s.lists)+1) * 1024,
+	}
+	s.lists = append(s.lists, list)
+	return list
+}
+
+/*
+AddLabel puts a label onto the given board. Use an empty colour for a label with no colour.
+*/
+func (s *Server) AddLabel(boardId string, name string, colour string) common.TrelloLabel {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return *s.addLabel(boardId, name, colour)
+}
+
+func (s *Server) addLabel(boardId string, name string, colour string) *common.TrelloLabel {
+	label := &common.TrelloLabel{
+		Id:      s.newId(),
+		BoardId: boardId,
+		Name:    name,
+	}
+	if colour != "" && colour != "null" {
+		label.MaybeColour = common.StringPtr(colour)
+	}
+	s.labels = append(s.labels, label)
+	return label
+}
+
+/*
+AddCustomField puts a custom field onto the given board. For list fields, each of `options` becomes an option.
+*/
+func (s *Server) AddCustomField(boardId string, name string, fieldType common.CustomFieldType, options ...string) common.TrelloCustomField {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	field := s.addCustomField(boardId, name, fieldType)
+	for _, text := range options {
+		*field.Options = append(*field.Options, common.TrelloCustomFieldOption{
+			Id:            s.newId(),
+			CustomFieldId: field.Id,
+			Value:         common.TrelloCustomFieldOptionValue{Text: text},
+			Pos:           int64(len(*field.Options)+1) * 1024,
+		})
+	}
+	return copyCustomField(field)
+}
+
+func (s *Server) addCustomField(boardId string, name string, fieldType common.CustomFieldType) *common.TrelloCustomField {
+	options := make([]common.TrelloCustomFieldOption, 0)
+	field := &common.TrelloCustomField{
+		Id:        s.newId(),
+		BoardId:   boardId,
+		ModelType: "board",
+		Name:      name,
+		Pos:       int64(len(s.customFields)+1) * 1024,
+		Options:   &options,
+		Type:      fieldType,
+	}
+	s.customFields = append(s.customFields, field)
+	return field
+}
+
+/*
+AddMember makes the given user a member of the board. If member.Id is empty then one is assigned.
+*/
+func (s *Server) AddMember(boardId string, member common.TrelloMember) common.TrelloMember {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if member.Id == "" {
+		member.Id = s.newId()
+	}
+	s.members[boardId] = append(s.members[boardId], member)
+	return member
+}
+
+/*
+FailNext makes the next request with the given method and path (relative to /1, e.g. "/cards") fail with the given
+status code. Can be called more than once to fail several requests in a row.
+*/
+func (s *Server) FailNext(method string, path string, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures = append(s.failures, failure{method: method, path: path, status: status})
+}
+
+/*
+FailNextAfterHandling is like FailNext, but the request is carried out before the failure is returned, as if the
+server did the work but the response never reached the client
+*/
+func (s *Server) FailNextAfterHandling(method string, path string, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failures = append(s.failures, failure{method: method, path: path, status: status, handle: true})
+}
+
+/*
+takeFailure returns the pending failure for this request, or nil if there isn't one.
+The caller must hold the mutex.
+*/
+func (s *Server) takeFailure(method string, path string) *failure {
+	for i, f := range s.failures {
+		if f.method == method && f.path == path {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			return &f
+		}
+	}
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, content interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(content)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/1/") {
+		writeError(w, 404, "Cannot "+r.Method+" "+r.URL.Path)
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("key") != s.Key.User || query.Get("token") != s.Key.Key {
+		writeError(w, 401, "invalid key")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/1")
+	s.requests = append(s.requests, r.Method+" "+path)
+	if f := s.takeFailure(r.Method, path); f != nil {
+		if f.handle {
+			s.route(httptest.NewRecorder(), r, path)
+		}
+		writeError(w, f.status, "injected failure")
+		return
+	}
+	s.route(w, r, path)
+}
+
+/*
+route carries out a request. The caller must hold the mutex.
+*/
+func (s *Server) route(w http.ResponseWriter, r *http.Request, path string) {
+	query := r.URL.Query()
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	route := r.Method + " " + segments[0]
+	if len(segments) > 2 {
+		route += "/*/" + strings.Join(segments[2:], "/")
+	} else if len(segments) == 2 {
+		route += "/*"
+	}
+	var id string
+	if len(segments) > 1 {
+		id = segments[1]
+	}
+
+	switch {
+	case route == "GET boards/*/lists":
+		s.getLists(w, id)
+	case route == "POST lists":
+		s.postList(w, query)
+	case route == "GET boards/*/labels":
+		s.getLabels(w, id, query)
+	case route == "POST boards/*/labels":
+		writeJson(w, s.addLabel(id, query.Get("name"), query.Get("color")))
+	case route == "DELETE labels/*":
+		s.deleteLabel(w, id)
+	case route == "GET boards/*/members":
+		s.getMembers(w, id)
+	case route == "GET boards/*/customFields":
+		s.getCustomFields(w, id)
+	case route == "POST customFields":
+		s.postCustomField(w, r)
+	case route == "PUT customFields/*":
+		s.putCustomField(w, r, id)
+	case route == "POST customFields/*/options":
+		s.postCustomFieldOption(w, r, id)
+	case len(segments) == 4 && r.Method == "DELETE" && segments[0] == "customFields" && segments[2] == "options":
+		s.deleteCustomFieldOption(w, id, segments[3])
+	case route == "GET boards/*/cards/all":
+		s.getBoardCards(w, id)
+	case route == "POST cards":
+		s.postCard(w, query)
+	case route == "PUT cards/*":
+		s.putCard(w, query, id)
+	case route == "DELETE cards/*":
+		s.deleteCard(w, id)
+	case len(segments) == 5 && r.Method == "PUT" && segments[0] == "cards" && segments[2] == "customField" && segments[4] == "item":
+		s.putCustomFieldItem(w, r, id, segments[3])
+	case route == "GET cards/*/actions":
+		s.getComments(w, query, id)
+	case len(segments) == 6 && r.Method == "GET" && segments[0] == "cards" && segments[2] == "attachments" && segments[4] == "download":
+		s.downloadAttachment(w, id, segments[3])
+	case route == "POST cards/*/actions/comments":
+		s.postComment(w, query, id)
+	case route == "GET cards/*/attachments":
+		s.getAttachments(w, id)
+	case route == "POST cards/*/attachments":
+		s.postAttachment(w, r, id)
+	case len(segments) == 4 && r.Method == "PUT" && segments[0] == "cards" && segments[2] == "checkItem":
+		s.putCheckItem(w, query, id, segments[3])
+	case route == "POST checklists":
+		s.postChecklist(w, query)
+	case route == "POST checklists/*/checkItems":
+		s.postCheckItem(w, query, id)
+	default:
+		writeError(w, 404, "Cannot "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) getLists(w http.ResponseWriter, boardId string) {
+	out := make([]common.TrelloList, 0)
+	for _, l := range s.lists {
+		if l.BoardId == boardId {
+			out = append(out, *l)
+		}
+	}
+	writeJson(w, out)
+}
+
+func (s *Server) postList(w http.ResponseWriter, query url.Values) {
+	if query.Get("idBoard") == "" || query.Get("name") == "" {
+		writeError(w, 400, "invalid value for idBoard or name")
+		return
+	}
+	writeJson(w, s.addList(query.Get("idBoard"), query.Get("name")))
+}
+
+func (s *Server) getLabels(w http.ResponseWriter, boardId string, query url.Values) {
+	//like Trello, only the first 50 labels are returned unless a limit is given
+	limit := 50
+	if query.Get("limit") != "" {
+		limit, _ = strconv.Atoi(query.Get("limit"))
+	}
+	out := make([]common.TrelloLabel, 0)
+	for _, l := range s.labels {
+		if l.BoardId == boardId && len(out) < limit {
+			out = append(out, *l)
+		}
+	}
+	writeJson(w, out)
+}
+
+func (s *Server) deleteLabel(w http.ResponseWriter, labelId string) {
+	for i, l := range s.labels {
+		if l.Id == labelId {
+			s.labels = append(s.labels[:i], s.labels[i+1:]...)
+			for _, c := range s.cards {
+				for j, cardLabel := range c.LabelIDs {
+					if cardLabel == labelId {
+						c.LabelIDs = append(c.LabelIDs[:j], c.LabelIDs[j+1:]...)
+						break
+					}
+				}
+			}
+			writeJson(w, map[string]interface{}{})
+			return
+		}
+	}
+	writeError(w, 404, "label not found")
+}
+
+func (s *Server) getMembers(w http.ResponseWriter, boardId string) {
+	out := make([]common.TrelloMember, 0)
+	out = append(out, s.members[boardId]...)
+	writeJson(w, out)
+}
+
+func copyCustomField(f *common.TrelloCustomField) common.TrelloCustomField {
+	out := *f
+	options := make([]common.TrelloCustomFieldOption, len(*f.Options))
+	copy(options, *f.Options)
+	out.Options = &options
+	return out
+}
+
+func (s *Server) findCustomField(fieldId string) *common.TrelloCustomField {
+	for _, f := range s.customFields {
+		if f.Id == fieldId {
+			return f
+		}
+	}
+	return nil
+}
+
+func (s *Server) getCustomFields(w http.ResponseWriter, boardId string) {
+	out := make([]common.TrelloCustomField, 0)
+	for _, f := range s.customFields {
+		if f.BoardId == boardId {
+			out = append(out, copyCustomField(f))
+		}
+	}
+	writeJson(w, out)
+}
+
+func (s *Server) postCustomField(w http.ResponseWriter, r *http.Request) {
+	var definition common.NewTrelloCustomField
+	if err := json.NewDecoder(r.Body).Decode(&definition); err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	if definition.BoardId == "" || definition.Name == "" || definition.Type == "" {
+		writeError(w, 400, "invalid custom field definition")
+		return
+	}
+	field := s.addCustomField(definition.BoardId, definition.Name, definition.Type)
+	field.Display.CardFront = definition.DisplayCardFront
+	writeJson(w, copyCustomField(field))
+}
+
+func (s *Server) putCustomField(w http.ResponseWriter, r *http.Request, fieldId string) {
+	field := s.findCustomField(fieldId)
+	if field == nil {
+		writeError(w, 404, "custom field not found")
+		return
+	}
+	var update common.TrelloCustomField
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	field.Name = update.Name
+	field.Display = update.Display
+	field.Pos = update.Pos
+	writeJson(w, copyCustomField(field))
+}
+
+func (s *Server) postCustomFieldOption(w http.ResponseWriter, r *http.Request, fieldId string) {
+	field := s.findCustomField(fieldId)
+	if field == nil {
+		writeError(w, 404, "custom field not found")
+		return
+	}
+	var option common.TrelloCustomFieldOption
+	if err := json.NewDecoder(r.Body).Decode(&option); err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	option.Id = s.newId()
+	option.CustomFieldId = fieldId
+	*field.Options = append(*field.Options, option)
+	writeJson(w, option)
+}
+
+func (s *Server) deleteCustomFieldOption(w http.ResponseWriter, fieldId string, optionId string) {
+	field := s.findCustomField(fieldId)
+	if field == nil {
+		writeError(w, 404, "custom field not found")
+		return
+	}
+	for i, o := range *field.Options {
+		if o.Id == optionId {
+			*field.Options = append((*field.Options)[:i], (*field.Options)[i+1:]...)
+			writeJson(w, map[string]interface{}{})
+			return
+		}
+	}
+	writeError(w, 404, "option not found")
+}
+
+func (s *Server) findList(listId string) *common.TrelloList {
+	for _, l := range s.lists {
+		if l.Id == listId {
+			return l
+		}
+	}
+	return nil
+}
+
+func (s *Server) findCard(cardId string) *Card {
+	for _, c := range s.cards {
+		if c.Id == cardId {
+			return c
+		}
+	}
+	return nil
+}
+
+func splitIds(value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return strings.Split(value, ",")
+}
+
+func (s *Server) postCard(w http.ResponseWriter, query url.Values) {
+	if s.findList(query.Get("idList")) == nil {
+		writeError(w, 400, "invalid value for idList")
+		return
+	}
+	id := s.newId()
+	shortLink := id[len(id)-8:]
+	card := &Card{
+		TrelloCard: common.TrelloCard{
+			Id:          id,
+			Name:        query.Get("name"),
+			Description: query.Get("desc"),
+			ListId:      query.Get("idList"),
+			Members:     splitIds(query.Get("idMembers")),
+			ShortId:     int64(len(s.cards) + 1),
+			ShortLink:   shortLink,
+			ShortUrl:    "https://trello.com/c/" + shortLink,
+			URL:         "https://trello.com/c/" + shortLink,
+		},
+		CustomFieldItems: make(map[string]CustomFieldItem),
+		Comments:         make([]string, 0),
+		Attachments:      make([]Attachment, 0),
+	}
+	labelIds := splitIds(query.Get("idLabels"))
+	card.LabelIDs = make([]interface{}, len(labelIds))
+	for i, l := range labelIds {
+		card.LabelIDs[i] = l
+	}
+	if due := query.Get("due"); due != "" {
+		card.Due = common.StringPtr(due)
+	}
+	s.cards = append(s.cards, card)
+	writeJson(w, card.TrelloCard)
+}
+
+func (s *Server) getBoardCards(w http.ResponseWriter, boardId string) {
+	out := make([]common.TrelloCardDetail, 0)
+	for _, c := range s.cards {
+		if list := s.findList(c.ListId); list == nil || list.BoardId != boardId {
+			continue
+		}
+		detail := common.TrelloCardDetail{
+			TrelloCard:       c.TrelloCard,
+			Badges:           common.TrelloBadges{Comments: len(c.Comments), Attachments: len(c.Attachments)},
+			CustomFieldItems: make([]common.TrelloCustomFieldItem, 0, len(c.CustomFieldItems)),
+			Attachments:      make([]common.TrelloAttachment, 0, len(c.Attachments)),
+		}
+		for fieldId, item := range c.CustomFieldItems {
+			detail.CustomFieldItems = append(detail.CustomFieldItems, common.TrelloCustomFieldItem{
+				CustomFieldId: fieldId,
+				IdValue:       item.IdValue,
+				Value:         item.Value,
+			})
+		}
+		for _, a := range c.Attachments {
+			detail.Attachments = append(detail.Attachments, s.toTrelloAttachment(c.Id, a))
+		}
+		for _, l := range c.LabelIDs {
+			for _, label := range s.labels {
+				if label.Id == l {
+					detail.Labels = append(detail.Labels, *label)
+				}
+			}
+		}
+		detail.Checklists = make([]common.TrelloChecklist, 0)
+		for _, checklist := range s.checklists {
+			if checklist.CardId == c.Id {
+				detail.Checklists = append(detail.Checklists, *checklist)
+			}
+		}
+		out = append(out, detail)
+	}
+	writeJson(w, out)
+}
+
+func (s *Server) putCard(w http.ResponseWriter, query url.Values, cardId string) {
+	card := s.findCard(cardId)
+	if card == nil {
+		writeError(w, 404, "card not found")
+		return
+	}
+	if closed := query.Get("closed"); closed != "" {
+		card.Closed = closed == "true"
+	}
+	if _, haveName := query["name"]; haveName {
+		card.Name = query.Get("name")
+	}
+	if _, haveDesc := query["desc"]; haveDesc {
+		card.Description = query.Get("desc")
+	}
+	if due, haveDue := query["due"]; haveDue {
+		card.Due = nil
+		if due[0] != "null" && due[0] != "" {
+			card.Due = common.StringPtr(due[0])
+		}
+	}
+	if listId := query.Get("idList"); listId != "" {
+		card.ListId = listId
+	}
+	writeJson(w, card.TrelloCard)
+}
+
+func (s *Server) deleteCard(w http.ResponseWriter, cardId string) {
+	for i, c := range s.cards {
+		if c.Id == cardId {
+			s.cards = append(s.cards[:i], s.cards[i+1:]...)
+			writeJson(w, map[string]interface{}{})
+			return
+		}
+	}
+	writeError(w, 404, "card not found")
+}
+
+func (s *Server) putCustomFieldItem(w http.ResponseWriter, r *http.Request, cardId string, fieldId string) {
+	card := s.findCard(cardId)
+	field := s.findCustomField(fieldId)
+	if card == nil || field == nil {
+		writeError(w, 404, "card or custom field not found")
+		return
+	}
+
+	if idValue := r.URL.Query().Get("idValue"); idValue != "" {
+		for _, o := range *field.Options {
+			if o.Id == idValue {
+				card.CustomFieldItems[fieldId] = CustomFieldItem{IdValue: idValue}
+				writeJson(w, map[string]string{"idValue": idValue})
+				return
+			}
+		}
+		writeError(w, 400, "invalid value for idValue")
+		return
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	var clear map[string]string
+	if json.Unmarshal(body, &clear) == nil && clear["value"] == "" && clear["idValue"] == "" {
+		delete(card.CustomFieldItems, fieldId)
+		writeJson(w, map[string]interface{}{})
+		return
+	}
+	var content struct {
+		Value map[string]string `json:"value"`
+	}
+	if err := json.Unmarshal(body, &content); err != nil || len(content.Value) == 0 {
+		writeError(w, 400, "invalid value for value")
+		return
+	}
+	card.CustomFieldItems[fieldId] = CustomFieldItem{Value: content.Value}
+	writeJson(w, content)
+}
+
+func (s *Server) postComment(w http.ResponseWriter, query url.Values, cardId string) {
+	card := s.findCard(cardId)
+	if card == nil {
+		writeError(w, 404, "card not found")
+		return
+	}
+	if query.Get("text") == "" {
+		writeError(w, 400, "invalid value for text")
+		return
+	}
+	card.Comments = append(card.Comments, query.Get("text"))
+	writeJson(w, map[string]interface{}{"id": s.newId(), "type": "commentCard"})
+}
+
+/*
+getComments returns a card's comments as commentCard actions, newest first like the real API. Every comment is
+made by the same member.
+*/
+func (s *Server) getComments(w http.ResponseWriter, query url.Values, cardId string) {
+	card := s.findCard(cardId)
+	if card == nil {
+		writeError(w, 404, "card not found")
+		return
+	}
+	if query.Get("filter") != "commentCard" {
+		writeError(w, 400, "only the commentCard filter is supported")
+		return
+	}
+	out := make([]common.TrelloComment, 0, len(card.Comments))
+	for i := len(card.Comments) - 1; i >= 0; i-- {
+		comment := common.TrelloComment{
+			Id:            fmt.Sprintf("%s%08x", cardId[:16], i+1),
+			Date:          fmt.Sprintf("2021-06-01T10:%02d:00.000Z", i%60),
+			MemberCreator: common.TrelloMember{Id: "member1", FullName: "Test User", Username: "testuser"},
+		}
+		comment.Data.Text = card.Comments[i]
+		out = append(out, comment)
+	}
+	writeJson(w, out)
+}
+
+/*
+toTrelloAttachment returns an attachment as Trello describes it
+*/
+func (s *Server) toTrelloAttachment(cardId string, a Attachment) common.TrelloAttachment {
+	attachment := common.TrelloAttachment{Id: a.Id, Name: a.Name, Url: a.Url, MimeType: a.MimeType}
+	if a.Url == "" {
+		size := int64(len(a.Content))
+		attachment.Bytes = &size
+		attachment.Url = fmt.Sprintf("%s/1/cards/%s/attachments/%s/download/%s", s.URL, cardId, a.Id, url.PathEscape(a.Name))
+	}
+	return attachment
+}
+
+func (s *Server) getAttachments(w http.ResponseWriter, cardId string) {
+	card := s.findCard(cardId)
+	if card == nil {
+		writeError(w, 404, "card not found")
+		return
+	}
+	out := make([]common.TrelloAttachment, 0, len(card.Attachments))
+	for _, a := range card.Attachments {
+		out = append(out, s.toTrelloAttachment(card.Id, a))
+	}
+	writeJson(w, out)
+}
+
+func (s *Server) downloadAttachment(w http.ResponseWriter, cardId string, attachmentId string) {
+	card := s.findCard(cardId)
+	if card == nil {
+		writeError(w, 404, "card not found")
+		return
+	}
+	for _, a := range card.Attachments {
+		if a.Id == attachmentId && a.Url == "" {
+			w.Header().Set("Content-Type", a.MimeType)
+			w.Write(a.Content)
+			return
+		}
+	}
+	writeError(w, 404, "attachment not found")
+}
+
+func (s *Server) postAttachment(w http.ResponseWriter, r *http.Request, cardId string) {
+	card := s.findCard(cardId)
+	if card == nil {
+		writeError(w, 404, "card not found")
+		return
+	}
+	attachment := Attachment{Id: s.newId()}
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, 400, err.Error())
+			return
+		}
+		defer file.Close()
+		attachment.Content, err = ioutil.ReadAll(file)
+		if err != nil {
+			writeError(w, 400, err.Error())
+			return
+		}
+		attachment.FileName = header.Filename
+		attachment.Name = header.Filename
+		attachment.MimeType = r.FormValue("mimeType")
+	} else {
+		query := r.URL.Query()
+		if query.Get("url") == "" {
+			writeError(w, 400, "must provide a file or a url")
+			return
+		}
+		attachment.Url = query.Get("url")
+		attachment.Name = query.Get("name")
+	}
+	card.Attachments = append(card.Attachments, attachment)
+	writeJson(w, map[string]string{"id": attachment.Id, "name": attachment.Name})
+}
+
+func (s *Server) findChecklist(checklistId string) *common.TrelloChecklist {
+	for _, c := range s.checklists {
+		if c.Id == checklistId {
+			return c
+		}
+	}
+	return nil
+}
+
+func (s *Server) postChecklist(w http.ResponseWriter, query url.Values) {
+	card := s.findCard(query.Get("idCard"))
+	if card == nil {
+		writeError(w, 400, "invalid value for idCard")
+		return
+	}
+	list := s.findList(card.ListId)
+	checklist := &common.TrelloChecklist{
+		Id:         s.newId(),
+		Name:       query.Get("name"),
+		BoardId:    list.BoardId,
+		CardId:     card.Id,
+		Pos:        float64(len(s.checklists)+1) * 1024,
+		CheckItems: make([]common.TrelloCheckItem, 0),
+	}
+	s.checklists = append(s.checklists, checklist)
+	writeJson(w, checklist)
+}
+
+func (s *Server) postCheckItem(w http.ResponseWriter, query url.Values, checklistId string) {
+	checklist := s.findChecklist(checklistId)
+	if checklist == nil {
+		writeError(w, 404, "checklist not found")
+		return
+	}
+	item := common.TrelloCheckItem{
+		Id:          s.newId(),
+		Name:        query.Get("name"),
+		ChecklistId: checklistId,
+		State:       common.CheckItemIncomplete,
+		Pos:         float64(len(checklist.CheckItems)+1) * 1024,
+	}
+	if query.Get("checked") == "true" {
+		item.State = common.CheckItemComplete
+	}
+	checklist.CheckItems = append(checklist.CheckItems, item)
+	writeJson(w, item)
+}
+
+func (s *Server) putCheckItem(w http.ResponseWriter, query url.Values, cardId string, itemId string) {
+	for _, checklist := range s.checklists {
+		if checklist.CardId != cardId {
+			continue
+		}
+		for i := range checklist.CheckItems {
+			if checklist.CheckItems[i].Id == itemId {
+				checklist.CheckItems[i].State = common.CheckItemState(query.Get("state"))
+				writeJson(w, checklist.CheckItems[i])
+				return
+			}
+		}
+	}
+	writeError(w, 404, "check item not found")
+}
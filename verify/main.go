@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/jira"
+	"github.com/fredex42/mm-jira-migration/migrate"
+	"github.com/fredex42/mm-jira-migration/trello"
+	"log"
+	"os"
+)
+
+// exit code used when the check ran but found discrepancies, to tell it apart from a failure to run the check
+const exitDiscrepancies = 2
+
+func main() {
+	cfg := common.DefaultMigrationConfig()
+	configPath := flag.String("config", "", "Path to the YAML migration config file used for load-issues. Flags given on the command line override its settings")
+	flag.StringVar(&cfg.Jira.Credentials, "jira", cfg.Jira.Credentials, "Path to a file containing a Jira API key")
+	flag.StringVar(&cfg.Trello.Credentials, "trello", cfg.Trello.Credentials, "Path to a file containing a Trello API key")
+	flag.StringVar(&cfg.Jira.Host, "host", cfg.Jira.Host, "Jira host to query, or its full base URL (including any context path) if it is not https")
+	flag.IntVar(&cfg.Jira.PageSize, "pagesize", cfg.Jira.PageSize, "number of issues to fetch in one page")
+	flag.StringVar(&cfg.Jira.IssueJql, "jql", cfg.Jira.IssueJql, "JQL query that load-issues was run with")
+	flag.StringVar(&cfg.Trello.Board, "board", cfg.Trello.Board, "Board ID that the issues were migrated to")
+	flag.StringVar(&cfg.Fields.Epic, "epicfield", cfg.Fields.Epic, "Name of the custom field holding epics information")
+	flag.StringVar(&cfg.Fields.JiraKey, "jira-id", cfg.Fields.JiraKey, "Name of the custom field holding the jira ID")
+	flag.StringVar(&cfg.Fields.Priority, "priority-field", cfg.Fields.Priority, "Name of the list custom field holding the priority")
+	listMapPath := flag.String("listmap", "", "Path to the listmap file that load-issues was run with, if any. Only its 'done' setting is used, to know whether done issues should have cards")
+	subtaskModeSpec := flag.String("subtasks", string(migrate.SubtasksAsChecklist), "How load-issues migrated sub-tasks: 'checklist' or 'cards'. Sub-tasks are only expected to have their own cards with 'cards'")
+	statePath := flag.String("state", "migration-state.json", "Path to the migration state written by load-issues, used to know which attachments were deliberately left in Jira")
+	err := common.ParseFlagsWithConfig(cfg, configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	subtaskMode, err := migrate.ParseSubtaskMode(*subtaskModeSpec)
+	if err != nil {
+		log.Fatalf("Invalid -subtasks: %s", err)
+	}
+	routing, err := migrate.ListRoutingFromConfig(cfg.Lists)
+	if err != nil {
+		log.Fatalf("Invalid list mapping in the config: %s", err)
+	}
+	if *listMapPath != "" {
+		routing, err = migrate.LoadListRouting(*listMapPath)
+		if err != nil {
+			log.Fatalf("Could not load list mapping from '%s': %s", *listMapPath, err)
+		}
+	}
+	skipDone := routing.Done == migrate.DoneSkip
+
+	httpClient := common.SharedHttpClient()
+	jiraKey, err := common.LoadScriptKey(&cfg.Jira.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraAuth, err := jira.AuthFromScriptKey(jiraKey)
+	if err != nil {
+		log.Fatalf("Invalid scripting key '%s': %s", cfg.Jira.Credentials, err)
+	}
+	jiraClient := jira.NewClient(jira.BaseUrlFor(cfg.Jira.Host), jiraAuth, httpClient)
+	jiraClient.FieldMapping = cfg.Jira.Fields
+
+	trelloKey, err := common.LoadScriptKey(&cfg.Trello.Credentials)
+	if err != nil {
+		log.Fatalf("Could not open scripting key '%s': %s", cfg.Trello.Credentials, err)
+	}
+	trelloClient := trello.NewClient(trelloKey, httpClient)
+
+	state, err := common.LoadMigrationState(*statePath, common.JiraToTrello)
+	if err != nil {
+		log.Fatalf("Could not load migration state from '%s': %s", *statePath, err)
+	}
+
+	customFieldCache, err := trelloClient.LoadAllCustomFields(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load custom fields from board '%s': %s", cfg.Trello.Board, err)
+	}
+	fields := make(map[string]common.TrelloCustomField, 3)
+	for _, name := range []string{cfg.Fields.JiraKey, cfg.Fields.Epic, cfg.Fields.Priority} {
+		field, haveField := (*customFieldCache)[name]
+		if !haveField {
+			log.Fatalf("Could not find any custom field matching '%s' on board '%s'", name, cfg.Trello.Board)
+		}
+		fields[name] = field
+	}
+
+	cards, err := trelloClient.LoadAllCards(cfg.Trello.Board)
+	if err != nil {
+		log.Fatalf("Could not load cards from board '%s': %s", cfg.Trello.Board, err)
+	}
+	log.Printf("INFO Found %d cards on board '%s'", len(cards), cfg.Trello.Board)
+
+	issues, err := jiraClient.SyncLoadIssuesJQL(cfg.Jira.PageSize, cfg.Jira.IssueJql)
+	if err != nil {
+		log.Fatalf("Could not load issues from Jira: %s", err)
+	}
+	log.Printf("INFO Loaded %d issues from Jira", len(issues))
+
+	verifier := NewVerifier(cards, fields[cfg.Fields.JiraKey], fields[cfg.Fields.Epic], fields[cfg.Fields.Priority], skipDone, subtaskMode == migrate.SubtasksAsCards, state)
+	found := make([]Discrepancy, 0)
+	checked := 0
+	for i := range issues {
+		issue := &issues[i]
+		if !verifier.ExpectsCard(issue) {
+			continue
+		}
+		checked++
+		jiraComments := 0
+		if len(verifier.CardsFor(issue.Key)) > 0 {
+			comments, err := jiraClient.LoadAllComments(issue.Key, 20)
+			if err != nil {
+				log.Fatalf("Could not load comments for %s: %s", issue.Key, err)
+			}
+			jiraComments = len(*comments)
+		}
+		found = append(found, verifier.VerifyIssue(issue, jiraComments)...)
+	}
+
+	SortDiscrepancies(found)
+	counts := make(map[DiscrepancyKind]int)
+	for _, d := range found {
+		fmt.Println(d.String())
+		counts[d.Kind]++
+	}
+	log.Printf("INFO Checked %d issues against %d cards", checked, len(cards))
+	if len(found) > 0 {
+		for kind, count := range counts {
+			log.Printf("ERROR %d %s", count, kind)
+		}
+		log.Printf("ERROR Found %d discrepancies", len(found))
+		os.Exit(exitDiscrepancies)
+	}
+	log.Printf("INFO No discrepancies found")
+}
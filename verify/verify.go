@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fredex42/mm-jira-migration/common"
+	"sort"
+	"strings"
+)
+
+type DiscrepancyKind string
+
+const (
+	MissingCard         DiscrepancyKind = "missing-card"         //no card has the issue's Jira key
+	DuplicateCard       DiscrepancyKind = "duplicate-card"       //more than one card has the issue's Jira key
+	TitleMismatch       DiscrepancyKind = "title-mismatch"       //the card name is not the issue summary
+	DescriptionMismatch DiscrepancyKind = "description-mismatch" //the card description is not the converted issue description
+	CommentMismatch     DiscrepancyKind = "comment-count"        //the card does not have the number of comments expected
+	MissingAttachment   DiscrepancyKind = "missing-attachment"   //a Jira attachment is not on the card
+	EpicUnset           DiscrepancyKind = "epic-unset"           //the issue has an epic but the card's epic field is empty
+	PriorityUnset       DiscrepancyKind = "priority-unset"       //the issue has a priority but the card's priority field is empty
+)
+
+/*
+Discrepancy is a single difference found between a Jira issue and its card
+*/
+type Discrepancy struct {
+	JiraKey string
+	CardId  string
+	Kind    DiscrepancyKind
+	Detail  string
+}
+
+func (d Discrepancy) String() string {
+	if d.CardId == "" {
+		return fmt.Sprintf("%s %s: %s", d.JiraKey, d.Kind, d.Detail)
+	}
+	return fmt.Sprintf("%s %s on card %s: %s", d.JiraKey, d.Kind, d.CardId, d.Detail)
+}
+
+/*
+Verifier compares Jira issues with the cards that load-issues made for them
+*/
+type Verifier struct {
+	JiraKeyField  common.TrelloCustomField
+	EpicField     common.TrelloCustomField
+	PriorityField common.TrelloCustomField
+	SkipDone      bool //done issues were not migrated, so are not expected to have cards
+	SubtaskCards  bool //sub-tasks were migrated as cards rather than checklist items
+	State         *common.MigrationState
+	cardsByKey    map[string][]common.TrelloCardDetail
+}
+
+/*
+NewVerifier indexes the given cards by the value of their Jira key field. Archived cards are left out, such as those
+left behind by a rollback, unless the migration state says that one is the issue's card.
+*/
+func NewVerifier(cards []common.TrelloCardDetail, jiraKeyField common.TrelloCustomField, epicField common.TrelloCustomField, priorityField common.TrelloCustomField, skipDone bool, subtaskCards bool, state *common.MigrationState) *Verifier {
+	v := &Verifier{
+		JiraKeyField:  jiraKeyField,
+		EpicField:     epicField,
+		PriorityField: priorityField,
+		SkipDone:      skipDone,
+		SubtaskCards:  subtaskCards,
+		State:         state,
+		cardsByKey:    make(map[string][]common.TrelloCardDetail),
+	}
+	for _, card := range cards {
+		item, haveKey := card.CustomFieldItem(jiraKeyField.Id)
+		if !haveKey || item.Value["text"] == "" {
+			continue
+		}
+		key := item.Value["text"]
+		if card.Closed && !v.isRecordedCard(key, card.Id) {
+			continue
+		}
+		v.cardsByKey[key] = append(v.cardsByKey[key], card)
+	}
+	return v
+}
+
+/*
+isRecordedCard returns true if the migration state says the given card is the one made for the given Jira key
+*/
+func (v *Verifier) isRecordedCard(jiraKey string, cardId string) bool {
+	if v.State == nil {
+		return false
+	}
+	previous, havePrevious := v.State.Get(jiraKey)
+	return havePrevious && previous.CardId == cardId
+}
+
+/*
+ExpectsCard returns false for issues that load-issues does not make cards for: done issues when they are skipped,
+and sub-tasks when they go onto their parent's checklist
+*/
+func (v *Verifier) ExpectsCard(issue *common.Issue) bool {
+	if v.SkipDone && issue.Fields.Status.IsDone() {
+		return false
+	}
+	if !v.SubtaskCards && issue.Fields.IssueType.SubTask && issue.Fields.Parent != nil {
+		return false
+	}
+	return true
+}
+
+/*
+CardsFor returns the cards that have the given Jira key
+*/
+func (v *Verifier) CardsFor(jiraKey string) []common.TrelloCardDetail {
+	return v.cardsByKey[jiraKey]
+}
+
+/*
+normaliseText removes differences that Trello introduces when it stores text, such as line endings and trailing space
+*/
+func normaliseText(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+/*
+skippedAttachments returns the IDs of the issue's attachments that the migration state says were deliberately left in
+Jira, which get a comment on the card instead
+*/
+func (v *Verifier) skippedAttachments(jiraKey string) map[string]bool {
+	skipped := make(map[string]bool)
+	previous, havePrevious := v.State.Get(jiraKey)
+	if !havePrevious {
+		return skipped
+	}
+	for id, outcome := range previous.Attachments {
+		if outcome.Action == "skip" {
+			skipped[id] = true
+		}
+	}
+	return skipped
+}
+
+/*
+VerifyIssue compares an issue with its cards. jiraComments is the number of comments on the issue in Jira.
+Every card with the issue's key is checked, so that a duplicate that is also wrong shows up.
+*/
+func (v *Verifier) VerifyIssue(issue *common.Issue, jiraComments int) []Discrepancy {
+	found := make([]Discrepancy, 0)
+	cards := v.CardsFor(issue.Key)
+	if len(cards) == 0 {
+		return append(found, Discrepancy{JiraKey: issue.Key, Kind: MissingCard, Detail: fmt.Sprintf("no card has '%s' in the %s field", issue.Key, v.JiraKeyField.Name)})
+	}
+	if len(cards) > 1 {
+		urls := make([]string, len(cards))
+		for i, c := range cards {
+			urls[i] = c.ShortUrl
+		}
+		found = append(found, Discrepancy{JiraKey: issue.Key, Kind: DuplicateCard, Detail: fmt.Sprintf("%d cards: %s", len(cards), strings.Join(urls, ", "))})
+	}
+
+	expected := issue.ToTrelloCard("", false)
+	skipped := v.skippedAttachments(issue.Key)
+	//each Jira comment is copied, then the origin comment is added, plus one for each attachment left in Jira
+	expectedComments := jiraComments + 1 + len(skipped)
+
+	for _, card := range cards {
+		if normaliseText(card.Name) != normaliseText(expected.Name) {
+			found = append(found, Discrepancy{JiraKey: issue.Key, CardId: card.Id, Kind: TitleMismatch, Detail: fmt.Sprintf("card is '%s', issue is '%s'", card.Name, expected.Name)})
+		}
+		if normaliseText(card.Description) != normaliseText(expected.Description) {
+			found = append(found, Discrepancy{JiraKey: issue.Key, CardId: card.Id, Kind: DescriptionMismatch, Detail: fmt.Sprintf("card has %d characters, expected %d", len(card.Description), len(expected.Description))})
+		}
+		if card.Badges.Comments != expectedComments {
+			found = append(found, Discrepancy{JiraKey: issue.Key, CardId: card.Id, Kind: CommentMismatch, Detail: fmt.Sprintf("card has %d comments, expected %d", card.Badges.Comments, expectedComments)})
+		}
+
+		attachmentNames := make(map[string]bool, len(card.Attachments))
+		for _, a := range card.Attachments {
+			attachmentNames[a.Name] = true
+		}
+		for _, a := range issue.Fields.Attachment {
+			if !attachmentNames[a.Filename] && !skipped[a.Id] {
+				found = append(found, Discrepancy{JiraKey: issue.Key, CardId: card.Id, Kind: MissingAttachment, Detail: fmt.Sprintf("'%s' is not attached", a.Filename)})
+			}
+		}
+
+		if issue.Fields.EpicLink != nil && *issue.Fields.EpicLink != "" {
+			if item, haveItem := card.CustomFieldItem(v.EpicField.Id); !haveItem || item.IdValue == "" {
+				found = append(found, Discrepancy{JiraKey: issue.Key, CardId: card.Id, Kind: EpicUnset, Detail: fmt.Sprintf("issue is in epic %s but %s is empty", *issue.Fields.EpicLink, v.EpicField.Name)})
+			}
+		}
+		if issue.Fields.Priority.Id != "" || issue.Fields.Priority.Name != "" {
+			if item, haveItem := card.CustomFieldItem(v.PriorityField.Id); !haveItem || item.IdValue == "" {
+				found = append(found, Discrepancy{JiraKey: issue.Key, CardId: card.Id, Kind: PriorityUnset, Detail: fmt.Sprintf("issue has priority '%s' but %s is empty", issue.Fields.Priority.Name, v.PriorityField.Name)})
+			}
+		}
+	}
+	return found
+}
+
+/*
+SortDiscrepancies puts discrepancies into Jira key order, then by kind
+*/
+func SortDiscrepancies(found []Discrepancy) {
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].JiraKey != found[j].JiraKey {
+			return found[i].JiraKey < found[j].JiraKey
+		}
+		return found[i].Kind < found[j].Kind
+	})
+}
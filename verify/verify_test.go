@@ -0,0 +1,146 @@
+package main
+
+import (
+	"github.com/fredex42/mm-jira-migration/common"
+	"github.com/fredex42/mm-jira-migration/trellotest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeTestIssue(key string, summary string) *common.Issue {
+	return &common.Issue{
+		Key: key,
+		Fields: common.IssueFields{
+			Summary:  summary,
+			Priority: common.IssuePriority{Id: "2", Name: "High"},
+			Status:   common.IssueStatus{Name: "To Do"},
+			EpicLink: common.StringPtr("PROJ-100"),
+			Attachment: []common.Attachment{
+				{Id: "10001", Filename: "screenshot.png", Size: 18},
+			},
+		},
+	}
+}
+
+func kindsOf(found []Discrepancy) string {
+	kinds := make([]string, len(found))
+	for i, d := range found {
+		kinds[i] = string(d.Kind)
+	}
+	return strings.Join(kinds, ",")
+}
+
+/*
+TestVerifyIssue sets up a board with a correctly migrated card, a card with everything wrong and a duplicated card,
+then checks that each discrepancy is found
+*/
+func TestVerifyIssue(t *testing.T) {
+	server := trellotest.NewServer()
+	defer server.Close()
+	list := server.AddList("board1", "To Do")
+	epicField := server.AddCustomField("board1", "Epic", common.List, "Big Project")
+	priorityField := server.AddCustomField("board1", "Priority", common.List, "High")
+	jiraKeyField := server.AddCustomField("board1", "Jira Key", common.Text)
+	client := server.Client()
+
+	stateDir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(stateDir)
+	state, err := common.LoadMigrationState(filepath.Join(stateDir, "state.json"), common.JiraToTrello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	migrate := func(issue *common.Issue, complete bool) *common.TrelloCard {
+		card, err := client.PutTrelloCard(issue.ToTrelloCard(list.Id, false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = client.SetCustomFieldText(card.Id, jiraKeyField.Id, issue.Key); err != nil {
+			t.Fatal(err)
+		}
+		if !complete {
+			return card
+		}
+		client.SetCustomFieldValue(card.Id, epicField.Id, (*epicField.Options)[0].Id)
+		client.SetCustomFieldValue(card.Id, priorityField.Id, (*priorityField.Options)[0].Id)
+		client.AttachUrl(card.Id, "https://example.com/screenshot.png", "screenshot.png")
+		client.AddComment(card.Id, "a migrated comment")
+		client.AddComment(card.Id, "the origin comment")
+		return card
+	}
+	good := makeTestIssue("PROJ-1", "Something is broken")
+	migrate(good, true)
+	bad := makeTestIssue("PROJ-2", "Something else is broken")
+	migrate(&common.Issue{Key: "PROJ-2", Fields: common.IssueFields{Summary: "Wrong title"}}, false)
+	duplicated := makeTestIssue("PROJ-3", "Twice")
+	migrate(duplicated, true)
+	migrate(duplicated, true)
+	missing := makeTestIssue("PROJ-4", "Never migrated")
+	done := makeTestIssue("PROJ-5", "Finished")
+	done.Fields.Status.StatusCategory.Key = "done"
+	//a card archived by a rollback is not a duplicate of the card made when the issue was migrated again
+	rolledBack := makeTestIssue("PROJ-6", "Migrated twice")
+	archived := migrate(rolledBack, true)
+	if err = client.ArchiveCard(archived.Id); err != nil {
+		t.Fatal(err)
+	}
+	migrate(rolledBack, true)
+	//an archived card is still checked if it is the one recorded in the state
+	recorded := makeTestIssue("PROJ-7", "Archived after migration")
+	recordedCard := migrate(recorded, true)
+	if err = client.ArchiveCard(recordedCard.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = state.RecordCard("PROJ-7", recordedCard); err != nil {
+		t.Fatal(err)
+	}
+
+	cards, err := client.LoadAllCards("board1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cards) != 7 {
+		t.Fatalf("expected 7 cards on the board, got %d", len(cards))
+	}
+	verifier := NewVerifier(cards, jiraKeyField, epicField, priorityField, true, false, state)
+
+	if found := verifier.VerifyIssue(good, 1); len(found) != 0 {
+		t.Errorf("expected no discrepancies for a good card, got %v", found)
+	}
+	if found := verifier.VerifyIssue(good, 2); kindsOf(found) != "comment-count" {
+		t.Errorf("expected a comment count discrepancy, got %v", found)
+	}
+	if found := verifier.VerifyIssue(bad, 0); kindsOf(found) != "title-mismatch,comment-count,missing-attachment,epic-unset,priority-unset" {
+		t.Errorf("unexpected discrepancies for a bad card: %v", found)
+	}
+	if found := verifier.VerifyIssue(duplicated, 1); kindsOf(found) != "duplicate-card" {
+		t.Errorf("expected a duplicate discrepancy, got %v", found)
+	}
+	if found := verifier.VerifyIssue(missing, 0); kindsOf(found) != "missing-card" {
+		t.Errorf("expected a missing card discrepancy, got %v", found)
+	}
+	if found := verifier.VerifyIssue(rolledBack, 1); len(found) != 0 {
+		t.Errorf("expected the archived card to be ignored, got %v", found)
+	}
+	if found := verifier.VerifyIssue(recorded, 1); len(found) != 0 || len(verifier.CardsFor("PROJ-7")) != 1 {
+		t.Errorf("expected the archived card recorded in the state to be checked, got %v", found)
+	}
+	if verifier.ExpectsCard(done) {
+		t.Error("expected done issues not to need cards when they are skipped")
+	}
+
+	//an attachment that load-issues left in Jira gets a comment instead
+	err = state.RecordAttachment("PROJ-2", "10001", common.AttachmentOutcome{Filename: "screenshot.png", Size: 18, Action: "skip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found := verifier.VerifyIssue(bad, 0); strings.Contains(kindsOf(found), "missing-attachment") || !strings.Contains(found[1].Detail, "expected 2") {
+		t.Errorf("expected the skipped attachment to count as a comment, got %v", found)
+	}
+}